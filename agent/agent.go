@@ -20,6 +20,7 @@ package agent
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -31,13 +32,18 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/agent/audit"
+	"github.com/percona/qan-agent/agent/policy"
 	"github.com/percona/qan-agent/agent/release"
+	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
 	pctCmd "github.com/percona/qan-agent/pct/cmd"
+	"github.com/percona/qan-agent/query/plugin/os/summary"
 	"github.com/pkg/errors"
 )
 
@@ -50,10 +56,23 @@ const (
 	CMD_QUEUE_SIZE    = 10
 	STATUS_QUEUE_SIZE = 10
 	MAX_ERRORS        = 3
+	NOTIFY_PERIOD     = 1 * time.Second
 )
 
 var (
-	ErrStop = errors.New("received Stop command")
+	ErrStop    = errors.New("received Stop command")
+	ErrRestart = errors.New("received Restart command")
+)
+
+var (
+	// Cmds time out after this long, unless set in CmdTimeouts.
+	DefaultCmdTimeout = 1 * time.Minute
+	CmdTimeouts       = map[string]time.Duration{
+		"Update": 5 * time.Minute,
+	}
+	// The agent isn't alive if a cmd is still running this long after it
+	// timed out: it blocks the cmds queued after it.
+	MaxCmdOverrun = 1 * time.Minute
 )
 
 type Agent struct {
@@ -65,10 +84,17 @@ type Agent struct {
 	services  map[string]pct.ServiceManager
 	addr      string
 	keepalive *time.Ticker
+	auditLog  *audit.Log
+	policy    *policy.Policy
+	repo      *instance.Repo
 	// --
 	cmdSync        *pct.SyncChan
 	cmdChan        chan *proto.Cmd
 	cmdHandlerSync *pct.SyncChan
+	cmdsRunning    int32
+	cmdsStarted    map[*proto.Cmd]time.Time
+	cmdsStartedMux *sync.Mutex
+	pingChan       chan struct{}
 	//
 	statusSync        *pct.SyncChan
 	status            *pct.Status
@@ -89,10 +115,15 @@ func NewAgent(config *pc.Agent, logger *pct.Logger, client pct.WebsocketClient,
 		client:    client,
 		addr:      addr,
 		services:  services,
+		auditLog:  audit.NewLog(pct.Basedir.File("audit-log"), audit.DefaultMaxSize, audit.DefaultRetain),
 		// --
 		status:     pct.NewStatus([]string{"agent", "agent-cmd-handler"}),
 		cmdChan:    make(chan *proto.Cmd, CMD_QUEUE_SIZE),
 		statusChan: make(chan *proto.Cmd, STATUS_QUEUE_SIZE),
+		pingChan:   make(chan struct{}),
+		// --
+		cmdsStarted:    map[*proto.Cmd]time.Time{},
+		cmdsStartedMux: &sync.Mutex{},
 	}
 	return agent
 }
@@ -101,6 +132,18 @@ func NewAgent(config *pc.Agent, logger *pct.Logger, client pct.WebsocketClient,
 // Interface
 /////////////////////////////////////////////////////////////////////////////
 
+// SetRepo sets the instance repo. It must be called before Run. Without a
+// repo, the default, query cmds for all instances share one queue.
+func (agent *Agent) SetRepo(repo *instance.Repo) {
+	agent.repo = repo
+}
+
+// SetPolicy sets the local policy which allows or denies commands. It must be
+// called before Run. A nil policy, the default, allows all commands.
+func (agent *Agent) SetPolicy(p *policy.Policy) {
+	agent.policy = p
+}
+
 func (agent *Agent) Run() error {
 	logger := agent.logger
 	logger.Debug("Run:call")
@@ -116,10 +159,12 @@ func (agent *Agent) Run() error {
 	go agent.connect()
 
 	/*
-	 * Start the status and cmd handlers.  Most messages must be serialized because,
-	 * for example, handling start-service and stop-service at the same
-	 * time would cause weird problems.  The cmdChan serializes messages,
-	 * so it's "first come, first serve" (i.e. fifo).  Concurrency has
+	 * Start the status and cmd handlers.  Messages for a service must be
+	 * serialized because, for example, handling start-service and stop-service
+	 * at the same time would cause weird problems.  The cmdHandler queues
+	 * messages per service, so each service is "first come, first serve"
+	 * (i.e. fifo), and different services are handled concurrently, so a slow
+	 * cmd for one service doesn't block the others.  Concurrency has
 	 * consequences: e.g. if user1 sends a start-service and it succeeds
 	 * and user2 send the same start-service, user2 will get a ServiceIsRunningError.
 	 * Status requests are handled concurrently so the user can always see what
@@ -148,6 +193,17 @@ func (agent *Agent) Run() error {
 
 		select {
 		case cmd := <-cmdChan: // from API
+			received := time.Now().UTC()
+			// Other cmds are checked by Handle or before their service
+			// handles them.
+			switch cmd.Cmd {
+			case "Abort", "Restart", "Stop", "Status":
+				if err := agent.policy.Check(cmd); err != nil {
+					logger.Warn(err)
+					agent.replyCmd(cmd, received, cmd.Reply(nil, err))
+					continue
+				}
+			}
 			if cmd.Cmd == "Abort" {
 				panic(cmd)
 			}
@@ -156,46 +212,17 @@ func (agent *Agent) Run() error {
 				logger.Debug("cmd:restart")
 				agent.status.UpdateRe("agent", "Restarting", cmd)
 
-				// Secure the start-lock file.  This lets us start our self but
-				// wait until this process has exited, at which time the start-lock
-				// is removed and the 2nd self continues starting.
-				if err := pct.MakeStartLock(); err != nil {
-					agent.reply(cmd.Reply(nil, err))
-					continue
-				}
-
-				// Start our self with the same args this process was started with.
-				cwd, err := os.Getwd()
-				if err != nil {
-					agent.reply(cmd.Reply(nil, err))
-				}
-				comment := fmt.Sprintf(
-					"This script was created by percona-agent in response to this Restart command:\n"+
-						"# %s\n"+
-						"# It is safe to delete.", cmd)
-				sh := fmt.Sprintf("#!/bin/sh\n# %s\ncd %s\n%s %s >> %s/percona-agent.log 2>&1 &\n",
-					comment,
-					cwd,
-					os.Args[0],
-					strings.Join(os.Args[1:len(os.Args)], " "),
-					pct.Basedir.Path(),
-				)
-				startScript := pct.Basedir.File("start-script")
-				if err := ioutil.WriteFile(startScript, []byte(sh), os.FileMode(0754)); err != nil {
-					agent.reply(cmd.Reply(nil, err))
-				}
-				logger.Debug("Restart:sh")
-				self := pctCmd.Factory.Make(startScript)
-				output, err := self.Run()
-				agent.reply(cmd.Reply(output, err))
+				// Stop cleanly, then the caller re-execs the agent, see pct.Restart.
+				agent.stop()
+				agent.replyCmd(cmd, received, cmd.Reply(nil))
 				logger.Debug("Restart:done")
-				return nil
+				return ErrRestart
 			case "Stop":
 				logger.Debug("cmd:stop")
 				logger.Info("Stopping", cmd)
 				agent.status.UpdateRe("agent", "Stopping", cmd)
 				agent.stop()
-				agent.reply(cmd.Reply(nil))
+				agent.replyCmd(cmd, received, cmd.Reply(nil))
 				logger.Info("Stopped", cmd)
 				agent.status.UpdateRe("agent", "Stopped", cmd)
 				return ErrStop
@@ -206,7 +233,7 @@ func (agent *Agent) Run() error {
 				case agent.statusChan <- cmd: // to statusHandler
 				default:
 					err := pct.QueueFullError{Cmd: cmd.Cmd, Name: "statusQueue", Size: STATUS_QUEUE_SIZE}
-					agent.reply(cmd.Reply(nil, err))
+					agent.replyCmd(cmd, received, cmd.Reply(nil, err))
 				}
 			default:
 				logger.Debug("cmd")
@@ -215,7 +242,7 @@ func (agent *Agent) Run() error {
 				case agent.cmdChan <- cmd: // to cmdHandler
 				default:
 					err := pct.QueueFullError{Cmd: cmd.Cmd, Name: "cmdQueue", Size: CMD_QUEUE_SIZE}
-					agent.reply(cmd.Reply(nil, err))
+					agent.replyCmd(cmd, received, cmd.Reply(nil, err))
 				}
 			}
 		case <-agent.cmdHandlerSync.CrashChan:
@@ -349,46 +376,38 @@ func (agent *Agent) GetConfig() ([]proto.AgentConfig, []error) {
 // --------------------------------------------------------------------------
 
 func (agent *Agent) cmdHandler() {
+	queues := map[string]chan *proto.Cmd{}
+	stopChan := make(chan struct{})
 	wg := sync.WaitGroup{}
 	defer func() {
 		if err := recover(); err != nil {
 			agent.logger.Error("Agent command handler crashed: ", err)
 		}
+		// Wait for the cmds being handled, queued cmds are dropped.
+		close(stopChan)
 		wg.Wait()
 		agent.status.Update("agent-cmd-handler", "Stopped")
 		agent.cmdHandlerSync.Done()
 	}()
 
-	// maximum number of parallel cmds executing at the same time
-	limit := 20
-	queue := make(chan bool, limit)
-
 	for {
 		select {
 		case cmd := <-agent.cmdChan:
-			select {
-			case queue <- true:
+			name := agent.cmdQueue(cmd)
+			queue, ok := queues[name]
+			if !ok {
+				queue = make(chan *proto.Cmd, CMD_QUEUE_SIZE)
+				queues[name] = queue
 				wg.Add(1)
-				go func() {
-					defer func() {
-						<-queue
-						wg.Done()
-
-						// check if there is anything on the queue, if not, mark agent as idle
-						select {
-						case <-queue:
-							queue <- true
-						default:
-							agent.status.Update("agent-cmd-handler", "Idle")
-						}
-					}()
-
-					agent.handleCmd(cmd)
-				}()
-			case <-agent.cmdHandlerSync.StopChan:
-				agent.cmdHandlerSync.Graceful()
-				return
+				go agent.serviceCmdHandler(queue, stopChan, &wg)
+			}
+			select {
+			case queue <- cmd:
+			default:
+				err := pct.QueueFullError{Cmd: cmd.Cmd, Name: name + " cmdQueue", Size: CMD_QUEUE_SIZE}
+				agent.replyCmd(cmd, time.Now().UTC(), cmd.Reply(nil, err))
 			}
+		case <-agent.pingChan: // from Alive()
 		case <-agent.cmdHandlerSync.StopChan: // from stop()
 			agent.cmdHandlerSync.Graceful()
 			return
@@ -396,7 +415,56 @@ func (agent *Agent) cmdHandler() {
 	}
 }
 
-func (agent *Agent) handleCmd(cmd *proto.Cmd) {
+// cmdQueue returns the name of the queue for the cmd, which is the name of
+// its service. StartService and StopService are queued with the service
+// they start or stop. Unknown services are queued with the agent. Query cmds
+// are queued per instance, so a slow EXPLAIN on one instance doesn't block
+// queries on the others. Only instances in the repo get a queue, so cmds
+// with made-up UUIDs can't create queues and goroutines without limit.
+func (agent *Agent) cmdQueue(cmd *proto.Cmd) string {
+	service := cmd.Service
+	if service == "agent" && (cmd.Cmd == "StartService" || cmd.Cmd == "StopService") {
+		serviceData := &proto.ServiceData{}
+		if err := json.Unmarshal(cmd.Data, serviceData); err == nil {
+			service = serviceData.Name
+		}
+	}
+	if _, ok := agent.services[service]; !ok {
+		return "agent"
+	}
+	if service == "query" {
+		in := proto.Instance{}
+		if err := json.Unmarshal(cmd.Data, &in); err == nil && in.UUID != "" && agent.repo != nil {
+			if _, err := agent.repo.Get(in.UUID, false); err == nil {
+				return service + " " + in.UUID
+			}
+		}
+	}
+	return service
+}
+
+// serviceCmdHandler handles the cmds queued for a service, one at a time.
+func (agent *Agent) serviceCmdHandler(queue chan *proto.Cmd, stopChan chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case cmd := <-queue:
+			atomic.AddInt32(&agent.cmdsRunning, 1)
+			agent.handleCmd(cmd, stopChan)
+			if atomic.AddInt32(&agent.cmdsRunning, -1) == 0 {
+				agent.status.Update("agent-cmd-handler", "Idle")
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// handleCmd handles the cmd and replies. If the cmd times out, it replies with
+// the timeout but doesn't return until the cmd does, or the stopChan is closed,
+// so the next cmd queued for the service doesn't run concurrently with it.
+func (agent *Agent) handleCmd(cmd *proto.Cmd, stopChan chan struct{}) {
+	received := time.Now().UTC()
 	defer func() {
 		if err := recover(); err != nil {
 			agent.logger.Error("Agent command handler crashed: ", err)
@@ -407,6 +475,19 @@ func (agent *Agent) handleCmd(cmd *proto.Cmd) {
 		agent.logger.Info("Cmd begin:", cmd)
 	}
 
+	// Track the cmd until it returns, even after it times out, for Alive().
+	agent.cmdsStartedMux.Lock()
+	agent.cmdsStarted[cmd] = received
+	agent.cmdsStartedMux.Unlock()
+	defer func() {
+		agent.cmdsStartedMux.Lock()
+		delete(agent.cmdsStarted, cmd)
+		agent.cmdsStartedMux.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmdTimeout(cmd))
+	defer cancel() // stops the cmd if it times out
+
 	cmdReply := make(chan *proto.Reply, 1)
 	// Handle the cmd in a separate goroutine so if it gets stuck it won't affect us.
 	go func() {
@@ -419,10 +500,16 @@ func (agent *Agent) handleCmd(cmd *proto.Cmd) {
 			cmdReply <- reply
 		}()
 		if cmd.Service == "agent" {
-			reply = agent.Handle(cmd)
+			reply = agent.HandleContext(ctx, cmd)
 		} else {
 			if manager, ok := agent.services[cmd.Service]; ok {
-				reply = manager.Handle(cmd)
+				if err := agent.policy.Check(cmd); err != nil {
+					reply = cmd.Reply(nil, err)
+				} else if h, ok := manager.(pct.ContextHandler); ok {
+					reply = h.HandleContext(ctx, cmd)
+				} else {
+					reply = manager.Handle(cmd)
+				}
 			} else {
 				reply = cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
 			}
@@ -430,18 +517,14 @@ func (agent *Agent) handleCmd(cmd *proto.Cmd) {
 	}()
 
 	// Wait for the cmd to complete.
-	var timeout <-chan time.Time
-	if cmd.Cmd == "Update" {
-		timeout = time.After(5 * time.Minute)
-	} else {
-		timeout = time.After(1 * time.Minute)
-	}
 	var reply *proto.Reply
+	timedOut := false
 	select {
 	case reply = <-cmdReply:
 	// todo: instrument cmd exec time
-	case <-timeout:
+	case <-ctx.Done():
 		reply = cmd.Reply(nil, pct.CmdTimeoutError{Cmd: cmd.Cmd})
+		timedOut = true
 	}
 
 	if reply.Error == "" {
@@ -454,10 +537,42 @@ func (agent *Agent) handleCmd(cmd *proto.Cmd) {
 
 	// Reply to cmd.
 	if reply != nil {
-		agent.reply(reply)
+		agent.replyCmd(cmd, received, reply)
 	} else {
+		agent.auditCmd(cmd, received, nil)
 		agent.logger.Info(cmd, "executed, no reply")
 	}
+
+	if timedOut {
+		agent.status.UpdateRe("agent-cmd-handler", "Waiting for timed out", cmd)
+		select {
+		case <-cmdReply:
+			agent.logger.Info("Cmd timed out but returned:", cmd)
+		case <-stopChan:
+		}
+	}
+}
+
+// cmdTimeout returns how long the cmd can run before it times out.
+func cmdTimeout(cmd *proto.Cmd) time.Duration {
+	if t, ok := CmdTimeouts[cmd.Cmd]; ok {
+		return t
+	}
+	return DefaultCmdTimeout
+}
+
+// replyCmd writes the cmd and its reply to the audit log, then replies.
+func (agent *Agent) replyCmd(cmd *proto.Cmd, received time.Time, reply *proto.Reply) {
+	agent.auditCmd(cmd, received, reply)
+	agent.reply(reply)
+}
+
+// auditCmd writes the cmd and its reply, nil if none, to the audit log.
+// The relay can drop log entries, the audit log is local.
+func (agent *Agent) auditCmd(cmd *proto.Cmd, received time.Time, reply *proto.Reply) {
+	if err := agent.auditLog.Write(audit.NewRecord(cmd, received, time.Now().UTC(), reply)); err != nil {
+		agent.logger.Warn("Cannot write audit log:", err)
+	}
 }
 
 func (agent *Agent) reply(reply *proto.Reply) {
@@ -472,8 +587,19 @@ func (agent *Agent) reply(reply *proto.Reply) {
 }
 
 func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
+	return agent.HandleContext(context.Background(), cmd)
+}
+
+// HandleContext handles the cmd like Handle, but stops commands which run
+// external tools, like GetMySQLSummary, when the ctx is done.
+func (agent *Agent) HandleContext(ctx context.Context, cmd *proto.Cmd) *proto.Reply {
 	agent.status.UpdateRe("agent-cmd-handler", "Handling", cmd)
 
+	if err := agent.policy.Check(cmd); err != nil {
+		agent.logger.Warn(err)
+		return cmd.Reply(nil, err)
+	}
+
 	var data interface{}
 	var err error
 	var errs []error
@@ -488,10 +614,14 @@ func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 		data, errs = agent.GetAllConfigs()
 	case "SetConfig":
 		data, errs = agent.handleSetConfig(cmd)
+	case "RollbackConfig":
+		data, errs = agent.handleRollbackConfig(cmd)
+	case "Reload":
+		errs = agent.Reload()
 	case "GetDefaults":
 		data, errs = agent.GetDefaults(cmd)
 	case "Version":
-		data, errs = agent.handleVersion(cmd)
+		data, errs = agent.handleVersion(ctx, cmd)
 	case "Reconnect":
 		/*
 			Reconnect is a special case: there's no reply because we can't
@@ -506,15 +636,17 @@ func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 		return nil // no reply
 	// TODO @obsolete by query/plugin/os/summary
 	case "GetServerSummary":
-		data, errs = agent.handleGetServerSummary()
+		data, errs = agent.handleGetServerSummary(ctx, cmd)
 	// TODO @obsolete by query/plugin/mysql/summary
 	case "GetMySQLSummary":
-		data, errs = agent.handleGetMySQLSummary()
+		data, errs = agent.handleGetMySQLSummary(ctx)
 	// TODO @obsolete by query/plugin/mongo/summary
 	case "GetMongoSummary":
-		data, errs = agent.handleGetMongoSummary()
+		data, errs = agent.handleGetMongoSummary(ctx)
 	case "CollectServicesData":
-		data, errs = agent.handleCollectInfo()
+		data, errs = agent.handleCollectInfo(ctx)
+	case "GetAuditLog":
+		data, err = agent.handleGetAuditLog(cmd)
 	default:
 		errs = append(errs, pct.UnknownCmdError{Cmd: cmd.Cmd})
 	}
@@ -533,6 +665,17 @@ func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 	return cmd.Reply(data, errs...)
 }
 
+func (agent *Agent) handleGetAuditLog(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "GetAuditLog", cmd)
+	query := audit.Query{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, &query); err != nil {
+			return nil, err
+		}
+	}
+	return agent.auditLog.Read(query.Offset, query.Limit)
+}
+
 func (agent *Agent) handleStartService(cmd *proto.Cmd) (interface{}, error) {
 	agent.status.UpdateRe("agent-cmd-handler", "StartService", cmd)
 	agent.logger.Info(cmd)
@@ -656,6 +799,21 @@ func (agent *Agent) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
 
 	errs := []error{}
 
+	// Dry run: return what would change.
+	if pct.GetSetConfigFlags(cmd).DryRun {
+		runningConfig := finalConfig
+		if newConfig.Keepalive > 0 {
+			finalConfig.Keepalive = newConfig.Keepalive
+		}
+		runningConfig.Links = nil
+		finalConfig.Links = nil
+		diff, err := pct.DiffConfig(runningConfig, finalConfig)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return diff, errs
+	}
+
 	// Change keepalive if valid. It is not dynamic.
 	if newConfig.Keepalive > 0 {
 		agent.logger.Warn("Changing keepalive from", finalConfig.Keepalive, "to", newConfig.Keepalive,
@@ -676,7 +834,22 @@ func (agent *Agent) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
 	return &finalConfig, errs
 }
 
-func (agent *Agent) handleVersion(cmd *proto.Cmd) (interface{}, []error) {
+// handleRollbackConfig sets a previous version of the agent config.
+func (agent *Agent) handleRollbackConfig(cmd *proto.Cmd) (interface{}, []error) {
+	r, err := pct.GetRollback(cmd)
+	if err != nil {
+		return nil, []error{err}
+	}
+	set, err := pct.Basedir.ReadConfigVersion("agent", r.Version, &pc.Agent{})
+	if err != nil {
+		return nil, []error{err}
+	}
+	setCmd := *cmd
+	setCmd.Data = []byte(set)
+	return agent.handleSetConfig(&setCmd)
+}
+
+func (agent *Agent) handleVersion(ctx context.Context, cmd *proto.Cmd) (interface{}, []error) {
 	v := &proto.Version{
 		Running: release.VERSION,
 	}
@@ -684,7 +857,7 @@ func (agent *Agent) handleVersion(cmd *proto.Cmd) (interface{}, []error) {
 	if err != nil {
 		return v, []error{err}
 	}
-	out, err := exec.Command(bin, "-version").Output()
+	out, err := exec.CommandContext(ctx, bin, "-version").Output()
 	if err != nil {
 		return v, []error{err}
 	}
@@ -692,27 +865,44 @@ func (agent *Agent) handleVersion(cmd *proto.Cmd) (interface{}, []error) {
 	return v, nil
 }
 
-func runRealCmd(name string, args ...string) (interface{}, []error) {
-	output, err := pctCmd.NewRealCmd(name, args...).Run()
+func runRealCmd(ctx context.Context, name string, args ...string) (interface{}, []error) {
+	output, err := pctCmd.NewRealCmd(name, args...).RunContext(ctx)
 	if err != nil {
 		return nil, []error{err}
 	}
 	return output, nil
 }
 
-func (agent *Agent) handleGetServerSummary() (interface{}, []error) {
-	return runRealCmd("pt-summary")
+func (agent *Agent) handleGetServerSummary(ctx context.Context, cmd *proto.Cmd) (interface{}, []error) {
+	q := summary.Query{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, &q); err != nil {
+			return nil, []error{err}
+		}
+	}
+	switch q.Format {
+	case "", summary.FormatJSON:
+		system, err := summary.ReadSystem()
+		if err != nil {
+			return nil, []error{err}
+		}
+		return system, nil
+	case summary.FormatText:
+		return runRealCmd(ctx, "pt-summary")
+	default:
+		return nil, []error{fmt.Errorf("invalid format %s", q.Format)}
+	}
 }
 
-func (agent *Agent) handleGetMySQLSummary() (interface{}, []error) {
-	return runRealCmd("pt-mysql-summary", "--sleep", "1")
+func (agent *Agent) handleGetMySQLSummary(ctx context.Context) (interface{}, []error) {
+	return runRealCmd(ctx, "pt-mysql-summary", "--sleep", "1")
 }
 
-func (agent *Agent) handleGetMongoSummary() (interface{}, []error) {
-	return runRealCmd("pt-mongodb-summary")
+func (agent *Agent) handleGetMongoSummary(ctx context.Context) (interface{}, []error) {
+	return runRealCmd(ctx, "pt-mongodb-summary")
 }
 
-func (agent *Agent) handleCollectInfo() (interface{}, []error) {
+func (agent *Agent) handleCollectInfo(ctx context.Context) (interface{}, []error) {
 	tmpDir, err := ioutil.TempDir("", "data_collection")
 	if err != nil {
 		return nil, []error{errors.Wrap(err, "cannot create temp dir for data collection")}
@@ -724,7 +914,7 @@ func (agent *Agent) handleCollectInfo() (interface{}, []error) {
 	for _, cmd := range cmds {
 		outFile := path.Join(tmpDir, cmd+".out")
 		outFiles = append(outFiles, outFile)
-		_, err := runRealCmd(cmd, "> "+outFile)
+		_, err := runRealCmd(ctx, cmd, "> "+outFile)
 		if err != nil && len(err) > 0 {
 			return nil, err
 		}
@@ -834,18 +1024,22 @@ func (agent *Agent) statusHandler() {
 	for {
 		select {
 		case cmd := <-agent.statusChan:
+			received := time.Now().UTC()
+			var reply *proto.Reply
 			switch cmd.Service {
 			case "":
-				replyChan <- cmd.Reply(agent.AllStatus())
+				reply = cmd.Reply(agent.AllStatus())
 			case "agent":
-				replyChan <- cmd.Reply(agent.Status())
+				reply = cmd.Reply(agent.Status())
 			default:
 				if manager, ok := agent.services[cmd.Service]; ok {
-					replyChan <- cmd.Reply(manager.Status())
+					reply = cmd.Reply(manager.Status())
 				} else {
-					replyChan <- cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
+					reply = cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
 				}
 			}
+			agent.auditCmd(cmd, received, reply)
+			replyChan <- reply
 		case <-agent.statusHandlerSync.StopChan:
 			agent.statusHandlerSync.Graceful()
 			return
@@ -19,9 +19,12 @@ package agent
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
@@ -31,9 +34,13 @@ import (
 
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/agent/audit"
+	"github.com/percona/qan-agent/agent/policy"
 	"github.com/percona/qan-agent/agent/release"
+	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
-	pctCmd "github.com/percona/qan-agent/pct/cmd"
+	"github.com/percona/qan-agent/pct/sdnotify"
+	"github.com/percona/qan-agent/query/plugin/os/summary"
 	"github.com/percona/qan-agent/test"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/percona/qan-agent/test/rootdir"
@@ -599,6 +606,99 @@ func (s *AgentTestSuite) TestGetVersion(t *C) {
 	t.Check(version.Running, Equals, release.VERSION)
 }
 
+func (s *AgentTestSuite) TestGetAuditLog(t *C) {
+	cmd := &proto.Cmd{
+		Ts:      time.Now().UTC(),
+		User:    "daniel",
+		Cmd:     "Version",
+		Service: "agent",
+	}
+	s.sendChan <- cmd
+	got := test.WaitReply(s.recvChan)
+	t.Assert(len(got), Equals, 1)
+
+	cmd = &proto.Cmd{
+		Ts:      time.Now().UTC(),
+		User:    "daniel",
+		Cmd:     "GetAuditLog",
+		Service: "agent",
+		Data:    []byte(`{"Limit":1}`),
+	}
+	s.sendChan <- cmd
+	got = test.WaitReplyCmd(s.recvChan, "GetAuditLog")
+	t.Assert(len(got), Equals, 1)
+	t.Assert(got[0].Error, Equals, "")
+	auditLog := audit.Page{}
+	t.Assert(json.Unmarshal(got[0].Data, &auditLog), IsNil)
+	t.Check(auditLog.Total > 0, Equals, true)
+	t.Assert(auditLog.Records, HasLen, 1)
+	t.Check(auditLog.Records[0].Cmd, Equals, "Version")
+	t.Check(auditLog.Records[0].Service, Equals, "agent")
+	t.Check(auditLog.Records[0].User, Equals, "daniel")
+}
+
+func (s *AgentTestSuite) TestPolicy(t *C) {
+	// Handle doesn't need the agent running.
+	a := NewAgent(s.config, s.logger, s.client, "http://localhost", s.servicesMap)
+	p := &policy.Policy{Preset: policy.ReadOnly}
+	t.Assert(p.Init(), IsNil)
+	a.SetPolicy(p)
+
+	cmd := &proto.Cmd{
+		Ts:      time.Now(),
+		User:    "daniel",
+		Cmd:     "StopService",
+		Service: "agent",
+		Data:    []byte(`{"Name":"qan"}`),
+	}
+	reply := a.Handle(cmd)
+	t.Check(reply.Error, Equals, pct.CmdRejectedError{Cmd: "StopService", Reason: "the local agent policy denies it for service agent"}.Error())
+	t.Check(test.WaitTrace(s.traceChan), HasLen, 0) // qan service not stopped
+
+	cmd = &proto.Cmd{
+		Ts:      time.Now(),
+		User:    "daniel",
+		Cmd:     "GetConfig",
+		Service: "agent",
+	}
+	reply = a.Handle(cmd)
+	t.Check(reply.Error, Equals, "")
+}
+
+func (s *AgentTestSuite) TestPolicyAbort(t *C) {
+	// Stop the default agent. The policy must be set before Run.
+	s.TearDownTest(t)
+
+	// Read-only denies Abort, Stop is allowed only to stop the agent below.
+	newAgent := NewAgent(s.config, s.logger, s.client, "localhost", s.servicesMap)
+	p := &policy.Policy{
+		Preset: policy.ReadOnly,
+		Rules:  []policy.Rule{{Service: "agent", Cmd: "Stop", Action: policy.Allow}},
+	}
+	t.Assert(p.Init(), IsNil)
+	newAgent.SetPolicy(p)
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- newAgent.Run()
+	}()
+
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "Abort"}
+	replies := test.WaitReply(s.recvChan)
+	t.Assert(replies, HasLen, 1)
+	t.Check(replies[0].Error, Equals, pct.CmdRejectedError{Cmd: "Abort", Reason: "the local agent policy denies it for service agent"}.Error())
+
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "Stop"}
+	replies = test.WaitReply(s.recvChan)
+	t.Assert(replies, HasLen, 1)
+	t.Check(replies[0].Error, Equals, "")
+	select {
+	case err := <-doneChan:
+		t.Check(err, Equals, ErrStop)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Agent did not stop")
+	}
+}
+
 func (s *AgentTestSuite) TestGetSystemSummary(t *C) {
 	cmd := &proto.Cmd{
 		Ts:      time.Now(),
@@ -611,6 +711,25 @@ func (s *AgentTestSuite) TestGetSystemSummary(t *C) {
 	got := test.WaitReplyCmd(s.recvChan, "GetServerSummary")
 	t.Assert(len(got), Equals, 1)
 	t.Assert(got[0].Error, Equals, "")
+	system := &summary.System{}
+	err := json.Unmarshal(got[0].Data, system)
+	t.Assert(err, IsNil)
+	t.Check(system.CPU.Processors > 0, Equals, true)
+	t.Check(system.Memory.Total > 0, Equals, true)
+
+	cmd.Data = []byte(`{"Format":"xml"}`)
+	s.sendChan <- cmd
+
+	got = test.WaitReplyCmd(s.recvChan, "GetServerSummary")
+	t.Assert(len(got), Equals, 1)
+	t.Check(got[0].Error, Equals, "invalid format xml")
+
+	cmd.Data = []byte(`{"Format":"text"}`)
+	s.sendChan <- cmd
+
+	got = test.WaitReplyCmd(s.recvChan, "GetServerSummary")
+	t.Assert(len(got), Equals, 1)
+	t.Assert(got[0].Error, Equals, "")
 	t.Assert(string(got[0].Data), Matches, ".*# Percona Toolkit System Summary Report.*")
 }
 
@@ -816,14 +935,6 @@ func (s *AgentTestSuite) TestRestart(t *C) {
 	// Stop the default agent.  We need our own to check its return value.
 	s.TearDownTest(t)
 
-	cmdFactory := &mock.CmdFactory{}
-	pctCmd.Factory = cmdFactory
-
-	defer func() {
-		os.Remove(pct.Basedir.File("start-lock"))
-		os.Remove(pct.Basedir.File("start-script"))
-	}()
-
 	newAgent := NewAgent(s.config, s.logger, s.client, "localhost", s.servicesMap)
 	doneChan := make(chan error, 1)
 	go func() {
@@ -847,17 +958,10 @@ func (s *AgentTestSuite) TestRestart(t *C) {
 		t.Fatal("Agent did not restart")
 	}
 
-	// Agent should return without an error.
-	t.Check(err, IsNil)
-
-	// Agent should create the start-lock file and start-script.
-	t.Check(pct.FileExists(pct.Basedir.File("start-lock")), Equals, true)
-	t.Check(pct.FileExists(pct.Basedir.File("start-script")), Equals, true)
-
-	// Agent should make a command to run the start-script.
-	t.Assert(cmdFactory.Cmds, HasLen, 1)
-	t.Check(cmdFactory.Cmds[0].Name, Equals, pct.Basedir.File("start-script"))
-	t.Check(cmdFactory.Cmds[0].Args, IsNil)
+	// Agent should stop and return ErrRestart so main re-execs it.
+	t.Check(err, Equals, ErrRestart)
+	t.Check(newAgent.cmdHandlerSync.IsGraceful(), Equals, true)
+	t.Check(newAgent.statusHandlerSync.IsGraceful(), Equals, true)
 }
 
 func (s *AgentTestSuite) TestCmdToService(t *C) {
@@ -875,3 +979,212 @@ func (s *AgentTestSuite) TestCmdToService(t *C) {
 	t.Assert(s.services["mm"].(*mock.MockServiceManager).Cmds, HasLen, 1)
 	t.Check(s.services["mm"].(*mock.MockServiceManager).Cmds[0].Cmd, Equals, "Hello")
 }
+
+func (s *AgentTestSuite) TestCmdQueues(t *C) {
+	// A slow cmd for one service shouldn't block cmds for other services,
+	// but cmds for the same service wait their turn.
+	qanConfigData, _ := json.Marshal(&pc.QAN{Interval: 60})
+	serviceData, _ := json.Marshal(&proto.ServiceData{
+		Name:   "qan",
+		Config: qanConfigData,
+	})
+	s.startWaitGroup.Add(1)
+	s.sendChan <- &proto.Cmd{
+		Service: "agent",
+		Cmd:     "StartService",
+		Data:    serviceData,
+	}
+	// Wait for qan Start, which blocks until startWaitGroup is done.
+	for started := false; !started; {
+		select {
+		case trace := <-s.traceChan:
+			started = trace == "Start qan"
+		case <-time.After(1 * time.Second):
+			t.Fatal("qan wasn't started")
+		}
+	}
+
+	s.sendChan <- &proto.Cmd{Service: "qan", Cmd: "Hello"}
+	s.sendChan <- &proto.Cmd{Service: "mm", Cmd: "Hello"}
+
+	got := test.WaitReply(s.recvChan)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Cmd, Equals, "Hello")
+	t.Check(s.services["mm"].(*mock.MockServiceManager).Cmds, HasLen, 1)
+	t.Check(s.services["qan"].(*mock.MockServiceManager).Cmds, HasLen, 0)
+
+	// Let qan start, then its queued cmd is handled.
+	s.startWaitGroup.Done()
+	got = test.WaitReply(s.recvChan)
+	t.Assert(got, HasLen, 2)
+	t.Check(got[0].Cmd, Equals, "StartService")
+	t.Check(got[0].Error, Equals, "")
+	t.Check(got[1].Cmd, Equals, "Hello")
+	t.Check(s.services["qan"].(*mock.MockServiceManager).Cmds, HasLen, 1)
+}
+
+func (s *AgentTestSuite) TestCmdQueue(t *C) {
+	services := map[string]pct.ServiceManager{"query": s.services["mm"]}
+	newAgent := NewAgent(s.config, s.logger, s.client, "localhost", services)
+	data, _ := json.Marshal(proto.Instance{UUID: "313"})
+	cmd := &proto.Cmd{Service: "query", Cmd: "Explain", Data: data}
+
+	// Without a repo, all instances share the query queue.
+	t.Check(newAgent.cmdQueue(cmd), Equals, "query")
+
+	// With a repo, only its instances get their own queue.
+	repo := instance.NewRepo(s.logger, pct.Basedir.Dir("instance"), s.api)
+	err := repo.Add(proto.Instance{Subsystem: "mysql", UUID: "313", Name: "db1"}, false)
+	t.Assert(err, IsNil)
+	newAgent.SetRepo(repo)
+	t.Check(newAgent.cmdQueue(cmd), Equals, "query 313")
+	data, _ = json.Marshal(proto.Instance{UUID: "made-up"})
+	cmd.Data = data
+	t.Check(newAgent.cmdQueue(cmd), Equals, "query")
+}
+
+func (s *AgentTestSuite) TestCmdTimeout(t *C) {
+	defer func(timeout time.Duration) { DefaultCmdTimeout = timeout }(DefaultCmdTimeout)
+	DefaultCmdTimeout = 200 * time.Millisecond
+	defer func(overrun time.Duration) { MaxCmdOverrun = overrun }(MaxCmdOverrun)
+	MaxCmdOverrun = 200 * time.Millisecond
+
+	serviceData, _ := json.Marshal(&proto.ServiceData{Name: "qan"})
+	s.startWaitGroup.Add(1)
+	started := false
+	defer func() {
+		if !started {
+			s.startWaitGroup.Done()
+		}
+	}()
+	s.sendChan <- &proto.Cmd{
+		Service: "agent",
+		Cmd:     "StartService",
+		Data:    serviceData,
+	}
+
+	select {
+	case reply := <-s.recvChan:
+		t.Check(reply.Cmd, Equals, "StartService")
+		t.Check(reply.Error, Equals, pct.CmdTimeoutError{Cmd: "StartService"}.Error())
+	case <-time.After(2 * time.Second):
+		t.Fatal("StartService didn't time out")
+	}
+
+	// The next qan cmd waits until the timed out StartService returns.
+	s.sendChan <- &proto.Cmd{Service: "qan", Cmd: "Hello"}
+	select {
+	case reply := <-s.recvChan:
+		t.Fatalf("Cmd handled before StartService returned: %+v", reply)
+	case <-time.After(300 * time.Millisecond):
+	}
+	t.Check(s.services["qan"].(*mock.MockServiceManager).Cmds, HasLen, 0)
+
+	// StartService has overrun its timeout by more than MaxCmdOverrun.
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	err := s.agent.Alive(ctx)
+	t.Assert(err, NotNil)
+	t.Check(err.Error(), Matches, "cmd Cmd\\[Service:agent Cmd:StartService .* has been running for .*")
+
+	started = true
+	s.startWaitGroup.Done()
+	select {
+	case reply := <-s.recvChan:
+		t.Check(reply.Cmd, Equals, "Hello")
+		t.Check(reply.Error, Equals, "")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Cmd not handled after StartService returned")
+	}
+	t.Check(s.agent.Alive(ctx), IsNil)
+}
+
+func (s *AgentTestSuite) TestNotify(t *C) {
+	// A unixgram socket like the one systemd listens on.
+	tmpDir, err := ioutil.TempDir("", "agent-notify")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(tmpDir)
+	socket := filepath.Join(tmpDir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	t.Assert(err, IsNil)
+	defer conn.Close()
+
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		s.agent.Notify(sdnotify.NewNotifier(socket), 300*time.Millisecond, stopChan)
+		close(doneChan)
+	}()
+
+	got := map[string]bool{}
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for !got["STATUS=Idle"] || !got["WATCHDOG=1"] {
+		n, err := conn.Read(buf)
+		t.Assert(err, IsNil)
+		got[string(buf[:n])] = true
+	}
+
+	close(stopChan)
+	select {
+	case <-doneChan:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Notify didn't return")
+	}
+}
+
+func (s *AgentTestSuite) TestAlive(t *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	t.Check(s.agent.Alive(ctx), IsNil)
+
+	// The cmd handler of an agent which isn't running doesn't respond.
+	newAgent := NewAgent(s.config, s.logger, s.client, "localhost", s.servicesMap)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	t.Check(newAgent.Alive(ctx), NotNil)
+}
+
+func (s *AgentTestSuite) TestReload(t *C) {
+	// Change the agent config file outside the agent.
+	newConfig := *s.config
+	newConfig.Keepalive = 2
+	err := pct.Basedir.WriteConfig("agent", newConfig)
+	t.Assert(err, IsNil)
+	defer os.Remove(s.configFile)
+
+	s.services["mm"].(*mock.MockServiceManager).ReloadErrs = []error{errors.New("bad mm config")}
+
+	cmd := &proto.Cmd{
+		Ts:      time.Now(),
+		User:    "daniel",
+		Cmd:     "Reload",
+		Service: "agent",
+	}
+	s.sendChan <- cmd
+
+	got := test.WaitReply(s.recvChan)
+	t.Assert(len(got), Equals, 1)
+	t.Check(got[0].Error, Equals, "mm: bad mm config")
+
+	// Services are reloaded in RELOAD_ORDER, then the others by name.
+	gotTrace := test.WaitTrace(s.traceChan)
+	t.Check(gotTrace, DeepEquals, []string{"Reload qan", "Reload mm"})
+
+	// Keepalive changed in memory, like with SetConfig.
+	configs, errs := s.agent.GetConfig()
+	t.Assert(errs, HasLen, 0)
+	t.Assert(configs, HasLen, 1)
+	gotConfig := &pc.Agent{}
+	err = json.Unmarshal([]byte(configs[0].Running), gotConfig)
+	t.Assert(err, IsNil)
+	t.Check(gotConfig.Keepalive, Equals, uint(2))
+
+	// The config file is not written back.
+	data, err := ioutil.ReadFile(s.configFile)
+	t.Assert(err, IsNil)
+	fileConfig := &pc.Agent{}
+	err = json.Unmarshal(data, fileConfig)
+	t.Assert(err, IsNil)
+	t.Check(*fileConfig, DeepEquals, newConfig)
+}
@@ -0,0 +1,368 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package manifest applies a declarative agent config: the agent settings,
+// the MySQL and MongoDB instances and their QAN configs are written to the
+// basedir on startup, so an agent can be configured without running the
+// installer, e.g. when it's baked into an image. The instances are registered
+// with the API once it's reachable.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/agent/release"
+	"github.com/percona/qan-agent/instance"
+	"github.com/percona/qan-agent/pct"
+	qc "github.com/percona/qan-agent/qan/config"
+)
+
+// Subsystems of the instances which a manifest declares. Instances of these
+// subsystems which the manifest doesn't declare are removed.
+var Subsystems = []string{"mysql", "mongo"}
+
+// An Instance is a MySQL or MongoDB instance and its QAN config, if any.
+type Instance struct {
+	Subsystem string  // "mysql" or "mongo"
+	UUID      string  `json:",omitempty"` // generated if not set, then kept
+	Name      string  // unique per subsystem
+	DSN       string  // mysql or mongodb DSN
+	QAN       *qc.QAN `json:",omitempty"` // QAN is stopped if not set
+}
+
+// A Manifest is the agent config. Agent is the agent.conf config: UUID and
+// Links can be omitted, they're generated then updated by the API. Hostname
+// is the OS instance name, the local hostname if not set.
+type Manifest struct {
+	Agent     pc.Agent
+	Hostname  string     `json:",omitempty"`
+	Instances []Instance `json:",omitempty"`
+}
+
+// Load loads the manifest file, if any. It returns nil, nil if the file
+// doesn't exist.
+func Load(file string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return m, nil
+}
+
+// Validate checks the required fields and that instances are unique.
+func (m *Manifest) Validate() error {
+	if m.Agent.ApiHostname == "" {
+		return fmt.Errorf("Agent.ApiHostname is not set")
+	}
+	names := map[string]bool{}
+	uuids := map[string]bool{}
+	for i, in := range m.Instances {
+		if !validSubsystem(in.Subsystem) {
+			return fmt.Errorf("Instances[%d]: invalid Subsystem: %s; expected %s", i, in.Subsystem, strings.Join(Subsystems, " or "))
+		}
+		if in.Name == "" {
+			return fmt.Errorf("Instances[%d]: Name is not set", i)
+		}
+		if in.DSN == "" {
+			return fmt.Errorf("Instances[%d]: DSN is not set", i)
+		}
+		if names[in.Subsystem+"/"+in.Name] {
+			return fmt.Errorf("Instances[%d]: duplicate %s instance: %s", i, in.Subsystem, in.Name)
+		}
+		names[in.Subsystem+"/"+in.Name] = true
+		if in.UUID != "" {
+			if uuids[in.UUID] {
+				return fmt.Errorf("Instances[%d]: duplicate UUID: %s", i, in.UUID)
+			}
+			uuids[in.UUID] = true
+		}
+	}
+	return nil
+}
+
+// Apply writes the agent config, the instance files and the QAN configs so
+// the agent services start as declared. The repo must be initialized. It
+// returns the changes it made, e.g. "added mysql db1 (UUID)".
+func (m *Manifest) Apply(repo *instance.Repo) ([]string, error) {
+	changes := []string{}
+
+	// agent.conf
+	existing := pc.Agent{}
+	if _, err := pct.Basedir.ReadConfig("agent", &existing); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	agentConfig := m.Agent
+	if agentConfig.UUID == "" {
+		agentConfig.UUID = existing.UUID
+		if agentConfig.UUID == "" {
+			agentConfig.UUID = pct.NewUUID()
+		}
+	}
+	if agentConfig.Links == nil && agentConfig.UUID == existing.UUID {
+		agentConfig.Links = existing.Links
+	}
+	if !reflect.DeepEqual(agentConfig, existing) {
+		if err := pct.Basedir.WriteConfig("agent", agentConfig); err != nil {
+			return nil, err
+		}
+		changes = append(changes, "wrote agent config (UUID "+agentConfig.UUID+")")
+	}
+
+	// OS instance, the parent of the other instances
+	hostname := m.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	osInstance := proto.Instance{
+		Subsystem: "os",
+		UUID:      pct.NewUUID(),
+		Name:      hostname,
+	}
+	if list := repo.List("os"); len(list) > 0 {
+		osInstance = list[0]
+		if osInstance.Name != hostname {
+			osInstance.Name = hostname
+			if err := repo.Update(osInstance, true); err != nil {
+				return nil, err
+			}
+			changes = append(changes, fmt.Sprintf("updated os %s (%s)", osInstance.Name, osInstance.UUID))
+		}
+	} else {
+		if err := repo.Add(osInstance, true); err != nil {
+			return nil, err
+		}
+		changes = append(changes, fmt.Sprintf("added os %s (%s)", osInstance.Name, osInstance.UUID))
+	}
+
+	// MySQL and MongoDB instances
+	for _, subsystem := range Subsystems {
+		existing := repo.List(subsystem)
+		keep := map[string]bool{}
+		for _, declared := range m.Instances {
+			if declared.Subsystem != subsystem {
+				continue
+			}
+			in, found := match(declared, existing)
+			if !found {
+				in.UUID = declared.UUID
+				if in.UUID == "" {
+					in.UUID = pct.NewUUID()
+				}
+			}
+			old := in
+			in.Subsystem = subsystem
+			in.ParentUUID = osInstance.UUID
+			in.Name = declared.Name
+			in.DSN = declared.DSN
+			keep[in.UUID] = true
+
+			switch {
+			case !found:
+				if err := repo.Add(in, true); err != nil {
+					return nil, err
+				}
+				changes = append(changes, fmt.Sprintf("added %s %s (%s)", in.Subsystem, in.Name, in.UUID))
+			case !reflect.DeepEqual(old, in):
+				if err := repo.Update(in, true); err != nil {
+					return nil, err
+				}
+				changes = append(changes, fmt.Sprintf("updated %s %s (%s)", in.Subsystem, in.Name, in.UUID))
+			}
+
+			changed, err := applyQAN(in.UUID, declared.QAN)
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				changes = append(changes, fmt.Sprintf("wrote QAN config of %s %s (%s)", in.Subsystem, in.Name, in.UUID))
+			}
+		}
+		for _, in := range existing {
+			if keep[in.UUID] {
+				continue
+			}
+			if err := repo.Remove(in.UUID); err != nil {
+				return nil, err
+			}
+			if _, err := applyQAN(in.UUID, nil); err != nil {
+				return nil, err
+			}
+			changes = append(changes, fmt.Sprintf("removed %s %s (%s)", in.Subsystem, in.Name, in.UUID))
+		}
+	}
+
+	return changes, nil
+}
+
+// Register creates the OS, agent and declared instances in the API, like
+// the installer does. If an instance already exists, the API returns it and
+// its UUID replaces the local one: the instance file, QAN config and agent
+// config are updated. It returns true if any file changed, in which case
+// the agent should reload them. The API must be initialized.
+func (m *Manifest) Register(api pct.APIConnector, repo *instance.Repo) (bool, error) {
+	changed := false
+
+	list := repo.List("os")
+	if len(list) == 0 {
+		return false, fmt.Errorf("no os instance, the manifest is not applied")
+	}
+	osInstance := list[0]
+	c, err := register(api, repo, osInstance)
+	if err != nil {
+		return false, fmt.Errorf("cannot register os %s: %s", osInstance.Name, err)
+	}
+	changed = changed || c
+	osUUID := repo.List("os")[0].UUID
+
+	agentConfig := pc.Agent{}
+	if _, err := pct.Basedir.ReadConfig("agent", &agentConfig); err != nil {
+		return false, err
+	}
+	agentInstance := &proto.Instance{
+		Subsystem:  "agent",
+		UUID:       agentConfig.UUID,
+		ParentUUID: osUUID,
+		Name:       osInstance.Name,
+		Version:    release.VERSION,
+	}
+	if _, err := api.CreateInstance("/instances", agentInstance); err != nil {
+		return false, fmt.Errorf("cannot register agent %s: %s", agentInstance.Name, err)
+	}
+	if agentInstance.UUID != agentConfig.UUID || !reflect.DeepEqual(agentInstance.Links, agentConfig.Links) {
+		agentConfig.UUID = agentInstance.UUID
+		agentConfig.Links = agentInstance.Links
+		if err := pct.Basedir.WriteConfig("agent", agentConfig); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	for _, subsystem := range Subsystems {
+		for _, in := range repo.List(subsystem) {
+			in.ParentUUID = osUUID
+			c, err := register(api, repo, in)
+			if err != nil {
+				return false, fmt.Errorf("cannot register %s %s: %s", in.Subsystem, in.Name, err)
+			}
+			changed = changed || c
+		}
+	}
+
+	return changed, nil
+}
+
+// register creates the instance in the API and updates the repo and the
+// QAN config if the API returns a different instance.
+func register(api pct.APIConnector, repo *instance.Repo, in proto.Instance) (bool, error) {
+	old, err := repo.Get(in.UUID, false)
+	if err != nil {
+		return false, err
+	}
+	if _, err := api.CreateInstance("/instances", &in); err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(old, in) {
+		return false, nil
+	}
+	if in.UUID == old.UUID {
+		return true, repo.Update(in, true)
+	}
+
+	// The API has the instance with another UUID: rename it.
+	if err := repo.Remove(old.UUID); err != nil {
+		return false, err
+	}
+	if err := repo.Add(in, true); err != nil {
+		return false, err
+	}
+	qan := &qc.QAN{}
+	if !pct.FileExists(pct.Basedir.ConfigFile("qan-" + old.UUID)) {
+		return true, nil
+	}
+	if _, err := pct.Basedir.ReadConfig("qan-"+old.UUID, qan); err != nil {
+		return false, err
+	}
+	if _, err := applyQAN(in.UUID, qan); err != nil {
+		return false, err
+	}
+	if _, err := applyQAN(old.UUID, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyQAN writes the QAN config of the instance or, if config is nil,
+// removes it. It returns true if the config file changed.
+func applyQAN(uuid string, config *qc.QAN) (bool, error) {
+	name := "qan-" + uuid
+	exists := pct.FileExists(pct.Basedir.ConfigFile(name))
+	if config == nil {
+		if !exists {
+			return false, nil
+		}
+		return true, pct.Basedir.RemoveConfig(name)
+	}
+	qan := *config
+	qan.UUID = uuid
+	if exists {
+		current := qc.QAN{}
+		if _, err := pct.Basedir.ReadConfig(name, &current); err == nil && reflect.DeepEqual(current, qan) {
+			return false, nil
+		}
+	}
+	return true, pct.Basedir.WriteConfig(name, qan)
+}
+
+// match returns the existing instance with the declared UUID or, if it's
+// not set, with the declared name.
+func match(declared Instance, existing []proto.Instance) (proto.Instance, bool) {
+	for _, in := range existing {
+		if declared.UUID != "" && in.UUID == declared.UUID {
+			return in, true
+		}
+		if declared.UUID == "" && in.Name == declared.Name {
+			return in, true
+		}
+	}
+	return proto.Instance{}, false
+}
+
+func validSubsystem(subsystem string) bool {
+	for _, s := range Subsystems {
+		if s == subsystem {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/instance"
+	"github.com/percona/qan-agent/pct"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/percona/qan-agent/test/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setup(t *testing.T) (string, *instance.Repo) {
+	tmpDir, err := ioutil.TempDir("", "manifest-test")
+	require.NoError(t, err)
+	require.NoError(t, pct.Basedir.Init(tmpDir))
+	repo := instance.NewRepo(pct.NewLogger(make(chan proto.LogEntry, 100), "manifest-test"), pct.Basedir.Dir("instance"), nil)
+	require.NoError(t, repo.Init())
+	return tmpDir, repo
+}
+
+func newManifest() *Manifest {
+	interval := uint(60)
+	return &Manifest{
+		Agent:    pc.Agent{ApiHostname: "localhost", ApiPath: "/qan-api"},
+		Hostname: "db-host",
+		Instances: []Instance{
+			{Subsystem: "mysql", Name: "db1", DSN: "root@unix(/var/run/mysqld/mysqld.sock)/", QAN: &qc.QAN{QAN: pc.QAN{CollectFrom: "perfschema", Interval: interval}}},
+			{Subsystem: "mongo", Name: "m1", DSN: "localhost:27017"},
+		},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "manifest-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	file := filepath.Join(tmpDir, "manifest.json")
+
+	m, err := Load(file)
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"Agent":{"ApiHostname":"localhost"},"Instances":[{"Subsystem":"mysql","Name":"db1","DSN":"root@/"}]}`), 0600))
+	m, err = Load(file)
+	require.NoError(t, err)
+	assert.Equal(t, "db1", m.Instances[0].Name)
+
+	invalid := []string{
+		`{"Instances":[]}`,
+		`{"Agent":{"ApiHostname":"localhost"},"Instances":[{"Subsystem":"postgres","Name":"db1","DSN":"x"}]}`,
+		`{"Agent":{"ApiHostname":"localhost"},"Instances":[{"Subsystem":"mysql","DSN":"x"}]}`,
+		`{"Agent":{"ApiHostname":"localhost"},"Instances":[{"Subsystem":"mysql","Name":"db1","DSN":"x"},{"Subsystem":"mysql","Name":"db1","DSN":"y"}]}`,
+		`{"Agent":{"ApiHostname":"localhost"},"Instances":[{"Subsystem":"mysql","UUID":"1","Name":"db1","DSN":"x"},{"Subsystem":"mongo","UUID":"1","Name":"db1","DSN":"y"}]}`,
+		`not json`,
+	}
+	for _, data := range invalid {
+		require.NoError(t, ioutil.WriteFile(file, []byte(data), 0600))
+		_, err = Load(file)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tmpDir, repo := setup(t)
+	defer os.RemoveAll(tmpDir)
+
+	m := newManifest()
+	changes, err := m.Apply(repo)
+	require.NoError(t, err)
+	assert.Len(t, changes, 5) // agent config, os, mysql, QAN config, mongo
+
+	agentConfig := pc.Agent{}
+	_, err = pct.Basedir.ReadConfig("agent", &agentConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", agentConfig.ApiHostname)
+	assert.NotEmpty(t, agentConfig.UUID)
+
+	oses := repo.List("os")
+	require.Len(t, oses, 1)
+	assert.Equal(t, "db-host", oses[0].Name)
+	mysqls := repo.List("mysql")
+	require.Len(t, mysqls, 1)
+	assert.Equal(t, "db1", mysqls[0].Name)
+	assert.Equal(t, oses[0].UUID, mysqls[0].ParentUUID)
+	assert.True(t, pct.FileExists(pct.Basedir.InstanceFile(mysqls[0].UUID)))
+	qan := qc.QAN{}
+	_, err = pct.Basedir.ReadConfig("qan-"+mysqls[0].UUID, &qan)
+	require.NoError(t, err)
+	assert.Equal(t, qc.QAN{QAN: pc.QAN{UUID: mysqls[0].UUID, CollectFrom: "perfschema", Interval: 60}}, qan)
+	mongos := repo.List("mongo")
+	require.Len(t, mongos, 1)
+	assert.False(t, pct.FileExists(pct.Basedir.ConfigFile("qan-"+mongos[0].UUID)))
+
+	// Applying it again changes nothing, UUIDs are kept.
+	changes, err = m.Apply(repo)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, mysqls, repo.List("mysql"))
+
+	// Remove the mongo instance, stop QAN and change the DSN of the mysql one.
+	m.Instances = m.Instances[:1]
+	m.Instances[0].DSN = "root@tcp(127.0.0.1:3306)/"
+	m.Instances[0].QAN = nil
+	changes, err = m.Apply(repo)
+	require.NoError(t, err)
+	assert.Len(t, changes, 3)
+	assert.Empty(t, repo.List("mongo"))
+	assert.False(t, pct.FileExists(pct.Basedir.InstanceFile(mongos[0].UUID)))
+	mysql, err := repo.Get(mysqls[0].UUID, false)
+	require.NoError(t, err)
+	assert.Equal(t, "root@tcp(127.0.0.1:3306)/", mysql.DSN)
+	assert.False(t, pct.FileExists(pct.Basedir.ConfigFile("qan-"+mysql.UUID)))
+
+	// The agent UUID is kept unless the manifest sets it.
+	kept := pc.Agent{}
+	_, err = pct.Basedir.ReadConfig("agent", &kept)
+	require.NoError(t, err)
+	assert.Equal(t, agentConfig.UUID, kept.UUID)
+}
+
+func TestRegister(t *testing.T) {
+	tmpDir, repo := setup(t)
+	defer os.RemoveAll(tmpDir)
+
+	m := newManifest()
+	_, err := m.Apply(repo)
+	require.NoError(t, err)
+	oldUUID := repo.List("mysql")[0].UUID
+
+	// The API has the agent and mysql instance, e.g. the image was
+	// registered before, so their UUIDs are used.
+	api := mock.NewAPI("http://localhost", "http://localhost", "", nil)
+	api.Existing = map[string]proto.Instance{
+		"agent/db-host": {UUID: "a1", Links: map[string]string{"self": "http://localhost/agents/a1"}},
+		"mysql/db1":     {UUID: "313"},
+	}
+	changed, err := m.Register(api, repo)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	require.Len(t, api.Created, 4) // os, agent, mysql, mongo
+
+	agentConfig := pc.Agent{}
+	_, err = pct.Basedir.ReadConfig("agent", &agentConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "a1", agentConfig.UUID)
+	assert.Equal(t, "http://localhost/agents/a1", agentConfig.Links["self"])
+
+	mysqls := repo.List("mysql")
+	require.Len(t, mysqls, 1)
+	assert.Equal(t, "313", mysqls[0].UUID)
+	assert.False(t, pct.FileExists(pct.Basedir.InstanceFile(oldUUID)))
+	assert.False(t, pct.FileExists(pct.Basedir.ConfigFile("qan-"+oldUUID)))
+	qan := qc.QAN{}
+	_, err = pct.Basedir.ReadConfig("qan-313", &qan)
+	require.NoError(t, err)
+	assert.Equal(t, "313", qan.UUID)
+
+	// The next startup keeps the registered UUIDs.
+	changes, err := m.Apply(repo)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	changed, err = m.Register(api, repo)
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
@@ -0,0 +1,95 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/pct/sdnotify"
+)
+
+// Alive returns an error if the cmd handler or a service, e.g. a QAN
+// analyzer, doesn't respond before the ctx is done, or if a cmd is still
+// running MaxCmdOverrun after it timed out.
+func (agent *Agent) Alive(ctx context.Context) error {
+	select {
+	case agent.pingChan <- struct{}{}: // to cmdHandler
+	case <-ctx.Done():
+		return fmt.Errorf("cmd handler is not responding: %s", agent.status.Get("agent-cmd-handler"))
+	}
+	agent.cmdsStartedMux.Lock()
+	for cmd, started := range agent.cmdsStarted {
+		if d := time.Now().UTC().Sub(started); d > cmdTimeout(cmd)+MaxCmdOverrun {
+			agent.cmdsStartedMux.Unlock()
+			return fmt.Errorf("cmd %s has been running for %s", cmd, d)
+		}
+	}
+	agent.cmdsStartedMux.Unlock()
+	for service, manager := range agent.services {
+		if lc, ok := manager.(pct.LivenessChecker); ok {
+			if err := lc.Alive(ctx); err != nil {
+				return fmt.Errorf("%s: %s", service, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Notify keeps the supervisor, i.e. systemd, informed until stopChan is
+// closed: the agent status is sent as STATUS= when it changes and, if the
+// watchdog interval isn't zero, WATCHDOG=1 is sent at least three times per
+// interval while the agent is alive, so a wedged agent is restarted.
+func (agent *Agent) Notify(n *sdnotify.Notifier, watchdog time.Duration, stopChan <-chan struct{}) {
+	period := NOTIFY_PERIOD
+	if watchdog > 0 && watchdog/3 < period {
+		period = watchdog / 3
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	lastStatus := ""
+	for {
+		if status := agent.status.Get("agent"); status != lastStatus {
+			if err := n.Status(status); err != nil {
+				agent.logger.Warn("Cannot notify status: ", err)
+			}
+			lastStatus = status
+		}
+
+		if watchdog > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			err := agent.Alive(ctx)
+			cancel()
+			if err != nil {
+				// Don't ping, let the watchdog restart the agent.
+				agent.logger.Error("Agent is not alive: ", err)
+			} else if err := n.Notify(sdnotify.Watchdog); err != nil {
+				agent.logger.Warn("Cannot notify watchdog: ", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return
+		}
+	}
+}
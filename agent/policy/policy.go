@@ -0,0 +1,183 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package policy allows or denies the commands received by the agent
+// according to a local policy file, so a remote UI can be prevented from
+// changing the agent or the databases it monitors.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Presets
+const (
+	ReadOnly = "read-only"
+)
+
+// A Rule allows or denies the commands it matches. Service and Cmd are
+// patterns like "Get*", empty matches any.
+type Rule struct {
+	Service    string `json:",omitempty"`
+	Cmd        string `json:",omitempty"`
+	Action     string // Allow or Deny
+	SelectOnly bool   `json:",omitempty"` // match only if the cmd data is a proto.ExplainQuery of a SELECT
+}
+
+// A Policy is the rules, checked in order: the first that matches a command
+// allows or denies it. Rules of the preset are checked after Rules.
+type Policy struct {
+	Preset  string `json:",omitempty"` // ReadOnly or empty
+	Default string `json:",omitempty"` // Allow (default) or Deny commands that no rule matches, Deny if Preset is ReadOnly
+	Rules   []Rule `json:",omitempty"`
+	// --
+	rules  []Rule
+	action string
+}
+
+// readOnlyRules allow status, config reads and EXPLAIN of SELECTs.
+var readOnlyRules = []Rule{
+	{Cmd: "Status", Action: Allow},
+	{Cmd: "GetConfig", Action: Allow},
+	{Cmd: "GetAllConfigs", Action: Allow},
+	{Cmd: "GetDefaults", Action: Allow},
+	{Service: "agent", Cmd: "Version", Action: Allow},
+	{Service: "agent", Cmd: "GetAuditLog", Action: Allow},
+	{Service: "query", Cmd: "Explain", Action: Allow, SelectOnly: true},
+	{Service: "query", Cmd: "ExplainDigest", Action: Allow, SelectOnly: true},
+	{Service: "query", Cmd: "ExplainTree", Action: Allow, SelectOnly: true},
+}
+
+// Leading comments, whitespace and parentheses before the first keyword.
+var selectRe = regexp.MustCompile(`(?is)^(?:\s+|\(|/\*.*?\*/|(?:--|#)[^\n]*\n)*select\b`)
+
+// Load reads the policy file. If it doesn't exist, the policy is nil which
+// allows all commands.
+func Load(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if err := p.Init(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return p, nil
+}
+
+// Init validates the policy and applies its preset. It must be called
+// before Check unless the policy is loaded.
+func (p *Policy) Init() error {
+	p.rules = append([]Rule{}, p.Rules...)
+	p.action = Allow
+	switch p.Preset {
+	case "":
+	case ReadOnly:
+		p.rules = append(p.rules, readOnlyRules...)
+		p.action = Deny
+	default:
+		return fmt.Errorf("invalid Preset: %s; expected '%s' or empty", p.Preset, ReadOnly)
+	}
+	switch p.Default {
+	case "":
+	case Allow, Deny:
+		p.action = p.Default
+	default:
+		return fmt.Errorf("invalid Default: %s; expected '%s' or '%s'", p.Default, Allow, Deny)
+	}
+	for i, r := range p.Rules {
+		if r.Action != Allow && r.Action != Deny {
+			return fmt.Errorf("rule %d: invalid Action: %s; expected '%s' or '%s'", i+1, r.Action, Allow, Deny)
+		}
+		for _, pattern := range []string{r.Service, r.Cmd} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid pattern: %s", i+1, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns a pct.CmdRejectedError if the policy denies the cmd, else nil.
+// A nil policy allows all commands.
+func (p *Policy) Check(cmd *proto.Cmd) error {
+	if p == nil {
+		return nil
+	}
+	action := p.action
+	for _, r := range p.rules {
+		if r.match(cmd) {
+			action = r.Action
+			break
+		}
+	}
+	if action == Allow {
+		return nil
+	}
+	return pct.CmdRejectedError{
+		Cmd:    cmd.Cmd,
+		Reason: fmt.Sprintf("the local agent policy denies it for service %s", cmd.Service),
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (r Rule) match(cmd *proto.Cmd) bool {
+	if !matchPattern(r.Service, cmd.Service) || !matchPattern(r.Cmd, cmd.Cmd) {
+		return false
+	}
+	if r.SelectOnly {
+		q := proto.ExplainQuery{}
+		if err := json.Unmarshal(cmd.Data, &q); err != nil {
+			return false
+		}
+		return IsSelect(q.Query)
+	}
+	return true
+}
+
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// IsSelect returns true if the query is a SELECT, ignoring leading comments.
+func IsSelect(query string) bool {
+	return selectRe.MatchString(query)
+}
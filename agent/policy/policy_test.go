@@ -0,0 +1,145 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package policy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func explainCmd(t *testing.T, query string) *proto.Cmd {
+	data, err := json.Marshal(proto.ExplainQuery{UUID: "313", Db: "test", Query: query})
+	require.NoError(t, err)
+	return &proto.Cmd{Service: "query", Cmd: "Explain", Data: data}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var p *Policy
+	assert.NoError(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "Restart"}))
+}
+
+func TestReadOnly(t *testing.T) {
+	p := &Policy{Preset: ReadOnly}
+	require.NoError(t, p.Init())
+
+	allowed := []*proto.Cmd{
+		{Service: "agent", Cmd: "Status"},
+		{Service: "qan", Cmd: "Status"},
+		{Service: "agent", Cmd: "GetAllConfigs"},
+		{Service: "qan", Cmd: "GetConfig"},
+		{Service: "agent", Cmd: "Version"},
+		explainCmd(t, "SELECT * FROM t WHERE id = 1"),
+		explainCmd(t, "/* app.go:10 */ (select 1)"),
+		explainCmd(t, "-- comment\n  SELECT 1"),
+	}
+	for _, cmd := range allowed {
+		assert.NoError(t, p.Check(cmd), "%s %s %s", cmd.Service, cmd.Cmd, cmd.Data)
+	}
+
+	denied := []*proto.Cmd{
+		{Service: "agent", Cmd: "Restart"},
+		{Service: "agent", Cmd: "Stop"},
+		{Service: "agent", Cmd: "SetConfig"},
+		{Service: "agent", Cmd: "StopService"},
+		{Service: "qan", Cmd: "StartTool"},
+		{Service: "data", Cmd: "SetConfig"},
+		{Service: "query", Cmd: "KillQuery"},
+		{Service: "query", Cmd: "ExplainAnalyze"},
+		{Service: "query", Cmd: "Explain", Data: []byte("not json")},
+		explainCmd(t, "DELETE FROM t WHERE id = 1"),
+		explainCmd(t, "/* SELECT */ UPDATE t SET c = 1"),
+		explainCmd(t, "selectivity"),
+	}
+	for _, cmd := range denied {
+		err := p.Check(cmd)
+		assert.IsType(t, pct.CmdRejectedError{}, err, "%s %s %s", cmd.Service, cmd.Cmd, cmd.Data)
+	}
+	assert.Equal(t,
+		"Restart command rejected because the local agent policy denies it for service agent",
+		p.Check(&proto.Cmd{Service: "agent", Cmd: "Restart"}).Error(),
+	)
+}
+
+func TestRules(t *testing.T) {
+	// Rules are checked before the preset, the first match wins.
+	p := &Policy{
+		Preset: ReadOnly,
+		Rules: []Rule{
+			{Service: "qan", Cmd: "GetConfig", Action: Deny},
+			{Service: "qan", Action: Allow},
+			{Cmd: "Get*Summary", Action: Allow},
+		},
+	}
+	require.NoError(t, p.Init())
+	assert.Error(t, p.Check(&proto.Cmd{Service: "qan", Cmd: "GetConfig"}))
+	assert.NoError(t, p.Check(&proto.Cmd{Service: "qan", Cmd: "StartTool"}))
+	assert.NoError(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "GetMySQLSummary"}))
+	assert.Error(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "SetConfig"}))
+
+	// Deny only some commands.
+	p = &Policy{
+		Rules: []Rule{
+			{Service: "agent", Cmd: "Restart", Action: Deny},
+			{Service: "query", Cmd: "KillQuery", Action: Deny},
+		},
+	}
+	require.NoError(t, p.Init())
+	assert.Error(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "Restart"}))
+	assert.Error(t, p.Check(&proto.Cmd{Service: "query", Cmd: "KillQuery"}))
+	assert.NoError(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "SetConfig"}))
+
+	// Invalid policies.
+	for _, p := range []*Policy{
+		{Preset: "read-write"},
+		{Default: "maybe"},
+		{Rules: []Rule{{Cmd: "Stop"}}},
+		{Rules: []Rule{{Cmd: "[", Action: Deny}}},
+	} {
+		assert.Error(t, p.Init())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "policy.json")
+	p, err := Load(file)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"Default":"deny","Rules":[{"Cmd":"Status","Action":"allow"}]}`), 0600))
+	p, err = Load(file)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	assert.NoError(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "Status"}))
+	assert.Error(t, p.Check(&proto.Cmd{Service: "agent", Cmd: "GetConfig"}))
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"Preset":"none"}`), 0600))
+	_, err = Load(file)
+	assert.Error(t, err)
+}
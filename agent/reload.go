@@ -0,0 +1,96 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/pct"
+)
+
+// RELOAD_ORDER lists services which are reloaded before others: instances
+// first because QAN analyzers are restarted if their instances changed.
+var RELOAD_ORDER = []string{"instance", "log", "data", "qan"}
+
+// Reload applies changes to config and instance files made outside the agent,
+// e.g. by configuration management tools like Ansible or Puppet, through the
+// services which implement pct.Reloader. Files are not written back, so
+// changes which cannot be applied while running take effect on restart.
+func (agent *Agent) Reload() []error {
+	agent.logger.Info("Reloading config")
+
+	errs := []error{}
+	if err := agent.reloadConfig(); err != nil {
+		errs = append(errs, err)
+	}
+
+	others := []string{}
+	for name := range agent.services {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	names := append(append([]string{}, RELOAD_ORDER...), others...)
+	reloaded := map[string]bool{}
+	for _, name := range names {
+		r, ok := agent.services[name].(pct.Reloader)
+		if !ok || reloaded[name] {
+			continue
+		}
+		reloaded[name] = true
+		for _, err := range r.Reload() {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+
+	agent.logger.Info("Reloaded config")
+	return errs
+}
+
+func (agent *Agent) reloadConfig() error {
+	newConfig := &pc.Agent{}
+	if _, err := pct.Basedir.ReadConfig("agent", newConfig); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if newConfig.Keepalive == 0 {
+		newConfig.Keepalive = DEFAULT_KEEPALIVE
+	}
+
+	agent.configMux.Lock()
+	defer agent.configMux.Unlock()
+
+	// Links are internal only, from the API.
+	running := *agent.config
+	running.Links = nil
+	newConfig.Links = nil
+	if reflect.DeepEqual(running, *newConfig) {
+		return nil
+	}
+
+	// Like SetConfig, only keepalive is changed, and it's not dynamic either.
+	agent.logger.Warn("Agent config changed; restart agent to take effect")
+	if newConfig.Keepalive != running.Keepalive {
+		finalConfig := *agent.config // copy current config
+		finalConfig.Keepalive = newConfig.Keepalive
+		agent.config = &finalConfig
+	}
+	return nil
+}
@@ -20,9 +20,7 @@ package installer
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
 
-	"github.com/nu7hatch/gouuid"
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/agent/release"
@@ -49,15 +47,6 @@ type Installer struct {
 	debug bool
 }
 
-func newUUID() string {
-	u4, err := uuid.NewV4()
-	if err != nil {
-		fmt.Printf("Could not create UUID4: %v", err)
-		return ""
-	}
-	return strings.Replace(u4.String(), "-", "", -1)
-}
-
 func NewInstaller(basedir string, api pct.APIConnector, instanceRepo *instance.Repo, agentConfig *pc.Agent, hostname string, flags Flags) (*Installer, error) {
 	installer := &Installer{
 		basedir:      basedir,
@@ -101,7 +90,7 @@ func (i *Installer) Run() (err error) {
 func (i *Installer) CreateOSInstance() error {
 	i.os = &proto.Instance{
 		Subsystem: "os",
-		UUID:      newUUID(),
+		UUID:      pct.NewUUID(),
 		Name:      i.hostname,
 	}
 	created, err := i.api.CreateInstance("/instances", i.os)
@@ -126,7 +115,7 @@ func (i *Installer) CreateOSInstance() error {
 func (i *Installer) CreateAgent() error {
 	i.agent = &proto.Instance{
 		Subsystem:  "agent",
-		UUID:       newUUID(),
+		UUID:       pct.NewUUID(),
 		ParentUUID: i.os.UUID,
 		Name:       i.os.Name,
 		Version:    release.VERSION,
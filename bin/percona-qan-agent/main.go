@@ -26,33 +26,44 @@ import (
 	"os/signal"
 	"os/user"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/agent"
+	"github.com/percona/qan-agent/agent/manifest"
+	"github.com/percona/qan-agent/agent/policy"
 	"github.com/percona/qan-agent/agent/release"
 	"github.com/percona/qan-agent/client"
 	"github.com/percona/qan-agent/data"
+	"github.com/percona/qan-agent/discovery"
 	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/log"
 	"github.com/percona/qan-agent/mrms"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	pctCmd "github.com/percona/qan-agent/pct/cmd"
+	"github.com/percona/qan-agent/pct/sdnotify"
+	"github.com/percona/qan-agent/pct/watch"
 	"github.com/percona/qan-agent/qan"
 	qanAnalyzerFactory "github.com/percona/qan-agent/qan/analyzer/factory"
 	"github.com/percona/qan-agent/query"
 	"github.com/percona/qan-agent/ticker"
 )
 
+// RELOAD_DELAY is how long config files must stay unchanged before they're
+// reloaded, so files written in several steps are reloaded once.
+const RELOAD_DELAY = 1 * time.Second
+
 var (
-	flagBasedir string
-	flagPidFile string
-	flagListen  string
-	flagPing    bool
-	flagVersion bool
+	flagBasedir  string
+	flagManifest string
+	flagPidFile  string
+	flagListen   string
+	flagPing     bool
+	flagVersion  bool
 )
 
 func init() {
@@ -69,6 +80,7 @@ func init() {
 	flag.StringVar(&flagPidFile, "pid-file", "", "PID file")
 
 	flag.StringVar(&flagBasedir, "basedir", pct.DEFAULT_BASEDIR, "Agent basedir")
+	flag.StringVar(&flagManifest, "manifest", "", "Agent manifest file (default basedir/"+pct.MANIFEST_FILE+")")
 	flag.StringVar(&flagListen, "listen", agent.DEFAULT_LISTEN, "Agent interface address")
 	flag.BoolVar(&flagPing, "ping", false, "Ping API")
 	flag.BoolVar(&flagVersion, "version", false, "Print version")
@@ -98,22 +110,61 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Read agent.conf to get API hostname and agent UUID.
-	agentConfigFile := pct.Basedir.ConfigFile("agent")
-	if !pct.FileExists(agentConfigFile) {
-		fmt.Printf("Agent config file %s does not exist\n", agentConfigFile)
-		os.Exit(1)
+	// Load the manifest, if any. It's applied only to run the agent, below.
+	manifestFile := flagManifest
+	if manifestFile == "" {
+		manifestFile = pct.Basedir.File("manifest")
 	}
-
-	bytes, err := agent.LoadConfig()
+	agentManifest, err := manifest.Load(manifestFile)
 	if err != nil {
-		fmt.Printf("Error reading agent config file %s: %s\n", agentConfigFile, err)
+		fmt.Printf("Error loading manifest: %s\n", err)
 		os.Exit(1)
 	}
+
+	// Apply the manifest, unless only pinging. It writes agent.conf, so it
+	// replaces the installer, and the instance files and QAN configs.
+	var manifestRepo *instance.Repo
+	if agentManifest != nil && !flagPing {
+		manifestRepo = instance.NewRepo(
+			pct.NewLogger(make(chan proto.LogEntry, 100), "manifest"),
+			pct.Basedir.Dir("instance"),
+			nil,
+		)
+		if err := manifestRepo.Init(); err != nil {
+			fmt.Printf("Error loading instances: %s\n", err)
+			os.Exit(1)
+		}
+		changes, err := agentManifest.Apply(manifestRepo)
+		if err != nil {
+			fmt.Printf("Error applying manifest %s: %s\n", manifestFile, err)
+			os.Exit(1)
+		}
+		for _, change := range changes {
+			fmt.Printf("Manifest %s: %s\n", manifestFile, change)
+		}
+	}
+
+	// Read agent.conf to get API hostname and agent UUID. To ping with a
+	// manifest, use its agent config: agent.conf may not be written yet.
 	agentConfig := &pc.Agent{}
-	if err := json.Unmarshal(bytes, agentConfig); err != nil {
-		fmt.Printf("Error decoding agent config file %s: %s\n", agentConfigFile, err)
-		os.Exit(1)
+	if agentManifest != nil && flagPing {
+		*agentConfig = agentManifest.Agent
+	} else {
+		agentConfigFile := pct.Basedir.ConfigFile("agent")
+		if !pct.FileExists(agentConfigFile) {
+			fmt.Printf("Agent config file %s does not exist\n", agentConfigFile)
+			os.Exit(1)
+		}
+
+		bytes, err := agent.LoadConfig()
+		if err != nil {
+			fmt.Printf("Error reading agent config file %s: %s\n", agentConfigFile, err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(bytes, agentConfig); err != nil {
+			fmt.Printf("Error decoding agent config file %s: %s\n", agentConfigFile, err)
+			os.Exit(1)
+		}
 	}
 
 	apiURL := agentConfig.ApiHostname + agentConfig.ApiPath
@@ -123,6 +174,9 @@ func main() {
 	fmt.Printf("# PID:     %d\n", os.Getpid())
 	fmt.Printf("# API:     %s\n", apiURL)
 	fmt.Printf("# UUID:    %s\n", agentConfig.UUID)
+	if agentManifest != nil {
+		fmt.Printf("# Manifest: %s\n", manifestFile)
+	}
 
 	// -ping and exit.
 	if flagPing {
@@ -141,7 +195,19 @@ func main() {
 	// Run the agent
 	// //////////////////////////////////////////////////////////////////////
 
-	err = run(agentConfig) // run the agent
+	err = run(agentConfig, manifestFile, agentManifest, manifestRepo) // run the agent
+
+	if err == agent.ErrRestart {
+		// Let the supervisor restart us, else it would lose track of the
+		// agent, e.g. systemd expects a new READY=1.
+		if pct.Supervised() {
+			golog.Println("Exiting to be restarted by the supervisor")
+			os.Exit(pct.RESTART_EXIT_STATUS)
+		}
+		golog.Println("Restarting agent")
+		err = pct.Restart() // returns only on error
+		err = fmt.Errorf("cannot restart agent: %s", err)
+	}
 
 	if err != nil {
 		golog.Println(err)
@@ -149,7 +215,7 @@ func main() {
 	}
 }
 
-func run(agentConfig *pc.Agent) error {
+func run(agentConfig *pc.Agent, manifestFile string, agentManifest *manifest.Manifest, manifestRepo *instance.Repo) error {
 	golog.Println("Starting agent...")
 	var stopErr error
 	defer func() {
@@ -172,10 +238,41 @@ func run(agentConfig *pc.Agent) error {
 	// The API interface provides low-level functionality to websocket clients.
 	// To be useful, it must connect once to get resource links. Do this async
 	// so in case we're offline the agent still starts and collects data. We
-	// can spool and send data later when API is online.
+	// can spool and send data later when API is online. With a manifest, the
+	// agent and its instances are registered first, which may change their
+	// UUIDs, so the files are reloaded, or the agent is restarted if its own
+	// UUID changed because everything running uses it.
 	api := pct.NewAPI(agentConfig.ServerUser, agentConfig.ServerPassword, agentConfig.ServerSSL, agentConfig.ServerInsecureSSL)
+	registeredChan := make(chan string, 1) // agent UUID
 	go func() {
 		haveWarned := false
+		for agentManifest != nil {
+			changed, err := register(api, agentConfig, agentManifest, manifestRepo)
+			if err != nil {
+				if !haveWarned {
+					golog.Printf("Cannot register with API: %s. Registration"+
+						" attempts will continue until successful, but additional"+
+						" errors will not be logged.", err)
+					haveWarned = true
+				}
+				time.Sleep(3 * time.Second)
+				continue
+			}
+			golog.Println("Registered with API")
+			if changed {
+				registered := &pc.Agent{}
+				if _, err := pct.Basedir.ReadConfig("agent", registered); err != nil {
+					golog.Printf("Cannot read agent config: %s", err)
+					registered.UUID = agentConfig.UUID
+				}
+				registeredChan <- registered.UUID
+				if registered.UUID != agentConfig.UUID {
+					return // agent restarts
+				}
+			}
+			haveWarned = false
+			break
+		}
 		for {
 			if err := api.Connect(agentConfig.ApiHostname, agentConfig.ApiPath, agentConfig.UUID); err != nil {
 				if !haveWarned {
@@ -238,6 +335,21 @@ func run(agentConfig *pc.Agent) error {
 		return fmt.Errorf("error starting instance manager: %s", err)
 	}
 
+	// Discovery of local MySQL and MongoDB servers
+	discoveryManager := discovery.NewManager(
+		pct.NewLogger(logChan, "discovery"),
+		"/proc",
+		itManager.Repo(),
+		api,
+		pct.Basedir.File("credentials"),
+	)
+	if agentManifest != nil {
+		discoveryManager.SetManifest(manifestFile)
+	}
+	if err := discoveryManager.Start(); err != nil {
+		return fmt.Errorf("error starting discovery manager: %s", err)
+	}
+
 	// Data spooler and sender
 	hostname, _ := os.Hostname()
 	dataClient, err := client.NewWebsocketClient(pct.NewLogger(logChan, "data-ws"), api, "data", nil)
@@ -255,11 +367,18 @@ func run(agentConfig *pc.Agent) error {
 		return fmt.Errorf("error starting data manager: %s", err)
 	}
 
+	// Local policy which allows or denies commands, if any.
+	cmdPolicy, err := policy.Load(pct.Basedir.File("policy"))
+	if err != nil {
+		return fmt.Errorf("error loading policy: %s", err)
+	}
+
 	// Query (real-time EXPLAIN, SHOW CREATE TABLE, etc.)
 	queryManager := query.NewManager(
 		pct.NewLogger(logChan, "query"),
 		itManager.Repo(),
 	)
+	queryManager.SetPolicy(cmdPolicy)
 	if err := queryManager.Start(); err != nil {
 		return fmt.Errorf("error starting query manager: %s", err)
 	}
@@ -295,14 +414,17 @@ func run(agentConfig *pc.Agent) error {
 		cmdClient,
 		flagListen,
 		map[string]pct.ServiceManager{ // agent services
-			"log":      logManager,
-			"data":     dataManager,
-			"qan":      qanManager,
-			"instance": itManager,
-			"mrms":     mrmsManager,
-			"query":    queryManager,
+			"log":       logManager,
+			"data":      dataManager,
+			"qan":       qanManager,
+			"instance":  itManager,
+			"mrms":      mrmsManager,
+			"query":     queryManager,
+			"discovery": discoveryManager,
 		},
 	)
+	agentRouter.SetPolicy(cmdPolicy)
+	agentRouter.SetRepo(itManager.Repo())
 
 	// Run the agent, wait for it to stop, signal, or crash.
 	stopChan := make(chan error, 2)
@@ -320,6 +442,17 @@ func run(agentConfig *pc.Agent) error {
 
 	golog.Println("Agent is ready")
 
+	// Tell systemd we're ready if it runs the agent as a Type=notify service,
+	// then keep it informed: status and, if enabled, watchdog pings.
+	notifier := sdnotify.New()
+	notifyStopChan := make(chan struct{})
+	if notifier != nil {
+		if err := notifier.Notify(sdnotify.Ready); err != nil {
+			golog.Printf("Cannot notify systemd: %s", err)
+		}
+		go agentRouter.Notify(notifier, sdnotify.WatchdogInterval(), notifyStopChan)
+	}
+
 	// //////////////////////////////////////////////////////////////////////
 	// Signal handlers
 	// //////////////////////////////////////////////////////////////////////
@@ -327,12 +460,40 @@ func run(agentConfig *pc.Agent) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	reconnectSigChan := make(chan os.Signal, 1)
-	signal.Notify(reconnectSigChan, syscall.SIGHUP) // kill -HUP PID
+	reloadSigChan := make(chan os.Signal, 1)
+	signal.Notify(reloadSigChan, syscall.SIGHUP) // kill -HUP PID
 
 	intSigChan := make(chan os.Signal, 1)
 	signal.Notify(intSigChan, syscall.SIGINT) // CTRL-C
 
+	// //////////////////////////////////////////////////////////////////////
+	// Config reload
+	// //////////////////////////////////////////////////////////////////////
+
+	// Reload config and instance files when they change, e.g. when written by
+	// Ansible or Puppet. Files written by the agent itself are reloaded too,
+	// but that's a no-op because they match what's running.
+	var watchChan <-chan string
+	watcher, err := watch.New(pct.Basedir.Dir("config"), pct.Basedir.Dir("instance"))
+	if err != nil {
+		golog.Printf("Cannot watch config files, send SIGHUP to reload them: %s", err)
+	} else {
+		defer watcher.Close()
+		watchChan = watcher.Events()
+	}
+	reloadTimer := time.NewTimer(RELOAD_DELAY)
+	reloadTimer.Stop()
+
+	reload := func(why string) {
+		golog.Printf("Reloading config (%s)", why)
+		notifier.Notify(sdnotify.Reloading)
+		for _, err := range agentRouter.Reload() {
+			agentLogger.Error(err)
+			golog.Println(err)
+		}
+		notifier.Notify(sdnotify.Ready)
+	}
+
 	// //////////////////////////////////////////////////////////////////////
 	// Wait for agent stop, signals, etc.
 	// //////////////////////////////////////////////////////////////////////
@@ -353,7 +514,9 @@ SIGNAL_LOOP:
 			agentLogger.Warn(msg)
 			golog.Println(msg)
 			break SIGNAL_LOOP // stop running
-		case <-reconnectSigChan:
+		case <-reloadSigChan:
+			// Reload config then, like before reload was supported, reconnect.
+			reload("SIGHUP")
 			u, _ := user.Current()
 			cmd := &proto.Cmd{
 				Ts:        time.Now().UTC(),
@@ -363,9 +526,32 @@ SIGNAL_LOOP:
 				Cmd:       "Reconnect",
 			}
 			agentRouter.Handle(cmd)
+		case file, ok := <-watchChan:
+			if !ok {
+				watchChan = nil // watcher failed, only SIGHUP reloads
+				continue
+			}
+			// On overflow any file may have changed, so reload all of them.
+			if file == watch.OVERFLOW || strings.HasSuffix(file, pct.CONFIG_FILE_SUFFIX) || strings.HasSuffix(file, pct.INSTANCE_FILE_SUFFIX) {
+				reloadTimer.Reset(RELOAD_DELAY)
+			}
+		case <-reloadTimer.C:
+			reload("config files changed")
+		case agentUUID := <-registeredChan:
+			if agentUUID != agentConfig.UUID {
+				msg := fmt.Sprintf("Agent UUID changed to %s by registration, restarting", agentUUID)
+				agentLogger.Info(msg)
+				golog.Println(msg)
+				stopErr = agent.ErrRestart
+				break SIGNAL_LOOP
+			}
+			reload("registered with API")
 		}
 	}
 
+	close(notifyStopChan)
+	notifier.Notify(sdnotify.Stopping)
+
 	// //////////////////////////////////////////////////////////////////////
 	// Clean up, undo any changes we made to MySQL.
 	/////////////////////////////////////////////////////////////////////////
@@ -384,3 +570,16 @@ SIGNAL_LOOP:
 
 	return stopErr
 }
+
+// register registers the agent and the instances of the manifest with the
+// API if it's reachable. It returns true if it changed the config files.
+func register(api *pct.API, agentConfig *pc.Agent, agentManifest *manifest.Manifest, repo *instance.Repo) (bool, error) {
+	schema := "http"
+	if agentConfig.ServerSSL || agentConfig.ServerInsecureSSL {
+		schema = "https"
+	}
+	if _, err := api.Init(schema + "://" + agentConfig.ApiHostname + agentConfig.ApiPath); err != nil {
+		return false, err
+	}
+	return agentManifest.Register(api, repo)
+}
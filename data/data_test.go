@@ -1149,6 +1149,132 @@ func (s *ManagerTestSuite) TestSetConfig(t *C) {
 	assert.Equal(t, config, pcData)
 }
 
+func (s *ManagerTestSuite) TestReload(t *C) {
+	m := data.NewManager(s.logger, s.dataDir, s.trashDir, "localhost", s.client)
+	t.Assert(m, NotNil)
+
+	config := pc.Data{
+		Encoding:     "none",
+		SendInterval: 1,
+		Limits: pc.DataSpoolLimits{
+			MaxAge:   3,
+			MaxSize:  7,
+			MaxFiles: 17,
+		},
+	}
+	pct.Basedir.WriteConfig("data", &config)
+
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	// Change the config file outside the agent. Encoding and SendInterval
+	// are dynamic, Blackhole is not.
+	config.Encoding = "gzip"
+	config.SendInterval = 5
+	config.Blackhole = "true"
+	pct.Basedir.WriteConfig("data", &config)
+	content, err := ioutil.ReadFile(pct.Basedir.ConfigFile("data"))
+	t.Assert(err, IsNil)
+
+	test.DrainLogChan(s.logChan)
+	errs := m.Reload()
+	t.Check(errs, HasLen, 0)
+	var warnings []string
+	for len(s.logChan) > 0 {
+		if l := <-s.logChan; l.Level == proto.LOG_WARNING {
+			warnings = append(warnings, l.Msg)
+		}
+	}
+	t.Check(warnings, DeepEquals, []string{"Restart agent for all data config changes to take effect"})
+
+	gotConfig, errs := m.GetConfig()
+	t.Check(errs, HasLen, 0)
+	t.Assert(gotConfig, HasLen, 1)
+	t.Check(gotConfig[0].Set, Equals, string(content))
+	pcData := pc.Data{}
+	if err := json.Unmarshal([]byte(gotConfig[0].Running), &pcData); err != nil {
+		t.Fatal(err)
+	}
+	expect := config
+	expect.Blackhole = ""
+	assert.Equal(t, expect, pcData)
+
+	// The config file is not written back.
+	got, err := ioutil.ReadFile(pct.Basedir.ConfigFile("data"))
+	t.Assert(err, IsNil)
+	t.Check(string(got), Equals, string(content))
+
+	// Reloading the same file again doesn't repeat the restart warning.
+	test.DrainLogChan(s.logChan)
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	t.Check(s.logChan, HasLen, 0)
+}
+
+func (s *ManagerTestSuite) TestDryRunAndRollback(t *C) {
+	m := data.NewManager(s.logger, s.dataDir, s.trashDir, "localhost", s.client)
+	t.Assert(m, NotNil)
+
+	config := pc.Data{
+		Encoding:     "none",
+		SendInterval: 1,
+	}
+	pct.Basedir.WriteConfig("data", &config)
+
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+	running, _ := m.GetConfig()
+
+	// Dry run returns the diff and changes nothing.
+	cmd := &proto.Cmd{
+		User:    "daniel",
+		Service: "data",
+		Cmd:     "SetConfig",
+		Data:    []byte(`{"Encoding":"gzip","SendInterval":5,"DryRun":true}`),
+	}
+	reply := m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	diff := []pct.ConfigChange{}
+	err = json.Unmarshal(reply.Data, &diff)
+	t.Assert(err, IsNil)
+	t.Check(diff, DeepEquals, []pct.ConfigChange{
+		{Key: "Encoding", Old: "none", New: "gzip"},
+		{Key: "SendInterval", Old: float64(1), New: float64(5)},
+	})
+	got, _ := m.GetConfig()
+	t.Check(got, DeepEquals, running)
+
+	// Dry run validates the config.
+	cmd.Data = []byte(`{"Encoding":"bzip2","DryRun":true}`)
+	reply = m.Handle(cmd)
+	t.Check(reply.Error, Equals, "Invalid Encoding: 'bzip2', must be 'none' or 'gzip'")
+
+	// Set the config, then roll it back to the previous version.
+	cmd.Data = []byte(`{"Encoding":"gzip","SendInterval":5}`)
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+
+	cmd = &proto.Cmd{
+		User:    "daniel",
+		Service: "data",
+		Cmd:     "RollbackConfig",
+	}
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	gotConfig := pc.Data{}
+	err = json.Unmarshal(reply.Data, &gotConfig)
+	t.Assert(err, IsNil)
+	t.Check(gotConfig.Encoding, Equals, "none")
+	t.Check(gotConfig.SendInterval, Equals, uint(1))
+
+	// Only CONFIG_VERSIONS versions are kept.
+	cmd.Data = []byte(`{"Version":6}`)
+	reply = m.Handle(cmd)
+	t.Check(reply.Error, Equals, "invalid config version: 6, expected 1 to 5")
+}
+
 func (s *ManagerTestSuite) TestStatus(t *C) {
 	// Start a data manager.
 	m := data.NewManager(s.logger, s.dataDir, s.trashDir, "localhost", s.client)
@@ -45,14 +45,15 @@ type Manager struct {
 	hostname string
 	client   pct.WebsocketClient
 	// --
-	setConfig string
-	config    *pc.Data
-	running   bool
-	mux       *sync.Mutex // guards config and running
-	sz        proto.Serializer
-	spooler   Spooler
-	sender    *Sender
-	status    *pct.Status
+	setConfig  string
+	config     *pc.Data
+	diskConfig pc.Data // last config read from or written to disk
+	running    bool
+	mux        *sync.Mutex // guards config and running
+	sz         proto.Serializer
+	spooler    Spooler
+	sender     *Sender
+	status     *pct.Status
 }
 
 func NewManager(logger *pct.Logger, dataDir, trashDir, hostname string, client pct.WebsocketClient) *Manager {
@@ -140,6 +141,7 @@ func (m *Manager) Start() error {
 	m.sender = sender
 
 	m.config = config
+	m.diskConfig = *config
 	m.running = true
 
 	m.logger.Info("Started")
@@ -177,6 +179,9 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	case "SetConfig":
 		newConfig, errs := m.handleSetConfig(cmd)
 		return cmd.Reply(newConfig, errs...)
+	case "RollbackConfig":
+		newConfig, errs := m.handleRollbackConfig(cmd)
+		return cmd.Reply(newConfig, errs...)
 	default:
 		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 	}
@@ -264,6 +269,97 @@ func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
 
 	m.mux.Lock()
 	defer m.mux.Unlock()
+
+	// Dry run: return what would change.
+	if pct.GetSetConfigFlags(cmd).DryRun {
+		finalConfig, err := m.effectiveConfig(newConfig)
+		if err != nil {
+			return nil, []error{err}
+		}
+		diff, err := pct.DiffConfig(m.config, finalConfig)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return diff, nil
+	}
+
+	finalConfig, errs := m.applyConfig(newConfig)
+
+	// Write the new, updated config.  If this fails, agent will use old config if restarted.
+	if err := pct.Basedir.WriteConfig("data", finalConfig); err != nil {
+		errs = append(errs, errors.New("data.WriteConfig:"+err.Error()))
+	}
+
+	m.setConfig = string(cmd.Data)
+	m.config = finalConfig
+	m.diskConfig = *finalConfig
+
+	return m.config, errs
+}
+
+// handleRollbackConfig sets a previous version of the config.
+func (m *Manager) handleRollbackConfig(cmd *proto.Cmd) (interface{}, []error) {
+	r, err := pct.GetRollback(cmd)
+	if err != nil {
+		return nil, []error{err}
+	}
+	set, err := pct.Basedir.ReadConfigVersion("data", r.Version, &pc.Data{})
+	if err != nil {
+		return nil, []error{err}
+	}
+	setCmd := *cmd
+	setCmd.Data = []byte(set)
+	return m.handleSetConfig(&setCmd)
+}
+
+// Reload applies changes to the data config file. Blackhole and Limits take
+// effect on restart.
+func (m *Manager) Reload() []error {
+	newConfig := &pc.Data{}
+	set, err := pct.Basedir.ReadConfig("data", newConfig)
+	if err != nil && !os.IsNotExist(err) {
+		return []error{err}
+	}
+	if err := m.validateConfig(newConfig); err != nil {
+		return []error{err}
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	// Compare to the last config on disk, not the running config, else the
+	// non-dynamic changes would be reloaded, and warned about, every time.
+	if m.config == nil || m.diskConfig == *newConfig {
+		return nil
+	}
+	m.diskConfig = *newConfig
+
+	finalConfig, errs := m.applyConfig(newConfig)
+	if *finalConfig != *newConfig {
+		m.logger.Warn("Restart agent for all data config changes to take effect")
+	}
+
+	m.setConfig = set
+	m.config = finalConfig
+
+	m.logger.Info("Reloaded config")
+	return errs
+}
+
+// effectiveConfig returns the config which applying newConfig results in,
+// like applyConfig but without applying it.
+func (m *Manager) effectiveConfig(newConfig *pc.Data) (*pc.Data, error) {
+	// XXX Assume caller has locked m.mux.
+	finalConfig := *m.config // copy current config
+	finalConfig.SendInterval = newConfig.SendInterval
+	if _, err := makeSerializer(newConfig.Encoding); err != nil {
+		return nil, err
+	}
+	finalConfig.Encoding = newConfig.Encoding
+	return &finalConfig, nil
+}
+
+func (m *Manager) applyConfig(newConfig *pc.Data) (*pc.Data, []error) {
+	// XXX Assume caller has locked m.mux.
 	finalConfig := *m.config // copy current config
 
 	errs := []error{}
@@ -305,15 +401,7 @@ func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
 		}
 	}
 
-	// Write the new, updated config.  If this fails, agent will use old config if restarted.
-	if err := pct.Basedir.WriteConfig("data", finalConfig); err != nil {
-		errs = append(errs, errors.New("data.WriteConfig:"+err.Error()))
-	}
-
-	m.setConfig = string(cmd.Data)
-	m.config = &finalConfig
-
-	return m.config, errs
+	return &finalConfig, errs
 }
 
 func makeSerializer(encoding string) (proto.Serializer, error) {
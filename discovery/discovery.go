@@ -0,0 +1,295 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package discovery finds the local mysqld and mongod processes, reads their
+// command line and config files for their sockets, ports and datadirs, and
+// proposes instances for them. The manager rediscovers them periodically to
+// warn about servers which aren't monitored or disappeared, and creates their
+// instances if there are credentials for them.
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/percona/pmm/proto"
+)
+
+// A Process is a local process, from /proc.
+type Process struct {
+	PID  int
+	Args []string
+	// --
+	procDir string
+}
+
+// ReadFile reads the file as seen by the process, i.e. in its root which
+// differs if it runs in a container, or as seen by the agent if the process
+// root isn't readable.
+func (p Process) ReadFile(file string) ([]byte, error) {
+	if data, err := ioutil.ReadFile(p.rootPath(file)); err == nil {
+		return data, nil
+	}
+	return ioutil.ReadFile(file)
+}
+
+// ReadDir returns the sorted names of the files in the dir as seen by the
+// process, like ReadFile.
+func (p Process) ReadDir(dir string) []string {
+	if names := readDir(p.rootPath(dir)); names != nil {
+		return names
+	}
+	return readDir(dir)
+}
+
+func (p Process) rootPath(file string) string {
+	return filepath.Join(p.procDir, strconv.Itoa(p.PID), "root", file)
+}
+
+// Processes returns the processes in procDir, usually /proc, which have a
+// command line, i.e. not kernel threads.
+func Processes(procDir string) ([]Process, error) {
+	dirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	procs := []Process{}
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil || !dir.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(procDir, dir.Name(), "cmdline"))
+		if err != nil || len(data) == 0 {
+			continue // exited or kernel thread
+		}
+		args := strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+		procs = append(procs, Process{PID: pid, Args: args, procDir: procDir})
+	}
+	return procs, nil
+}
+
+// A Server is a local mysqld or mongod process.
+type Server struct {
+	Subsystem   string   // "mysql" or "mongo"
+	PID         int      // changes on restart
+	ConfigFiles []string `json:",omitempty"` // read, in order
+	Socket      string   `json:",omitempty"`
+	Host        string   `json:",omitempty"` // TCP address, empty if networking is disabled
+	Port        int      `json:",omitempty"`
+	Datadir     string   `json:",omitempty"`
+	// Instance is the proposed instance, with the username but not the
+	// password of the credentials, if any. UUID is the UUID of the instance
+	// in the repo if it's monitored.
+	Instance proto.Instance
+	UUID     string `json:",omitempty"`
+}
+
+// Key identifies the server across restarts.
+func (s Server) Key() string {
+	switch {
+	case s.Datadir != "":
+		return s.Subsystem + " " + s.Datadir
+	case s.Socket != "":
+		return s.Subsystem + " " + s.Socket
+	}
+	return s.Subsystem + " " + s.Host + ":" + strconv.Itoa(s.Port)
+}
+
+func (s Server) String() string {
+	addr := s.Socket
+	if addr == "" {
+		addr = net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	}
+	return fmt.Sprintf("%s %s (PID %d, %s)", s.Subsystem, s.Instance.Name, s.PID, addr)
+}
+
+// Discover returns the mysqld and mongod servers which run on this host,
+// sorted by key.
+func Discover(procDir string) ([]Server, error) {
+	procs, err := Processes(procDir)
+	if err != nil {
+		return nil, err
+	}
+	servers := []Server{}
+	for _, p := range procs {
+		switch filepath.Base(p.Args[0]) {
+		case "mysqld", "mariadbd":
+			servers = append(servers, mysqld(p))
+		case "mongod":
+			servers = append(servers, mongod(p))
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Key() < servers[j].Key() })
+	return servers, nil
+}
+
+// A Credential is the username and password of a server.
+type Credential struct {
+	Username string
+	Password string `json:",omitempty"`
+}
+
+// Credentials are the credentials of the servers by subsystem, e.g. "mysql",
+// or by subsystem and port, e.g. "mysql:3307", which takes precedence.
+type Credentials map[string]Credential
+
+// LoadCredentials loads the credentials file, if any. It returns nil, nil if
+// the file doesn't exist.
+func LoadCredentials(file string) (Credentials, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	c := Credentials{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return c, nil
+}
+
+// For returns the credential of the server, if any.
+func (c Credentials) For(s Server) (Credential, bool) {
+	if cred, ok := c[s.Subsystem+":"+strconv.Itoa(s.Port)]; ok && s.Port > 0 {
+		return cred, true
+	}
+	cred, ok := c[s.Subsystem]
+	return cred, ok
+}
+
+// Propose returns the instance of the server, named like the MySQL instances
+// created by the installer: hostname, with the port if it's not the default.
+func Propose(s Server, cred Credential, hostname string) proto.Instance {
+	in := proto.Instance{
+		Subsystem: s.Subsystem,
+		Name:      hostname,
+	}
+	switch s.Subsystem {
+	case "mysql":
+		auth := ""
+		if cred.Username != "" {
+			auth = cred.Username
+			if cred.Password != "" {
+				auth += ":" + cred.Password
+			}
+			auth += "@"
+		}
+		if s.Socket != "" {
+			in.DSN = auth + "unix(" + s.Socket + ")/"
+		} else {
+			in.DSN = auth + "tcp(" + net.JoinHostPort(s.Host, strconv.Itoa(s.Port)) + ")/"
+		}
+		if s.Port != DEFAULT_MYSQL_PORT && s.Port > 0 {
+			in.Name += "." + strconv.Itoa(s.Port)
+		} else if s.Port == 0 && s.Socket != "" {
+			in.Name += "." + strings.TrimSuffix(filepath.Base(s.Socket), filepath.Ext(s.Socket))
+		}
+	case "mongo":
+		u := url.URL{
+			Scheme: "mongodb",
+			Host:   net.JoinHostPort(s.Host, strconv.Itoa(s.Port)),
+		}
+		if cred.Username != "" {
+			u.User = url.User(cred.Username)
+			if cred.Password != "" {
+				u.User = url.UserPassword(cred.Username, cred.Password)
+			}
+		}
+		in.DSN = u.String()
+		if s.Port != DEFAULT_MONGO_PORT {
+			in.Name += "." + strconv.Itoa(s.Port)
+		}
+	}
+	return in
+}
+
+// Match returns the instance of the server, if any: the instance whose DSN
+// is the socket or the local port of the server.
+func Match(s Server, instances []proto.Instance) (proto.Instance, bool) {
+	for _, in := range instances {
+		if in.Subsystem != s.Subsystem {
+			continue
+		}
+		network, addr := dsnAddr(in.Subsystem, in.DSN)
+		switch network {
+		case "unix":
+			if addr == s.Socket && s.Socket != "" {
+				return in, true
+			}
+		case "tcp":
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil || port != strconv.Itoa(s.Port) || s.Port == 0 {
+				continue
+			}
+			if host == s.Host || isLocal(host) {
+				return in, true
+			}
+		}
+	}
+	return proto.Instance{}, false
+}
+
+// dsnAddr returns the network, "unix" or "tcp", and the address of the DSN.
+func dsnAddr(subsystem, dsn string) (string, string) {
+	switch subsystem {
+	case "mysql":
+		cfg, err := mysqlDriver.ParseDSN(dsn)
+		if err != nil {
+			return "", ""
+		}
+		return cfg.Net, cfg.Addr
+	case "mongo":
+		if !strings.HasPrefix(dsn, "mongodb://") {
+			dsn = "mongodb://" + dsn
+		}
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", ""
+		}
+		host := strings.Split(u.Host, ",")[0]
+		if strings.HasSuffix(host, ".sock") {
+			return "unix", host
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(DEFAULT_MONGO_PORT))
+		}
+		return "tcp", host
+	}
+	return "", ""
+}
+
+func isLocal(host string) bool {
+	switch host {
+	case "", "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	hostname, _ := os.Hostname()
+	return host == hostname
+}
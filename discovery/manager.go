@@ -0,0 +1,312 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/go-mysql/dsn"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/instance"
+	"github.com/percona/qan-agent/pct"
+)
+
+const (
+	SERVICE_NAME      = "discovery"
+	DISCOVER_INTERVAL = 1 * time.Minute
+)
+
+type Manager struct {
+	logger          *pct.Logger
+	procDir         string
+	repo            *instance.Repo
+	api             pct.APIConnector
+	credentialsFile string
+	manifestFile    string // declares the instances, so none are created
+	// --
+	servers     map[string]Server // last discovered, by key
+	missing     map[string]Server // disappeared, by key
+	discoverMux *sync.Mutex       // one Discover at a time
+	mux         *sync.Mutex
+	status      *pct.Status
+	stopChan    chan struct{}
+	doneChan    chan struct{}
+}
+
+// NewManager returns a manager which discovers the servers in procDir,
+// usually /proc, and creates their instances in the repo and the API if the
+// credentials file has credentials for them.
+func NewManager(logger *pct.Logger, procDir string, repo *instance.Repo, api pct.APIConnector, credentialsFile string) *Manager {
+	m := &Manager{
+		logger:          logger,
+		procDir:         procDir,
+		repo:            repo,
+		api:             api,
+		credentialsFile: credentialsFile,
+		// --
+		servers:     map[string]Server{},
+		missing:     map[string]Server{},
+		discoverMux: &sync.Mutex{},
+		mux:         &sync.Mutex{},
+		status:      pct.NewStatus([]string{SERVICE_NAME, SERVICE_NAME + "-servers", SERVICE_NAME + "-missing"}),
+	}
+	return m
+}
+
+// SetManifest sets the manifest file which declares the instances. It must be
+// called before Start. Servers are still discovered, but their instances are
+// not created: the manifest would remove them.
+func (m *Manager) SetManifest(file string) {
+	m.manifestFile = file
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (m *Manager) Start() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.stopChan != nil {
+		return pct.ServiceIsRunningError{Service: SERVICE_NAME}
+	}
+	if _, err := os.Stat(m.procDir); err != nil {
+		// Not Linux: nothing to discover, but the agent works without it.
+		m.logger.Warn("Cannot discover local servers: ", err)
+		m.status.Update(SERVICE_NAME, "Not supported: "+err.Error())
+		return nil
+	}
+	m.stopChan = make(chan struct{})
+	m.doneChan = make(chan struct{})
+	go m.run(DISCOVER_INTERVAL, m.stopChan, m.doneChan)
+	m.logger.Info("Started")
+	m.status.Update(SERVICE_NAME, "Running")
+	return nil
+}
+
+func (m *Manager) Stop() error {
+	m.mux.Lock()
+	stopChan, doneChan := m.stopChan, m.doneChan
+	m.stopChan, m.doneChan = nil, nil
+	m.mux.Unlock()
+	if stopChan == nil {
+		return nil
+	}
+	close(stopChan)
+	<-doneChan
+	m.logger.Info("Stopped")
+	m.status.Update(SERVICE_NAME, "Stopped")
+	return nil
+}
+
+func (m *Manager) Status() map[string]string {
+	return m.status.All()
+}
+
+func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
+	return nil, nil
+}
+
+func (m *Manager) GetDefaults(uuid string) map[string]interface{} {
+	return nil
+}
+
+// Handle handles Discover which discovers the servers now and returns them
+// with their proposed instances.
+func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
+	m.status.UpdateRe(SERVICE_NAME, "Handling", cmd)
+	defer m.status.Update(SERVICE_NAME, "Running")
+
+	switch cmd.Cmd {
+	case "Discover":
+		servers, err := m.Discover()
+		return cmd.Reply(servers, err)
+	}
+	return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
+}
+
+// Discover discovers the servers, warns about the new ones which aren't
+// monitored and about the ones which disappeared, and creates the instances
+// of those not monitored if there are credentials for them.
+func (m *Manager) Discover() ([]Server, error) {
+	m.logger.Debug("Discover:call")
+	defer m.logger.Debug("Discover:return")
+
+	// Not m.mux: creating instances calls the API, which can be slow.
+	m.discoverMux.Lock()
+	defer m.discoverMux.Unlock()
+
+	servers, err := Discover(m.procDir)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := LoadCredentials(m.credentialsFile)
+	if err != nil {
+		m.logger.Warn("Cannot load credentials: ", err)
+	}
+	hostname, _ := os.Hostname()
+
+	for i, s := range servers {
+		cred, haveCred := creds.For(s)
+		safe := cred
+		safe.Password = "" // proposals don't show passwords
+		s.Instance = Propose(s, safe, hostname)
+
+		if in, ok := Match(s, append(m.repo.List("mysql"), m.repo.List("mongo")...)); ok {
+			s.UUID = in.UUID
+		} else if haveCred && m.manifestFile == "" {
+			in, err := m.create(s, cred, hostname)
+			if err != nil {
+				m.logger.Warn(fmt.Sprintf("Cannot create instance of %s: %s", s, err))
+			} else {
+				s.UUID = in.UUID
+				m.logger.Info(fmt.Sprintf("Created instance %s of %s", in.UUID, s))
+			}
+		}
+		servers[i] = s
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	found := make(map[string]Server, len(servers))
+	for _, s := range servers {
+		key := s.Key()
+		if _, ok := m.servers[key]; !ok {
+			if s.UUID == "" {
+				m.logger.Warn(fmt.Sprintf("Found %s which is not monitored, %s", s, m.howToMonitor()))
+			} else {
+				m.logger.Info(fmt.Sprintf("Found %s, instance %s", s, s.UUID))
+			}
+		}
+		delete(m.missing, key)
+		found[key] = s
+	}
+
+	for key, s := range m.servers {
+		if _, ok := found[key]; ok {
+			continue
+		}
+		msg := fmt.Sprintf("%s disappeared", s)
+		if s.UUID != "" {
+			msg += fmt.Sprintf(", instance %s is not monitored until it's back", s.UUID)
+		}
+		m.logger.Warn(msg)
+		m.missing[key] = s
+	}
+	m.servers = found
+
+	m.updateStatus(servers)
+	return servers, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+func (m *Manager) run(interval time.Duration, stopChan, doneChan chan struct{}) {
+	m.logger.Debug("run:call")
+	defer m.logger.Debug("run:return")
+
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Discovery crashed: ", err)
+			m.status.Update(SERVICE_NAME, "Crashed")
+		}
+		close(doneChan)
+	}()
+
+	for {
+		if _, err := m.Discover(); err != nil {
+			m.logger.Warn("Cannot discover local servers: ", err)
+		}
+		select {
+		case <-time.After(interval):
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// create creates the instance of the server in the API, like the installer,
+// then in the repo. The API doesn't get the password. If the API has the
+// instance, it returns it and its UUID is used.
+func (m *Manager) create(s Server, cred Credential, hostname string) (proto.Instance, error) {
+	if m.api.Hostname() == "" {
+		return proto.Instance{}, fmt.Errorf("not connected to API")
+	}
+	in := Propose(s, cred, hostname)
+	in.UUID = pct.NewUUID()
+	if oses := m.repo.List("os"); len(oses) > 0 {
+		in.ParentUUID = oses[0].UUID
+	}
+	created := in
+	// We don't want to store passwords in the API.
+	if in.Subsystem == "mysql" {
+		created.DSN = dsn.HidePassword(in.DSN)
+	} else {
+		safe := cred
+		safe.Password = "" // url.URL would escape a mask like ***
+		created.DSN = Propose(s, safe, hostname).DSN
+	}
+	if _, err := m.api.CreateInstance("/instances", &created); err != nil {
+		return in, err
+	}
+	in.UUID = created.UUID
+	in.Links = created.Links
+	if err := m.repo.Add(in, true); err != nil {
+		return in, err
+	}
+	return in, nil
+}
+
+// howToMonitor returns how to monitor a server which isn't.
+func (m *Manager) howToMonitor() string {
+	if m.manifestFile != "" {
+		return "declare its instance in " + m.manifestFile
+	}
+	return "add its instance or set its credentials in " + m.credentialsFile + " to create it"
+}
+
+func (m *Manager) updateStatus(servers []Server) {
+	count := map[string]int{}
+	notMonitored := 0
+	for _, s := range servers {
+		count[s.Subsystem]++
+		if s.UUID == "" {
+			notMonitored++
+		}
+	}
+	status := fmt.Sprintf("Found %d mysql, %d mongo", count["mysql"], count["mongo"])
+	if notMonitored > 0 {
+		status += fmt.Sprintf(", %d not monitored", notMonitored)
+	}
+	m.status.Update(SERVICE_NAME+"-servers", status)
+
+	missing := make([]string, 0, len(m.missing))
+	for _, s := range m.missing {
+		missing = append(missing, s.String())
+	}
+	sort.Strings(missing)
+	m.status.Update(SERVICE_NAME+"-missing", strings.Join(missing, ", "))
+}
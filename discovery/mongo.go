@@ -0,0 +1,198 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_MONGO_PORT = 27017
+	SHARD_MONGO_PORT   = 27018 // --shardsvr
+	CONFIG_MONGO_PORT  = 27019 // --configsvr
+)
+
+// mongoLegacyOpts are the options of the legacy mongod.conf format and the
+// command line, by their YAML config name.
+var mongoLegacyOpts = map[string]string{
+	"port":             "net.port",
+	"bind_ip":          "net.bindIp",
+	"bind_ip_all":      "net.bindIpAll",
+	"nounixsocket":     "net.unixDomainSocket.enabled",
+	"unixsocketprefix": "net.unixDomainSocket.pathPrefix",
+	"dbpath":           "storage.dbPath",
+	"shardsvr":         "sharding.clusterRole",
+	"configsvr":        "sharding.clusterRole",
+}
+
+// mongod returns the server of the mongod process: the command line options
+// override the options of the --config file, if any.
+func mongod(p Process) Server {
+	cmdline := mongoCmdlineOpts(p.Args[1:])
+
+	s := Server{
+		Subsystem: "mongo",
+		PID:       p.PID,
+	}
+	opts := map[string]string{}
+	if file, ok := cmdline["config"]; ok {
+		if data, err := p.ReadFile(file); err == nil {
+			opts = parseMongoConfig(data)
+			s.ConfigFiles = []string{file}
+		}
+	}
+	for k, v := range cmdline {
+		opts[k] = v
+	}
+
+	s.Datadir = opts["storage.dbPath"]
+	s.Port = DEFAULT_MONGO_PORT
+	switch opts["sharding.clusterRole"] {
+	case "shardsvr":
+		s.Port = SHARD_MONGO_PORT
+	case "configsvr":
+		s.Port = CONFIG_MONGO_PORT
+	}
+	if port, err := strconv.Atoi(opts["net.port"]); err == nil && port > 0 {
+		s.Port = port
+	}
+	s.Host = "127.0.0.1"
+	if ip := strings.Split(opts["net.bindIp"], ",")[0]; ip != "" && ip != "0.0.0.0" && ip != "::" && opts["net.bindIpAll"] != "true" {
+		s.Host = strings.TrimSpace(ip)
+	}
+	if opts["net.unixDomainSocket.enabled"] != "false" {
+		prefix := opts["net.unixDomainSocket.pathPrefix"]
+		if prefix == "" {
+			prefix = "/tmp"
+		}
+		s.Socket = filepath.Join(prefix, "mongodb-"+strconv.Itoa(s.Port)+".sock")
+	}
+	return s
+}
+
+// mongoCmdlineOpts returns the options of a mongod command line by their
+// YAML config name, e.g. --port 27018 as "net.port": "27018", and the
+// config file as "config".
+func mongoCmdlineOpts(args []string) map[string]string {
+	opts := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if n := strings.Index(name, "="); n >= 0 {
+			name, value, hasValue = name[:n], name[n+1:], true
+		}
+		if name == "f" {
+			name = "config"
+		}
+		flag := name == "nounixsocket" || name == "bind_ip_all" || name == "shardsvr" || name == "configsvr"
+		if !hasValue && !flag && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			value = args[i+1]
+			i++
+		}
+		setMongoOpt(opts, name, value)
+	}
+	return opts
+}
+
+// parseMongoConfig returns the scalar options of a mongod.conf file by
+// their dotted name, e.g. "net.port". It reads the YAML format, only the
+// nested mappings it uses, and the legacy "name = value" format.
+func parseMongoConfig(data []byte) map[string]string {
+	opts := map[string]string{}
+	type level struct {
+		indent int
+		name   string
+	}
+	path := []level{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		raw := scanner.Text()
+		if n := strings.Index(raw, "#"); n >= 0 {
+			raw = raw[:n]
+		}
+		line := strings.TrimSpace(raw)
+		if line == "" || line == "---" || strings.HasPrefix(line, "- ") {
+			continue
+		}
+
+		if n := strings.Index(line, "="); n >= 0 && !strings.Contains(line[:n], ":") {
+			setMongoOpt(opts, strings.TrimSpace(line[:n]), strings.TrimSpace(line[n+1:]))
+			continue
+		}
+
+		n := strings.Index(line, ":")
+		if n < 0 {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " \t"))
+		for len(path) > 0 && path[len(path)-1].indent >= indent {
+			path = path[:len(path)-1]
+		}
+		name := strings.TrimSpace(line[:n])
+		value := strings.Trim(strings.TrimSpace(line[n+1:]), `"'`)
+		if value == "" {
+			path = append(path, level{indent: indent, name: name})
+			continue
+		}
+		names := make([]string, 0, len(path)+1)
+		for _, l := range path {
+			names = append(names, l.name)
+		}
+		opts[strings.Join(append(names, name), ".")] = value
+	}
+	return opts
+}
+
+// setMongoOpt sets the option of the legacy format or command line by its
+// YAML config name.
+func setMongoOpt(opts map[string]string, name, value string) {
+	lower := strings.ToLower(name)
+	yamlName, ok := mongoLegacyOpts[lower]
+	if !ok {
+		if lower == "config" {
+			opts["config"] = value
+		}
+		return
+	}
+	switch lower {
+	case "nounixsocket":
+		if value == "" || value == "true" {
+			value = "false"
+		} else {
+			value = "true"
+		}
+	case "bind_ip_all":
+		if value == "" {
+			value = "true"
+		}
+	case "shardsvr", "configsvr":
+		if value != "" && value != "true" {
+			return
+		}
+		value = lower
+	}
+	opts[yamlName] = value
+}
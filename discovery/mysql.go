@@ -0,0 +1,226 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_MYSQL_PORT = 3306
+
+// MYSQL_DEFAULTS_FILES are read by mysqld, in order, unless --defaults-file
+// is given. Later files override earlier ones.
+var MYSQL_DEFAULTS_FILES = []string{
+	"/etc/my.cnf",
+	"/etc/mysql/my.cnf",
+	"/etc/mysql/mariadb.cnf",
+}
+
+// mysqlShortOpts are the short mysqld options we need, by long name.
+var mysqlShortOpts = map[string]string{
+	"P": "port",
+	"S": "socket",
+	"h": "datadir",
+}
+
+// mysqld returns the server of the mysqld process: the command line options
+// override the options of the [mysqld] groups in the defaults files.
+func mysqld(p Process) Server {
+	cmdline := mysqlCmdlineOpts(p.Args[1:])
+
+	var files []string
+	if file, ok := cmdline["defaults-file"]; ok {
+		files = []string{file}
+	} else {
+		files = append(files, MYSQL_DEFAULTS_FILES...)
+	}
+	if file, ok := cmdline["defaults-extra-file"]; ok {
+		files = append(files, file)
+	}
+	groups := []string{"mysqld", "server", "mariadb", "mariadbd"}
+	if suffix, ok := cmdline["defaults-group-suffix"]; ok {
+		for _, g := range groups {
+			groups = append(groups, g+suffix)
+		}
+	}
+
+	s := Server{
+		Subsystem: "mysql",
+		PID:       p.PID,
+	}
+	opts := map[string]string{}
+	for _, file := range files {
+		read := readMySQLConfig(p, file, groups, opts, 0)
+		s.ConfigFiles = append(s.ConfigFiles, read...)
+	}
+	for k, v := range cmdline {
+		opts[k] = v
+	}
+
+	s.Datadir = opts["datadir"]
+	if socket, ok := opts["socket"]; ok {
+		if !filepath.IsAbs(socket) && s.Datadir != "" {
+			socket = filepath.Join(s.Datadir, socket) // relative to datadir like mysqld
+		}
+		s.Socket = socket
+	}
+	if _, ok := opts["skip-networking"]; !ok {
+		s.Host = "127.0.0.1"
+		if addr := opts["bind-address"]; addr != "" && addr != "*" && addr != "0.0.0.0" && addr != "::" {
+			s.Host = strings.Split(addr, ",")[0]
+		}
+		s.Port = DEFAULT_MYSQL_PORT
+		if port, err := strconv.Atoi(opts["port"]); err == nil && port > 0 {
+			s.Port = port
+		}
+	}
+	return s
+}
+
+// mysqlCmdlineOpts returns the options of a mysqld command line, e.g.
+// --port=3307, --port 3307 or -P3307 as "port": "3307". Names are normalized
+// like mysqld does: "loose-" prefix removed and "_" replaced with "-".
+func mysqlCmdlineOpts(args []string) map[string]string {
+	opts := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var name, value string
+		hasValue := false
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name = arg[2:]
+			if n := strings.Index(name, "="); n >= 0 {
+				name, value, hasValue = name[:n], name[n+1:], true
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			long, ok := mysqlShortOpts[arg[1:2]]
+			if !ok {
+				continue
+			}
+			name = long
+			if len(arg) > 2 {
+				value, hasValue = arg[2:], true
+			}
+		default:
+			continue
+		}
+		name = mysqlOptName(name)
+		if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && takesValue(name) {
+			value = args[i+1]
+			i++
+		}
+		opts[name] = value
+	}
+	return opts
+}
+
+// takesValue returns true if the option we need takes a value, so the next
+// command line arg is its value.
+func takesValue(name string) bool {
+	switch name {
+	case "port", "socket", "datadir", "bind-address", "defaults-file", "defaults-extra-file", "defaults-group-suffix":
+		return true
+	}
+	return false
+}
+
+func mysqlOptName(name string) string {
+	name = strings.TrimPrefix(name, "loose-")
+	return strings.Replace(strings.ToLower(name), "_", "-", -1)
+}
+
+// readMySQLConfig reads the options of the groups in the MySQL config file
+// and its !include and !includedir files into opts. It returns the files it
+// read; missing files are ignored like mysqld does.
+func readMySQLConfig(p Process, file string, groups []string, opts map[string]string, depth int) []string {
+	if depth > 10 {
+		return nil // include loop
+	}
+	data, err := p.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	read := []string{file}
+
+	inGroup := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case strings.HasPrefix(line, "!includedir"):
+			dir := strings.TrimSpace(strings.TrimPrefix(line, "!includedir"))
+			for _, f := range p.ReadDir(dir) {
+				if strings.HasSuffix(f, ".cnf") {
+					read = append(read, readMySQLConfig(p, filepath.Join(dir, f), groups, opts, depth+1)...)
+				}
+			}
+			continue
+		case strings.HasPrefix(line, "!include"):
+			f := strings.TrimSpace(strings.TrimPrefix(line, "!include"))
+			read = append(read, readMySQLConfig(p, f, groups, opts, depth+1)...)
+			continue
+		case line[0] == '[':
+			group := strings.ToLower(strings.TrimSpace(strings.Trim(line, "[]")))
+			inGroup = false
+			for _, g := range groups {
+				if group == g {
+					inGroup = true
+					break
+				}
+			}
+			continue
+		}
+		if !inGroup {
+			continue
+		}
+		name, value := line, ""
+		if n := strings.Index(line, "="); n >= 0 {
+			name, value = line[:n], line[n+1:]
+		}
+		if n := strings.Index(value, " #"); n >= 0 {
+			value = value[:n] // trailing comment
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		opts[mysqlOptName(strings.TrimSpace(name))] = value
+	}
+	return read
+}
+
+// readDir returns the sorted names of the files in dir, if any.
+func readDir(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -190,3 +190,50 @@ func (s *ManagerTestSuite) TestStartAndUpdate(t *C) {
 	t.Check(reply.Error, Equals, "")
 	t.Check(pct.FileExists(mysqlInstanceFile), Equals, false)
 }
+
+func (s *ManagerTestSuite) TestRepoReload(t *C) {
+	test.ClearDir(s.instanceDir, "*")
+	defer test.ClearDir(s.instanceDir, "*")
+
+	err := test.CopyFile(filepath.Join(rootdir.RootDir(), "test/instances/001/os-AAA.json"), pct.Basedir.InstanceFile("AAA"))
+	t.Assert(err, IsNil)
+
+	repo := instance.NewRepo(s.logger, s.instanceDir, s.api)
+	err = repo.Init()
+	t.Assert(err, IsNil)
+	t.Check(repo.List("os"), HasLen, 1)
+	t.Check(repo.List("mysql"), HasLen, 0)
+
+	// Instance file created outside the agent is added.
+	err = test.CopyFile(filepath.Join(rootdir.RootDir(), "test/instances/001/mysql-BBB.json"), pct.Basedir.InstanceFile("BBB"))
+	t.Assert(err, IsNil)
+	err = repo.Reload()
+	t.Assert(err, IsNil)
+	t.Assert(repo.List("mysql"), HasLen, 1)
+
+	// Changed instance file is updated.
+	in := repo.List("mysql")[0]
+	in.DSN = "user:newpass@tcp(localhost)/"
+	err = pct.Basedir.WriteInstance("BBB", in)
+	t.Assert(err, IsNil)
+	err = repo.Reload()
+	t.Assert(err, IsNil)
+	got, err := repo.Get("BBB", false)
+	t.Assert(err, IsNil)
+	t.Check(got.DSN, Equals, in.DSN)
+
+	// Invalid instance file changes nothing.
+	err = ioutil.WriteFile(pct.Basedir.InstanceFile("AAA"), []byte("{"), 0600)
+	t.Assert(err, IsNil)
+	err = repo.Reload()
+	t.Check(err, ErrorMatches, "Invalid instance file: .*/AAA.json: .*")
+	t.Check(repo.List("os"), HasLen, 1)
+
+	// Removed instance file is removed.
+	err = os.Remove(pct.Basedir.InstanceFile("AAA"))
+	t.Assert(err, IsNil)
+	err = repo.Reload()
+	t.Assert(err, IsNil)
+	t.Check(repo.List("os"), HasLen, 0)
+	t.Check(repo.List("mysql"), HasLen, 1)
+}
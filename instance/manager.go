@@ -121,6 +121,14 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 }
 
+// Reload reloads the instance files into the repo.
+func (m *Manager) Reload() []error {
+	if err := m.repo.Reload(); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
 func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
 	return nil, nil
 }
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"sync"
 
@@ -69,14 +70,9 @@ func (r *Repo) Init() (err error) {
 	}
 	r.logger.Debug(len(files), "instance files")
 	for _, file := range files {
-		r.logger.Debug("reading " + file)
-		data, err := ioutil.ReadFile(file)
+		in, err := r.readFile(file)
 		if err != nil {
-			return fmt.Errorf("Cannot read instance file: %s: %s", file, err)
-		}
-		var in proto.Instance
-		if err := json.Unmarshal(data, &in); err != nil {
-			return fmt.Errorf("Invalid instance file: %s: %s", file, err)
+			return err
 		}
 		// Use low-level add() because we have locked the mutex.
 		if err := r.add(in, false); err != nil {
@@ -88,6 +84,48 @@ func (r *Repo) Init() (err error) {
 	return nil
 }
 
+// Reload re-reads the instance files: it adds, updates and removes instances
+// whose files were created, changed or removed. If a file is invalid, nothing
+// is changed. Services aren't notified: the qan manager restarts analyzers
+// whose instances changed when it's reloaded after the repo, and query cmds
+// get their instance when they're handled, so a reload only affects new cmds.
+func (r *Repo) Reload() error {
+	r.logger.Debug("Reload:call")
+	defer r.logger.Debug("Reload:return")
+
+	files, err := filepath.Glob(filepath.Join(r.instanceDir, "*"+pct.INSTANCE_FILE_SUFFIX))
+	if err != nil {
+		return err
+	}
+	onDisk := make(map[string]proto.Instance, len(files))
+	for _, file := range files {
+		in, err := r.readFile(file)
+		if err != nil {
+			return err
+		}
+		onDisk[in.UUID] = in
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for uuid, in := range onDisk {
+		old, ok := r.instances[uuid]
+		if ok && reflect.DeepEqual(old, in) {
+			continue
+		}
+		r.instances[uuid] = in
+		r.logger.Info(fmt.Sprintf("Reloaded %s %s", in.Subsystem, in.Name))
+	}
+	for uuid, in := range r.instances {
+		if _, ok := onDisk[uuid]; ok {
+			continue
+		}
+		delete(r.instances, uuid)
+		r.logger.Info(fmt.Sprintf("Removed %s %s", in.Subsystem, in.Name))
+	}
+	return nil
+}
+
 func (r *Repo) List(subsystemName string) []proto.Instance {
 	r.mux.Lock()
 	defer r.mux.Unlock()
@@ -206,3 +244,16 @@ func (r *Repo) Remove(uuid string) error {
 	r.logger.Info("Removed " + uuid)
 	return nil
 }
+
+func (r *Repo) readFile(file string) (proto.Instance, error) {
+	r.logger.Debug("reading " + file)
+	var in proto.Instance
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return in, fmt.Errorf("Cannot read instance file: %s: %s", file, err)
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in, fmt.Errorf("Invalid instance file: %s: %s", file, err)
+	}
+	return in, nil
+}
@@ -595,3 +595,111 @@ func (s *ManagerTestSuite) TestReconnect(t *C) {
 	}
 	t.Check(got, DeepEquals, expect)
 }
+
+func (s *ManagerTestSuite) TestReload(t *C) {
+	config := &pc.Log{
+		Level: "info",
+	}
+	if err := pct.Basedir.WriteConfig("log", config); err != nil {
+		t.Fatal(err)
+	}
+
+	m := log.NewManager(s.client, s.logChan)
+	err := m.Start()
+	t.Assert(err, IsNil)
+
+	defer m.Stop()
+
+	// Change the config file outside the agent. Level is dynamic, Offline is not.
+	config = &pc.Log{
+		Level:   "warning",
+		Offline: "true",
+	}
+	if err := pct.Basedir.WriteConfig("log", config); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(pct.Basedir.ConfigFile("log"))
+	t.Assert(err, IsNil)
+
+	test.DrainRecvData(s.recvChan)
+	errs := m.Reload()
+	t.Check(errs, HasLen, 0)
+	t.Check(test.WaitStatus(1, m, "log-level", "warning"), Equals, true)
+	t.Check(restartWarnings(test.WaitLog(s.recvChan, 10)), Equals, 1)
+
+	configs, errs := m.GetConfig()
+	t.Check(errs, HasLen, 0)
+	t.Assert(configs, HasLen, 1)
+	t.Check(configs[0].Set, Equals, string(data))
+	t.Check(configs[0].Running, Equals, "{\"Level\":\"warning\"}")
+
+	// The config file is not written back.
+	got, err := ioutil.ReadFile(pct.Basedir.ConfigFile("log"))
+	t.Assert(err, IsNil)
+	t.Check(string(got), Equals, string(data))
+
+	// Reloading the same file again doesn't repeat the restart warning.
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	t.Check(restartWarnings(test.WaitLog(s.recvChan, 10)), Equals, 0)
+}
+
+func restartWarnings(logs []proto.LogEntry) int {
+	n := 0
+	for _, l := range logs {
+		if l.Msg == "Restart agent for all log config changes to take effect" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *ManagerTestSuite) TestDryRunAndRollback(t *C) {
+	config := &pc.Log{
+		Level: "info",
+	}
+	if err := pct.Basedir.WriteConfig("log", config); err != nil {
+		t.Fatal(err)
+	}
+
+	m := log.NewManager(s.client, s.logChan)
+	err := m.Start()
+	t.Assert(err, IsNil)
+
+	defer m.Stop()
+	t.Assert(test.WaitStatus(1, m, "log-level", "info"), Equals, true)
+
+	// Dry run returns the diff and changes nothing.
+	cmd := &proto.Cmd{
+		User:    "daniel",
+		Service: "log",
+		Cmd:     "SetConfig",
+		Data:    []byte(`{"Level":"error","DryRun":true}`),
+	}
+	reply := m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	diff := []pct.ConfigChange{}
+	err = json.Unmarshal(reply.Data, &diff)
+	t.Assert(err, IsNil)
+	t.Check(diff, DeepEquals, []pct.ConfigChange{{Key: "Level", Old: "info", New: "error"}})
+	t.Check(m.Status()["log-level"], Equals, "info")
+
+	// Set the config, then roll it back to the previous version.
+	cmd.Data = []byte(`{"Level":"error"}`)
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	t.Check(test.WaitStatus(1, m, "log-level", "error"), Equals, true)
+
+	cmd = &proto.Cmd{
+		User:    "daniel",
+		Service: "log",
+		Cmd:     "RollbackConfig",
+	}
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	t.Check(test.WaitStatus(1, m, "log-level", "info"), Equals, true)
+	gotConfig := &pc.Log{}
+	err = json.Unmarshal(reply.Data, gotConfig)
+	t.Assert(err, IsNil)
+	t.Check(gotConfig, DeepEquals, config)
+}
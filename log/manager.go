@@ -37,13 +37,14 @@ type Manager struct {
 	client  pct.WebsocketClient
 	logChan chan proto.LogEntry
 	// --
-	setConfig string
-	config    *pc.Log
-	running   bool
-	mux       *sync.RWMutex // guards config and running
-	logger    *pct.Logger
-	relay     *Relay
-	status    *pct.Status
+	setConfig  string
+	config     *pc.Log
+	diskConfig pc.Log // last config read from or written to disk
+	running    bool
+	mux        *sync.RWMutex // guards config and running
+	logger     *pct.Logger
+	relay      *Relay
+	status     *pct.Status
 }
 
 func NewManager(client pct.WebsocketClient, logChan chan proto.LogEntry) *Manager {
@@ -83,6 +84,7 @@ func (m *Manager) Start() error {
 
 	m.logger = pct.NewLogger(m.relay.LogChan(), "log")
 	m.config = config
+	m.diskConfig = *config
 	m.running = true
 
 	m.logger.Info("Started")
@@ -101,37 +103,11 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 
 	switch cmd.Cmd {
 	case "SetConfig":
-		m.mux.Lock()
-		defer m.mux.Unlock()
-
-		// proto.Cmd[Service:log, Cmd:SetConfig, Data:log.Config]
-		newConfig := &pc.Log{}
-		if err := json.Unmarshal(cmd.Data, newConfig); err != nil {
-			return cmd.Reply(nil, err)
-		}
-
-		if err := m.validateConfig(newConfig); err != nil {
-			return cmd.Reply(nil, err)
-		}
-		m.setConfig = string(cmd.Data)
-
-		errs := []error{}
-		if m.config.Level != newConfig.Level { // log level has changed
-			level := proto.LogLevelNumber[newConfig.Level] // already validated
-			select {
-			case m.relay.LogLevelChan() <- level:
-				m.config.Level = newConfig.Level
-			case <-time.After(3 * time.Second):
-				errs = append(errs, errors.New("Timeout setting new log level"))
-			}
-		}
-
-		// Write the new, updated config.  If this fails, agent will use old config if restarted.
-		if err := pct.Basedir.WriteConfig("log", m.config); err != nil {
-			errs = append(errs, errors.New("log.WriteConfig:"+err.Error()))
-		}
-
-		return cmd.Reply(m.config, errs...)
+		config, errs := m.handleSetConfig(cmd)
+		return cmd.Reply(config, errs...)
+	case "RollbackConfig":
+		config, errs := m.handleRollbackConfig(cmd)
+		return cmd.Reply(config, errs...)
 	case "GetConfig":
 		config, errs := m.GetConfig()
 		return cmd.Reply(config, errs...)
@@ -143,6 +119,88 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	}
 }
 
+func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	// proto.Cmd[Service:log, Cmd:SetConfig, Data:log.Config]
+	newConfig := &pc.Log{}
+	if err := json.Unmarshal(cmd.Data, newConfig); err != nil {
+		return nil, []error{err}
+	}
+
+	if err := m.validateConfig(newConfig); err != nil {
+		return nil, []error{err}
+	}
+
+	// Dry run: return what would change. Only the log level is dynamic.
+	if pct.GetSetConfigFlags(cmd).DryRun {
+		finalConfig := *m.config
+		finalConfig.Level = newConfig.Level
+		diff, err := pct.DiffConfig(m.config, finalConfig)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return diff, nil
+	}
+	m.setConfig = string(cmd.Data)
+
+	errs := m.applyConfig(newConfig)
+
+	// Write the new, updated config.  If this fails, agent will use old config if restarted.
+	if err := pct.Basedir.WriteConfig("log", m.config); err != nil {
+		errs = append(errs, errors.New("log.WriteConfig:"+err.Error()))
+	}
+	m.diskConfig = *m.config
+
+	config := *m.config // copy because reply is encoded after unlocking
+	return &config, errs
+}
+
+// handleRollbackConfig sets a previous version of the config.
+func (m *Manager) handleRollbackConfig(cmd *proto.Cmd) (interface{}, []error) {
+	r, err := pct.GetRollback(cmd)
+	if err != nil {
+		return nil, []error{err}
+	}
+	set, err := pct.Basedir.ReadConfigVersion("log", r.Version, &pc.Log{})
+	if err != nil {
+		return nil, []error{err}
+	}
+	setCmd := *cmd
+	setCmd.Data = []byte(set)
+	return m.handleSetConfig(&setCmd)
+}
+
+// Reload applies changes to the log config file. Offline takes effect on restart.
+func (m *Manager) Reload() []error {
+	newConfig := &pc.Log{}
+	set, err := pct.Basedir.ReadConfig("log", newConfig)
+	if err != nil && !os.IsNotExist(err) {
+		return []error{err}
+	}
+	if err := m.validateConfig(newConfig); err != nil {
+		return []error{err}
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	// Compare to the last config on disk, not the running config, else the
+	// non-dynamic changes would be reloaded, and warned about, every time.
+	if m.config == nil || m.diskConfig == *newConfig {
+		return nil
+	}
+	m.diskConfig = *newConfig
+	m.setConfig = set
+
+	errs := m.applyConfig(newConfig)
+	if *m.config != *newConfig {
+		m.logger.Warn("Restart agent for all log config changes to take effect")
+	}
+	m.logger.Info("Reloaded config")
+	return errs
+}
+
 func (m *Manager) Status() map[string]string {
 	return m.status.Merge(m.client.Status(), m.relay.Status())
 }
@@ -173,6 +231,21 @@ func (m *Manager) Relay() *Relay {
 	return m.relay
 }
 
+func (m *Manager) applyConfig(newConfig *pc.Log) []error {
+	// XXX Assume caller has locked m.mux.
+	errs := []error{}
+	if m.config.Level != newConfig.Level { // log level has changed
+		level := proto.LogLevelNumber[newConfig.Level] // already validated
+		select {
+		case m.relay.LogLevelChan() <- level:
+			m.config.Level = newConfig.Level
+		case <-time.After(3 * time.Second):
+			errs = append(errs, errors.New("Timeout setting new log level"))
+		}
+	}
+	return errs
+}
+
 func (m *Manager) validateConfig(config *pc.Log) error {
 	if config.Level == "" {
 		config.Level = DEFAULT_LOG_LEVEL
@@ -0,0 +1,92 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// How long to wait for KILL QUERY when the ctx of a Conn is done.
+const killTimeout = 5 * time.Second
+
+// A Queryer runs queries, like *sql.DB, *sql.Conn, *sql.Tx and *Conn.
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// A Conn is a single connection to MySQL. When the ctx of a query is done,
+// the driver only closes the connection and MySQL keeps running the query,
+// e.g. a stuck EXPLAIN, so Conn kills the running query with KILL QUERY
+// when the ctx of the Conn is done.
+type Conn struct {
+	*sql.Conn
+	id      int64
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newConn(ctx context.Context, db *sql.DB) (*Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var id int64
+	if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &Conn{
+		Conn:    conn,
+		id:      id,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go c.killOnCancel(ctx, db)
+	return c, nil
+}
+
+// Id returns the MySQL connection id, i.e. CONNECTION_ID().
+func (c *Conn) Id() int64 {
+	return c.id
+}
+
+// Close stops killing queries and returns the connection to the pool.
+func (c *Conn) Close() error {
+	close(c.done)
+	<-c.stopped // don't kill a query of the next user of the connection
+	return c.Conn.Close()
+}
+
+func (c *Conn) killOnCancel(ctx context.Context, db *sql.DB) {
+	defer close(c.stopped)
+	select {
+	case <-ctx.Done():
+		// Kill on another connection from the pool, the ctx is done so it
+		// can't be used. The query might have finished already, so errors
+		// like ER_NO_SUCH_THREAD are expected and ignored.
+		killCtx, cancel := context.WithTimeout(context.Background(), killTimeout)
+		defer cancel()
+		db.ExecContext(killCtx, fmt.Sprintf("KILL QUERY %d", c.id))
+	case <-c.done:
+	}
+}
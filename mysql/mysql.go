@@ -18,6 +18,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -45,6 +46,8 @@ type Connector interface {
 	VersionConstraint(constraint string) (bool, error)
 	AtLeastVersion(string) (bool, error)
 	Connect() error
+	ConnectContext(ctx context.Context) error
+	Conn(ctx context.Context) (*Conn, error)
 	Close()
 	DB() *sql.DB
 	DSN() string
@@ -82,6 +85,11 @@ func (c *Connection) DSN() string {
 }
 
 func (c *Connection) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect which gives up when the ctx is done.
+func (c *Connection) ConnectContext(ctx context.Context) error {
 	c.Lock()
 	defer c.Unlock()
 	if c.connected {
@@ -97,7 +105,7 @@ func (c *Connection) Connect() error {
 	}
 
 	// Must call sql.DB.Ping to test actual MySQL connection.
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
 		db.Close()
 		return fmt.Errorf("Cannot connect to MySQL %s: %s", dsn.HidePassword(c.dsn), FormatError(err))
 	}
@@ -107,6 +115,20 @@ func (c *Connection) Connect() error {
 	return nil
 }
 
+// Conn returns a single connection to MySQL on which queries are killed when
+// the ctx is done. The caller must Close it.
+func (c *Connection) Conn(ctx context.Context) (*Conn, error) {
+	// Don't hold the lock while connecting: if Close closes the sql.DB
+	// meanwhile, newConn returns an error.
+	c.Lock()
+	db, connected := c.conn, c.connected
+	c.Unlock()
+	if !connected {
+		return nil, ErrNotConnected
+	}
+	return newConn(ctx, db)
+}
+
 func (c *Connection) Close() {
 	c.Lock()
 	defer c.Unlock()
@@ -18,10 +18,13 @@
 package mysql_test
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/percona/qan-agent/mysql"
 	"github.com/stretchr/testify/require"
@@ -63,6 +66,33 @@ func (s *MysqlTestSuite) TestConnection(t *C) {
 	t.Check(conn.DB(), IsNil)
 }
 
+func (s *MysqlTestSuite) TestConnKillOnCancel(t *C) {
+	conn := mysql.NewConnection(dsn)
+	err := conn.Connect()
+	t.Assert(err, IsNil)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	c, err := conn.Conn(ctx)
+	t.Assert(err, IsNil)
+	id := c.Id()
+
+	start := time.Now()
+	_, err = c.ExecContext(ctx, "SELECT SLEEP(30)")
+	t.Check(err, NotNil)
+	t.Check(time.Since(start) < 5*time.Second, Equals, true)
+	c.Close()
+
+	// The query was killed, not only abandoned by the client.
+	var info sql.NullString
+	err = conn.DB().QueryRow("SELECT INFO FROM information_schema.PROCESSLIST WHERE ID = ?", id).Scan(&info)
+	if err != sql.ErrNoRows {
+		t.Check(err, IsNil)
+		t.Check(info.String, Not(Equals), "SELECT SLEEP(30)")
+	}
+}
+
 func (s *MysqlTestSuite) TestMissingSocketError(t *C) {
 	// https://jira.percona.com/browse/PCT-791
 	conn := mysql.NewConnection("percona:percona@unix(/foo/bar/my.sock)/")
@@ -18,11 +18,14 @@
 package pct
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 const (
@@ -30,13 +33,18 @@ const (
 	CONFIG_FILE_SUFFIX   = ".conf"
 	INSTANCE_FILE_SUFFIX = ".json"
 	// Relative to Basedir.path:
-	CONFIG_DIR   = "config"
-	INSTANCE_DIR = "instance"
-	DATA_DIR     = "data"
-	BIN_DIR      = "bin"
-	TRASH_DIR    = "trash"
-	START_LOCK   = "start.lock"
-	START_SCRIPT = "start.sh"
+	CONFIG_DIR       = "config"
+	INSTANCE_DIR     = "instance"
+	DATA_DIR         = "data"
+	BIN_DIR          = "bin"
+	TRASH_DIR        = "trash"
+	AUDIT_LOG        = "audit.log"
+	POLICY_FILE      = "policy.json"
+	MANIFEST_FILE    = "manifest.json"
+	CREDENTIALS_FILE = "credentials.json"
+	// Previous versions of each config file kept as <name>.conf.1 (latest)
+	// to <name>.conf.N, so config changes can be rolled back.
+	CONFIG_VERSIONS = 5
 )
 
 type basedir struct {
@@ -119,10 +127,14 @@ func (b *basedir) Dir(service string) string {
 
 func (b *basedir) File(file string) string {
 	switch file {
-	case "start-lock":
-		file = START_LOCK
-	case "start-script":
-		file = START_SCRIPT
+	case "audit-log":
+		file = AUDIT_LOG
+	case "policy":
+		file = POLICY_FILE
+	case "manifest":
+		file = MANIFEST_FILE
+	case "credentials":
+		file = CREDENTIALS_FILE
 	default:
 		log.Panicf("Unknown basedir file: %s", file)
 	}
@@ -142,19 +154,56 @@ func (b *basedir) ReadConfig(name string, v interface{}) (string, error) {
 }
 
 func (b *basedir) WriteConfig(name string, v interface{}) error {
-	return b.writeFile(b.ConfigFile(name), v)
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	return b.WriteConfigString(name, string(data))
 }
 
+// RemoveConfig removes the config file, keeping it as version 1.
 func (b *basedir) RemoveConfig(service string) error {
+	if err := b.versionConfig(service, nil); err != nil {
+		return err
+	}
 	configFile := filepath.Join(b.configDir, service+CONFIG_FILE_SUFFIX)
 	return RemoveFile(configFile)
 }
 
+// WriteConfigString writes the config file, keeping the current one as
+// version 1 if it's different.
 func (b *basedir) WriteConfigString(service, config string) error {
+	if err := b.versionConfig(service, []byte(config)); err != nil {
+		return err
+	}
 	configFile := filepath.Join(b.configDir, service+CONFIG_FILE_SUFFIX)
 	return ioutil.WriteFile(configFile, []byte(config), 0600)
 }
 
+func (b *basedir) ConfigVersionFile(service string, version int) string {
+	return b.ConfigFile(service) + "." + strconv.Itoa(version)
+}
+
+// ReadConfigVersion reads a previous version of the config file, 1 being
+// the latest.
+func (b *basedir) ReadConfigVersion(service string, version int, v interface{}) (string, error) {
+	if version < 1 || version > CONFIG_VERSIONS {
+		return "", fmt.Errorf("invalid config version: %d, expected 1 to %d", version, CONFIG_VERSIONS)
+	}
+	file := b.ConfigVersionFile(service, version)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no version %d of %s config", version, service)
+		}
+		return "", err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", fmt.Errorf("invalid %s: %s", file, err)
+	}
+	return string(data), nil
+}
+
 func (b *basedir) ReadInstance(uuid string, v interface{}) error {
 	_, err := b.readFile(b.InstanceFile(uuid), v)
 	return err
@@ -186,3 +235,25 @@ func (b *basedir) writeFile(filePath string, config interface{}) error {
 	}
 	return ioutil.WriteFile(filePath, data, 0600)
 }
+
+// versionConfig copies the current config file, if any, to version 1 after
+// shifting the older versions, unless it's the same as the new config.
+func (b *basedir) versionConfig(service string, config []byte) error {
+	current, err := ioutil.ReadFile(b.ConfigFile(service))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if bytes.Equal(current, config) {
+		return nil
+	}
+	for v := CONFIG_VERSIONS - 1; v > 0; v-- {
+		err := os.Rename(b.ConfigVersionFile(service, v), b.ConfigVersionFile(service, v+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ioutil.WriteFile(b.ConfigVersionFile(service, 1), current, 0600)
+}
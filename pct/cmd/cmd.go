@@ -18,6 +18,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"os"
 	"os/exec"
@@ -79,6 +80,11 @@ func NewRealCmd(name string, args ...string) *RealCmd {
 }
 
 func (c *RealCmd) Run() (output string, err error) {
+	return c.RunContext(context.Background())
+}
+
+// RunContext runs the cmd like Run, but kills it if the ctx is done first.
+func (c *RealCmd) RunContext(ctx context.Context) (output string, err error) {
 	var basepath string
 	osPath := os.Getenv("PATH")
 	defer func() {
@@ -107,7 +113,7 @@ func (c *RealCmd) Run() (output string, err error) {
 		}
 		args = append(args, arg)
 	}
-	cmd := exec.Command(c.name, args...)
+	cmd := exec.CommandContext(ctx, c.name, args...)
 	if outFilename != "" {
 		outfile, err = os.Create(outFilename)
 		if err != nil {
@@ -136,6 +142,9 @@ func (c *RealCmd) Run() (output string, err error) {
 			return "", ErrKillProcessAfterTimeout
 		}
 		return "", ErrTimeout
+	case <-ctx.Done():
+		// exec kills the process.
+		return "", ctx.Err()
 	case result := <-resultChan:
 		execError, ok := result.err.(*exec.Error)
 		if ok && execError.Err == exec.ErrNotFound {
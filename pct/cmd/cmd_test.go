@@ -18,9 +18,11 @@
 package cmd_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/percona/qan-agent/pct/cmd"
 	. "gopkg.in/check.v1"
@@ -71,3 +73,14 @@ func (s *TestSuite) TestCmdRedirectOutput(t *C) {
 	t.Assert(string(content), Equals, string(gotContent))
 	os.Remove(output)
 }
+
+func (s *TestSuite) TestCmdContext(t *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sleep := cmd.NewRealCmd("sleep", "10")
+	t0 := time.Now()
+	output, err := sleep.RunContext(ctx)
+	t.Check(time.Now().Sub(t0) < 5*time.Second, Equals, true)
+	t.Check(output, Equals, "")
+	t.Check(err, Equals, context.DeadlineExceeded)
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/percona/pmm/proto"
+)
+
+// SetConfigFlags are optional keys in the data of a SetConfig cmd, next to
+// the config keys. Services ignore unknown config keys, so they're not part
+// of the config.
+type SetConfigFlags struct {
+	DryRun bool `json:",omitempty"` // validate the config and return the diff, don't apply it
+}
+
+// GetSetConfigFlags returns the flags of a SetConfig cmd.
+func GetSetConfigFlags(cmd *proto.Cmd) SetConfigFlags {
+	flags := SetConfigFlags{}
+	json.Unmarshal(cmd.Data, &flags) // the config is validated by the service
+	return flags
+}
+
+// A ConfigChange is a config key, like "Limits.MaxAge", whose value changes.
+// Old or New is nil if the key is not set.
+type ConfigChange struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+// DiffConfig returns the changes from the old to the new config, sorted by key.
+func DiffConfig(oldConfig, newConfig interface{}) ([]ConfigChange, error) {
+	oldKeys, err := configKeys(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newKeys, err := configKeys(newConfig)
+	if err != nil {
+		return nil, err
+	}
+	changes := []ConfigChange{}
+	for key, oldVal := range oldKeys {
+		if newVal, ok := newKeys[key]; !ok || !reflect.DeepEqual(oldVal, newVal) {
+			changes = append(changes, ConfigChange{Key: key, Old: oldVal, New: newVal})
+		}
+	}
+	for key, newVal := range newKeys {
+		if _, ok := oldKeys[key]; !ok {
+			changes = append(changes, ConfigChange{Key: key, New: newVal})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes, nil
+}
+
+// configKeys returns the config keys and values as encoded in JSON, nested
+// keys joined by dots.
+func configKeys(config interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("config is not a JSON object: %s", err)
+	}
+	keys := map[string]interface{}{}
+	flattenKeys("", m, keys)
+	return keys, nil
+}
+
+func flattenKeys(prefix string, m map[string]interface{}, keys map[string]interface{}) {
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenKeys(prefix+k+".", nested, keys)
+			continue
+		}
+		keys[prefix+k] = v
+	}
+}
+
+// Rollback is the data of a RollbackConfig cmd, which applies a previous
+// version of a config file like SetConfig.
+type Rollback struct {
+	UUID    string `json:",omitempty"` // of the instance, for per-instance configs like QAN
+	Version int    `json:",omitempty"` // 1 (default) is the previous version, up to CONFIG_VERSIONS
+}
+
+// GetRollback returns the data of a RollbackConfig cmd, which is optional.
+func GetRollback(cmd *proto.Cmd) (Rollback, error) {
+	r := Rollback{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, &r); err != nil {
+			return r, err
+		}
+	}
+	if r.Version == 0 {
+		r.Version = 1
+	}
+	return r, nil
+}
@@ -0,0 +1,137 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+/////////////////////////////////////////////////////////////////////////////
+// config.go and basedir.go config versions test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type ConfigTestSuite struct {
+	tmpDir string
+}
+
+var _ = Suite(&ConfigTestSuite{})
+
+func (s *ConfigTestSuite) SetUpSuite(t *C) {
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-test")
+	t.Assert(err, IsNil)
+	err = pct.Basedir.Init(s.tmpDir)
+	t.Assert(err, IsNil)
+}
+
+func (s *ConfigTestSuite) TearDownSuite(t *C) {
+	err := os.RemoveAll(s.tmpDir)
+	t.Check(err, IsNil)
+}
+
+// --------------------------------------------------------------------------
+
+func (s *ConfigTestSuite) TestConfigVersions(t *C) {
+	// No previous version yet.
+	config := &pc.Log{}
+	_, err := pct.Basedir.ReadConfigVersion("log", 1, config)
+	t.Check(err, ErrorMatches, "no version 1 of log config")
+	_, err = pct.Basedir.ReadConfigVersion("log", pct.CONFIG_VERSIONS+1, config)
+	t.Check(err, ErrorMatches, "invalid config version: 6, expected 1 to 5")
+
+	// Each write keeps the current config as version 1, up to CONFIG_VERSIONS.
+	levels := []string{"debug", "info", "notice", "warning", "error", "critical", "alert"}
+	for _, level := range levels {
+		err := pct.Basedir.WriteConfig("log", &pc.Log{Level: level})
+		t.Assert(err, IsNil)
+	}
+	for v := 1; v <= pct.CONFIG_VERSIONS; v++ {
+		config := &pc.Log{}
+		_, err := pct.Basedir.ReadConfigVersion("log", v, config)
+		t.Assert(err, IsNil)
+		t.Check(config.Level, Equals, levels[len(levels)-1-v])
+	}
+	t.Check(pct.FileExists(pct.Basedir.ConfigVersionFile("log", pct.CONFIG_VERSIONS+1)), Equals, false)
+
+	// Writing the same config doesn't make a version.
+	err = pct.Basedir.WriteConfig("log", &pc.Log{Level: "alert"})
+	t.Assert(err, IsNil)
+	_, err = pct.Basedir.ReadConfigVersion("log", 1, config)
+	t.Assert(err, IsNil)
+	t.Check(config.Level, Equals, "critical")
+
+	// Removing a config keeps it as version 1.
+	err = pct.Basedir.RemoveConfig("log")
+	t.Assert(err, IsNil)
+	t.Check(pct.FileExists(pct.Basedir.ConfigFile("log")), Equals, false)
+	_, err = pct.Basedir.ReadConfigVersion("log", 1, config)
+	t.Assert(err, IsNil)
+	t.Check(config.Level, Equals, "alert")
+}
+
+func (s *ConfigTestSuite) TestDiffConfig(t *C) {
+	oldConfig := pc.Data{
+		Encoding:     "gzip",
+		SendInterval: 60,
+		Limits: pc.DataSpoolLimits{
+			MaxAge:   3,
+			MaxFiles: 17,
+		},
+	}
+	newConfig := oldConfig
+	newConfig.Encoding = ""
+	newConfig.SendInterval = 1
+	newConfig.Limits.MaxAge = 5
+
+	diff, err := pct.DiffConfig(oldConfig, newConfig)
+	t.Assert(err, IsNil)
+	t.Check(diff, DeepEquals, []pct.ConfigChange{
+		{Key: "Encoding", Old: "gzip", New: nil},
+		{Key: "Limits.MaxAge", Old: float64(3), New: float64(5)},
+		{Key: "SendInterval", Old: float64(60), New: float64(1)},
+	})
+
+	diff, err = pct.DiffConfig(oldConfig, oldConfig)
+	t.Assert(err, IsNil)
+	t.Check(diff, HasLen, 0)
+
+	_, err = pct.DiffConfig(oldConfig, "not a config")
+	t.Check(err, NotNil)
+}
+
+func (s *ConfigTestSuite) TestSetConfigFlags(t *C) {
+	cmd := &proto.Cmd{Cmd: "SetConfig", Data: []byte(`{"Level":"info"}`)}
+	t.Check(pct.GetSetConfigFlags(cmd).DryRun, Equals, false)
+	cmd.Data = []byte(`{"Level":"info","DryRun":true}`)
+	t.Check(pct.GetSetConfigFlags(cmd).DryRun, Equals, true)
+
+	cmd = &proto.Cmd{Cmd: "RollbackConfig"}
+	r, err := pct.GetRollback(cmd)
+	t.Assert(err, IsNil)
+	t.Check(r, Equals, pct.Rollback{Version: 1})
+	cmd.Data = []byte(`{"UUID":"abc","Version":3}`)
+	r, err = pct.GetRollback(cmd)
+	t.Assert(err, IsNil)
+	t.Check(r, Equals, pct.Rollback{UUID: "abc", Version: 3})
+}
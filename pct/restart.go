@@ -0,0 +1,69 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// Exit status which asks the supervisor, e.g. systemd with
+// RestartForceExitStatus=75, to restart the agent. It's EX_TEMPFAIL.
+const RESTART_EXIT_STATUS = 75
+
+// The argv and env the agent was started with, before anything changed them.
+var (
+	startArgs = append([]string{}, os.Args...)
+	startEnv  = os.Environ()
+)
+
+// Supervised returns true if the agent runs under a supervisor which restarts
+// it, i.e. systemd, which sets NOTIFY_SOCKET for Type=notify services.
+func Supervised() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// RestartCmd returns the path, argv and env to re-exec the agent with. The
+// path is resolved from argv[0] so an updated binary is run, not the one
+// this process was loaded from.
+func RestartCmd() (string, []string, []string, error) {
+	bin := startArgs[0]
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		// E.g. argv[0] isn't a path, fall back to the binary of this process.
+		if path, err = os.Executable(); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", nil, nil, err
+	}
+	return path, startArgs, startEnv, nil
+}
+
+// Restart replaces this process with a new agent process with the same pid,
+// argv and env. It only returns on error. The agent must be stopped first.
+func Restart() error {
+	path, argv, env, err := RestartCmd()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, argv, env)
+}
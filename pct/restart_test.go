@@ -0,0 +1,61 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/percona/qan-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+/////////////////////////////////////////////////////////////////////////////
+// restart.go test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type RestartTestSuite struct {
+}
+
+var _ = Suite(&RestartTestSuite{})
+
+func (s *RestartTestSuite) TestRestartCmd(t *C) {
+	path, argv, env, err := pct.RestartCmd()
+	t.Assert(err, IsNil)
+	t.Check(filepath.IsAbs(path), Equals, true)
+	t.Check(pct.FileExists(path), Equals, true)
+	t.Check(argv, DeepEquals, os.Args)
+	t.Check(env, DeepEquals, os.Environ())
+
+	// Changes made while running aren't passed on.
+	os.Setenv("PCT_TEST_RESTART", "1")
+	defer os.Unsetenv("PCT_TEST_RESTART")
+	_, _, env, err = pct.RestartCmd()
+	t.Assert(err, IsNil)
+	t.Check(env, Not(DeepEquals), os.Environ())
+}
+
+func (s *RestartTestSuite) TestSupervised(t *C) {
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	t.Check(pct.Supervised(), Equals, false)
+
+	os.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	t.Check(pct.Supervised(), Equals, true)
+}
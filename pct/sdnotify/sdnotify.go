@@ -0,0 +1,92 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package sdnotify implements the systemd service notification protocol, see
+// sd_notify(3), so the agent can run as a Type=notify service with a watchdog.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States sent with Notify.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// A Notifier sends notifications to the service manager. A nil Notifier, i.e.
+// when the agent isn't run by systemd, discards them.
+type Notifier struct {
+	socket string
+}
+
+// New returns a Notifier for NOTIFY_SOCKET, or nil if it's not set.
+func New() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	return NewNotifier(socket)
+}
+
+// NewNotifier returns a Notifier for the unixgram socket. A socket starting
+// with @ is in the abstract namespace.
+func NewNotifier(socket string) *Notifier {
+	return &Notifier{
+		socket: socket,
+	}
+}
+
+// Notify sends the states, e.g. Ready, in one datagram.
+func (n *Notifier) Notify(states ...string) error {
+	if n == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// Status sends the status shown by systemctl status.
+func (n *Notifier) Status(status string) error {
+	// A newline would start another state.
+	return n.Notify("STATUS=" + strings.Replace(status, "\n", " ", -1))
+}
+
+// WatchdogInterval returns how often the service manager expects Watchdog, or
+// zero if the watchdog isn't enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
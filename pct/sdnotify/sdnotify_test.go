@@ -0,0 +1,120 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sdnotify
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen returns a unixgram socket like the one systemd listens on.
+func listen(t *testing.T, socket string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	return conn
+}
+
+func read(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sdnotify")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn := listen(t, socket)
+	defer conn.Close()
+
+	n := NewNotifier(socket)
+	require.NoError(t, n.Notify(Ready))
+	assert.Equal(t, "READY=1", read(t, conn))
+
+	require.NoError(t, n.Notify(Ready, "STATUS=Idle"))
+	assert.Equal(t, "READY=1\nSTATUS=Idle", read(t, conn))
+
+	require.NoError(t, n.Status("Handling\nStatus"))
+	assert.Equal(t, "STATUS=Handling Status", read(t, conn))
+
+	require.NoError(t, n.Notify(Watchdog))
+	assert.Equal(t, "WATCHDOG=1", read(t, conn))
+
+	// Nothing listens anymore.
+	conn.Close()
+	os.Remove(socket)
+	assert.Error(t, n.Notify(Watchdog))
+}
+
+func TestNotifyAbstract(t *testing.T) {
+	socket := "@qan-agent-sdnotify-test-" + strconv.Itoa(os.Getpid())
+	conn := listen(t, socket)
+	defer conn.Close()
+
+	n := NewNotifier(socket)
+	require.NoError(t, n.Notify(Stopping))
+	assert.Equal(t, "STOPPING=1", read(t, conn))
+}
+
+func TestNew(t *testing.T) {
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	n := New()
+	assert.Nil(t, n)
+	assert.NoError(t, n.Notify(Ready)) // discarded
+	assert.NoError(t, n.Status("Idle"))
+
+	os.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	assert.Equal(t, NewNotifier("/run/systemd/notify"), New())
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Setenv("WATCHDOG_USEC", os.Getenv("WATCHDOG_USEC"))
+	defer os.Setenv("WATCHDOG_PID", os.Getenv("WATCHDOG_PID"))
+
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	// For another process, e.g. the parent which started us.
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getppid()))
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", "")
+	os.Setenv("WATCHDOG_USEC", "invalid")
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+}
@@ -18,6 +18,8 @@
 package pct
 
 import (
+	"context"
+
 	"github.com/percona/pmm/proto"
 )
 
@@ -29,3 +31,23 @@ type ServiceManager interface {
 	GetDefaults(string) map[string]interface{}
 	Handle(cmd *proto.Cmd) *proto.Reply
 }
+
+// A ContextHandler is a ServiceManager which stops handling a cmd when the
+// ctx is done, for example when the cmd times out.
+type ContextHandler interface {
+	HandleContext(ctx context.Context, cmd *proto.Cmd) *proto.Reply
+}
+
+// A LivenessChecker can tell if its goroutines still respond, so a watchdog
+// can restart a wedged agent. Alive returns an error if they don't respond
+// before the ctx is done.
+type LivenessChecker interface {
+	Alive(ctx context.Context) error
+}
+
+// A Reloader applies changes to its config files made outside the agent,
+// e.g. by configuration management tools, without writing them back. Changes
+// which cannot be applied while running take effect on restart.
+type Reloader interface {
+	Reload() []error
+}
@@ -17,10 +17,6 @@
 
 package pct
 
-import (
-	"os"
-)
-
 type SyncChan struct {
 	StartChan chan bool
 	StopChan  chan bool
@@ -76,14 +72,3 @@ func (sync *SyncChan) Graceful() {
 func (sync *SyncChan) IsGraceful() bool {
 	return !sync.Crash
 }
-
-// --------------------------------------------------------------------------
-
-func MakeStartLock() error {
-	flags := os.O_CREATE | os.O_EXCL | os.O_WRONLY
-	file, err := os.OpenFile(Basedir.File("start-lock"), flags, 0644)
-	if err != nil {
-		return err
-	}
-	return file.Close()
-}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-version"
+	"github.com/nu7hatch/gouuid"
 )
 
 func FileSize(fileName string) (int64, error) {
@@ -200,3 +201,12 @@ func ToBool(s string) bool {
 		return false
 	}
 }
+
+// NewUUID returns a random UUID without dashes, like the UUIDs of the API.
+func NewUUID() string {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		panic(err) // only if reading crypto/rand fails
+	}
+	return strings.Replace(u4.String(), "-", "", -1)
+}
@@ -164,3 +164,9 @@ func (s *SysTestSuite) TestAtLeastVersion(t *C) {
 	t.Check(err, IsNil)
 	t.Check(got, Equals, true)
 }
+
+func (s *SysTestSuite) TestNewUUID(t *C) {
+	u1 := pct.NewUUID()
+	t.Check(u1, Matches, "[0-9a-f]{32}")
+	t.Check(pct.NewUUID(), Not(Equals), u1)
+}
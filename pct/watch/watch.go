@@ -0,0 +1,34 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package watch notifies when files in directories are created, changed or
+// removed, so the agent can reload config files changed by other tools.
+package watch
+
+import (
+	"errors"
+)
+
+// ErrNotSupported is returned by New on platforms without inotify.
+var ErrNotSupported = errors.New("watching files is not supported on this platform")
+
+// EVENTS_SIZE is the size of the Events chan.
+const EVENTS_SIZE = 100
+
+// OVERFLOW is received on the Events chan instead of a path when events were
+// lost, e.g. because many files changed at once, so any file may have changed.
+const OVERFLOW = ""
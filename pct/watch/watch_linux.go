@@ -0,0 +1,99 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Files are changed in place (written and closed), replaced (moved over) or
+// removed. Editors and config management tools usually replace them.
+const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// A Watcher watches directories with inotify(7).
+type Watcher struct {
+	f      *os.File
+	dirs   map[int32]string // inotify watch descriptor => dir
+	events chan string
+}
+
+// New returns a Watcher for the dirs. Close it when done.
+func New(dirs ...string) (*Watcher, error) {
+	// Non-blocking so os.File uses the runtime poller and Close unblocks Read.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &Watcher{
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+		events: make(chan string, EVENTS_SIZE),
+	}
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir, mask)
+		if err != nil {
+			w.f.Close()
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+		w.dirs[int32(wd)] = dir
+	}
+	go w.run()
+	return w, nil
+}
+
+// Events returns a chan which receives the path of each file changed in the
+// watched dirs, or OVERFLOW if events were lost. It's closed when the Watcher
+// is closed.
+func (w *Watcher) Events() <-chan string {
+	return w.events
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	return w.f.Close()
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return // closed
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(e.Len)]
+			offset += syscall.SizeofInotifyEvent + int(e.Len)
+
+			if e.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.events <- OVERFLOW
+				continue
+			}
+			dir, ok := w.dirs[e.Wd]
+			if !ok || e.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+			w.events <- filepath.Join(dir, strings.TrimRight(string(name), "\x00"))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package watch
+
+// A Watcher is not supported on this platform.
+type Watcher struct{}
+
+// New returns ErrNotSupported.
+func New(dirs ...string) (*Watcher, error) {
+	return nil, ErrNotSupported
+}
+
+// Events returns nil.
+func (w *Watcher) Events() <-chan string {
+	return nil
+}
+
+// Close does nothing.
+func (w *Watcher) Close() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func next(t *testing.T, w *Watcher) string {
+	select {
+	case file := <-w.Events():
+		return file
+	case <-time.After(1 * time.Second):
+		t.Fatal("no event")
+	}
+	return ""
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w, err := New(dir)
+	require.NoError(t, err)
+
+	// Write in place.
+	file := filepath.Join(dir, "log.conf")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"Level":"debug"}`), 0600))
+	assert.Equal(t, file, next(t, w))
+
+	// Replace, like config management tools do.
+	tmp := filepath.Join(dir, ".data.conf.tmp")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte(`{}`), 0600))
+	assert.Equal(t, tmp, next(t, w))
+	require.NoError(t, os.Rename(tmp, filepath.Join(dir, "data.conf")))
+	assert.Equal(t, tmp, next(t, w))
+	assert.Equal(t, filepath.Join(dir, "data.conf"), next(t, w))
+
+	// Remove.
+	require.NoError(t, os.Remove(file))
+	assert.Equal(t, file, next(t, w))
+
+	// Close stops the Watcher and closes Events.
+	require.NoError(t, w.Close())
+	select {
+	case _, ok := <-w.Events():
+		assert.False(t, ok)
+	case <-time.After(1 * time.Second):
+		t.Fatal("Events not closed")
+	}
+}
+
+func TestNoDir(t *testing.T) {
+	_, err := New("/does/not/exist")
+	assert.Error(t, err)
+}
@@ -19,7 +19,7 @@ package analyzer
 
 import (
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 // AnalyzerFactory makes an Analyzer, real or mock.
@@ -40,11 +40,18 @@ type Analyzer interface {
 	// Stop stops running analyzer, waits until it stops
 	Stop() error
 	// Config returns analyzer configuration
-	Config() pc.QAN
+	Config() qc.QAN
 	// SetConfig sets configuration of analyzer
-	SetConfig(setConfig pc.QAN)
+	SetConfig(setConfig qc.QAN)
 	// Get default configuration
 	GetDefaults(uuid string) map[string]interface{}
 	// String returns human readable identification of Analyzer
 	String() string
 }
+
+// ConfigValidator is an Analyzer which can validate a config without being
+// started with it.
+type ConfigValidator interface {
+	// ValidateConfig returns the running config for the set config
+	ValidateConfig(setConfig qc.QAN) (qc.QAN, error)
+}
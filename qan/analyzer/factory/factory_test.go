@@ -23,6 +23,7 @@ import (
 
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -143,8 +144,10 @@ func TestFactory_MakeMySQL(t *testing.T) {
 	)
 	require.NoError(t, err)
 
-	pcQan := pc.QAN{
-		CollectFrom: "perfschema",
+	pcQan := qc.QAN{
+		QAN: pc.QAN{
+			CollectFrom: "perfschema",
+		},
 	}
 	plugin.SetConfig(pcQan)
 
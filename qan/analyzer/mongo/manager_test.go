@@ -13,6 +13,7 @@ import (
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan"
 	"github.com/percona/qan-agent/qan/analyzer/factory"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/percona/qan-agent/test/profiling"
@@ -55,10 +56,12 @@ func TestRealStartTool(t *testing.T) {
 
 	// Create the qan config.
 	exampleQueries := true
-	config := &pc.QAN{
-		UUID:           protoInstance.UUID,
-		Interval:       1, // 1 second
-		ExampleQueries: &exampleQueries,
+	config := &qc.QAN{
+		QAN: pc.QAN{
+			UUID:           protoInstance.UUID,
+			Interval:       1, // 1 second
+			ExampleQueries: &exampleQueries,
+		},
 	}
 
 	// Send a StartTool cmd with the qan config to start an analyzer.
@@ -78,7 +81,7 @@ func TestRealStartTool(t *testing.T) {
 	// The manager writes the qan config to disk.
 	data, err := ioutil.ReadFile(pct.Basedir.ConfigFile("qan-" + config.UUID))
 	require.NoError(t, err)
-	gotConfig := &pc.QAN{}
+	gotConfig := &qc.QAN{}
 	err = json.Unmarshal(data, gotConfig)
 	require.NoError(t, err)
 	assert.Equal(t, config, gotConfig)
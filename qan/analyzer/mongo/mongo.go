@@ -7,7 +7,7 @@ import (
 
 	"github.com/percona/pmgo"
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
 
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/pct"
@@ -42,7 +42,7 @@ type MongoAnalyzer struct {
 	spool  data.Spooler
 
 	// dependency from setter SetConfig
-	config pc.QAN
+	config qc.QAN
 
 	// profiler
 	profiler Profiler
@@ -53,12 +53,12 @@ type MongoAnalyzer struct {
 }
 
 // SetConfig sets the config
-func (m *MongoAnalyzer) SetConfig(setConfig pc.QAN) {
+func (m *MongoAnalyzer) SetConfig(setConfig qc.QAN) {
 	m.config = setConfig
 }
 
 // Config returns analyzer running configuration
-func (m *MongoAnalyzer) Config() pc.QAN {
+func (m *MongoAnalyzer) Config() qc.QAN {
 	return m.config
 }
 
@@ -10,8 +10,8 @@ import (
 	"github.com/percona/percona-toolkit/src/go/mongolib/fingerprinter"
 	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	mongostats "github.com/percona/percona-toolkit/src/go/mongolib/stats"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/pmm/proto/qan"
+	qc "github.com/percona/qan-agent/qan/config"
 
 	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
 	"github.com/percona/qan-agent/qan/analyzer/report"
@@ -24,7 +24,7 @@ const (
 )
 
 // New returns configured *Aggregator
-func New(timeStart time.Time, config pc.QAN) *Aggregator {
+func New(timeStart time.Time, config qc.QAN) *Aggregator {
 	defaultExampleQueries := DefaultExampleQueries
 	// verify config
 	if config.Interval == 0 {
@@ -52,7 +52,7 @@ func New(timeStart time.Time, config pc.QAN) *Aggregator {
 // Aggregator aggregates system.profile document
 type Aggregator struct {
 	// dependencies
-	config pc.QAN
+	config qc.QAN
 
 	// status
 	status *status.Status
@@ -225,7 +225,7 @@ func (self *Aggregator) interval(ts time.Time) *qan.Report {
 	result := self.createResult()
 
 	// translate result into report and return it
-	return report.MakeReport(self.config, self.timeStart, self.timeEnd, nil, result)
+	return &report.MakeReport(self.config, self.timeStart, self.timeEnd, nil, result).Report
 }
 
 // TimeStart returns start time for current interval
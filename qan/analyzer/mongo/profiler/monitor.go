@@ -5,7 +5,7 @@ import (
 	"sync"
 
 	"github.com/percona/pmgo"
-	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
 
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/pct"
@@ -20,7 +20,7 @@ func NewMonitor(
 	aggregator *aggregator.Aggregator,
 	logger *pct.Logger,
 	spool data.Spooler,
-	config pc.QAN,
+	config qc.QAN,
 ) *monitor {
 	return &monitor{
 		session:    session,
@@ -39,7 +39,7 @@ type monitor struct {
 	aggregator *aggregator.Aggregator
 	spool      data.Spooler
 	logger     *pct.Logger
-	config     pc.QAN
+	config     qc.QAN
 
 	// internal services
 	services []services
@@ -8,6 +8,7 @@ import (
 	pm "github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/pmm/proto/qan"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -16,8 +17,10 @@ import (
 
 func TestNew(t *testing.T) {
 	docsChan := make(chan pm.SystemProfile)
-	pcQan := pc.QAN{
-		Interval: 60,
+	pcQan := qc.QAN{
+		QAN: pc.QAN{
+			Interval: 60,
+		},
 	}
 	a := aggregator.New(time.Now(), pcQan)
 
@@ -51,8 +54,10 @@ func TestNew(t *testing.T) {
 func TestParser_StartStop(t *testing.T) {
 	var err error
 	docsChan := make(chan pm.SystemProfile)
-	pcQan := pc.QAN{
-		Interval: 60,
+	pcQan := qc.QAN{
+		QAN: pc.QAN{
+			Interval: 60,
+		},
 	}
 	a := aggregator.New(time.Now(), pcQan)
 
@@ -71,8 +76,10 @@ func TestParser_StartStop(t *testing.T) {
 
 func TestParser_running(t *testing.T) {
 	docsChan := make(chan pm.SystemProfile)
-	pcQan := pc.QAN{
-		Interval: 1,
+	pcQan := qc.QAN{
+		QAN: pc.QAN{
+			Interval: 1,
+		},
 	}
 	a := aggregator.New(time.Now(), pcQan)
 	reportChan := a.Start()
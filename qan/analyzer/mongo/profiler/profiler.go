@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/percona/pmgo"
-	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
 
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/pct"
@@ -20,7 +20,7 @@ func New(
 	dialer pmgo.Dialer,
 	logger *pct.Logger,
 	spool data.Spooler,
-	config pc.QAN,
+	config qc.QAN,
 ) *profiler {
 	return &profiler{
 		dialInfo: dialInfo,
@@ -37,7 +37,7 @@ type profiler struct {
 	dialer   pmgo.Dialer
 	spool    data.Spooler
 	logger   *pct.Logger
-	config   pc.QAN
+	config   qc.QAN
 
 	// internal deps
 	monitors   *monitors
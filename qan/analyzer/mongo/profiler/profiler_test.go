@@ -6,12 +6,13 @@ import (
 
 	"github.com/percona/pmgo"
 	"github.com/percona/pmm/proto"
-	"github.com/percona/pmm/proto/config"
+	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/pmm/proto/qan"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/percona/qan-agent/test/profiling"
 )
@@ -35,9 +36,11 @@ func TestCollectingAndSendingData(t *testing.T) {
 	// Create the QAN config.
 	exampleQueries := true
 	qanConfig := config.QAN{
-		UUID:           "12345678",
-		Interval:       5, // seconds
-		ExampleQueries: &exampleQueries,
+		QAN: pc.QAN{
+			UUID:           "12345678",
+			Interval:       5, // seconds
+			ExampleQueries: &exampleQueries,
+		},
 	}
 	plugin := New(dialInfo, dialer, logger, spool, qanConfig)
 
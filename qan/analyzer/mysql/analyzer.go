@@ -18,6 +18,7 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"runtime/debug"
@@ -25,7 +26,6 @@ import (
 	"time"
 
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/mrms"
 	"github.com/percona/qan-agent/mysql"
@@ -34,16 +34,35 @@ import (
 	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/ticker"
 )
 
 const MIN_SLOWLOG_ROTATION_SIZE int64 = 4096
 
+// The analyzer isn't alive if its worker runs longer than this many
+// intervals. Workers stop before the end of their interval, so it's stuck.
+var MaxWorkerIntervals = 2.0
+
 // --------------------------------------------------------------------------
 
+// Collector collects data beside the worker once per interval, for example
+// deadlocks. Collect returns nil data if there's nothing new to spool.
+type Collector interface {
+	Collect() (service string, data interface{}, err error)
+}
+
+// Sampler is a Collector which samples in the background between Collect calls.
+// It's started and stopped with the analyzer.
+type Sampler interface {
+	Collector
+	Start()
+	Stop()
+}
+
 type RealAnalyzer struct {
 	logger      *pct.Logger
-	config      pc.QAN
+	config      qc.QAN
 	iter        iter.IntervalIter
 	mysqlConn   mysql.Connector
 	mrms        mrms.Monitor
@@ -51,10 +70,12 @@ type RealAnalyzer struct {
 	worker      worker.Worker
 	clock       ticker.Manager
 	spool       data.Spooler
+	collectors  []Collector
 	// --
 	name                string
 	mysqlConfiguredChan chan bool
 	workerDoneChan      chan *iter.Interval
+	pingChan            chan chan time.Time
 	status              *pct.Status
 	closeChan           chan struct{}
 	runWg               *sync.WaitGroup
@@ -66,7 +87,7 @@ type RealAnalyzer struct {
 
 func NewRealAnalyzer(
 	logger *pct.Logger,
-	config pc.QAN,
+	config qc.QAN,
 	it iter.IntervalIter,
 	mysqlConn mysql.Connector,
 	restartChan chan proto.Instance,
@@ -91,6 +112,7 @@ func NewRealAnalyzer(
 		name:                name,
 		mysqlConfiguredChan: make(chan bool), // note: this channel can't be buffered
 		workerDoneChan:      make(chan *iter.Interval, 1),
+		pingChan:            make(chan chan time.Time),
 		status:              pct.NewStatus([]string{name, name + "-last-interval", name + "-next-interval"}),
 		mux:                 &sync.RWMutex{},
 	}
@@ -114,10 +136,20 @@ func (a *RealAnalyzer) Start() error {
 	a.runWg = &sync.WaitGroup{}
 	a.runWg.Add(1)
 	go a.run()
+	for _, c := range a.collectors {
+		if s, ok := c.(Sampler); ok {
+			s.Start()
+		}
+	}
 	a.running = true
 	return nil
 }
 
+// AddCollector adds a collector run after the worker, before Start.
+func (a *RealAnalyzer) AddCollector(c Collector) {
+	a.collectors = append(a.collectors, c)
+}
+
 func (a *RealAnalyzer) Stop() error {
 	a.logger.Debug("Stop:call")
 	defer a.logger.Debug("Stop:return")
@@ -129,6 +161,11 @@ func (a *RealAnalyzer) Stop() error {
 
 	close(a.closeChan)
 	a.runWg.Wait()
+	for _, c := range a.collectors {
+		if s, ok := c.(Sampler); ok {
+			s.Stop()
+		}
+	}
 	a.running = false
 	return nil
 }
@@ -144,11 +181,41 @@ func (a *RealAnalyzer) Status() map[string]string {
 	return a.status.Merge(a.worker.Status())
 }
 
-func (a *RealAnalyzer) Config() pc.QAN {
+// Alive returns an error if the analyzer is running but its run loop doesn't
+// respond before the ctx is done, e.g. because it's wedged or crashed, or if
+// its worker has been running for more than MaxWorkerIntervals.
+func (a *RealAnalyzer) Alive(ctx context.Context) error {
+	a.mux.RLock()
+	running := a.running
+	closeChan := a.closeChan
+	a.mux.RUnlock()
+	if !running {
+		return nil
+	}
+	startedChan := make(chan time.Time, 1)
+	select {
+	case a.pingChan <- startedChan:
+	case <-closeChan: // stopping
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s is not responding: %s", a.name, a.status.Get(a.name))
+	}
+	started := <-startedChan // zero if the worker isn't running
+	if started.IsZero() {
+		return nil
+	}
+	maxRunTime := time.Duration(float64(a.config.Interval) * MaxWorkerIntervals * float64(time.Second))
+	if d := time.Now().Sub(started); d > maxRunTime {
+		return fmt.Errorf("%s-worker has been running for %s: %s", a.name, d, a.status.Get(a.name))
+	}
+	return nil
+}
+
+func (a *RealAnalyzer) Config() qc.QAN {
 	return a.config
 }
 
-func (a *RealAnalyzer) SetConfig(config pc.QAN) {
+func (a *RealAnalyzer) SetConfig(config qc.QAN) {
 	a.config = config
 }
 
@@ -169,6 +236,11 @@ func (a *RealAnalyzer) TakeOverPerconaServerRotation() error {
 	if !boolValue(a.config.SlowLogRotation) {
 		return nil
 	}
+	// The general log is rotated at MaxSlowLogSize, max_slowlog_size is only
+	// for the slow log.
+	if a.config.CollectFrom == "generallog" {
+		return nil
+	}
 
 	// max_slowlog_size: https://www.percona.com/doc/percona-server/LATEST/flexibility/slowlog_rotation.html#max_slowlog_size
 	maxSlowLogSizeNullInt64, err := a.mysqlConn.GetGlobalVarInteger("max_slowlog_size")
@@ -314,6 +386,7 @@ func (a *RealAnalyzer) run() {
 	}()
 
 	workerRunning := false
+	workerStarted := time.Time{}
 	lastTs := time.Time{}
 	currentInterval := &iter.Interval{}
 	for {
@@ -349,10 +422,12 @@ func (a *RealAnalyzer) run() {
 			// the report. When done the interval is returned on workerDoneChan.
 			go a.runWorker(interval)
 			workerRunning = true
+			workerStarted = time.Now()
 		case interval := <-a.workerDoneChan:
 			a.logger.Debug("run:worker:done")
 			a.status.Update(a.name, fmt.Sprintf("Cleaning up after interval '%s'", interval))
 			workerRunning = false
+			workerStarted = time.Time{}
 
 			if interval.StartTime.After(lastTs) {
 				t0 := interval.StartTime.Format("2006-01-02 15:04:05")
@@ -386,6 +461,8 @@ func (a *RealAnalyzer) run() {
 			} else {
 				a.logger.Info(fmt.Sprintf("First interval begins in %.1f seconds", t))
 			}
+		case startedChan := <-a.pingChan: // from Alive
+			startedChan <- workerStarted
 		case <-a.restartChan:
 			a.logger.Debug("run:mysql:restart")
 			// If MySQL is not configured, then configureMySQL() should already
@@ -416,6 +493,9 @@ func (a *RealAnalyzer) runWorker(interval *iter.Interval) {
 		a.logger.Debug(fmt.Sprintf("runWorker:return:%d", interval.Number))
 	}()
 
+	// Run collectors even if the worker fails, they don't depend on it.
+	defer a.runCollectors()
+
 	// Let worker do whatever it needs before it starts processing
 	// the interval. This mostly makes testing easier.
 	if err := a.worker.Setup(interval); err != nil {
@@ -457,6 +537,22 @@ func (a *RealAnalyzer) runWorker(interval *iter.Interval) {
 	}
 }
 
+func (a *RealAnalyzer) runCollectors() {
+	for _, c := range a.collectors {
+		service, data, err := c.Collect()
+		if err != nil {
+			a.logger.Warn(err)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		if err := a.spool.Write(service, data); err != nil {
+			a.logger.Warn("Lost "+service+" data:", err)
+		}
+	}
+}
+
 // boolValue returns the value of the bool pointer passed in or
 // false if the pointer is nil.
 func boolValue(v *bool) bool {
@@ -18,6 +18,7 @@
 package mysql_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"time"
@@ -31,6 +32,7 @@ import (
 	mysqlAnalyzer "github.com/percona/qan-agent/qan/analyzer/mysql"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/slowlog"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/percona/qan-agent/test/mock/interval_iter"
@@ -59,7 +61,7 @@ type AnalyzerTestSuite struct {
 	im            *instance.Repo
 	mysqlUUID     string
 	mysqlInstance proto.Instance
-	config        pc.QAN
+	config        qc.QAN
 }
 
 var _ = Suite(&AnalyzerTestSuite{})
@@ -125,19 +127,21 @@ func (s *AnalyzerTestSuite) SetUpTest(t *C) {
 	// Config needs to be recreated on every test since it can be modified by the test analyzers
 	exampleQueries := true
 	slowLogRotation := true
-	s.config = pc.QAN{
-		UUID:            s.mysqlUUID,
-		CollectFrom:     "slowlog",
-		Interval:        60,
-		MaxSlowLogSize:  MAX_SLOW_LOG_SIZE,
-		SlowLogRotation: &slowLogRotation,
-		Start: []string{
-			"-- start",
+	s.config = qc.QAN{
+		QAN: pc.QAN{
+			UUID:            s.mysqlUUID,
+			CollectFrom:     "slowlog",
+			Interval:        60,
+			MaxSlowLogSize:  MAX_SLOW_LOG_SIZE,
+			SlowLogRotation: &slowLogRotation,
+			Start: []string{
+				"-- start",
+			},
+			Stop: []string{
+				"-- stop",
+			},
+			ExampleQueries: &exampleQueries,
 		},
-		Stop: []string{
-			"-- stop",
-		},
-		ExampleQueries: &exampleQueries,
 	}
 }
 
@@ -203,6 +207,63 @@ func (s *AnalyzerTestSuite) TestRunMockWorker(t *C) {
 	t.Check(a.String(), Equals, "qan-analyzer")
 }
 
+func (s *AnalyzerTestSuite) TestAlive(t *C) {
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0) // TakeOverPerconaServerRotation
+
+	a := mysqlAnalyzer.NewRealAnalyzer(
+		pct.NewLogger(s.logChan, "qan-analyzer"),
+		s.config,
+		s.iter,
+		s.nullmysql,
+		s.restartChan,
+		s.worker,
+		s.clock,
+		s.spool,
+	)
+
+	// Not running is alive, there's nothing to check.
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	t.Check(a.Alive(ctx), IsNil)
+
+	err := a.Start()
+	t.Assert(err, IsNil)
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	t.Check(a.Alive(ctx), IsNil)
+
+	// A worker stuck for more than MaxWorkerIntervals isn't alive.
+	defer func(n float64) { mysqlAnalyzer.MaxWorkerIntervals = n }(mysqlAnalyzer.MaxWorkerIntervals)
+	mysqlAnalyzer.MaxWorkerIntervals = 0.005 // 300ms of the 60s interval
+	s.worker.RunChan <- true                 // Run blocks until it's drained
+	now := time.Now()
+	s.intervalChan <- &iter.Interval{
+		Number:    1,
+		StartTime: now,
+		StopTime:  now.Add(1 * time.Minute),
+	}
+	if !test.WaitState(s.worker.SetupChan) {
+		t.Fatal("Timeout waiting for <-s.worker.SetupChan")
+	}
+	t.Check(a.Alive(ctx), IsNil)
+	time.Sleep(400 * time.Millisecond)
+	err = a.Alive(ctx)
+	t.Assert(err, NotNil)
+	t.Check(err.Error(), Matches, "qan-analyzer-worker has been running for .*")
+
+	// Alive again once the worker is done.
+	test.WaitState(s.worker.RunChan)
+	test.WaitState(s.worker.RunChan)
+	if !test.WaitState(s.worker.CleanupChan) {
+		t.Fatal("Timeout waiting for <-s.worker.CleanupChan")
+	}
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	t.Check(a.Alive(ctx), IsNil)
+
+	err = a.Stop()
+	t.Assert(err, IsNil)
+	t.Check(a.Alive(ctx), IsNil)
+}
+
 func (s *AnalyzerTestSuite) TestStartServiceFast(t *C) {
 	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0) // TakeOverPerconaServerRotation
 
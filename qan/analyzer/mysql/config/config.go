@@ -23,8 +23,8 @@ import (
 	"fmt"
 	"strings"
 
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/mysql"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 var (
@@ -95,14 +95,14 @@ func ReadInfoFromShowGlobalStatus(conn mysql.Connector) (info map[string]interfa
 		default:
 			msg = fmt.Errorf("unable to read global variable: %s", err)
 		}
-		info[underscoreToCamelCase(mysqlVarName)] = msg
+		info[UnderscoreToCamelCase(mysqlVarName)] = msg
 	}
 
 	return info
 }
 
-func ValidateConfig(setConfig pc.QAN) (pc.QAN, error) {
-	runConfig := pc.NewQAN()
+func ValidateConfig(setConfig qc.QAN) (qc.QAN, error) {
+	runConfig := qc.NewQAN()
 	fmt.Printf("%+v\n", runConfig)
 
 	// Marshal setConfig and unmarshal it back on default config.
@@ -121,8 +121,10 @@ func ValidateConfig(setConfig pc.QAN) (pc.QAN, error) {
 	runConfig.UUID = setConfig.UUID
 
 	// Strings
-	if setConfig.CollectFrom != "slowlog" && setConfig.CollectFrom != "perfschema" {
-		return runConfig, fmt.Errorf("CollectFrom must be 'slowlog' or 'perfschema'")
+	switch setConfig.CollectFrom {
+	case "slowlog", "perfschema", "binlog", "generallog", "tcpdump":
+	default:
+		return runConfig, fmt.Errorf("CollectFrom must be 'slowlog', 'perfschema', 'binlog', 'generallog' or 'tcpdump'")
 	}
 	runConfig.CollectFrom = setConfig.CollectFrom
 
@@ -133,12 +135,15 @@ func ValidateConfig(setConfig pc.QAN) (pc.QAN, error) {
 	if setConfig.Interval > 0 {
 		runConfig.Interval = setConfig.Interval
 	}
+	if setConfig.Port > 65535 {
+		return runConfig, fmt.Errorf("Port must be <= 65535")
+	}
 
 	return runConfig, nil
 }
 
 // UnderscoreToCamelCase converts from underscore separated form to camel case form.
 // Ex.: my_func => MyFunc
-func underscoreToCamelCase(s string) string {
+func UnderscoreToCamelCase(s string) string {
 	return strings.Replace(strings.Title(strings.Replace(strings.ToLower(s), "_", " ", -1)), " ", "", -1)
 }
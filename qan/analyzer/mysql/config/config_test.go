@@ -4,19 +4,40 @@ import (
 	"testing"
 
 	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestValidateConfig(t *testing.T) {
 	uuid := "123"
 	exampleQueries := true
-	cfg := pc.QAN{
-		UUID:           uuid,
-		Interval:       300,        // 5 min
-		MaxSlowLogSize: 1073741824, // 1 GiB
-		ExampleQueries: &exampleQueries,
-		CollectFrom:    "slowlog",
+	cfg := qc.QAN{
+		QAN: pc.QAN{
+			UUID:           uuid,
+			Interval:       300,        // 5 min
+			MaxSlowLogSize: 1073741824, // 1 GiB
+			ExampleQueries: &exampleQueries,
+			CollectFrom:    "slowlog",
+		},
 	}
 	_, err := ValidateConfig(cfg)
 	require.NoError(t, err)
 }
+
+func TestValidateConfigCollectFrom(t *testing.T) {
+	for _, collectFrom := range []string{"slowlog", "perfschema", "binlog", "generallog", "tcpdump"} {
+		cfg, err := ValidateConfig(qc.QAN{QAN: pc.QAN{CollectFrom: collectFrom}})
+		require.NoError(t, err, collectFrom)
+		assert.Equal(t, collectFrom, cfg.CollectFrom)
+	}
+	_, err := ValidateConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "pcap"}})
+	require.Error(t, err)
+
+	cfg, err := ValidateConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "tcpdump"}, PcapFile: "/tmp/mysql.pcap", Port: 3307})
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/mysql.pcap", cfg.PcapFile)
+	assert.Equal(t, uint(3307), cfg.Port)
+	_, err = ValidateConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "tcpdump"}, Port: 70000})
+	require.Error(t, err)
+}
@@ -65,7 +65,27 @@ func (f *RealIntervalIterFactory) Make(analyzerType string, mysqlConn mysql.Conn
 			return filename, nil
 		}
 		return slowlog.NewIter(pct.NewLogger(f.logChan, "qan-interval"), getSlowLogFunc, tickChan)
-	case "perfschema":
+	case "generallog":
+		getGeneralLogFunc := func() (string, error) {
+			if err := mysqlConn.Connect(); err != nil {
+				return "", err
+			}
+			defer mysqlConn.Close()
+			dataDir, err := mysqlConn.GetGlobalVarString("datadir")
+			if err != nil {
+				return "", err
+			}
+			generalLogFile, err := mysqlConn.GetGlobalVarString("general_log_file")
+			if err != nil {
+				return "", err
+			}
+			return AbsDataFile(dataDir.String, generalLogFile.String), nil
+		}
+		return slowlog.NewIter(pct.NewLogger(f.logChan, "qan-interval"), getGeneralLogFunc, tickChan)
+	case "perfschema", "slowlog-table", "binlog", "tcpdump":
+		// mysql.slow_log is read by start_time, binary logs by position and
+		// packets as they're captured, so they only need intervals of time
+		// like perfschema.
 		return perfschema.NewIter(pct.NewLogger(f.logChan, "qan-interval"), tickChan)
 	default:
 		panic("Invalid analyzerType: " + analyzerType)
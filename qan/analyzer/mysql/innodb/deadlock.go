@@ -0,0 +1,97 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package innodb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/percona/qan-agent/mysql"
+)
+
+// DeadlockService is the data type of spooled deadlocks.
+const DeadlockService = "qan-deadlock"
+
+// DeadlockReport is a new deadlock, spooled as its own data type.
+type DeadlockReport struct {
+	UUID string // of MySQL instance
+	Deadlock
+}
+
+// DeadlockCollector reports the latest detected deadlock when it changes.
+type DeadlockCollector struct {
+	uuid string
+	conn mysql.Connector
+	// --
+	last    string
+	started bool
+}
+
+// NewDeadlockCollector returns a collector of deadlocks of MySQL instance uuid.
+// The collector connects and disconnects conn on every Collect.
+func NewDeadlockCollector(uuid string, conn mysql.Connector) *DeadlockCollector {
+	return &DeadlockCollector{
+		uuid: uuid,
+		conn: conn,
+	}
+}
+
+// Collect returns the latest detected deadlock if it's new since the last call.
+// The deadlock found by the first call is only remembered, not returned, because
+// it was probably reported before the agent restarted.
+func (c *DeadlockCollector) Collect() (string, interface{}, error) {
+	if err := c.conn.Connect(); err != nil {
+		return "", nil, err
+	}
+	defer c.conn.Close()
+
+	status, err := Read(context.Background(), c.conn)
+	if err != nil {
+		return "", nil, err
+	}
+
+	started := c.started
+	c.started = true
+	if status.Deadlock == nil {
+		return "", nil, nil
+	}
+	key := deadlockKey(status.Deadlock)
+	if key == c.last {
+		return "", nil, nil
+	}
+	c.last = key
+	if !started {
+		return "", nil, nil
+	}
+
+	report := &DeadlockReport{
+		UUID:     c.uuid,
+		Deadlock: *status.Deadlock,
+	}
+	return DeadlockService, report, nil
+}
+
+// deadlockKey identifies a deadlock by its time and transactions, time alone
+// has only one second resolution.
+func deadlockKey(d *Deadlock) string {
+	key := []string{d.Time}
+	for _, trx := range d.Transactions {
+		key = append(key, trx.Id)
+	}
+	return strings.Join(key, " ")
+}
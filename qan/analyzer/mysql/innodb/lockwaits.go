@@ -0,0 +1,383 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package innodb
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+)
+
+// LockWaitService is the data type of spooled lock wait reports.
+const LockWaitService = "qan-lockwait"
+
+// DefaultLockWaitPeriod is how often lock waits are sampled.
+const DefaultLockWaitPeriod = time.Second
+
+// LockWait is one transaction waiting for a lock held by another transaction.
+type LockWait struct {
+	Table               string
+	Index               string `json:",omitempty"`
+	WaitAge             int64  // seconds
+	WaitingTrxId        string
+	WaitingThreadId     int64
+	WaitingQuery        string
+	WaitingFingerprint  string
+	WaitingClassId      string
+	BlockingTrxId       string
+	BlockingThreadId    int64
+	BlockingQuery       string // last statement if the blocker is idle
+	BlockingIdle        bool   // blocker isn't running a statement, e.g. idle in transaction
+	BlockingFingerprint string
+	BlockingClassId     string
+	Chain               []int64 // thread IDs from the waiting thread to the root blocker
+}
+
+// BlockedClass is the time one query class spent blocked by another.
+type BlockedClass struct {
+	ClassId             string
+	Fingerprint         string
+	BlockingClassId     string
+	BlockingFingerprint string
+	BlockingIdle        bool
+	Seconds             float64 // estimated from samples
+	MaxWaitAge          int64
+	Waits               int // distinct waiting transactions
+	Example             LockWait
+}
+
+// LockWaitReport is lock waits sampled between two Collect calls.
+type LockWaitReport struct {
+	UUID    string // of MySQL instance
+	StartTs time.Time
+	EndTs   time.Time
+	Period  float64 // seconds between samples
+	Samples int
+	Classes []BlockedClass // most blocked first
+	Errors  []string       `json:",omitempty"`
+}
+
+// Queries return the same columns as sys.innodb_lock_waits which is tried first.
+const (
+	sysLockWaitsQuery = `
+SELECT COALESCE(locked_table, ''), COALESCE(locked_index, ''), COALESCE(wait_age_secs, 0),
+       waiting_trx_id, COALESCE(waiting_pid, 0), COALESCE(waiting_query, ''),
+       blocking_trx_id, COALESCE(blocking_pid, 0), COALESCE(blocking_query, '')
+  FROM sys.innodb_lock_waits`
+
+	dataLockWaitsQuery = `
+SELECT CONCAT(l.OBJECT_SCHEMA, '.', l.OBJECT_NAME), COALESCE(l.INDEX_NAME, ''),
+       COALESCE(TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW()), 0),
+       r.trx_id, r.trx_mysql_thread_id, COALESCE(r.trx_query, ''),
+       b.trx_id, b.trx_mysql_thread_id, COALESCE(b.trx_query, '')
+  FROM performance_schema.data_lock_waits w
+  JOIN performance_schema.data_locks l ON l.ENGINE_LOCK_ID = w.REQUESTING_ENGINE_LOCK_ID
+  JOIN information_schema.INNODB_TRX r ON r.trx_id = w.REQUESTING_ENGINE_TRANSACTION_ID
+  JOIN information_schema.INNODB_TRX b ON b.trx_id = w.BLOCKING_ENGINE_TRANSACTION_ID`
+
+	innodbLockWaitsQuery = `
+SELECT l.lock_table, COALESCE(l.lock_index, ''),
+       COALESCE(TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW()), 0),
+       r.trx_id, r.trx_mysql_thread_id, COALESCE(r.trx_query, ''),
+       b.trx_id, b.trx_mysql_thread_id, COALESCE(b.trx_query, '')
+  FROM information_schema.INNODB_LOCK_WAITS w
+  JOIN information_schema.INNODB_LOCKS l ON l.lock_id = w.requested_lock_id
+  JOIN information_schema.INNODB_TRX r ON r.trx_id = w.requesting_trx_id
+  JOIN information_schema.INNODB_TRX b ON b.trx_id = w.blocking_trx_id`
+
+	lastStatementQuery = `
+SELECT h.SQL_TEXT
+  FROM performance_schema.events_statements_history h
+  JOIN performance_schema.threads t ON t.THREAD_ID = h.THREAD_ID
+ WHERE t.PROCESSLIST_ID = ? AND h.SQL_TEXT IS NOT NULL
+ ORDER BY h.EVENT_ID DESC LIMIT 1`
+)
+
+// DataLockWaitsVersion has performance_schema.data_lock_waits instead of
+// information_schema.INNODB_LOCK_WAITS.
+const DataLockWaitsVersion = ">= 8.0.1, < 10.0.0"
+
+// LockWaits returns current lock waits from sys.innodb_lock_waits, or from
+// performance_schema.data_lock_waits (8.0) or information_schema.INNODB_LOCK_WAITS
+// if there's no sys schema. Blocking chains are resolved and the statements fingerprinted.
+func LockWaits(c mysql.Connector) ([]LockWait, error) {
+	rows, err := c.DB().Query(sysLockWaitsQuery)
+	if err != nil {
+		query := innodbLockWaitsQuery
+		if ok, _ := c.VersionConstraint(DataLockWaitsVersion); ok {
+			query = dataLockWaitsQuery
+		}
+		rows, err = c.DB().Query(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	waits := []LockWait{}
+	for rows.Next() {
+		w := LockWait{}
+		err := rows.Scan(&w.Table, &w.Index, &w.WaitAge,
+			&w.WaitingTrxId, &w.WaitingThreadId, &w.WaitingQuery,
+			&w.BlockingTrxId, &w.BlockingThreadId, &w.BlockingQuery)
+		if err != nil {
+			return nil, err
+		}
+		w.Table = unquote(w.Table)
+		w.Index = unquote(w.Index)
+		waits = append(waits, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range waits {
+		w := &waits[i]
+		if w.BlockingQuery == "" {
+			// The blocker is idle in transaction, its last statement is likely
+			// the one which took the lock.
+			w.BlockingIdle = true
+			var last sql.NullString
+			if err := c.DB().QueryRow(lastStatementQuery, w.BlockingThreadId).Scan(&last); err == nil && last.Valid {
+				w.BlockingQuery = last.String
+			}
+		}
+		fingerprintWait(w)
+	}
+	chains(waits)
+	return waits, nil
+}
+
+func fingerprintWait(w *LockWait) {
+	if w.WaitingQuery != "" {
+		w.WaitingFingerprint, w.WaitingClassId = util.Fingerprint(w.WaitingQuery)
+	}
+	if w.BlockingQuery != "" {
+		w.BlockingFingerprint, w.BlockingClassId = util.Fingerprint(w.BlockingQuery)
+	}
+}
+
+// chains sets Chain of every wait: the waiting thread, its blocker, the blocker's
+// blocker and so on to the root blocker, which isn't waiting.
+func chains(waits []LockWait) {
+	blockedBy := map[int64]int64{}
+	for _, w := range waits {
+		// A transaction can wait for several blockers, follow the first one.
+		if _, ok := blockedBy[w.WaitingThreadId]; !ok {
+			blockedBy[w.WaitingThreadId] = w.BlockingThreadId
+		}
+	}
+	for i := range waits {
+		w := &waits[i]
+		w.Chain = []int64{w.WaitingThreadId, w.BlockingThreadId}
+		seen := map[int64]bool{w.WaitingThreadId: true, w.BlockingThreadId: true}
+		for next, ok := blockedBy[w.BlockingThreadId]; ok && !seen[next]; next, ok = blockedBy[next] {
+			w.Chain = append(w.Chain, next)
+			seen[next] = true
+		}
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// LockWaitCollector samples lock waits in the background and reports, on every
+// Collect, how long each class was blocked by another class.
+type LockWaitCollector struct {
+	uuid   string
+	conn   mysql.Connector
+	period time.Duration
+	// --
+	mux       *sync.Mutex
+	agg       *lockWaitAgg
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+	lastError string
+}
+
+// NewLockWaitCollector returns a collector of lock waits of MySQL instance uuid,
+// sampled every period.
+func NewLockWaitCollector(uuid string, conn mysql.Connector, period time.Duration) *LockWaitCollector {
+	return &LockWaitCollector{
+		uuid:   uuid,
+		conn:   conn,
+		period: period,
+		mux:    &sync.Mutex{},
+		agg:    newLockWaitAgg(time.Now().UTC()),
+	}
+}
+
+// Start starts sampling.
+func (c *LockWaitCollector) Start() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.stopChan != nil {
+		return
+	}
+	c.stopChan = make(chan struct{})
+	c.doneChan = make(chan struct{})
+	c.agg = newLockWaitAgg(time.Now().UTC())
+	go c.run(c.stopChan, c.doneChan)
+}
+
+// Stop stops sampling and waits for the last sample.
+func (c *LockWaitCollector) Stop() {
+	c.mux.Lock()
+	stopChan, doneChan := c.stopChan, c.doneChan
+	c.stopChan, c.doneChan = nil, nil
+	c.mux.Unlock()
+	if stopChan == nil {
+		return
+	}
+	close(stopChan)
+	<-doneChan
+}
+
+// Collect returns a LockWaitReport of lock waits since the previous call, or
+// nil if there were none.
+func (c *LockWaitCollector) Collect() (string, interface{}, error) {
+	now := time.Now().UTC()
+	c.mux.Lock()
+	agg := c.agg
+	c.agg = newLockWaitAgg(now)
+	c.mux.Unlock()
+
+	report := agg.report(c.uuid, now, c.period)
+	if report == nil {
+		return "", nil, nil
+	}
+	return LockWaitService, report, nil
+}
+
+func (c *LockWaitCollector) run(stopChan, doneChan chan struct{}) {
+	defer close(doneChan)
+	defer c.conn.Close()
+
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sample()
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (c *LockWaitCollector) sample() {
+	var waits []LockWait
+	err := c.conn.Connect()
+	if err == nil {
+		waits, err = LockWaits(c.conn)
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err != nil {
+		// Report every distinct error once per interval, not once per sample.
+		if err.Error() != c.lastError {
+			c.agg.errors = append(c.agg.errors, err.Error())
+			c.lastError = err.Error()
+		}
+		c.conn.Close()
+		return
+	}
+	c.lastError = ""
+	c.agg.add(waits, c.period)
+}
+
+// --------------------------------------------------------------------------
+
+type blockedKey struct {
+	classId, blockingClassId string
+}
+
+type lockWaitAgg struct {
+	start   time.Time
+	samples int
+	classes map[blockedKey]*BlockedClass
+	trxs    map[blockedKey]map[string]bool
+	errors  []string
+}
+
+func newLockWaitAgg(start time.Time) *lockWaitAgg {
+	return &lockWaitAgg{
+		start:   start,
+		classes: map[blockedKey]*BlockedClass{},
+		trxs:    map[blockedKey]map[string]bool{},
+	}
+}
+
+// add adds one sample: every wait in it lasted about period.
+func (a *lockWaitAgg) add(waits []LockWait, period time.Duration) {
+	a.samples++
+	for _, w := range waits {
+		key := blockedKey{w.WaitingClassId, w.BlockingClassId}
+		class, ok := a.classes[key]
+		if !ok {
+			class = &BlockedClass{
+				ClassId:             w.WaitingClassId,
+				Fingerprint:         w.WaitingFingerprint,
+				BlockingClassId:     w.BlockingClassId,
+				BlockingFingerprint: w.BlockingFingerprint,
+				BlockingIdle:        w.BlockingIdle,
+			}
+			a.classes[key] = class
+			a.trxs[key] = map[string]bool{}
+		}
+		class.Seconds += period.Seconds()
+		if !a.trxs[key][w.WaitingTrxId] {
+			a.trxs[key][w.WaitingTrxId] = true
+			class.Waits++
+		}
+		if w.WaitAge >= class.MaxWaitAge {
+			class.MaxWaitAge = w.WaitAge
+			class.Example = w
+		}
+	}
+}
+
+func (a *lockWaitAgg) report(uuid string, end time.Time, period time.Duration) *LockWaitReport {
+	if len(a.classes) == 0 && len(a.errors) == 0 {
+		return nil
+	}
+	r := &LockWaitReport{
+		UUID:    uuid,
+		StartTs: a.start,
+		EndTs:   end,
+		Period:  period.Seconds(),
+		Samples: a.samples,
+		Classes: make([]BlockedClass, 0, len(a.classes)),
+		Errors:  a.errors,
+	}
+	for _, class := range a.classes {
+		r.Classes = append(r.Classes, *class)
+	}
+	sort.Slice(r.Classes, func(i, j int) bool {
+		if r.Classes[i].Seconds != r.Classes[j].Seconds {
+			return r.Classes[i].Seconds > r.Classes[j].Seconds
+		}
+		return r.Classes[i].ClassId < r.Classes[j].ClassId
+	})
+	return r
+}
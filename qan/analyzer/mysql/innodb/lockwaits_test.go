@@ -0,0 +1,155 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package innodb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChains(t *testing.T) {
+	t.Parallel()
+
+	// 3 waits for 2 which waits for 1, 4 waits for 1.
+	waits := []LockWait{
+		{WaitingThreadId: 3, BlockingThreadId: 2},
+		{WaitingThreadId: 2, BlockingThreadId: 1},
+		{WaitingThreadId: 4, BlockingThreadId: 1},
+	}
+	chains(waits)
+	assert.Equal(t, []int64{3, 2, 1}, waits[0].Chain)
+	assert.Equal(t, []int64{2, 1}, waits[1].Chain)
+	assert.Equal(t, []int64{4, 1}, waits[2].Chain)
+
+	// Deadlock not detected yet: no infinite loop.
+	waits = []LockWait{
+		{WaitingThreadId: 1, BlockingThreadId: 2},
+		{WaitingThreadId: 2, BlockingThreadId: 1},
+	}
+	chains(waits)
+	assert.Equal(t, []int64{1, 2}, waits[0].Chain)
+}
+
+func TestLockWaitAgg(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1000, 0).UTC()
+	agg := newLockWaitAgg(start)
+	assert.Nil(t, agg.report("1", start, time.Second))
+
+	update := LockWait{
+		WaitingTrxId:    "10",
+		WaitingQuery:    "UPDATE t SET a = 1 WHERE id = 1",
+		BlockingTrxId:   "9",
+		BlockingQuery:   "DELETE FROM t WHERE id = 1",
+		WaitAge:         1,
+		Table:           "test.t",
+		WaitingThreadId: 5,
+	}
+	fingerprintWait(&update)
+	idle := LockWait{
+		WaitingTrxId:  "11",
+		WaitingQuery:  "SELECT * FROM t WHERE id = 2 FOR UPDATE",
+		BlockingTrxId: "8",
+		BlockingIdle:  true,
+	}
+	fingerprintWait(&idle)
+	assert.Empty(t, idle.BlockingClassId)
+
+	agg.add([]LockWait{update, idle}, time.Second)
+	update.WaitAge = 2
+	agg.add([]LockWait{update}, time.Second)
+	agg.add([]LockWait{}, time.Second)
+
+	r := agg.report("1", start.Add(3*time.Second), time.Second)
+	require.NotNil(t, r)
+	assert.Equal(t, "1", r.UUID)
+	assert.Equal(t, start, r.StartTs)
+	assert.Equal(t, 3, r.Samples)
+	assert.Equal(t, 1.0, r.Period)
+	require.Len(t, r.Classes, 2)
+
+	c := r.Classes[0]
+	assert.Equal(t, update.WaitingClassId, c.ClassId)
+	assert.Equal(t, "update t set a = ? where id = ?", c.Fingerprint)
+	assert.Equal(t, update.BlockingClassId, c.BlockingClassId)
+	assert.Equal(t, "delete from t where id = ?", c.BlockingFingerprint)
+	assert.Equal(t, 2.0, c.Seconds)
+	assert.Equal(t, 1, c.Waits)
+	assert.Equal(t, int64(2), c.MaxWaitAge)
+	assert.Equal(t, int64(2), c.Example.WaitAge)
+
+	c = r.Classes[1]
+	assert.Equal(t, idle.WaitingClassId, c.ClassId)
+	assert.True(t, c.BlockingIdle)
+	assert.Equal(t, 1.0, c.Seconds)
+}
+
+func TestLockWaits(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+	conn := mysql.NewConnection(dsn)
+	require.NoError(t, conn.Connect())
+	defer conn.Close()
+
+	_, err := conn.DB().Exec("CREATE DATABASE IF NOT EXISTS lock_waits")
+	require.NoError(t, err)
+	defer conn.DB().Exec("DROP DATABASE IF EXISTS lock_waits")
+	_, err = conn.DB().Exec("CREATE TABLE IF NOT EXISTS lock_waits.t (id INT PRIMARY KEY) ENGINE=InnoDB")
+	require.NoError(t, err)
+	_, err = conn.DB().Exec("INSERT IGNORE INTO lock_waits.t VALUES (1)")
+	require.NoError(t, err)
+
+	// Blocker holds the row lock, idle in transaction.
+	blocker, err := conn.DB().Begin()
+	require.NoError(t, err)
+	_, err = blocker.Exec("SELECT * FROM lock_waits.t WHERE id = 1 FOR UPDATE")
+	if err != nil {
+		blocker.Rollback()
+		t.Fatal(err)
+	}
+
+	waiter, err := conn.DB().Begin()
+	require.NoError(t, err)
+	// Release the blocker first, else the waiter's rollback waits for the lock.
+	defer func() {
+		blocker.Rollback()
+		waiter.Rollback()
+	}()
+	go waiter.Exec("UPDATE lock_waits.t SET id = 1 WHERE id = 1")
+
+	var waits []LockWait
+	for i := 0; i < 50 && len(waits) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		waits, err = LockWaits(conn)
+		require.NoError(t, err)
+	}
+	require.Len(t, waits, 1)
+	w := waits[0]
+	assert.Equal(t, "lock_waits.t", w.Table)
+	assert.Equal(t, "update lock_waits.t set id = ? where id = ?", w.WaitingFingerprint)
+	assert.True(t, w.BlockingIdle)
+	assert.Equal(t, []int64{w.WaitingThreadId, w.BlockingThreadId}, w.Chain)
+}
@@ -0,0 +1,503 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package innodb parses SHOW ENGINE INNODB STATUS.
+package innodb
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+)
+
+// Section titles of SHOW ENGINE INNODB STATUS
+const (
+	SectionSemaphores   = "SEMAPHORES"
+	SectionDeadlock     = "LATEST DETECTED DEADLOCK"
+	SectionTransactions = "TRANSACTIONS"
+	SectionBufferPool   = "BUFFER POOL AND MEMORY"
+)
+
+// InnoDB prints the two transactions of a deadlock, numbered from 1.
+// Higher numbers are garbage and are ignored rather than allocated.
+const maxDeadlockTrx = 16
+
+// Status is parsed SHOW ENGINE INNODB STATUS.
+type Status struct {
+	Time         string    // server time of the output, e.g. 2019-03-14 10:22:31
+	Deadlock     *Deadlock `json:",omitempty"` // latest detected deadlock
+	Semaphores   Semaphores
+	Transactions Transactions
+	BufferPool   BufferPool
+	Sections     map[string]string // raw text of every section, keyed on title
+}
+
+// Deadlock is the LATEST DETECTED DEADLOCK section.
+type Deadlock struct {
+	Time         string // server time of the deadlock
+	Transactions []Transaction
+	RolledBack   string // Id of transaction rolled back
+}
+
+// Transaction is a transaction from TRANSACTIONS or LATEST DETECTED DEADLOCK.
+type Transaction struct {
+	Id              string
+	State           string // e.g. "starting index read", "not started"
+	ActiveSeconds   int64
+	TablesInUse     int64
+	TablesLocked    int64
+	LockWait        bool
+	LockWaitSeconds int64 `json:",omitempty"`
+	LockStructs     int64
+	RowLocks        int64
+	UndoLogEntries  int64
+	ThreadId        int64
+	QueryId         int64
+	ThreadInfo      string // host, user and thread state
+	Query           string `json:",omitempty"`
+	Fingerprint     string `json:",omitempty"` // of Query, as the slowlog worker does
+	ClassId         string `json:",omitempty"`
+	Holds           []Lock `json:",omitempty"`
+	WaitsFor        []Lock `json:",omitempty"`
+}
+
+// Lock is a RECORD LOCKS or TABLE LOCK line.
+type Lock struct {
+	Type    string // RECORD or TABLE
+	Table   string // db.table
+	Index   string `json:",omitempty"`
+	Mode    string // e.g. "X locks rec but not gap", "IX"
+	Waiting bool
+}
+
+// Semaphores is the SEMAPHORES section.
+type Semaphores struct {
+	ReservationCount int64
+	SignalCount      int64
+	Waits            []SemaphoreWait
+}
+
+// SemaphoreWait is a thread waiting for a semaphore longer than a second.
+type SemaphoreWait struct {
+	Thread  string
+	File    string
+	Line    int64
+	Seconds float64
+	Info    string // the semaphore and who holds it
+}
+
+// Transactions is the TRANSACTIONS section.
+type Transactions struct {
+	TrxIdCounter      string
+	PurgeDone         string // purge done for trx's n:o < PurgeDone
+	HistoryListLength int64
+	Transactions      []Transaction
+}
+
+// BufferPool is the BUFFER POOL AND MEMORY section, in pages.
+type BufferPool struct {
+	Size             int64
+	FreeBuffers      int64
+	DatabasePages    int64
+	OldDatabasePages int64
+	ModifiedPages    int64
+	PendingReads     int64
+	PagesRead        int64
+	PagesCreated     int64
+	PagesWritten     int64
+	HitRate          *float64 `json:",omitempty"` // since last printout, nil if no page gets
+}
+
+var (
+	timeRe        = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d)`)
+	trxRe         = regexp.MustCompile(`^(?:---)?TRANSACTION (\d+), (?:ACTIVE(?: \(PREPARED\))? (\d+) sec)?\s*(.*)$`)
+	tablesRe      = regexp.MustCompile(`^mysql tables in use (\d+), locked (\d+)`)
+	lockStructsRe = regexp.MustCompile(`^(LOCK WAIT )?(\d+) lock struct\(s\), heap size \d+, (\d+) row lock\(s\)(?:, undo log entries (\d+))?`)
+	threadRe      = regexp.MustCompile(`^MySQL thread id (\d+), OS thread handle \S+, query id (\d+)\s*(.*)$`)
+	lockWaitRe    = regexp.MustCompile(`^------- TRX HAS BEEN WAITING (\d+) SEC`)
+	recordLockRe  = regexp.MustCompile(`^RECORD LOCKS space id \d+ page no \d+ n bits \d+ index (\S+) of table (\S+) trx id \d+ lock[_ ]mode (.+?)( waiting)?$`)
+	tableLockRe   = regexp.MustCompile(`^TABLE LOCK table (\S+) trx id \d+ lock mode (.+?)( waiting)?$`)
+	deadlockTrxRe = regexp.MustCompile(`^\*\*\* \((\d+)\) (TRANSACTION|HOLDS THE LOCK\(S\)|WAITING FOR THIS LOCK TO BE GRANTED):`)
+	rollbackRe    = regexp.MustCompile(`^\*\*\* WE ROLL BACK TRANSACTION \((\d+)\)`)
+	semWaitRe     = regexp.MustCompile(`^--Thread (\d+) has waited at (\S+) line (\d+) for ([\d.]+) seconds the semaphore:`)
+	hitRateRe     = regexp.MustCompile(`^Buffer pool hit rate (\d+) / (\d+)`)
+	pagesRe       = regexp.MustCompile(`^Pages read (\d+), created (\d+), written (\d+)`)
+)
+
+// Read runs SHOW ENGINE INNODB STATUS and parses it.
+func Read(ctx context.Context, c mysql.Connector) (*Status, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var typ, name, text string
+	if err := conn.QueryRowContext(ctx, "SHOW ENGINE INNODB STATUS").Scan(&typ, &name, &text); err != nil {
+		return nil, err
+	}
+	return Parse(text), nil
+}
+
+// Parse parses the output of SHOW ENGINE INNODB STATUS. Lines it doesn't know
+// are ignored, so output of any version can be parsed.
+func Parse(text string) *Status {
+	s := &Status{
+		Sections: map[string]string{},
+	}
+	lines := strings.Split(text, "\n")
+
+	title := ""
+	body := []string{}
+	flush := func() {
+		if title != "" {
+			s.Sections[title] = strings.Trim(strings.Join(body, "\n"), "\n")
+		}
+		body = []string{}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if s.Time == "" && strings.HasSuffix(line, "INNODB MONITOR OUTPUT") {
+			if m := timeRe.FindStringSubmatch(line); m != nil {
+				s.Time = m[1]
+			}
+		}
+		// A section starts with its title between lines of dashes, the last one
+		// ends with "=" instead.
+		if isRule(line, '-') && i+2 < len(lines) && isTitle(lines[i+1]) &&
+			(isRule(lines[i+2], '-') || isRule(lines[i+2], '=')) {
+			flush()
+			title = strings.TrimSpace(lines[i+1])
+			i += 2
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+	delete(s.Sections, "END OF INNODB MONITOR OUTPUT")
+
+	if text, ok := s.Sections[SectionDeadlock]; ok {
+		s.Deadlock = parseDeadlock(text)
+	}
+	s.Semaphores = parseSemaphores(s.Sections[SectionSemaphores])
+	s.Transactions = parseTransactions(s.Sections[SectionTransactions])
+	s.BufferPool = parseBufferPool(s.Sections[SectionBufferPool])
+	return s
+}
+
+func isRule(line string, ch rune) bool {
+	line = strings.TrimSpace(line)
+	if len(line) < 3 {
+		return false
+	}
+	for _, c := range line {
+		if c != ch {
+			return false
+		}
+	}
+	return true
+}
+
+func isTitle(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && line == strings.ToUpper(line) && !isRule(line, '-') && !isRule(line, '=')
+}
+
+func parseDeadlock(text string) *Deadlock {
+	d := &Deadlock{Transactions: []Transaction{}}
+	var trx *Transaction
+	var locks *[]Lock
+	query := false
+	number := ""
+	ids := map[string]string{} // deadlock trx number => trx id
+	for _, line := range strings.Split(text, "\n") {
+		if d.Time == "" {
+			if m := timeRe.FindStringSubmatch(line); m != nil {
+				d.Time = m[1]
+				continue
+			}
+		}
+		if m := deadlockTrxRe.FindStringSubmatch(line); m != nil {
+			query = false
+			number = m[1]
+			n, _ := strconv.Atoi(number)
+			if n < 1 || n > maxDeadlockTrx {
+				trx = nil
+				locks = nil
+				continue
+			}
+			for len(d.Transactions) < n {
+				d.Transactions = append(d.Transactions, Transaction{})
+			}
+			trx = &d.Transactions[n-1]
+			switch m[2] {
+			case "TRANSACTION":
+				locks = nil
+			case "HOLDS THE LOCK(S)":
+				locks = &trx.Holds
+			default:
+				locks = &trx.WaitsFor
+			}
+			continue
+		}
+		if m := rollbackRe.FindStringSubmatch(line); m != nil {
+			d.RolledBack = ids[m[1]]
+			trx = nil
+			continue
+		}
+		if trx == nil {
+			continue
+		}
+		if locks != nil {
+			if lock, ok := parseLock(line); ok {
+				*locks = append(*locks, lock)
+			}
+			continue
+		}
+		if query {
+			if line != "" {
+				trx.Query += "\n" + line
+			}
+			continue
+		}
+		if parseTrxLine(trx, line) {
+			if trx.Id != "" {
+				ids[number] = trx.Id
+			}
+			// The statement follows the thread line.
+			query = trx.ThreadId != 0 && threadRe.MatchString(line)
+		}
+	}
+	for i := range d.Transactions {
+		finishTrx(&d.Transactions[i])
+	}
+	return d
+}
+
+func parseSemaphores(text string) Semaphores {
+	s := Semaphores{Waits: []SemaphoreWait{}}
+	var wait *SemaphoreWait
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "OS WAIT ARRAY INFO: reservation count"):
+			s.ReservationCount = lastInt(line)
+			wait = nil
+		case strings.HasPrefix(line, "OS WAIT ARRAY INFO: signal count"):
+			s.SignalCount = lastInt(line)
+			wait = nil
+		case semWaitRe.MatchString(line):
+			m := semWaitRe.FindStringSubmatch(line)
+			s.Waits = append(s.Waits, SemaphoreWait{
+				Thread:  m[1],
+				File:    m[2],
+				Line:    atoi(m[3]),
+				Seconds: atof(m[4]),
+			})
+			wait = &s.Waits[len(s.Waits)-1]
+		case wait != nil:
+			if strings.HasPrefix(line, "RW-") || strings.HasPrefix(line, "Spin rounds") || strings.HasPrefix(line, "Mutex spin") {
+				wait = nil
+				continue
+			}
+			wait.Info = strings.TrimSpace(wait.Info + "\n" + line)
+		}
+	}
+	return s
+}
+
+func parseTransactions(text string) Transactions {
+	t := Transactions{Transactions: []Transaction{}}
+	var trx *Transaction
+	query := false
+	waiting := false
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Trx id counter "):
+			t.TrxIdCounter = strings.TrimSpace(strings.TrimPrefix(line, "Trx id counter "))
+			continue
+		case strings.HasPrefix(line, "Purge done for trx's n:o < "):
+			if f := strings.Fields(strings.TrimPrefix(line, "Purge done for trx's n:o < ")); len(f) > 0 {
+				t.PurgeDone = f[0]
+			}
+			continue
+		case strings.HasPrefix(line, "History list length "):
+			t.HistoryListLength = lastInt(line)
+			continue
+		case strings.HasPrefix(line, "---TRANSACTION "):
+			t.Transactions = append(t.Transactions, Transaction{})
+			trx = &t.Transactions[len(t.Transactions)-1]
+			query = false
+			waiting = false
+		}
+		if trx == nil {
+			continue
+		}
+		if query {
+			if line == "" || isTrxDetail(line) {
+				query = false
+			} else {
+				trx.Query += "\n" + line
+				continue
+			}
+		}
+		if m := lockWaitRe.FindStringSubmatch(line); m != nil {
+			trx.LockWaitSeconds = atoi(m[1])
+			waiting = true
+			continue
+		}
+		if lock, ok := parseLock(line); ok {
+			if waiting {
+				trx.WaitsFor = append(trx.WaitsFor, lock)
+			} else {
+				trx.Holds = append(trx.Holds, lock)
+			}
+			continue
+		}
+		if isRule(line, '-') {
+			waiting = false
+			continue
+		}
+		if parseTrxLine(trx, line) {
+			query = threadRe.MatchString(line)
+		}
+	}
+	for i := range t.Transactions {
+		finishTrx(&t.Transactions[i])
+	}
+	return t
+}
+
+// isTrxDetail returns true for lines which end the statement of a transaction.
+func isTrxDetail(line string) bool {
+	for _, prefix := range []string{"---", "Trx read view", "TABLE LOCK", "RECORD LOCKS", "Record lock", "mysql tables in use"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBufferPool(text string) BufferPool {
+	bp := BufferPool{}
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Buffer pool size "):
+			bp.Size = lastInt(line)
+		case strings.HasPrefix(line, "Free buffers "):
+			bp.FreeBuffers = lastInt(line)
+		case strings.HasPrefix(line, "Database pages "):
+			bp.DatabasePages = lastInt(line)
+		case strings.HasPrefix(line, "Old database pages "):
+			bp.OldDatabasePages = lastInt(line)
+		case strings.HasPrefix(line, "Modified db pages "):
+			bp.ModifiedPages = lastInt(line)
+		case strings.HasPrefix(line, "Pending reads "):
+			bp.PendingReads = lastInt(line)
+		case pagesRe.MatchString(line):
+			m := pagesRe.FindStringSubmatch(line)
+			bp.PagesRead, bp.PagesCreated, bp.PagesWritten = atoi(m[1]), atoi(m[2]), atoi(m[3])
+		case hitRateRe.MatchString(line):
+			m := hitRateRe.FindStringSubmatch(line)
+			if total := atof(m[2]); total > 0 {
+				rate := atof(m[1]) / total
+				bp.HitRate = &rate
+			}
+		}
+	}
+	return bp
+}
+
+// parseTrxLine parses a line common to TRANSACTIONS and LATEST DETECTED DEADLOCK.
+func parseTrxLine(trx *Transaction, line string) bool {
+	if m := trxRe.FindStringSubmatch(line); m != nil {
+		trx.Id = m[1]
+		trx.ActiveSeconds = atoi(m[2])
+		trx.State = strings.TrimSpace(m[3])
+		return true
+	}
+	if m := tablesRe.FindStringSubmatch(line); m != nil {
+		trx.TablesInUse, trx.TablesLocked = atoi(m[1]), atoi(m[2])
+		return true
+	}
+	if m := lockStructsRe.FindStringSubmatch(line); m != nil {
+		trx.LockWait = m[1] != ""
+		trx.LockStructs, trx.RowLocks, trx.UndoLogEntries = atoi(m[2]), atoi(m[3]), atoi(m[4])
+		return true
+	}
+	if m := threadRe.FindStringSubmatch(line); m != nil {
+		trx.ThreadId, trx.QueryId = atoi(m[1]), atoi(m[2])
+		trx.ThreadInfo = m[3]
+		return true
+	}
+	return false
+}
+
+func parseLock(line string) (Lock, bool) {
+	if m := recordLockRe.FindStringSubmatch(line); m != nil {
+		return Lock{
+			Type:    "RECORD",
+			Index:   unquote(m[1]),
+			Table:   unquote(m[2]),
+			Mode:    m[3],
+			Waiting: m[4] != "",
+		}, true
+	}
+	if m := tableLockRe.FindStringSubmatch(line); m != nil {
+		return Lock{
+			Type:    "TABLE",
+			Table:   unquote(m[1]),
+			Mode:    m[2],
+			Waiting: m[3] != "",
+		}, true
+	}
+	return Lock{}, false
+}
+
+// finishTrx trims the statement and fingerprints it so it links to the QAN class.
+func finishTrx(trx *Transaction) {
+	trx.Query = strings.TrimSpace(trx.Query)
+	if trx.Query != "" {
+		trx.Fingerprint, trx.ClassId = util.Fingerprint(trx.Query)
+	}
+}
+
+// unquote returns db.table for `db`.`table`.
+func unquote(s string) string {
+	return strings.Replace(s, "`", "", -1)
+}
+
+func lastInt(line string) int64 {
+	f := strings.Fields(line)
+	if len(f) == 0 {
+		return 0
+	}
+	return atoi(f[len(f)-1])
+}
+
+func atoi(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+func atof(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -0,0 +1,190 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package innodb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+	"github.com/percona/qan-agent/test/rootdir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readStatus(t *testing.T, file string) *Status {
+	b, err := ioutil.ReadFile(filepath.Join(rootdir.RootDir(), "test/mysql/innodb", file))
+	require.NoError(t, err)
+	return Parse(string(b))
+}
+
+func TestParse57(t *testing.T) {
+	t.Parallel()
+
+	s := readStatus(t, "status001.txt")
+	assert.Equal(t, "2019-03-14 10:22:31", s.Time)
+	for _, title := range []string{"BACKGROUND THREAD", SectionSemaphores, SectionDeadlock, SectionTransactions, "FILE I/O", "LOG", SectionBufferPool, "ROW OPERATIONS"} {
+		assert.Contains(t, s.Sections, title)
+	}
+	assert.NotContains(t, s.Sections, "END OF INNODB MONITOR OUTPUT")
+
+	// Deadlock
+	require.NotNil(t, s.Deadlock)
+	d := s.Deadlock
+	assert.Equal(t, "2019-03-14 10:20:05", d.Time)
+	assert.Equal(t, "5902", d.RolledBack)
+	require.Len(t, d.Transactions, 2)
+
+	trx1 := d.Transactions[0]
+	assert.Equal(t, "5901", trx1.Id)
+	assert.Equal(t, int64(12), trx1.ActiveSeconds)
+	assert.Equal(t, "starting index read", trx1.State)
+	assert.True(t, trx1.LockWait)
+	assert.Equal(t, int64(2), trx1.RowLocks)
+	assert.Equal(t, int64(12), trx1.ThreadId)
+	assert.Equal(t, int64(301), trx1.QueryId)
+	assert.Equal(t, "localhost root updating", trx1.ThreadInfo)
+	assert.Equal(t, "UPDATE accounts SET balance = balance - 10 WHERE id = 2", trx1.Query)
+	fingerprint, classId := util.Fingerprint(trx1.Query)
+	assert.Equal(t, fingerprint, trx1.Fingerprint)
+	assert.Equal(t, classId, trx1.ClassId)
+	assert.Empty(t, trx1.Holds)
+	assert.Equal(t, []Lock{{
+		Type:    "RECORD",
+		Table:   "bank.accounts",
+		Index:   "PRIMARY",
+		Mode:    "X locks rec but not gap",
+		Waiting: true,
+	}}, trx1.WaitsFor)
+
+	trx2 := d.Transactions[1]
+	assert.Equal(t, "5902", trx2.Id)
+	assert.False(t, trx2.LockWait)
+	assert.Equal(t, "UPDATE accounts\n   SET balance = balance + 10\n WHERE id = 1", trx2.Query)
+	assert.Equal(t, "update accounts set balance = balance + ? where id = ?", trx2.Fingerprint)
+	require.Len(t, trx2.Holds, 1)
+	assert.False(t, trx2.Holds[0].Waiting)
+	require.Len(t, trx2.WaitsFor, 1)
+
+	// Semaphores
+	assert.Equal(t, int64(112), s.Semaphores.ReservationCount)
+	assert.Equal(t, int64(108), s.Semaphores.SignalCount)
+	require.Len(t, s.Semaphores.Waits, 1)
+	w := s.Semaphores.Waits[0]
+	assert.Equal(t, "139965212591872", w.Thread)
+	assert.Equal(t, "btr0cur.cc", w.File)
+	assert.Equal(t, int64(5889), w.Line)
+	assert.Equal(t, 2.0, w.Seconds)
+	assert.Contains(t, w.Info, "has reserved it in mode exclusive")
+
+	// Transactions
+	trxs := s.Transactions
+	assert.Equal(t, "5910", trxs.TrxIdCounter)
+	assert.Equal(t, "5905", trxs.PurgeDone)
+	assert.Equal(t, int64(27), trxs.HistoryListLength)
+	require.Len(t, trxs.Transactions, 3)
+	assert.Equal(t, "not started", trxs.Transactions[0].State)
+
+	waiting := trxs.Transactions[1]
+	assert.Equal(t, "5909", waiting.Id)
+	assert.Equal(t, "DELETE FROM orders WHERE id = 7", waiting.Query)
+	assert.Equal(t, int64(31), waiting.LockWaitSeconds)
+	require.Len(t, waiting.WaitsFor, 1)
+	assert.Equal(t, "bank.orders", waiting.WaitsFor[0].Table)
+	assert.Equal(t, []Lock{{Type: "TABLE", Table: "bank.orders", Mode: "IX"}}, waiting.Holds)
+
+	idle := trxs.Transactions[2]
+	assert.Equal(t, int64(45), idle.ActiveSeconds)
+	assert.Equal(t, int64(1), idle.UndoLogEntries)
+	assert.Equal(t, int64(14), idle.ThreadId)
+	assert.Empty(t, idle.Query)
+
+	// Buffer pool
+	bp := s.BufferPool
+	assert.Equal(t, int64(8191), bp.Size)
+	assert.Equal(t, int64(7070), bp.FreeBuffers)
+	assert.Equal(t, int64(1117), bp.DatabasePages)
+	assert.Equal(t, int64(432), bp.OldDatabasePages)
+	assert.Equal(t, int64(3), bp.ModifiedPages)
+	assert.Equal(t, int64(975), bp.PagesRead)
+	assert.Equal(t, int64(142), bp.PagesCreated)
+	assert.Equal(t, int64(159), bp.PagesWritten)
+	require.NotNil(t, bp.HitRate)
+	assert.Equal(t, 0.998, *bp.HitRate)
+}
+
+func TestParse80(t *testing.T) {
+	t.Parallel()
+
+	s := readStatus(t, "status002.txt")
+	require.NotNil(t, s.Deadlock)
+	d := s.Deadlock
+	assert.Equal(t, "2021-06-01 07:59:12", d.Time)
+	assert.Equal(t, "8202", d.RolledBack)
+	require.Len(t, d.Transactions, 2)
+	for i, trx := range d.Transactions {
+		assert.Len(t, trx.Holds, 1, "trx %d", i+1)
+		assert.Len(t, trx.WaitsFor, 1, "trx %d", i+1)
+		assert.Equal(t, "test.t", trx.Holds[0].Table)
+	}
+	assert.Equal(t, "SELECT * FROM t WHERE id = 1 FOR UPDATE", d.Transactions[0].Query)
+	assert.Equal(t, d.Transactions[0].ClassId, d.Transactions[1].ClassId)
+
+	assert.Empty(t, s.Semaphores.Waits)
+	assert.Nil(t, s.BufferPool.HitRate)
+	assert.Equal(t, int64(8192), s.BufferPool.Size)
+}
+
+func TestParseNoDeadlock(t *testing.T) {
+	t.Parallel()
+
+	s := Parse("")
+	assert.Nil(t, s.Deadlock)
+	assert.Empty(t, s.Transactions.Transactions)
+}
+
+func TestParseDeadlockBadTrxNumber(t *testing.T) {
+	t.Parallel()
+
+	d := parseDeadlock("*** (0) TRANSACTION:\nTRANSACTION 1234, ACTIVE 1 sec\n" +
+		"*** (999999999) TRANSACTION:\nTRANSACTION 5678, ACTIVE 1 sec\n")
+	assert.Empty(t, d.Transactions)
+}
+
+func TestDeadlockCollector(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+
+	c := NewDeadlockCollector("1", mysql.NewConnection(dsn))
+	// First call only remembers the latest deadlock.
+	service, data, err := c.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, service)
+	assert.Nil(t, data)
+
+	// Nothing new.
+	service, data, err = c.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, service)
+	assert.Nil(t, data)
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/mrms"
 	"github.com/percona/qan-agent/mysql"
@@ -14,10 +13,16 @@ import (
 	"github.com/percona/qan-agent/qan/analyzer"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/config"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/factory"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/innodb"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/binlog"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/generallog"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/perfschema"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/slowlog"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/tcpdump"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/ticker"
 )
 
@@ -35,13 +40,16 @@ func New(ctx context.Context, protoInstance proto.Instance) analyzer.Analyzer {
 	logChan := logger.LogChan()
 	iterFactory := factory.NewRealIntervalIterFactory(logChan)
 	slowlogWorkerFactory := slowlog.NewRealWorkerFactory(logChan)
-	perfschemaWorkerFactory := perfschema.NewRealWorkerFactory(logChan)
 	mysqlConnFactory := &mysql.RealConnectionFactory{}
+	perfschemaWorkerFactory := perfschema.NewRealWorkerFactory(logChan, mysqlConnFactory)
+	binlogWorkerFactory := binlog.NewRealWorkerFactory(logChan)
+	generallogWorkerFactory := generallog.NewRealWorkerFactory(logChan)
+	tcpdumpWorkerFactory := tcpdump.NewRealWorkerFactory(logChan)
 
 	// return initialized MySQLAnalyzer
 	return &MySQLAnalyzer{
 		// on initialization config and analyzer are uninitialized
-		config:   pc.QAN{},
+		config:   qc.QAN{},
 		analyzer: nil,
 		// initialize
 		protoInstance:           protoInstance,
@@ -52,6 +60,9 @@ func New(ctx context.Context, protoInstance proto.Instance) analyzer.Analyzer {
 		iterFactory:             iterFactory,
 		slowlogWorkerFactory:    slowlogWorkerFactory,
 		perfschemaWorkerFactory: perfschemaWorkerFactory,
+		binlogWorkerFactory:     binlogWorkerFactory,
+		generallogWorkerFactory: generallogWorkerFactory,
+		tcpdumpWorkerFactory:    tcpdumpWorkerFactory,
 		mysqlConnFactory:        mysqlConnFactory,
 	}
 }
@@ -60,7 +71,7 @@ func New(ctx context.Context, protoInstance proto.Instance) analyzer.Analyzer {
 // and MySQL implementations of Slowlog Analyzer and Perfschema Analyzer
 type MySQLAnalyzer struct {
 	// on initialization config and analyzer are uninitialized
-	config   pc.QAN
+	config   qc.QAN
 	analyzer analyzer.Analyzer
 	// services initialized in New
 	protoInstance           proto.Instance
@@ -71,6 +82,9 @@ type MySQLAnalyzer struct {
 	iterFactory             iter.IntervalIterFactory
 	slowlogWorkerFactory    slowlog.WorkerFactory
 	perfschemaWorkerFactory perfschema.WorkerFactory
+	binlogWorkerFactory     binlog.WorkerFactory
+	generallogWorkerFactory generallog.WorkerFactory
+	tcpdumpWorkerFactory    tcpdump.WorkerFactory
 	mysqlConnFactory        mysql.ConnectionFactory
 	// real analyzer channels
 	tickChan    chan time.Time
@@ -78,7 +92,7 @@ type MySQLAnalyzer struct {
 }
 
 // SetConfig sets the config
-func (m *MySQLAnalyzer) SetConfig(setConfig pc.QAN) {
+func (m *MySQLAnalyzer) SetConfig(setConfig qc.QAN) {
 	m.config = setConfig
 	if m.analyzer != nil {
 		m.analyzer.SetConfig(m.config)
@@ -86,7 +100,7 @@ func (m *MySQLAnalyzer) SetConfig(setConfig pc.QAN) {
 }
 
 // Config returns analyzer running configuration
-func (m *MySQLAnalyzer) Config() pc.QAN {
+func (m *MySQLAnalyzer) Config() qc.QAN {
 	if m.analyzer != nil {
 		m.config = m.analyzer.Config()
 	}
@@ -94,6 +108,11 @@ func (m *MySQLAnalyzer) Config() pc.QAN {
 	return m.config
 }
 
+// ValidateConfig returns the running config for the set config
+func (m *MySQLAnalyzer) ValidateConfig(setConfig qc.QAN) (qc.QAN, error) {
+	return config.ValidateConfig(setConfig)
+}
+
 // Start starts analyzer but doesn't wait until it exits
 func (m *MySQLAnalyzer) Start() error {
 	setConfig := m.Config()
@@ -107,6 +126,16 @@ func (m *MySQLAnalyzer) Start() error {
 		return fmt.Errorf("invalid QAN config: %s", err)
 	}
 
+	// Read mysql.slow_log if MySQL logs slow queries to it. If MySQL isn't
+	// reachable, the slow log file is read like it always was.
+	if config.CollectFrom == "slowlog" {
+		table, err := util.SlowLogTable(mysqlConn)
+		if err != nil {
+			m.logger.Warn("Cannot get log_output, reading the slow log file:", err)
+		}
+		config.SlowLogTable = &table
+	}
+
 	// Add the MySQL DSN to the MySQL restart monitor. If MySQL restarts,
 	// the analyzer will stop its worker and re-configure MySQL.
 	restartChan := m.mrms.Add(m.protoInstance)
@@ -125,6 +154,12 @@ func (m *MySQLAnalyzer) Start() error {
 		worker = m.slowlogWorkerFactory.Make(name+"-worker", config, mysqlConn)
 	case "perfschema":
 		worker = m.perfschemaWorkerFactory.Make(name+"-worker", mysqlConn)
+	case "binlog":
+		worker = m.binlogWorkerFactory.Make(name+"-worker", mysqlConn)
+	case "generallog":
+		worker = m.generallogWorkerFactory.Make(name+"-worker", config, mysqlConn)
+	case "tcpdump":
+		worker = m.tcpdumpWorkerFactory.Make(name + "-worker")
 	default:
 		panic("Invalid analyzerType: " + analyzerType)
 	}
@@ -133,10 +168,10 @@ func (m *MySQLAnalyzer) Start() error {
 	// Create and start a new analyzer. This should return immediately.
 	// The analyzer will configure MySQL, start its iter, then run it worker
 	// for each interval.
-	m.analyzer = NewRealAnalyzer(
+	a := NewRealAnalyzer(
 		pct.NewLogger(logChan, name),
 		config,
-		m.iterFactory.Make(analyzerType, mysqlConn, tickChan),
+		m.iterFactory.Make(iterType(config), mysqlConn, tickChan),
 		mysqlConn,
 		restartChan,
 		worker,
@@ -144,6 +179,16 @@ func (m *MySQLAnalyzer) Start() error {
 		m.spool,
 	)
 
+	// Optional collectors use their own connections, the worker opens and
+	// closes mysqlConn as it needs.
+	if boolValue(config.InnoDBStatus) {
+		a.AddCollector(innodb.NewDeadlockCollector(m.protoInstance.UUID, m.mysqlConnFactory.Make(m.protoInstance.DSN)))
+	}
+	if boolValue(config.LockWaits) {
+		a.AddCollector(innodb.NewLockWaitCollector(m.protoInstance.UUID, m.mysqlConnFactory.Make(m.protoInstance.DSN), innodb.DefaultLockWaitPeriod))
+	}
+	m.analyzer = a
+
 	return m.analyzer.Start()
 }
 
@@ -193,8 +238,16 @@ func (m *MySQLAnalyzer) GetDefaults(uuid string) map[string]interface{} {
 		"MaxSlowLogSize":  m.config.MaxSlowLogSize,
 		"RetainSlowLogs":  m.config.RetainSlowLogs,
 		"SlowLogRotation": m.config.SlowLogRotation,
+		"SlowLogTable":    m.config.SlowLogTable,
 		"ExampleQueries":  m.config.ExampleQueries,
 		"ReportLimit":     m.config.ReportLimit,
+		"TruncateDigests": m.config.TruncateDigests,
+		"Breakdown":       m.config.Breakdown,
+		"InnoDBStatus":    m.config.InnoDBStatus,
+		"LockWaits":       m.config.LockWaits,
+		"PcapFile":        m.config.PcapFile,
+		"Interface":       m.config.Interface,
+		"Port":            m.config.Port,
 	}
 
 	// Info from SHOW GLOBAL STATUS
@@ -214,3 +267,12 @@ func (m *MySQLAnalyzer) GetDefaults(uuid string) map[string]interface{} {
 func (m *MySQLAnalyzer) String() string {
 	return m.analyzer.String()
 }
+
+// iterType returns the interval iter type for the config: mysql.slow_log is
+// read by time like perfschema, not by offset like the slow log file.
+func iterType(config qc.QAN) string {
+	if config.CollectFrom == "slowlog" && boolValue(config.SlowLogTable) {
+		return "slowlog-table"
+	}
+	return config.CollectFrom
+}
@@ -0,0 +1,37 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package util
+
+import (
+	"github.com/percona/go-mysql/query"
+)
+
+// Fingerprint returns the fingerprint and class ID of q the same way the slowlog
+// worker does. Both are empty if the fingerprinter crashes on q.
+func Fingerprint(q string) (fingerprint, id string) {
+	defer func() {
+		if err := recover(); err != nil {
+			fingerprint, id = "", ""
+		}
+	}()
+	fingerprint = query.Fingerprint(q)
+	if fingerprint == "" {
+		return "", ""
+	}
+	return fingerprint, query.Id(fingerprint)
+}
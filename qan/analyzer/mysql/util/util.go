@@ -2,25 +2,60 @@ package util
 
 import (
 	"fmt"
+	"strings"
 
-	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/mysql"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
-func GetMySQLConfig(config pc.QAN) ([]string, []string, error) {
+func GetMySQLConfig(config qc.QAN) ([]string, []string, error) {
 	switch config.CollectFrom {
 	case "slowlog":
-		return makeSlowLogConfig()
+		return makeSlowLogConfig(config)
 	case "perfschema":
 		return makePerfSchemaConfig()
+	case "binlog":
+		// The binary log can't be enabled at runtime, and its format is
+		// the DBA's choice: binlog_rows_query_log_events adds the query of
+		// row-based events, so writes are classified by query, not just table.
+		return []string{}, []string{}, nil
+	case "generallog":
+		return makeGeneralLogConfig()
+	case "tcpdump":
+		// Traffic is captured outside MySQL, nothing to configure.
+		return []string{}, []string{}, nil
 	default:
-		return nil, nil, fmt.Errorf("invalid CollectFrom: '%s'; expected 'slowlog' or 'perfschema'", config.CollectFrom)
+		return nil, nil, fmt.Errorf("invalid CollectFrom: '%s'; expected 'slowlog', 'perfschema', 'binlog', 'generallog' or 'tcpdump'", config.CollectFrom)
 	}
 }
 
-func makeSlowLogConfig() ([]string, []string, error) {
+// SlowLogTable returns true if @@global.log_output includes TABLE, i.e. MySQL
+// logs slow queries to mysql.slow_log.
+func SlowLogTable(mysqlConn mysql.Connector) (bool, error) {
+	if err := mysqlConn.Connect(); err != nil {
+		return false, err
+	}
+	defer mysqlConn.Close()
+	logOutput, err := mysqlConn.GetGlobalVarString("log_output")
+	if err != nil {
+		return false, err
+	}
+	for _, output := range strings.Split(logOutput.String, ",") {
+		if strings.EqualFold(strings.TrimSpace(output), "TABLE") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func makeSlowLogConfig(config qc.QAN) ([]string, []string, error) {
 	on := []string{
 		"SET GLOBAL slow_query_log=OFF",
-		"SET GLOBAL log_output='file'", // as of MySQL 5.1.6
+	}
+	// log_output is left as is if it includes TABLE: mysql.slow_log is read
+	// instead of the slow log file, and other tools may read it too.
+	if config.SlowLogTable == nil || !*config.SlowLogTable {
+		on = append(on, "SET GLOBAL log_output='file'") // as of MySQL 5.1.6
 	}
 	off := []string{
 		"SET GLOBAL slow_query_log=OFF",
@@ -33,6 +68,18 @@ func makeSlowLogConfig() ([]string, []string, error) {
 	return on, off, nil
 }
 
+func makeGeneralLogConfig() ([]string, []string, error) {
+	on := []string{
+		"SET GLOBAL general_log=OFF",
+		"SET GLOBAL log_output='file'",
+		"SET GLOBAL general_log=ON",
+	}
+	off := []string{
+		"SET GLOBAL general_log=OFF",
+	}
+	return on, off, nil
+}
+
 func makePerfSchemaConfig() ([]string, []string, error) {
 	return []string{"SET time_zone='+0:00'"}, []string{}, nil
 }
@@ -21,12 +21,14 @@ import (
 	"testing"
 
 	pc "github.com/percona/pmm/proto/config"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/percona/qan-agent/test/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestSlowLogMySQLBasic(t *testing.T) {
-	on, off, err := GetMySQLConfig(pc.QAN{CollectFrom: "slowlog"})
+	on, off, err := GetMySQLConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "slowlog"}})
 	require.NoError(t, err)
 	assert.Equal(t, []string{
 		"SET GLOBAL slow_query_log=OFF",
@@ -38,3 +40,51 @@ func TestSlowLogMySQLBasic(t *testing.T) {
 		"SET GLOBAL slow_query_log=OFF",
 	}, off)
 }
+
+func TestSlowLogTable(t *testing.T) {
+	table := true
+	on, _, err := GetMySQLConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "slowlog"}, SlowLogTable: &table})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"SET GLOBAL slow_query_log=OFF",
+		"SET GLOBAL slow_query_log=ON",
+		"SET time_zone='+0:00'",
+	}, on)
+
+	mysqlConn := mock.NewNullMySQL()
+	mysqlConn.Reset()
+	_, err = SlowLogTable(mysqlConn)
+	assert.Error(t, err) // log_output not set
+	for logOutput, want := range map[string]bool{
+		"FILE":       false,
+		"NONE":       false,
+		"TABLE":      true,
+		"FILE,TABLE": true,
+		"table":      true,
+	} {
+		mysqlConn.SetGlobalVarString("log_output", logOutput)
+		got, err := SlowLogTable(mysqlConn)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, logOutput)
+	}
+}
+
+func TestGeneralLogConfig(t *testing.T) {
+	on, off, err := GetMySQLConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "generallog"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"SET GLOBAL general_log=OFF",
+		"SET GLOBAL log_output='file'",
+		"SET GLOBAL general_log=ON",
+	}, on)
+	assert.Equal(t, []string{
+		"SET GLOBAL general_log=OFF",
+	}, off)
+
+	for _, collectFrom := range []string{"binlog", "tcpdump"} {
+		on, off, err = GetMySQLConfig(qc.QAN{QAN: pc.QAN{CollectFrom: collectFrom}})
+		require.NoError(t, err)
+		assert.Empty(t, on)
+		assert.Empty(t, off)
+	}
+}
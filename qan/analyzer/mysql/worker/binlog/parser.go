@@ -0,0 +1,471 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package binlog reads write statements from local MySQL binary logs.
+package binlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types, https://dev.mysql.com/doc/internals/en/binlog-event-type.html
+const (
+	queryEvent             = 2
+	formatDescriptionEvent = 15
+	xidEvent               = 16
+	tableMapEvent          = 19
+	writeRowsEventV1       = 23
+	updateRowsEventV1      = 24
+	deleteRowsEventV1      = 25
+	rowsQueryEvent         = 29
+	writeRowsEventV2       = 30
+	updateRowsEventV2      = 31
+	deleteRowsEventV2      = 32
+)
+
+const (
+	headerLen  = 19
+	stmtEndF   = 0x0001 // rows event flag: last event of the statement
+	checksumOn = 1      // binlog_checksum=CRC32
+)
+
+var magic = []byte{0xfe, 'b', 'i', 'n'}
+
+var (
+	ErrNotBinlog = errors.New("not a binary log")
+)
+
+// A Statement is a write to one or more tables. Row-based statements have the
+// original query only if binlog_rows_query_log_events is enabled, else there's
+// one Statement per table and operation with a query like "INSERT INTO db.t",
+// so classes are still per table and kind of write.
+type Statement struct {
+	Offset   int64 // of the first event
+	Ts       time.Time
+	Db       string
+	Query    string
+	ExecTime uint32 // seconds, statement-based only
+	Bytes    uint64 // size of all its events
+	Tables   []*TableChange
+}
+
+// A TableChange is what a Statement changed in a table. Rows are counted only
+// for row-based events; for statement-based events only the table is known,
+// if the query is a simple INSERT, REPLACE, UPDATE or DELETE.
+type TableChange struct {
+	Db       string
+	Table    string
+	Inserted uint64
+	Updated  uint64
+	Deleted  uint64
+	Bytes    uint64 // size of its rows events
+}
+
+func (c *TableChange) Rows() uint64 {
+	return c.Inserted + c.Updated + c.Deleted
+}
+
+// A Parser reads Statements from a binary log file between two positions,
+// which should be transaction boundaries, like positions from SHOW MASTER STATUS.
+type Parser struct {
+	file *os.File
+	end  int64
+	// --
+	r             *bufio.Reader
+	offset        int64
+	checksum      bool
+	postHeaderLen []byte
+	tables        map[uint64]*tableMap
+	stmt          *Statement
+	ready         []*Statement
+}
+
+// NewParser returns a Parser which reads the open file from the start offset to
+// the end offset, or to the end of the file if end is zero. The format
+// description event at the start of the file is always read first.
+func NewParser(file *os.File, start, end int64) (*Parser, error) {
+	p := &Parser{
+		file:   file,
+		end:    end,
+		tables: map[uint64]*tableMap{},
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	p.r = bufio.NewReaderSize(file, 64*1024)
+
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(p.r, buf); err != nil || string(buf) != string(magic) {
+		return nil, ErrNotBinlog
+	}
+	p.offset = int64(len(magic))
+	h, body, err := p.readEvent()
+	if err != nil {
+		return nil, err
+	}
+	if h.eventType != formatDescriptionEvent {
+		return nil, fmt.Errorf("%s: first event is type %d, expected format description", file.Name(), h.eventType)
+	}
+	if err := p.parseFormatDescription(body); err != nil {
+		return nil, err
+	}
+
+	if start > p.offset {
+		if _, err := file.Seek(start, os.SEEK_SET); err != nil {
+			return nil, err
+		}
+		p.r.Reset(file)
+		p.offset = start
+	}
+	return p, nil
+}
+
+// Offset returns the offset after the last event read.
+func (p *Parser) Offset() int64 {
+	return p.offset
+}
+
+// Next returns the next Statement, or io.EOF at the end offset or the end of
+// the file. An incomplete event at the end of the file is not read.
+func (p *Parser) Next() (*Statement, error) {
+	for len(p.ready) == 0 {
+		if p.end > 0 && p.offset >= p.end {
+			return nil, io.EOF
+		}
+		offset := p.offset
+		h, body, err := p.readEvent()
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, err
+		}
+		if err := p.parseEvent(offset, h, body); err != nil {
+			return nil, fmt.Errorf("%s at %d: %s", p.file.Name(), offset, err)
+		}
+	}
+	s := p.ready[0]
+	p.ready = p.ready[1:]
+	return s, nil
+}
+
+// --------------------------------------------------------------------------
+
+type eventHeader struct {
+	timestamp uint32
+	eventType byte
+	size      uint32
+}
+
+// readEvent reads the next event and returns its body without the checksum.
+func (p *Parser) readEvent() (eventHeader, []byte, error) {
+	var h eventHeader
+	buf, err := p.r.Peek(headerLen)
+	if err != nil {
+		if err == io.EOF && len(buf) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, nil, err
+	}
+	h.timestamp = binary.LittleEndian.Uint32(buf[0:])
+	h.eventType = buf[4]
+	h.size = binary.LittleEndian.Uint32(buf[9:])
+	if h.size < headerLen {
+		return h, nil, fmt.Errorf("invalid event size %d at %d", h.size, p.offset)
+	}
+	event := make([]byte, h.size)
+	if _, err := io.ReadFull(p.r, event); err != nil {
+		// Peek doesn't consume, so ReadFull can only fail if the event is
+		// incomplete: the file is being written. Don't read it until it's done.
+		return h, nil, io.ErrUnexpectedEOF
+	}
+	p.offset += int64(h.size)
+	body := event[headerLen:]
+	if p.checksum && h.eventType != formatDescriptionEvent && len(body) >= 4 {
+		body = body[:len(body)-4]
+	}
+	return h, body, nil
+}
+
+func (p *Parser) parseFormatDescription(body []byte) error {
+	// binlog_version(2) server_version(50) create_timestamp(4) header_length(1)
+	if len(body) < 57 {
+		return fmt.Errorf("format description event too short: %d bytes", len(body))
+	}
+	version := strings.TrimRight(string(body[2:52]), "\x00")
+	postHeaderLen := body[57:]
+	// As of 5.6.1, a checksum algorithm byte and the event checksum follow.
+	if checksumVersion(version) {
+		if len(postHeaderLen) < 5 {
+			return fmt.Errorf("format description event too short: %d bytes", len(body))
+		}
+		p.checksum = postHeaderLen[len(postHeaderLen)-5] == checksumOn
+		postHeaderLen = postHeaderLen[:len(postHeaderLen)-5]
+	}
+	p.postHeaderLen = postHeaderLen
+	return nil
+}
+
+var versionRe = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+func checksumVersion(version string) bool {
+	m := versionRe.FindStringSubmatch(version)
+	if m == nil {
+		return false
+	}
+	v := [3]int{}
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v[0] > 5 || (v[0] == 5 && (v[1] > 6 || (v[1] == 6 && v[2] >= 1)))
+}
+
+func (p *Parser) eventPostHeaderLen(eventType byte, def int) int {
+	if int(eventType) <= len(p.postHeaderLen) && eventType > 0 {
+		return int(p.postHeaderLen[eventType-1])
+	}
+	return def
+}
+
+func (p *Parser) parseEvent(offset int64, h eventHeader, body []byte) error {
+	ts := time.Unix(int64(h.timestamp), 0).UTC()
+	switch h.eventType {
+	case queryEvent:
+		return p.parseQuery(offset, ts, h, body)
+	case rowsQueryEvent:
+		// length(1), truncated to 255, then the full query.
+		if len(body) < 1 {
+			return errors.New("rows query event too short")
+		}
+		p.flush()
+		p.stmt = &Statement{
+			Offset: offset,
+			Ts:     ts,
+			Query:  string(body[1:]),
+			Bytes:  uint64(h.size),
+		}
+	case tableMapEvent:
+		t, err := p.parseTableMap(body)
+		if err != nil {
+			return err
+		}
+		p.tables[t.id] = t
+		if p.stmt == nil {
+			p.stmt = &Statement{Offset: offset, Ts: ts}
+		}
+		p.stmt.Bytes += uint64(h.size)
+	case writeRowsEventV1, updateRowsEventV1, deleteRowsEventV1,
+		writeRowsEventV2, updateRowsEventV2, deleteRowsEventV2:
+		return p.parseRows(offset, ts, h, body)
+	case xidEvent:
+		p.flush()
+	}
+	return nil
+}
+
+// flush makes the current statement ready, if any.
+func (p *Parser) flush() {
+	if p.stmt == nil {
+		return
+	}
+	s := p.stmt
+	p.stmt = nil
+	if s.Query != "" {
+		// Rows query events have no db: use the first table's.
+		if s.Db == "" && len(s.Tables) > 0 {
+			s.Db = s.Tables[0].Db
+		}
+		p.ready = append(p.ready, s)
+		return
+	}
+	// Row-based without binlog_rows_query_log_events: one statement per
+	// table and operation.
+	for _, t := range s.Tables {
+		for _, op := range []struct {
+			query string
+			rows  uint64
+		}{
+			{"INSERT INTO", t.Inserted},
+			{"UPDATE", t.Updated},
+			{"DELETE FROM", t.Deleted},
+		} {
+			if op.rows == 0 {
+				continue
+			}
+			c := &TableChange{Db: t.Db, Table: t.Table, Bytes: t.Bytes * op.rows / t.Rows()}
+			switch op.query {
+			case "INSERT INTO":
+				c.Inserted = op.rows
+			case "UPDATE":
+				c.Updated = op.rows
+			default:
+				c.Deleted = op.rows
+			}
+			p.ready = append(p.ready, &Statement{
+				Offset: s.Offset,
+				Ts:     s.Ts,
+				Db:     t.Db,
+				Query:  fmt.Sprintf("%s %s.%s", op.query, quoteName(t.Db), quoteName(t.Table)),
+				Bytes:  c.Bytes,
+				Tables: []*TableChange{c},
+			})
+		}
+	}
+}
+
+var tableRe = regexp.MustCompile("(?is)^\\s*(?:/\\*.*?\\*/\\s*)?(?:insert|replace)\\s+(?:(?:low_priority|delayed|high_priority|ignore)\\s+)*(?:into\\s+)?([`\\w$.]+)" +
+	"|^\\s*(?:/\\*.*?\\*/\\s*)?update\\s+(?:(?:low_priority|ignore)\\s+)*([`\\w$.]+)\\s+set\\s" +
+	"|^\\s*(?:/\\*.*?\\*/\\s*)?delete\\s+(?:(?:low_priority|quick|ignore)\\s+)*from\\s+([`\\w$.]+)\\s*(?:where\\s|order\\s|limit\\s|$)")
+
+var skipQueries = map[string]bool{
+	"BEGIN":    true,
+	"COMMIT":   true,
+	"ROLLBACK": true,
+}
+
+func (p *Parser) parseQuery(offset int64, ts time.Time, h eventHeader, body []byte) error {
+	// thread_id(4) exec_time(4) db_len(1) error_code(2) status_vars_len(2)
+	n := p.eventPostHeaderLen(queryEvent, 13)
+	if len(body) < n || n < 13 {
+		return errors.New("query event too short")
+	}
+	execTime := binary.LittleEndian.Uint32(body[4:])
+	dbLen := int(body[8])
+	statusLen := int(binary.LittleEndian.Uint16(body[11:]))
+	i := n + statusLen
+	if len(body) < i+dbLen+1 {
+		return errors.New("query event too short")
+	}
+	db := string(body[i : i+dbLen])
+	query := string(body[i+dbLen+1:])
+
+	p.flush()
+	if skipQueries[strings.ToUpper(strings.TrimSpace(query))] {
+		return nil
+	}
+	s := &Statement{
+		Offset:   offset,
+		Ts:       ts,
+		Db:       db,
+		Query:    query,
+		ExecTime: execTime,
+		Bytes:    uint64(h.size),
+	}
+	if m := tableRe.FindStringSubmatch(query); m != nil {
+		name := m[1] + m[2] + m[3]
+		c := &TableChange{Db: db, Table: unquoteName(name), Bytes: uint64(h.size)}
+		if i := strings.Index(name, "."); i > 0 {
+			c.Db, c.Table = unquoteName(name[:i]), unquoteName(name[i+1:])
+		}
+		s.Tables = []*TableChange{c}
+	}
+	p.ready = append(p.ready, s)
+	return nil
+}
+
+func (p *Parser) parseRows(offset int64, ts time.Time, h eventHeader, body []byte) error {
+	n := p.eventPostHeaderLen(h.eventType, 8)
+	if len(body) < n || n < 6 {
+		return errors.New("rows event too short")
+	}
+	id, flagsAt := tableId(body, n)
+	flags := binary.LittleEndian.Uint16(body[flagsAt:])
+	data := body[n:]
+	if h.eventType >= writeRowsEventV2 {
+		// extra_data_len(2), including itself, then extra data.
+		extra := int(binary.LittleEndian.Uint16(body[n-2:]))
+		if n == 10 {
+			// Post-header includes extra_data_len, data starts after extra data.
+			if extra < 2 || n+extra-2 > len(body) {
+				return fmt.Errorf("invalid rows event extra data length %d", extra)
+			}
+			data = body[n+extra-2:]
+		}
+	}
+
+	t, ok := p.tables[id]
+	if !ok {
+		return fmt.Errorf("no table map for table id %d", id)
+	}
+	if p.stmt == nil {
+		p.stmt = &Statement{Offset: offset, Ts: ts}
+	}
+	p.stmt.Bytes += uint64(h.size)
+	var c *TableChange
+	for _, tc := range p.stmt.Tables {
+		if tc.Db == t.db && tc.Table == t.table {
+			c = tc
+			break
+		}
+	}
+	if c == nil {
+		c = &TableChange{Db: t.db, Table: t.table}
+		p.stmt.Tables = append(p.stmt.Tables, c)
+	}
+	c.Bytes += uint64(h.size)
+
+	update := h.eventType == updateRowsEventV1 || h.eventType == updateRowsEventV2
+	rows, err := t.countRows(data, update)
+	if err != nil {
+		return err
+	}
+	switch h.eventType {
+	case writeRowsEventV1, writeRowsEventV2:
+		c.Inserted += rows
+	case updateRowsEventV1, updateRowsEventV2:
+		c.Updated += rows
+	default:
+		c.Deleted += rows
+	}
+
+	if flags&stmtEndF != 0 {
+		p.flush()
+		// Table ids are valid until the end of the statement.
+		p.tables = map[uint64]*tableMap{}
+	}
+	return nil
+}
+
+// tableId returns the table id, which is 6 bytes, or 4 bytes in very old
+// formats, and the offset of the flags which follow it.
+func tableId(body []byte, postHeaderLen int) (uint64, int) {
+	if postHeaderLen == 6 {
+		return uint64(binary.LittleEndian.Uint32(body)), 4
+	}
+	id := uint64(binary.LittleEndian.Uint32(body)) | uint64(binary.LittleEndian.Uint16(body[4:]))<<32
+	return id, 6
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func unquoteName(name string) string {
+	if len(name) >= 2 && name[0] == '`' && name[len(name)-1] == '`' {
+		return strings.Replace(name[1:len(name)-1], "``", "`", -1)
+	}
+	return name
+}
@@ -0,0 +1,308 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// builder writes binary log events in the v4 format of MySQL 5.7 with
+// binlog_checksum=CRC32. Checksums are not verified, so they're zeros.
+type builder struct {
+	buf bytes.Buffer
+	ts  uint32
+}
+
+func newBuilder() *builder {
+	b := &builder{ts: 1500000000}
+	b.buf.Write(magic)
+	body := make([]byte, 57)
+	binary.LittleEndian.PutUint16(body, 4)
+	copy(body[2:], "5.7.18-log")
+	body[56] = headerLen
+	postHeaderLen := make([]byte, 38)
+	postHeaderLen[queryEvent-1] = 13
+	postHeaderLen[tableMapEvent-1] = 8
+	for _, t := range []byte{writeRowsEventV1, updateRowsEventV1, deleteRowsEventV1} {
+		postHeaderLen[t-1] = 8
+	}
+	for _, t := range []byte{writeRowsEventV2, updateRowsEventV2, deleteRowsEventV2} {
+		postHeaderLen[t-1] = 10
+	}
+	body = append(body, postHeaderLen...)
+	body = append(body, checksumOn)
+	b.event(formatDescriptionEvent, body)
+	return b
+}
+
+func (b *builder) event(eventType byte, body []byte) {
+	h := make([]byte, headerLen)
+	size := headerLen + len(body) + 4
+	binary.LittleEndian.PutUint32(h[0:], b.ts)
+	h[4] = eventType
+	binary.LittleEndian.PutUint32(h[5:], 1)
+	binary.LittleEndian.PutUint32(h[9:], uint32(size))
+	binary.LittleEndian.PutUint32(h[13:], uint32(b.buf.Len()+size))
+	b.buf.Write(h)
+	b.buf.Write(body)
+	b.buf.Write([]byte{0, 0, 0, 0})
+}
+
+func (b *builder) query(db, query string, execTime uint32) {
+	body := make([]byte, 13)
+	binary.LittleEndian.PutUint32(body[4:], execTime)
+	body[8] = byte(len(db))
+	body = append(body, db...)
+	body = append(body, 0)
+	body = append(body, query...)
+	b.event(queryEvent, body)
+}
+
+func (b *builder) rowsQuery(query string) {
+	b.event(rowsQueryEvent, append([]byte{byte(len(query))}, query...))
+}
+
+func (b *builder) tableMap(id byte, db, table string, types []byte, meta []byte) {
+	body := []byte{id, 0, 0, 0, 0, 0, 0, 0}
+	body = append(body, byte(len(db)))
+	body = append(body, db...)
+	body = append(body, 0, byte(len(table)))
+	body = append(body, table...)
+	body = append(body, 0, byte(len(types)))
+	body = append(body, types...)
+	body = append(body, byte(len(meta)))
+	body = append(body, meta...)
+	body = append(body, make([]byte, (len(types)+7)/8)...) // nullable bitmap
+	b.event(tableMapEvent, body)
+}
+
+func (b *builder) rows(eventType byte, id byte, cols int, end bool, rows ...[]byte) {
+	body := []byte{id, 0, 0, 0, 0, 0, 0, 0}
+	if end {
+		body[6] = stmtEndF
+	}
+	if eventType >= writeRowsEventV2 {
+		body = append(body, 2, 0)
+	}
+	body = append(body, byte(cols))
+	present := make([]byte, (cols+7)/8)
+	for i := 0; i < cols; i++ {
+		present[i/8] |= 1 << uint(i%8)
+	}
+	body = append(body, present...)
+	if eventType == updateRowsEventV1 || eventType == updateRowsEventV2 {
+		body = append(body, present...)
+	}
+	for _, row := range rows {
+		body = append(body, row...)
+	}
+	b.event(eventType, body)
+}
+
+func (b *builder) xid() {
+	b.event(xidEvent, make([]byte, 8))
+}
+
+// t1 is (id INT, name VARCHAR(255), price DECIMAL(10,2), note BLOB, created DATETIME(3))
+// in utf8mb4, and t2 is (id INT, code CHAR(10), status ENUM(...)).
+var (
+	t1Types = []byte{typeLong, typeVarchar, typeNewDecimal, typeBlob, typeDatetime2}
+	t1Meta  = []byte{0xfc, 0x03, 10, 2, 2, 3}
+	t2Types = []byte{typeLong, typeString, typeString}
+	t2Meta  = []byte{typeString, 40, typeEnum, 1}
+)
+
+func t1Row(name, note string) []byte {
+	nulls := byte(0)
+	if name == "" {
+		nulls |= 1 << 1
+	}
+	if note == "" {
+		nulls |= 1 << 3
+	}
+	row := []byte{nulls, 1, 0, 0, 0}
+	if name != "" {
+		row = append(row, byte(len(name)), 0)
+		row = append(row, name...)
+	}
+	row = append(row, 0x80, 0, 0, 0, 1) // decimal
+	if note != "" {
+		row = append(row, byte(len(note)), 0)
+		row = append(row, note...)
+	}
+	return append(row, 1, 2, 3, 4, 5, 6, 7) // datetime(3)
+}
+
+func t2Row(code string) []byte {
+	row := []byte{0, 2, 0, 0, 0, byte(len(code))}
+	row = append(row, code...)
+	return append(row, 1) // enum
+}
+
+func writeFile(t *testing.T, dir, name string, b *builder) string {
+	file := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(file, b.buf.Bytes(), 0644))
+	return file
+}
+
+func readAll(t *testing.T, file string, start, end int64) ([]*Statement, int64) {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	p, err := NewParser(f, start, end)
+	require.NoError(t, err)
+	stmts := []*Statement{}
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		stmts = append(stmts, s)
+	}
+	return stmts, p.Offset()
+}
+
+func TestParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-binlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := newBuilder()
+	start := int64(b.buf.Len())
+
+	// Row-based with binlog_rows_query_log_events.
+	b.query("db1", "BEGIN", 0)
+	b.rowsQuery("INSERT INTO t1 VALUES (1, 'abc', 1.00, 'xy', NOW(3)), (1, NULL, 1.00, NULL, NOW(3))")
+	b.tableMap(70, "db1", "t1", t1Types, t1Meta)
+	insertAt := b.buf.Len()
+	b.rows(writeRowsEventV2, 70, 5, true, t1Row("abc", "xy"), t1Row("", ""))
+	insertSize := b.buf.Len() - insertAt
+	b.xid()
+
+	// Statement-based.
+	b.query("db1", "BEGIN", 0)
+	updateAt := b.buf.Len()
+	b.query("db1", "UPDATE t1 SET price = 2 WHERE id = 1", 2)
+	updateSize := b.buf.Len() - updateAt
+	b.xid()
+	middle := int64(b.buf.Len())
+
+	// Row-based without the query: one statement per table and operation.
+	b.query("db2", "BEGIN", 0)
+	b.tableMap(71, "db2", "t2", t2Types, t2Meta)
+	b.rows(updateRowsEventV2, 71, 3, false, t2Row("a"), t2Row("b"), t2Row("c"), t2Row("d"))
+	b.rows(deleteRowsEventV1, 71, 3, true, t2Row("e"))
+	b.xid()
+	end := int64(b.buf.Len())
+
+	// A DDL, and an incomplete event being written.
+	b.query("db2", "ALTER TABLE t2 ADD COLUMN x INT", 1)
+	full := int64(b.buf.Len())
+	b.buf.Write([]byte{1, 2, 3})
+
+	file := writeFile(t, dir, "mysql-bin.000001", b)
+
+	stmts, offset := readAll(t, file, start, 0)
+	assert.Equal(t, full, offset, "incomplete event not read")
+	require.Len(t, stmts, 5)
+
+	s := stmts[0]
+	assert.Equal(t, "INSERT INTO t1 VALUES (1, 'abc', 1.00, 'xy', NOW(3)), (1, NULL, 1.00, NULL, NOW(3))", s.Query)
+	assert.Equal(t, "db1", s.Db)
+	assert.Equal(t, time.Unix(1500000000, 0).UTC(), s.Ts)
+	require.Len(t, s.Tables, 1)
+	assert.Equal(t, TableChange{Db: "db1", Table: "t1", Inserted: 2, Bytes: uint64(insertSize)}, *s.Tables[0])
+
+	s = stmts[1]
+	assert.Equal(t, "UPDATE t1 SET price = 2 WHERE id = 1", s.Query)
+	assert.Equal(t, uint32(2), s.ExecTime)
+	assert.Equal(t, uint64(updateSize), s.Bytes)
+	require.Len(t, s.Tables, 1)
+	assert.Equal(t, TableChange{Db: "db1", Table: "t1", Bytes: uint64(updateSize)}, *s.Tables[0])
+
+	s = stmts[2]
+	assert.Equal(t, "UPDATE `db2`.`t2`", s.Query)
+	assert.Equal(t, "db2", s.Db)
+	require.Len(t, s.Tables, 1)
+	assert.Equal(t, uint64(2), s.Tables[0].Updated)
+	s = stmts[3]
+	assert.Equal(t, "DELETE FROM `db2`.`t2`", s.Query)
+	require.Len(t, s.Tables, 1)
+	assert.Equal(t, uint64(1), s.Tables[0].Deleted)
+
+	s = stmts[4]
+	assert.Equal(t, "ALTER TABLE t2 ADD COLUMN x INT", s.Query)
+	assert.Empty(t, s.Tables)
+
+	// Slices between transaction boundaries.
+	stmts, offset = readAll(t, file, start, middle)
+	assert.Equal(t, middle, offset)
+	assert.Len(t, stmts, 2)
+	stmts, offset = readAll(t, file, middle, end)
+	assert.Equal(t, end, offset)
+	assert.Len(t, stmts, 2)
+}
+
+func TestParserNotBinlog(t *testing.T) {
+	f, err := ioutil.TempFile("", "qan-binlog")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.WriteString("# Time: 2017-07-14T02:40:00\n")
+	_, err = NewParser(f, 0, 0)
+	assert.Equal(t, ErrNotBinlog, err)
+}
+
+func TestTableRe(t *testing.T) {
+	tests := map[string]string{
+		"INSERT INTO t VALUES (1)":                   "t",
+		"insert ignore into `db`.`t` (a) values (1)": "`db`.`t`",
+		"REPLACE t SET a=1":                          "t",
+		"UPDATE LOW_PRIORITY t SET a=1":              "t",
+		"/* app */ DELETE FROM db.t WHERE id=1":      "db.t",
+		"DELETE t1 FROM t1 JOIN t2":                  "",
+		"UPDATE t1, t2 SET t1.a=t2.a":                "",
+		"CREATE TABLE t (a INT)":                     "",
+	}
+	for query, table := range tests {
+		m := tableRe.FindStringSubmatch(query)
+		got := ""
+		if m != nil {
+			got = m[1] + m[2] + m[3]
+		}
+		assert.Equal(t, table, got, query)
+	}
+}
+
+func TestDecimalSize(t *testing.T) {
+	assert.Equal(t, 5, decimalSize(10, 2))
+	assert.Equal(t, 4, decimalSize(9, 0))
+	assert.Equal(t, 8, decimalSize(18, 9))
+	assert.Equal(t, 14, decimalSize(30, 10))
+}
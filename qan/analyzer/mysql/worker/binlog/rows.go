@@ -0,0 +1,347 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Column types, https://dev.mysql.com/doc/internals/en/com-query-response.html#column-type
+const (
+	typeDecimal    = 0
+	typeTiny       = 1
+	typeShort      = 2
+	typeLong       = 3
+	typeFloat      = 4
+	typeDouble     = 5
+	typeNull       = 6
+	typeTimestamp  = 7
+	typeLongLong   = 8
+	typeInt24      = 9
+	typeDate       = 10
+	typeTime       = 11
+	typeDatetime   = 12
+	typeYear       = 13
+	typeNewDate    = 14
+	typeVarchar    = 15
+	typeBit        = 16
+	typeTimestamp2 = 17
+	typeDatetime2  = 18
+	typeTime2      = 19
+	typeJSON       = 245
+	typeNewDecimal = 246
+	typeEnum       = 247
+	typeSet        = 248
+	typeTinyBlob   = 249
+	typeMediumBlob = 250
+	typeLongBlob   = 251
+	typeBlob       = 252
+	typeVarString  = 253
+	typeString     = 254
+	typeGeometry   = 255
+)
+
+var errShort = errors.New("event too short")
+
+// A tableMap is a TABLE_MAP_EVENT: the table and its column types and metadata,
+// which are needed to know where a row ends.
+type tableMap struct {
+	id    uint64
+	db    string
+	table string
+	types []byte
+	meta  []uint16
+}
+
+func (p *Parser) parseTableMap(body []byte) (*tableMap, error) {
+	n := p.eventPostHeaderLen(tableMapEvent, 8)
+	if len(body) < n+1 {
+		return nil, errShort
+	}
+	t := &tableMap{}
+	t.id, _ = tableId(body, n)
+	b := body[n:]
+
+	// db_len(1) db 0x00 table_len(1) table 0x00
+	dbLen := int(b[0])
+	if len(b) < dbLen+3 {
+		return nil, errShort
+	}
+	t.db = string(b[1 : 1+dbLen])
+	b = b[dbLen+2:]
+	tableLen := int(b[0])
+	if len(b) < tableLen+2 {
+		return nil, errShort
+	}
+	t.table = string(b[1 : 1+tableLen])
+	b = b[tableLen+2:]
+
+	cols, b, err := lenencInt(b)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) < cols {
+		return nil, errShort
+	}
+	t.types = b[:cols]
+	b = b[cols:]
+
+	metaLen, b, err := lenencInt(b)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) < metaLen {
+		return nil, errShort
+	}
+	t.meta, err = parseMeta(t.types, b[:metaLen])
+	if err != nil {
+		return nil, fmt.Errorf("table %s.%s: %s", t.db, t.table, err)
+	}
+	return t, nil
+}
+
+// parseMeta returns the metadata of each column, which is 0, 1 or 2 bytes
+// depending on the column type.
+func parseMeta(types []byte, b []byte) ([]uint16, error) {
+	meta := make([]uint16, len(types))
+	for i, t := range types {
+		var n int
+		switch t {
+		case typeFloat, typeDouble, typeBlob, typeGeometry, typeJSON,
+			typeTimestamp2, typeDatetime2, typeTime2:
+			n = 1
+		case typeVarchar, typeVarString, typeBit, typeNewDecimal, typeEnum, typeSet:
+			n = 2
+		case typeString:
+			// Big-endian: real type, then length.
+			if len(b) < 2 {
+				return nil, errShort
+			}
+			meta[i] = uint16(b[0])<<8 | uint16(b[1])
+			b = b[2:]
+			continue
+		}
+		if len(b) < n {
+			return nil, errShort
+		}
+		switch n {
+		case 1:
+			meta[i] = uint16(b[0])
+		case 2:
+			if t == typeNewDecimal || t == typeBit {
+				// precision, scale or bits, bytes: keep byte order.
+				meta[i] = uint16(b[0])<<8 | uint16(b[1])
+			} else {
+				meta[i] = binary.LittleEndian.Uint16(b)
+			}
+		}
+		b = b[n:]
+	}
+	return meta, nil
+}
+
+// countRows returns the number of rows in the rows data of a rows event. The
+// rows are not decoded, only their sizes.
+func (t *tableMap) countRows(b []byte, update bool) (uint64, error) {
+	cols, b, err := lenencInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if cols != uint64(len(t.types)) {
+		return 0, fmt.Errorf("table %s.%s: rows event has %d columns, table map has %d", t.db, t.table, cols, len(t.types))
+	}
+	bitmapLen := int(cols+7) / 8
+	if len(b) < bitmapLen {
+		return 0, errShort
+	}
+	present := b[:bitmapLen]
+	b = b[bitmapLen:]
+	presentAfter := present
+	if update {
+		if len(b) < bitmapLen {
+			return 0, errShort
+		}
+		presentAfter = b[:bitmapLen]
+		b = b[bitmapLen:]
+	}
+
+	rows := uint64(0)
+	for len(b) > 0 {
+		if b, err = t.skipRow(b, present); err != nil {
+			return rows, err
+		}
+		if update {
+			if b, err = t.skipRow(b, presentAfter); err != nil {
+				return rows, err
+			}
+		}
+		rows++
+	}
+	return rows, nil
+}
+
+// skipRow returns the data after the row at the start of b.
+func (t *tableMap) skipRow(b []byte, present []byte) ([]byte, error) {
+	n := 0
+	for i := range t.types {
+		if bit(present, i) {
+			n++
+		}
+	}
+	nullsLen := (n + 7) / 8
+	if len(b) < nullsLen {
+		return nil, errShort
+	}
+	nulls := b[:nullsLen]
+	b = b[nullsLen:]
+	j := 0 // index of present column
+	for i, typ := range t.types {
+		if !bit(present, i) {
+			continue
+		}
+		isNull := bit(nulls, j)
+		j++
+		if isNull {
+			continue
+		}
+		size, err := valueSize(typ, t.meta[i], b)
+		if err != nil {
+			return nil, fmt.Errorf("table %s.%s column %d: %s", t.db, t.table, i+1, err)
+		}
+		if len(b) < size {
+			return nil, errShort
+		}
+		b = b[size:]
+	}
+	return b, nil
+}
+
+// valueSize returns the size of the column value at the start of b.
+func valueSize(typ byte, meta uint16, b []byte) (int, error) {
+	switch typ {
+	case typeNull:
+		return 0, nil
+	case typeTiny, typeYear:
+		return 1, nil
+	case typeShort:
+		return 2, nil
+	case typeInt24, typeDate, typeNewDate, typeTime:
+		return 3, nil
+	case typeLong, typeTimestamp:
+		return 4, nil
+	case typeLongLong, typeDatetime:
+		return 8, nil
+	case typeFloat, typeDouble:
+		return int(meta), nil
+	case typeTimestamp2:
+		return 4 + (int(meta)+1)/2, nil
+	case typeDatetime2:
+		return 5 + (int(meta)+1)/2, nil
+	case typeTime2:
+		return 3 + (int(meta)+1)/2, nil
+	case typeNewDecimal:
+		return decimalSize(int(meta>>8), int(meta&0xff)), nil
+	case typeBit:
+		bits, bytes := int(meta>>8), int(meta&0xff)
+		if bits > 0 {
+			bytes++
+		}
+		return bytes, nil
+	case typeVarchar, typeVarString:
+		return lengthPrefixed(b, meta >= 256)
+	case typeBlob, typeGeometry, typeJSON:
+		return lengthBytes(b, int(meta))
+	case typeEnum, typeSet:
+		return int(meta & 0xff), nil
+	case typeString:
+		realType, length := byte(meta>>8), int(meta&0xff)
+		if realType&0x30 != 0x30 {
+			// Length > 255 is stored in the unused bits of the real type.
+			length |= int((realType&0x30)^0x30) << 4
+			realType |= 0x30
+		}
+		switch realType {
+		case typeEnum, typeSet:
+			return length, nil
+		}
+		return lengthPrefixed(b, length >= 256)
+	}
+	return 0, fmt.Errorf("unsupported column type %d", typ)
+}
+
+// decimalSize returns the size of a DECIMAL(precision, scale): 4 bytes per 9
+// digits plus the bytes for the leftover digits, for both parts.
+func decimalSize(precision, scale int) int {
+	dig2bytes := []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+	intg := precision - scale
+	return intg/9*4 + dig2bytes[intg%9] + scale/9*4 + dig2bytes[scale%9]
+}
+
+func lengthPrefixed(b []byte, twoBytes bool) (int, error) {
+	if twoBytes {
+		return lengthBytes(b, 2)
+	}
+	return lengthBytes(b, 1)
+}
+
+// lengthBytes returns the size of a value with an n-byte little-endian length.
+func lengthBytes(b []byte, n int) (int, error) {
+	if n < 1 || n > 4 {
+		return 0, fmt.Errorf("invalid length bytes %d", n)
+	}
+	if len(b) < n {
+		return 0, errShort
+	}
+	length := 0
+	for i := n - 1; i >= 0; i-- {
+		length = length<<8 | int(b[i])
+	}
+	return n + length, nil
+}
+
+func bit(bitmap []byte, i int) bool {
+	return bitmap[i/8]&(1<<uint(i%8)) != 0
+}
+
+// lenencInt reads a length-encoded integer.
+func lenencInt(b []byte) (uint64, []byte, error) {
+	if len(b) < 1 {
+		return 0, nil, errShort
+	}
+	var n int
+	switch b[0] {
+	case 0xfc:
+		n = 2
+	case 0xfd:
+		n = 3
+	case 0xfe:
+		n = 8
+	default:
+		return uint64(b[0]), b[1:], nil
+	}
+	if len(b) < n+1 {
+		return 0, nil, errShort
+	}
+	v := uint64(0)
+	for i := n; i >= 1; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, b[n+1:], nil
+}
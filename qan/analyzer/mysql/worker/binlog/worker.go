@@ -0,0 +1,407 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
+)
+
+type WorkerFactory interface {
+	Make(name string, mysqlConn mysql.Connector) *Worker
+}
+
+type RealWorkerFactory struct {
+	logChan chan proto.LogEntry
+}
+
+func NewRealWorkerFactory(logChan chan proto.LogEntry) *RealWorkerFactory {
+	f := &RealWorkerFactory{
+		logChan: logChan,
+	}
+	return f
+}
+
+func (f *RealWorkerFactory) Make(name string, mysqlConn mysql.Connector) *Worker {
+	getBinlogs := func() ([]string, Position, error) {
+		return GetBinlogs(mysqlConn)
+	}
+	return NewWorker(pct.NewLogger(f.logChan, name), mysqlConn, getBinlogs)
+}
+
+// A Position is a binary log file and an offset in it.
+type Position struct {
+	File   string // path
+	Offset int64
+}
+
+// GetBinlogsFunc returns the paths of the binary logs, oldest first, and the
+// current end of the last one.
+type GetBinlogsFunc func() ([]string, Position, error)
+
+// GetBinlogs returns the binary logs from SHOW BINARY LOGS and the end position
+// from SHOW MASTER STATUS. Files are in the directory of log_bin_basename, or
+// the datadir if the binary log name is relative and log_bin_basename is unknown.
+func GetBinlogs(mysqlConn mysql.Connector) ([]string, Position, error) {
+	if err := mysqlConn.Connect(); err != nil {
+		return nil, Position{}, err
+	}
+	defer mysqlConn.Close()
+
+	var end Position
+	var endFile string
+	rows, err := mysqlConn.DB().Query("SHOW MASTER STATUS")
+	if err != nil {
+		return nil, end, err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, end, err
+	}
+	if !rows.Next() {
+		rows.Close()
+		return nil, end, fmt.Errorf("binary log is disabled")
+	}
+	// File, Position, Binlog_Do_DB, Binlog_Ignore_DB[, Executed_Gtid_Set]
+	vals := make([]interface{}, len(cols))
+	for i := range vals {
+		vals[i] = new(interface{})
+	}
+	vals[0], vals[1] = &endFile, &end.Offset
+	err = rows.Scan(vals...)
+	rows.Close()
+	if err != nil {
+		return nil, end, err
+	}
+
+	dir := ""
+	if basename, err := mysqlConn.GetGlobalVarString("log_bin_basename"); err == nil && basename.String != "" {
+		dir = filepath.Dir(basename.String)
+	} else {
+		dataDir, err := mysqlConn.GetGlobalVarString("datadir")
+		if err != nil {
+			return nil, end, err
+		}
+		dir = dataDir.String
+	}
+
+	rows, err = mysqlConn.DB().Query("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, end, err
+	}
+	defer rows.Close()
+	cols, err = rows.Columns()
+	if err != nil {
+		return nil, end, err
+	}
+	files := []string{}
+	for rows.Next() {
+		// Log_name, File_size[, Encrypted]
+		var name string
+		vals := make([]interface{}, len(cols))
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		vals[0] = &name
+		if err := rows.Scan(vals...); err != nil {
+			return nil, end, err
+		}
+		files = append(files, absFile(dir, name))
+	}
+	end.File = absFile(dir, endFile)
+	return files, end, rows.Err()
+}
+
+func absFile(dir, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
+
+// --------------------------------------------------------------------------
+
+// A Worker reads the binary logs written during each interval and aggregates
+// their statements per class and their writes per table and class. Like the
+// perfschema worker, the first interval is only the starting position.
+type Worker struct {
+	logger     *pct.Logger
+	mysqlConn  mysql.Connector
+	getBinlogs GetBinlogsFunc
+	// --
+	ZeroRunTime bool // testing
+	// --
+	name     string
+	status   *pct.Status
+	config   qc.QAN
+	iter     *iter.Interval
+	start    *Position // next interval starts here
+	next     *Position // end of current interval
+	stopChan chan struct{}
+}
+
+func NewWorker(logger *pct.Logger, mysqlConn mysql.Connector, getBinlogs GetBinlogsFunc) *Worker {
+	name := logger.Service()
+	w := &Worker{
+		logger:     logger,
+		mysqlConn:  mysqlConn,
+		getBinlogs: getBinlogs,
+		// --
+		name: name,
+		status: pct.NewStatus([]string{
+			name,
+			name + "-last",
+		}),
+		stopChan: make(chan struct{}),
+	}
+	return w
+}
+
+func (w *Worker) Setup(interval *iter.Interval) error {
+	w.iter = interval
+	w.next = nil
+	return nil
+}
+
+func (w *Worker) Run() (*report.Result, error) {
+	w.logger.Debug("Run:call:", w.iter.Number)
+	defer w.logger.Debug("Run:return:", w.iter.Number)
+
+	defer w.status.Update(w.name, "Idle")
+	w.status.Update(w.name, "Getting binary logs")
+
+	files, end, err := w.getBinlogs()
+	if err != nil {
+		w.logger.Warn(err.Error())
+		w.status.Update(w.name+"-last", "error: "+err.Error())
+		return nil, nil // not an error to caller
+	}
+	w.next = &end
+
+	if w.start == nil {
+		return nil, nil // first interval, only the starting position
+	}
+	first := -1
+	for i, file := range files {
+		if file == w.start.File {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		w.logger.Warn(fmt.Sprintf("Binary log %s was purged, starting from %s", w.start.File, end.File))
+		return nil, nil
+	}
+
+	t0 := time.Now().UTC()
+	aggregator := event.NewAggregator(boolValue(w.config.ExampleQueries), 0, 0)
+	writes := map[writesKey]*report.TableWrites{}
+	nStatements := 0
+	for _, file := range files[first:] {
+		start := int64(0)
+		if file == w.start.File {
+			start = w.start.Offset
+		}
+		stop := int64(0)
+		if file == end.File {
+			stop = end.Offset
+		}
+		w.status.Update(w.name, "Reading "+file)
+		n, err := w.readFile(file, start, stop, aggregator, writes)
+		nStatements += n
+		if err != nil {
+			// Reading the rest of the interval would count the rest of
+			// this file as if it were nothing.
+			w.logger.Warn(err.Error())
+			w.status.Update(w.name+"-last", "error: "+err.Error())
+			return nil, nil
+		}
+		if file == end.File {
+			break
+		}
+		select {
+		case <-w.stopChan:
+			return nil, nil
+		default:
+		}
+	}
+
+	r := aggregator.Finalize()
+	classes := make([]*event.Class, 0, len(r.Class))
+	for _, class := range r.Class {
+		classes = append(classes, class)
+	}
+	result := &report.Result{
+		Global: r.Global,
+		Class:  classes,
+		Writes: sortWrites(writes),
+	}
+	if !w.ZeroRunTime {
+		result.RunTime = time.Now().UTC().Sub(t0).Seconds()
+	}
+	w.status.Update(w.name+"-last", fmt.Sprintf("statements: %d, end: %s:%d", nStatements, filepath.Base(end.File), end.Offset))
+	return result, nil
+}
+
+func (w *Worker) Cleanup() error {
+	// Start the next interval where this one ended, even if it failed:
+	// a failed interval is lost like a failed slow log slice.
+	if w.next != nil {
+		w.start = w.next
+	}
+	return nil
+}
+
+func (w *Worker) Stop() error {
+	select {
+	case <-w.stopChan:
+	default:
+		close(w.stopChan)
+	}
+	return nil
+}
+
+func (w *Worker) Status() map[string]string {
+	return w.status.All()
+}
+
+func (w *Worker) SetConfig(config qc.QAN) {
+	w.config = config
+}
+
+// --------------------------------------------------------------------------
+
+type writesKey struct {
+	db      string
+	table   string
+	classId string
+}
+
+// readFile aggregates the statements in the file from start to stop, or to
+// the end of the file if stop is zero. It returns the number of statements.
+func (w *Worker) readFile(file string, start, stop int64, aggregator *event.Aggregator, writes map[writesKey]*report.TableWrites) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	p, err := NewParser(f, start, stop)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", file, err)
+	}
+	n := 0
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		fingerprint, id := util.Fingerprint(s.Query)
+		if id == "" {
+			w.logger.Warn(fmt.Sprintf("Cannot fingerprint '%s'", s.Query))
+			continue
+		}
+		n++
+		aggregator.AddEvent(statementEvent(s), id, fingerprint)
+		for _, t := range s.Tables {
+			k := writesKey{t.Db, t.Table, id}
+			tw, ok := writes[k]
+			if !ok {
+				tw = &report.TableWrites{Db: t.Db, Table: t.Table, ClassId: id}
+				writes[k] = tw
+			}
+			tw.Statements++
+			tw.RowsInserted += t.Inserted
+			tw.RowsUpdated += t.Updated
+			tw.RowsDeleted += t.Deleted
+			tw.Bytes += t.Bytes
+		}
+	}
+}
+
+// statementEvent returns the statement as an event like a slow log event.
+// Query_time is the statement-based exec time, in whole seconds, or zero.
+func statementEvent(s *Statement) *log.Event {
+	e := log.NewEvent()
+	e.Offset = uint64(s.Offset)
+	e.Ts = s.Ts
+	e.Db = s.Db
+	e.Query = s.Query
+	e.TimeMetrics["Query_time"] = float64(s.ExecTime)
+	e.NumberMetrics["Binlog_bytes"] = s.Bytes
+	rows := uint64(0)
+	rowBased := false
+	for _, t := range s.Tables {
+		rows += t.Rows()
+		rowBased = rowBased || t.Rows() > 0
+	}
+	if rowBased {
+		e.NumberMetrics["Rows_affected"] = rows
+	}
+	return e
+}
+
+func sortWrites(writes map[writesKey]*report.TableWrites) []report.TableWrites {
+	if len(writes) == 0 {
+		return nil
+	}
+	sorted := make([]report.TableWrites, 0, len(writes))
+	for _, tw := range writes {
+		sorted = append(sorted, *tw)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Db != b.Db {
+			return a.Db < b.Db
+		}
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.ClassId < b.ClassId
+	})
+	return sorted
+}
+
+// boolValue returns the value of the bool pointer passed in or
+// false if the pointer is nil.
+func boolValue(v *bool) bool {
+	if v != nil {
+		return *v
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/percona/qan-agent/test/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-binlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Before the first interval: only the format description.
+	b1 := newBuilder()
+	file1 := writeFile(t, dir, "mysql-bin.000001", b1)
+	binlogs := [][]string{{file1}}
+	ends := []Position{{file1, int64(b1.buf.Len())}}
+
+	// During the second interval: an insert in file 1, rotation, and two
+	// updates in file 2.
+	b1.query("db1", "BEGIN", 0)
+	b1.tableMap(70, "db1", "t1", t1Types, t1Meta)
+	b1.rows(writeRowsEventV2, 70, 5, true, t1Row("abc", "xy"))
+	b1.xid()
+	file1 = writeFile(t, dir, "mysql-bin.000001", b1)
+	b2 := newBuilder()
+	for _, id := range []string{"1", "2"} {
+		b2.query("db1", "BEGIN", 0)
+		b2.query("db1", "UPDATE t1 SET price = 2 WHERE id = "+id, 1)
+		b2.xid()
+	}
+	end2 := int64(b2.buf.Len())
+	b2.query("db1", "BEGIN", 0)
+	b2.query("db1", "UPDATE t1 SET price = 3 WHERE id = 3", 0)
+	b2.xid()
+	file2 := writeFile(t, dir, "mysql-bin.000002", b2)
+	binlogs = append(binlogs, []string{file1, file2})
+	ends = append(ends, Position{file2, end2})
+
+	logChan := make(chan proto.LogEntry, 100)
+	w := NewWorker(pct.NewLogger(logChan, "qan-worker"), mock.NewNullMySQL(), func() ([]string, Position, error) {
+		files, end := binlogs[0], ends[0]
+		binlogs, ends = binlogs[1:], ends[1:]
+		return files, end, nil
+	})
+	w.ZeroRunTime = true
+	w.SetConfig(qc.QAN{})
+
+	run := func(n int) *report.Result {
+		require.NoError(t, w.Setup(&iter.Interval{Number: n, StartTime: time.Now().UTC()}))
+		res, err := w.Run()
+		require.NoError(t, err)
+		require.NoError(t, w.Cleanup())
+		return res
+	}
+
+	assert.Nil(t, run(1))
+
+	res := run(2)
+	require.NotNil(t, res)
+	assert.Equal(t, uint(3), res.Global.TotalQueries)
+	require.Len(t, res.Class, 2)
+
+	_, insertId := util.Fingerprint("INSERT INTO `db1`.`t1`")
+	_, updateId := util.Fingerprint("UPDATE t1 SET price = 2 WHERE id = 1")
+	classes := map[string]uint{}
+	for _, class := range res.Class {
+		classes[class.Id] = class.TotalQueries
+	}
+	assert.Equal(t, map[string]uint{insertId: 1, updateId: 2}, classes)
+
+	require.Len(t, res.Writes, 2)
+	var insert, update report.TableWrites
+	for _, tw := range res.Writes {
+		switch tw.ClassId {
+		case insertId:
+			insert = tw
+		case updateId:
+			update = tw
+		}
+	}
+	assert.Equal(t, "db1", insert.Db)
+	assert.Equal(t, "t1", insert.Table)
+	assert.Equal(t, uint64(1), insert.Statements)
+	assert.Equal(t, uint64(1), insert.RowsInserted)
+	assert.Equal(t, uint64(2), update.Statements)
+	assert.Equal(t, uint64(0), update.RowsUpdated, "statement-based rows are unknown")
+	assert.True(t, update.Bytes > 0)
+	assert.True(t, res.Writes[0].Bytes >= res.Writes[1].Bytes)
+}
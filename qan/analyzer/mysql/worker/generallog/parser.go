@@ -0,0 +1,246 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package generallog
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// entryRe matches the first line of a general log entry: time, thread id,
+// command and argument, separated by tabs. The time is RFC3339 as of MySQL 5.7,
+// like 2017-07-14T02:40:00.123456Z, else like "170714  2:40:00" and empty
+// if it's the same as the previous entry. Lines which don't match continue the
+// argument of the previous entry.
+var entryRe = regexp.MustCompile(`^(\d{4}-\d\d-\d\dT\S+|\d{6}\s+\d{1,2}:\d\d:\d\d|\t)\t\s*(\d+) ([A-Za-z][A-Za-z ]*?)\t(.*)$`)
+
+// connectRe matches the argument of Connect and Change user: "root@localhost on db1 using Socket".
+var connectRe = regexp.MustCompile(`^(\S*)@(\S*) (?:as \S+ )?on ?(\S*)`)
+
+// A thread is what's known about a connection from its previous entries.
+type thread struct {
+	user string
+	host string
+	db   string
+}
+
+// Threads are the connections seen by a parser. The user, host and db of a
+// query are known only from the Connect and Init DB entries of its thread,
+// so parsers of consecutive slices of a log should share Threads.
+type Threads map[uint64]*thread
+
+// A GeneralLogParser parses a MySQL general query log. It implements the
+// log.LogParser interface. The general log has no metrics, so events have
+// only a Query_time of zero: classes are counted, not timed.
+type GeneralLogParser struct {
+	file *os.File
+	opt  log.Options
+	// --
+	stopChan  chan bool
+	eventChan chan *log.Event
+	stopped   bool
+	threads   Threads
+	ts        time.Time // of last entry with a time
+	entry     *entry
+}
+
+type entry struct {
+	offset   uint64
+	ts       time.Time
+	id       uint64
+	command  string
+	argument string
+}
+
+// NewGeneralLogParser returns a new GeneralLogParser that reads from the open file.
+func NewGeneralLogParser(file *os.File, opt log.Options) *GeneralLogParser {
+	p := &GeneralLogParser{
+		file: file,
+		opt:  opt,
+		// --
+		stopChan:  make(chan bool, 1),
+		eventChan: make(chan *log.Event),
+		threads:   Threads{},
+	}
+	return p
+}
+
+// SetThreads makes the parser use and update the given threads.
+func (p *GeneralLogParser) SetThreads(threads Threads) {
+	p.threads = threads
+}
+
+func (p *GeneralLogParser) EventChan() <-chan *log.Event {
+	return p.eventChan
+}
+
+// Stop stops the parser before parsing the next event or while blocked on
+// sending the current event to the event channel.
+func (p *GeneralLogParser) Stop() {
+	p.stopChan <- true
+}
+
+// Start starts the parser. Events are sent to the unbuffered event channel.
+// Parsing stops on EOF, error, or call to Stop. The event channel is closed
+// when parsing stops. The file is not closed.
+func (p *GeneralLogParser) Start() error {
+	if p.opt.StartOffset > 0 {
+		if _, err := p.file.Seek(int64(p.opt.StartOffset), os.SEEK_SET); err != nil {
+			return err
+		}
+	}
+
+	defer close(p.eventChan)
+
+	r := bufio.NewReader(p.file)
+	bytesRead := p.opt.StartOffset
+
+	for !p.stopped {
+		select {
+		case <-p.stopChan:
+			p.stopped = true
+			return nil
+		default:
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+		offset := bytesRead
+		bytesRead += uint64(len(line))
+		line = strings.TrimSuffix(line, "\n")
+
+		// Meta lines written when the log is opened:
+		//   /usr/sbin/mysqld, Version: 5.7.18-log (MySQL Community Server (GPL)). started with:
+		//   Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+		//   Time                 Id Command    Argument
+		if strings.HasSuffix(line, ". started with:") ||
+			strings.HasPrefix(line, "Tcp port: ") ||
+			strings.HasPrefix(line, "TCP Port: ") ||
+			strings.HasPrefix(line, "Time                 Id Command") {
+			p.sendEntry()
+			continue
+		}
+
+		m := entryRe.FindStringSubmatch(line)
+		if m == nil {
+			if p.entry != nil {
+				p.entry.argument += "\n" + line
+			}
+			continue
+		}
+		p.sendEntry()
+		if m[1] != "\t" {
+			if ts, ok := parseTime(m[1]); ok {
+				p.ts = ts
+			}
+		}
+		id, _ := strconv.ParseUint(m[2], 10, 64)
+		p.entry = &entry{
+			offset:   offset,
+			ts:       p.ts,
+			id:       id,
+			command:  m[3],
+			argument: m[4],
+		}
+	}
+
+	p.sendEntry()
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+// sendEntry sends the current entry as an event if it's a query, else it
+// updates the state of its thread.
+func (p *GeneralLogParser) sendEntry() {
+	e := p.entry
+	p.entry = nil
+	if e == nil || p.stopped {
+		return
+	}
+
+	t, ok := p.threads[e.id]
+	if !ok {
+		t = &thread{}
+		p.threads[e.id] = t
+	}
+
+	switch e.command {
+	case "Connect", "Change user":
+		if m := connectRe.FindStringSubmatch(e.argument); m != nil {
+			t.user, t.host, t.db = m[1], m[2], m[3]
+		}
+		return
+	case "Init DB":
+		t.db = e.argument
+		return
+	case "Quit":
+		delete(p.threads, e.id)
+		return
+	case "Query", "Execute":
+	default:
+		return
+	}
+
+	query := strings.TrimSuffix(strings.TrimSpace(e.argument), ";")
+	if query == "" {
+		return
+	}
+	event := log.NewEvent()
+	event.Offset = e.offset
+	event.Ts = e.ts
+	event.Query = query
+	event.User = t.user
+	event.Host = t.host
+	event.Db = t.db
+	event.TimeMetrics["Query_time"] = 0
+
+	select {
+	case p.eventChan <- event:
+	case <-p.stopChan:
+		p.stopped = true
+	}
+}
+
+// parseTime parses the entry time of MySQL 5.7 and newer, which is RFC3339 in
+// UTC or the system time zone (log_timestamps), or of 5.6 and older, which has
+// no time zone.
+func parseTime(s string) (time.Time, bool) {
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, true
+	}
+	if ts, err := time.Parse("2006-01-02T15:04:05.999999", s); err == nil {
+		return ts, true
+	}
+	if ts, err := time.Parse("060102 15:04:05", strings.Join(strings.Fields(s), " ")); err == nil {
+		return ts, true
+	}
+	return time.Time{}, false
+}
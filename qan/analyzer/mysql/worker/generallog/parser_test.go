@@ -0,0 +1,127 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package generallog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/qan-agent/test/rootdir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var inputDir = filepath.Join(rootdir.RootDir(), "test/mysql/generallog")
+
+func parse(t *testing.T, file string, opt log.Options, threads Threads) []*log.Event {
+	f, err := os.Open(filepath.Join(inputDir, file))
+	require.NoError(t, err)
+	defer f.Close()
+	p := NewGeneralLogParser(f, opt)
+	if threads != nil {
+		p.SetThreads(threads)
+	}
+	errChan := make(chan error, 1)
+	go func() { errChan <- p.Start() }()
+	events := []*log.Event{}
+	for e := range p.EventChan() {
+		events = append(events, e)
+	}
+	require.NoError(t, <-errChan)
+	return events
+}
+
+func TestParseGeneralLog57(t *testing.T) {
+	events := parse(t, "general001.log", log.Options{}, nil)
+	require.Len(t, events, 4)
+
+	assert.Equal(t, "SELECT c FROM t WHERE id=1", events[0].Query)
+	assert.Equal(t, "root", events[0].User)
+	assert.Equal(t, "localhost", events[0].Host)
+	assert.Equal(t, "db1", events[0].Db)
+	assert.Equal(t, time.Date(2017, 7, 14, 2, 40, 0, 200000000, time.UTC), events[0].Ts)
+	assert.Equal(t, map[string]float64{"Query_time": 0}, events[0].TimeMetrics)
+
+	// Multi-line query, db from Init DB.
+	assert.Equal(t, "SELECT *\nFROM t2\nWHERE a = 1", events[1].Query)
+	assert.Equal(t, "app", events[1].User)
+	assert.Equal(t, "10.0.0.1", events[1].Host)
+	assert.Equal(t, "db2", events[1].Db)
+
+	// Prepare is not an event, Execute has the values.
+	assert.Equal(t, "SELECT c FROM t WHERE id=2", events[2].Query)
+	assert.Equal(t, "db1", events[2].Db)
+
+	assert.Equal(t, "UPDATE t2 SET a = 2", events[3].Query)
+	assert.Equal(t, "db2", events[3].Db)
+
+	// Offsets are where entries start, so the worker can slice the log.
+	for _, e := range events[1:] {
+		resumed := parse(t, "general001.log", log.Options{StartOffset: e.Offset}, nil)
+		require.NotEmpty(t, resumed)
+		assert.Equal(t, e.Query, resumed[0].Query)
+		assert.Equal(t, e.Offset, resumed[0].Offset)
+	}
+}
+
+func TestParseGeneralLogSlices(t *testing.T) {
+	// Parsers of consecutive slices share threads, so the db of a query
+	// is known even if its thread connected in a previous slice.
+	all := parse(t, "general001.log", log.Options{}, nil)
+	threads := Threads{}
+	first := parse(t, "general001.log", log.Options{}, threads)
+	require.NotEmpty(t, first)
+	last := parse(t, "general001.log", log.Options{StartOffset: all[3].Offset}, threads)
+	require.Len(t, last, 1)
+	assert.Equal(t, "db2", last[0].Db)
+	assert.Equal(t, "app", last[0].User)
+
+	last = parse(t, "general001.log", log.Options{StartOffset: all[3].Offset}, nil)
+	require.Len(t, last, 1)
+	assert.Equal(t, "", last[0].Db)
+}
+
+func TestParseGeneralLog56(t *testing.T) {
+	events := parse(t, "general002.log", log.Options{}, nil)
+	require.Len(t, events, 2)
+
+	// No time: same as the previous entry.
+	assert.Equal(t, "SELECT c FROM t WHERE id=1", events[0].Query)
+	assert.Equal(t, time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC), events[0].Ts)
+	assert.Equal(t, "db1", events[0].Db)
+
+	assert.Equal(t, "INSERT INTO t VALUES (1)", events[1].Query)
+	assert.Equal(t, time.Date(2017, 7, 14, 2, 40, 1, 0, time.UTC), events[1].Ts)
+}
+
+func TestStopGeneralLogParser(t *testing.T) {
+	f, err := os.Open(filepath.Join(inputDir, "general001.log"))
+	require.NoError(t, err)
+	defer f.Close()
+	p := NewGeneralLogParser(f, log.Options{})
+	errChan := make(chan error, 1)
+	go func() { errChan <- p.Start() }()
+	<-p.EventChan()
+	p.Stop()
+	require.NoError(t, <-errChan)
+	_, ok := <-p.EventChan()
+	assert.False(t, ok)
+}
@@ -0,0 +1,58 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package generallog
+
+import (
+	"os"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/slowlog"
+	qc "github.com/percona/qan-agent/qan/config"
+)
+
+// The general log is sliced into intervals by offset, and rotated, like the
+// slow log, so its worker is the slow log worker with a general log parser.
+
+type WorkerFactory interface {
+	Make(name string, config qc.QAN, mysqlConn mysql.Connector) *slowlog.Worker
+}
+
+type RealWorkerFactory struct {
+	logChan chan proto.LogEntry
+}
+
+func NewRealWorkerFactory(logChan chan proto.LogEntry) *RealWorkerFactory {
+	f := &RealWorkerFactory{
+		logChan: logChan,
+	}
+	return f
+}
+
+func (f *RealWorkerFactory) Make(name string, config qc.QAN, mysqlConn mysql.Connector) *slowlog.Worker {
+	w := slowlog.NewWorker(pct.NewLogger(f.logChan, name), config, mysqlConn)
+	threads := Threads{}
+	w.SetLogParserFunc(func(file *os.File, opts log.Options) log.LogParser {
+		p := NewGeneralLogParser(file, opts)
+		p.SetThreads(threads)
+		return p
+	}, "FLUSH NO_WRITE_TO_BINLOG GENERAL LOGS")
+	return w
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package generallog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/percona/qan-agent/test/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-generallog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The general log, and a log rotated before, to be removed because only
+	// one rotated log is retained.
+	data, err := ioutil.ReadFile(filepath.Join(inputDir, "general001.log"))
+	require.NoError(t, err)
+	file := filepath.Join(dir, "general.log")
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+	require.NoError(t, ioutil.WriteFile(file+"-1", data, 0600))
+
+	rotation := true
+	retain := 1
+	config := qc.QAN{
+		QAN: pc.QAN{
+			CollectFrom:     "generallog",
+			Interval:        60,
+			MaxSlowLogSize:  int64(len(data)),
+			SlowLogRotation: &rotation,
+			RetainSlowLogs:  &retain,
+			Start:           []string{"-- start"},
+			Stop:            []string{"-- stop"},
+		},
+	}
+	mysqlConn := mock.NewNullMySQL()
+	logChan := make(chan proto.LogEntry, 100)
+	w := NewRealWorkerFactory(logChan).Make("qan-worker", config, mysqlConn)
+	w.ZeroRunTime = true
+
+	// The log is rotated when the interval reaches MaxSlowLogSize, then the
+	// rest of the rotated log is parsed.
+	interval := &iter.Interval{
+		Number:    1,
+		Filename:  file,
+		EndOffset: int64(len(data)),
+		StartTime: time.Now().UTC(),
+		StopTime:  time.Now().UTC(),
+	}
+	require.NoError(t, w.Setup(interval))
+	assert.Equal(t, []string{"-- stop", "-- start", "FLUSH NO_WRITE_TO_BINLOG GENERAL LOGS"}, mysqlConn.GetExec())
+	assert.NotEqual(t, file, interval.Filename)
+	res, err := w.Run()
+	require.NoError(t, err)
+	require.NoError(t, w.Cleanup())
+	assert.Equal(t, uint(4), res.Global.TotalQueries)
+
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err), "general log moved")
+	files, err := filepath.Glob(file + "-*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{interval.Filename}, files)
+
+	// Below MaxSlowLogSize, the log isn't rotated.
+	mysqlConn.Reset()
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+	interval = &iter.Interval{
+		Number:    2,
+		Filename:  file,
+		EndOffset: int64(len(data)) - 1,
+		StartTime: time.Now().UTC(),
+		StopTime:  time.Now().UTC(),
+	}
+	require.NoError(t, w.Setup(interval))
+	assert.Empty(t, mysqlConn.GetExec())
+	assert.Equal(t, file, interval.Filename)
+}
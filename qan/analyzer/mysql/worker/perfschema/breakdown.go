@@ -0,0 +1,356 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/report"
+)
+
+const (
+	// BreakdownLimit is the max number of waits and stages reported per class.
+	BreakdownLimit = 10
+	// DefaultBreakdownPeriod is how often the history tables are read. The
+	// _long tables hold the last 10,000 events by default, so on a busy server
+	// events between two samples are lost; the breakdown is a sample, not a count.
+	DefaultBreakdownPeriod = 1 * time.Second
+)
+
+// A BreakdownRow is the time spent by the statements of a class in one wait or
+// stage event since the previous sample.
+type BreakdownRow struct {
+	ClassId string
+	Stage   bool
+	Event   string
+	Object  string
+	Count   uint64
+	Wait    uint64 // picoseconds
+}
+
+// Stages are nested in statements, waits are nested in stages or statements.
+// Both are matched to their statement through THREAD_ID and NESTING_EVENT_ID,
+// so only statements still in events_statements_history_long are counted.
+const stagesQuery = "SELECT s.DIGEST, st.EVENT_NAME, COUNT(*), SUM(st.TIMER_WAIT)" +
+	" FROM performance_schema.events_stages_history_long st" +
+	" JOIN performance_schema.events_statements_history_long s" +
+	" ON s.THREAD_ID = st.THREAD_ID AND s.EVENT_ID = st.NESTING_EVENT_ID" +
+	" WHERE st.TIMER_END > ? AND st.TIMER_END <= ? AND s.DIGEST IS NOT NULL" +
+	" GROUP BY s.DIGEST, st.EVENT_NAME"
+
+const waitsQuery = "SELECT s.DIGEST, w.EVENT_NAME, COALESCE(w.OBJECT_SCHEMA, ''), COALESCE(w.OBJECT_NAME, ''), COUNT(*), SUM(w.TIMER_WAIT)" +
+	" FROM performance_schema.events_waits_history_long w" +
+	" LEFT JOIN performance_schema.events_stages_history_long st" +
+	" ON w.NESTING_EVENT_TYPE = 'STAGE' AND st.THREAD_ID = w.THREAD_ID AND st.EVENT_ID = w.NESTING_EVENT_ID" +
+	" JOIN performance_schema.events_statements_history_long s" +
+	" ON s.THREAD_ID = w.THREAD_ID AND s.EVENT_ID = IF(w.NESTING_EVENT_TYPE = 'STAGE', st.NESTING_EVENT_ID, w.NESTING_EVENT_ID)" +
+	" WHERE w.TIMER_END > ? AND w.TIMER_END <= ? AND s.DIGEST IS NOT NULL" +
+	" GROUP BY s.DIGEST, w.EVENT_NAME, w.OBJECT_SCHEMA, w.OBJECT_NAME"
+
+// The consumers and instruments the breakdown needs: waits and stages are
+// matched to statements in the _history_long tables.
+const (
+	breakdownConsumers   = "NAME IN ('events_statements_history_long', 'events_stages_history_long', 'events_waits_history_long')"
+	breakdownInstruments = "(NAME LIKE 'stage/%' OR NAME LIKE 'wait/io/%' OR NAME LIKE 'wait/lock/%')"
+)
+
+// A BreakdownReader reads the stages and waits which ended since its previous
+// Read. Its watermarks are TIMER_END values, which are reset when MySQL restarts.
+type BreakdownReader struct {
+	conn      mysql.Connector
+	stagesEnd uint64
+	waitsEnd  uint64
+	// Rows Setup enabled, restored by Cleanup
+	consumers   map[string]bool       // disabled consumers
+	instruments map[string]instrument // keyed on instrument name
+}
+
+// instrument is the ENABLED and TIMED values of a setup_instruments row.
+type instrument struct {
+	enabled string
+	timed   string
+}
+
+func NewBreakdownReader(conn mysql.Connector) *BreakdownReader {
+	r := &BreakdownReader{
+		conn:        conn,
+		consumers:   map[string]bool{},
+		instruments: map[string]instrument{},
+	}
+	return r
+}
+
+// Setup enables the consumers and instruments the breakdown needs and saves
+// the rows it enables for Cleanup. It's safe to call again, e.g. after MySQL
+// restarted and reset them: rows saved by a previous call are kept.
+func (r *BreakdownReader) Setup() error {
+	if err := r.conn.Connect(); err != nil {
+		return err
+	}
+	db := r.conn.DB()
+
+	rows, err := db.Query("SELECT NAME FROM performance_schema.setup_consumers WHERE ENABLED = 'NO' AND " + breakdownConsumers)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		r.consumers[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query("SELECT NAME, ENABLED, TIMED FROM performance_schema.setup_instruments WHERE (ENABLED = 'NO' OR TIMED = 'NO') AND " + breakdownInstruments)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var i instrument
+		if err := rows.Scan(&name, &i.enabled, &i.timed); err != nil {
+			return err
+		}
+		if _, ok := r.instruments[name]; !ok {
+			r.instruments[name] = i
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return r.conn.Exec([]string{
+		"UPDATE performance_schema.setup_consumers SET ENABLED = 'YES' WHERE " + breakdownConsumers,
+		"UPDATE performance_schema.setup_instruments SET ENABLED = 'YES', TIMED = 'YES' WHERE " + breakdownInstruments,
+	})
+}
+
+// Cleanup restores the consumers and instruments Setup enabled, then closes
+// the connection. Other tools may enable the same rows, but an agent which
+// stops shouldn't leave its overhead behind.
+func (r *BreakdownReader) Cleanup() error {
+	defer r.conn.Close()
+	if len(r.consumers) == 0 && len(r.instruments) == 0 {
+		return nil
+	}
+	if err := r.conn.Connect(); err != nil {
+		return err
+	}
+	db := r.conn.DB()
+
+	if len(r.consumers) > 0 {
+		names := []interface{}{}
+		for name := range r.consumers {
+			names = append(names, name)
+		}
+		if _, err := db.Exec("UPDATE performance_schema.setup_consumers SET ENABLED = 'NO' WHERE NAME IN "+placeholders(len(names)), names...); err != nil {
+			return err
+		}
+		r.consumers = map[string]bool{}
+	}
+
+	// Restore instruments with the same ENABLED and TIMED in one statement.
+	byValues := map[instrument][]interface{}{}
+	for name, i := range r.instruments {
+		byValues[i] = append(byValues[i], name)
+	}
+	for i, names := range byValues {
+		args := append([]interface{}{i.enabled, i.timed}, names...)
+		if _, err := db.Exec("UPDATE performance_schema.setup_instruments SET ENABLED = ?, TIMED = ? WHERE NAME IN "+placeholders(len(names)), args...); err != nil {
+			return err
+		}
+		for _, name := range names {
+			delete(r.instruments, name.(string))
+		}
+	}
+	return nil
+}
+
+// Read returns the stages and waits since the previous call. The first call
+// reads all events in the history tables.
+func (r *BreakdownReader) Read() ([]BreakdownRow, error) {
+	if err := r.conn.Connect(); err != nil {
+		return nil, err
+	}
+	var rows []BreakdownRow
+	var err error
+	rows, r.stagesEnd, err = r.read(rows, "events_stages_history_long", r.stagesEnd, true)
+	if err != nil {
+		return nil, err
+	}
+	rows, r.waitsEnd, err = r.read(rows, "events_waits_history_long", r.waitsEnd, false)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (r *BreakdownReader) read(rows []BreakdownRow, table string, since uint64, stages bool) ([]BreakdownRow, uint64, error) {
+	var max []byte
+	if err := r.conn.DB().QueryRow("SELECT MAX(TIMER_END) FROM performance_schema." + table).Scan(&max); err != nil {
+		return rows, since, err
+	}
+	end, err := parseTimer(max)
+	if err != nil {
+		return rows, since, err
+	}
+	if end < since {
+		// MySQL restarted or the table was truncated: timers started again.
+		since = 0
+	}
+	if end == since {
+		return rows, since, nil
+	}
+
+	query := waitsQuery
+	if stages {
+		query = stagesQuery
+	}
+	res, err := r.conn.DB().Query(query, since, end)
+	if err != nil {
+		return rows, since, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var digest string
+		row := BreakdownRow{Stage: stages}
+		if stages {
+			err = res.Scan(&digest, &row.Event, &row.Count, &row.Wait)
+		} else {
+			var schema string
+			err = res.Scan(&digest, &row.Event, &schema, &row.Object, &row.Count, &row.Wait)
+			if schema != "" && row.Object != "" {
+				row.Object = schema + "." + row.Object
+			}
+		}
+		if err != nil {
+			return rows, since, err
+		}
+		if len(digest) < 32 {
+			continue
+		}
+		row.ClassId = strings.ToUpper(digest[16:32])
+		rows = append(rows, row)
+	}
+	if err := res.Err(); err != nil {
+		return rows, since, err
+	}
+	return rows, end, nil
+}
+
+// parseTimer parses a picosecond timer, which is a BIGINT UNSIGNED: after about
+// 106 days of uptime TIMER_END doesn't fit in an int64. NULL, e.g. MAX of an
+// empty table, is 0.
+func parseTimer(b []byte) (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// placeholders returns "(?, ?, ...)" for n args.
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// --------------------------------------------------------------------------
+
+type breakdownKey struct {
+	event  string
+	object string
+}
+
+type classBreakdown struct {
+	waits  map[breakdownKey]*report.EventTime
+	stages map[breakdownKey]*report.EventTime
+}
+
+// breakdownAgg sums BreakdownRows per class during an interval.
+type breakdownAgg map[string]*classBreakdown // keyed on class Id
+
+func (a breakdownAgg) add(rows []BreakdownRow) {
+	for _, row := range rows {
+		class, ok := a[row.ClassId]
+		if !ok {
+			class = &classBreakdown{
+				waits:  map[breakdownKey]*report.EventTime{},
+				stages: map[breakdownKey]*report.EventTime{},
+			}
+			a[row.ClassId] = class
+		}
+		events := class.waits
+		if row.Stage {
+			events = class.stages
+		}
+		key := breakdownKey{row.Event, row.Object}
+		e, ok := events[key]
+		if !ok {
+			e = &report.EventTime{Event: row.Event, Object: row.Object}
+			events[key] = e
+		}
+		e.Count += row.Count
+		e.Seconds += float64(row.Wait) / 1e12
+	}
+}
+
+// breakdowns returns the top limit waits and stages of every class, most time first.
+func (a breakdownAgg) breakdowns(limit int) map[string]*report.Breakdown {
+	if len(a) == 0 {
+		return nil
+	}
+	all := make(map[string]*report.Breakdown, len(a))
+	for classId, class := range a {
+		all[classId] = &report.Breakdown{
+			Waits:  topEvents(class.waits, limit),
+			Stages: topEvents(class.stages, limit),
+		}
+	}
+	return all
+}
+
+func topEvents(events map[breakdownKey]*report.EventTime, limit int) []report.EventTime {
+	if len(events) == 0 {
+		return nil
+	}
+	top := make([]report.EventTime, 0, len(events))
+	for _, e := range events {
+		top = append(top, *e)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Seconds != top[j].Seconds {
+			return top[i].Seconds > top[j].Seconds
+		}
+		if top[i].Event != top[j].Event {
+			return top[i].Event < top[j].Event
+		}
+		return top[i].Object < top[j].Object
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -32,10 +33,12 @@ import (
 
 	"github.com/percona/go-mysql/event"
 	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test/mock"
 	. "github.com/percona/qan-agent/test/rootdir"
 	"github.com/stretchr/testify/assert"
@@ -90,6 +93,8 @@ func testWorkerWithNullMySQL(t *testing.T, logger *pct.Logger) {
 		test003,
 		test005,
 		test004EmptyDigest,
+		test006DigestLost,
+		test007Breakdown,
 	}
 
 	for _, f := range tests {
@@ -104,6 +109,169 @@ func testWorkerWithNullMySQL(t *testing.T, logger *pct.Logger) {
 	}
 }
 
+func test006DigestLost(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMySQL) {
+	// Performance_schema_digest_lost increases by 50 in the 2nd interval while
+	// only 1 statement is counted, so the digest table is truncated. The 3rd
+	// snapshot, taken after truncate, has smaller values and must be compared
+	// to an empty snapshot instead of resetting the worker.
+	rows, err := loadData("001")
+	require.NoError(t, err)
+	rows = append(rows, rows[0])
+	getRows := makeGetRowsFunc(rows)
+	fetchSeconds := []float64{}
+	w := NewWorker(logger, nullmysql, func(c chan<- *DigestRow, lastFetchSeconds float64, done chan<- error) error {
+		fetchSeconds = append(fetchSeconds, lastFetchSeconds)
+		return getRows(c, lastFetchSeconds, done)
+	})
+	lost := []uint64{100, 150, 150}
+	w.getDigestLost = func() (uint64, error) {
+		l := lost[0]
+		lost = lost[1:]
+		return l, nil
+	}
+	truncated := 0
+	w.truncateDigests = func() error {
+		truncated++
+		return nil
+	}
+	truncate := true
+	w.SetConfig(qc.QAN{QAN: pc.QAN{ExampleQueries: new(bool)}, TruncateDigests: &truncate})
+
+	run := func(n int) *report.Result {
+		require.NoError(t, w.Setup(&iter.Interval{Number: n, StartTime: time.Now().UTC()}))
+		res, err := w.Run()
+		require.NoError(t, err)
+		require.NoError(t, w.Cleanup())
+		return res
+	}
+
+	// First interval: baseline only.
+	assert.Nil(t, run(1))
+	assert.Equal(t, 0, truncated)
+
+	res := run(2)
+	require.NotNil(t, res)
+	assert.Equal(t, uint64(50), res.DigestLost)
+	assert.Equal(t, uint(1), res.Global.TotalQueries)
+	assert.Equal(t, 1, truncated)
+	assert.Equal(t, "last: 50, total: 50", w.Status()["qan-worker-digest-lost"])
+
+	res = run(3)
+	require.NotNil(t, res)
+	assert.Equal(t, uint64(0), res.DigestLost)
+	assert.Equal(t, uint(1), res.Global.TotalQueries)
+	assert.Equal(t, 1, truncated)
+	require.Len(t, fetchSeconds, 3)
+	assert.Equal(t, float64(-1), fetchSeconds[2], "all rows fetched after truncate")
+}
+
+func test007Breakdown(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMySQL) {
+	// Waits and stages sampled between intervals are summed per class and
+	// reported with the next interval, then start again from zero.
+	rows, err := loadData("001")
+	require.NoError(t, err)
+	w := NewWorker(logger, nullmysql, makeGetRowsFunc(rows))
+	samples := make(chan struct{}, 100)
+	setups := 0
+	cleanups := 0
+	w.setupBreakdown = func() error {
+		setups++
+		return nil
+	}
+	w.cleanupBreakdown = func() error {
+		cleanups++
+		return nil
+	}
+	w.getBreakdownRows = func() ([]BreakdownRow, error) {
+		samples <- struct{}{}
+		return []BreakdownRow{
+			{ClassId: "ABC", Stage: true, Event: "stage/sql/Sending data", Count: 1, Wait: 2e12},
+			{ClassId: "ABC", Event: "wait/io/table/sql/handler", Object: "db.t", Count: 10, Wait: 1e12},
+		}, nil
+	}
+	breakdown := true
+	w.SetConfig(qc.QAN{QAN: pc.QAN{ExampleQueries: new(bool)}, Breakdown: &breakdown})
+	// Sample faster than DefaultBreakdownPeriod.
+	w.lock.Lock()
+	w.stopBreakdown()
+	w.startBreakdown(10 * time.Millisecond)
+	w.lock.Unlock()
+	defer w.Stop()
+
+	run := func(n int) *report.Result {
+		require.NoError(t, w.Setup(&iter.Interval{Number: n, StartTime: time.Now().UTC()}))
+		res, err := w.Run()
+		require.NoError(t, err)
+		require.NoError(t, w.Cleanup())
+		return res
+	}
+
+	assert.Nil(t, run(1))
+	<-samples
+	<-samples
+	res := run(2)
+	require.NotNil(t, res)
+	require.Contains(t, res.Breakdown, "ABC")
+	b := res.Breakdown["ABC"]
+	require.Len(t, b.Stages, 1)
+	require.Len(t, b.Waits, 1)
+	assert.Equal(t, "stage/sql/Sending data", b.Stages[0].Event)
+	assert.True(t, b.Stages[0].Count >= 2)
+	assert.Equal(t, float64(b.Stages[0].Count)*2, b.Stages[0].Seconds)
+	assert.Equal(t, "db.t", b.Waits[0].Object)
+	assert.Equal(t, b.Stages[0].Count*10, b.Waits[0].Count)
+
+	// Stopping the worker stops sampling and restores the setup.
+	require.NoError(t, w.Stop())
+	assert.Nil(t, w.takeBreakdown())
+	assert.Equal(t, 2, setups, "setup by both samplers")
+	assert.Equal(t, 2, cleanups, "cleanup by both samplers")
+}
+
+func TestBreakdownAgg(t *testing.T) {
+	agg := breakdownAgg{}
+	rows := []BreakdownRow{}
+	for i := 0; i < BreakdownLimit+5; i++ {
+		rows = append(rows, BreakdownRow{ClassId: "A", Event: fmt.Sprintf("wait/lock/%02d", i), Count: 1, Wait: uint64(i) * 1e9})
+	}
+	rows = append(rows, BreakdownRow{ClassId: "B", Stage: true, Event: "stage/sql/init", Count: 1, Wait: 1e9})
+	agg.add(rows)
+	agg.add(rows[len(rows)-2:])
+
+	got := agg.breakdowns(BreakdownLimit)
+	require.Len(t, got, 2)
+	a := got["A"]
+	assert.Nil(t, a.Stages)
+	require.Len(t, a.Waits, BreakdownLimit)
+	// Most time first; the last wait was added twice.
+	assert.Equal(t, report.EventTime{Event: fmt.Sprintf("wait/lock/%02d", BreakdownLimit+4), Count: 2, Seconds: 2 * float64(BreakdownLimit+4) / 1000}, a.Waits[0])
+	assert.Equal(t, fmt.Sprintf("wait/lock/%02d", BreakdownLimit+3), a.Waits[1].Event)
+	assert.Equal(t, "wait/lock/05", a.Waits[BreakdownLimit-1].Event)
+	b := got["B"]
+	assert.Nil(t, b.Waits)
+	assert.Equal(t, []report.EventTime{{Event: "stage/sql/init", Count: 2, Seconds: 0.002}}, b.Stages)
+
+	assert.Nil(t, breakdownAgg{}.breakdowns(BreakdownLimit))
+}
+
+func TestParseTimer(t *testing.T) {
+	// TIMER_END is BIGINT UNSIGNED: above math.MaxInt64 after ~106 days uptime.
+	end, err := parseTimer([]byte("9223372036854775808"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxInt64)+1, end)
+
+	end, err = parseTimer([]byte("18446744073709551615"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), end)
+
+	end, err = parseTimer(nil) // MAX of an empty table
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), end)
+
+	_, err = parseTimer([]byte("-1"))
+	assert.Error(t, err)
+}
+
 // --------------------------------------------------------------------------
 
 func loadData(dir string) ([][]*DigestRow, error) {
@@ -462,7 +630,7 @@ func testRealWorker(t *testing.T, logger *pct.Logger, dsn string) {
 	}
 
 	mysqlWorkerConn := mysql.NewConnection(dsn)
-	f := NewRealWorkerFactory(logger.LogChan())
+	f := NewRealWorkerFactory(logger.LogChan(), &mysql.RealConnectionFactory{})
 	w := f.Make("qan-worker", mysqlWorkerConn)
 
 	start := []mysql.Query{
@@ -564,7 +732,7 @@ func testIterOutOfSeq(t *testing.T, logger *pct.Logger, dsn string) {
 	}
 
 	mysqlWorkerConn := mysql.NewConnection(dsn)
-	f := NewRealWorkerFactory(logger.LogChan())
+	f := NewRealWorkerFactory(logger.LogChan(), &mysql.RealConnectionFactory{})
 	w := f.Make("qan-worker", mysqlWorkerConn)
 
 	start := []mysql.Query{
@@ -652,7 +820,7 @@ func testIterClockReset(t *testing.T, logger *pct.Logger, dsn string) {
 	}
 
 	mysqlWorkerConn := mysql.NewConnection(dsn)
-	f := NewRealWorkerFactory(logger.LogChan())
+	f := NewRealWorkerFactory(logger.LogChan(), &mysql.RealConnectionFactory{})
 	w := f.Make("qan-worker", mysqlWorkerConn)
 
 	start := []mysql.Query{
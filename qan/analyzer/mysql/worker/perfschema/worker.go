@@ -27,11 +27,11 @@ import (
 
 	"github.com/percona/go-mysql/event"
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 // A DigestRow is a row from performance_schema.events_statements_summary_by_digest.
@@ -81,7 +81,8 @@ type WorkerFactory interface {
 }
 
 type RealWorkerFactory struct {
-	logChan chan proto.LogEntry
+	logChan     chan proto.LogEntry
+	connFactory mysql.ConnectionFactory
 }
 
 type perfSchemaExample struct {
@@ -90,9 +91,10 @@ type perfSchemaExample struct {
 	LastSeen time.Time
 }
 
-func NewRealWorkerFactory(logChan chan proto.LogEntry) *RealWorkerFactory {
+func NewRealWorkerFactory(logChan chan proto.LogEntry, connFactory mysql.ConnectionFactory) *RealWorkerFactory {
 	f := &RealWorkerFactory{
-		logChan: logChan,
+		logChan:     logChan,
+		connFactory: connFactory,
 	}
 	return f
 }
@@ -101,7 +103,40 @@ func (f *RealWorkerFactory) Make(name string, mysqlConn mysql.Connector) *Worker
 	getRows := func(c chan<- *DigestRow, lastFetchSeconds float64, doneChan chan<- error) error {
 		return GetDigestRows(mysqlConn, lastFetchSeconds, c, doneChan)
 	}
-	return NewWorker(pct.NewLogger(f.logChan, name), mysqlConn, getRows)
+	w := NewWorker(pct.NewLogger(f.logChan, name), mysqlConn, getRows)
+	w.getDigestLost = func() (uint64, error) {
+		return GetDigestLost(mysqlConn)
+	}
+	w.truncateDigests = func() error {
+		return TruncateDigests(mysqlConn)
+	}
+	// Own connection: the sampler runs between intervals and Run closes mysqlConn.
+	breakdown := NewBreakdownReader(f.connFactory.Make(mysqlConn.DSN()))
+	w.setupBreakdown = breakdown.Setup
+	w.getBreakdownRows = breakdown.Read
+	w.cleanupBreakdown = breakdown.Cleanup
+	return w
+}
+
+// TruncateDigestsLostRatio is the ratio of lost to counted statements in an
+// interval above which the digest table is truncated, if config.TruncateDigests.
+const TruncateDigestsLostRatio = 0.01
+
+// GetDigestLost returns Performance_schema_digest_lost: the number of statements
+// which couldn't be counted because events_statements_summary_by_digest is full.
+// Those statements are counted under the NULL digest, class "2".
+func GetDigestLost(mysqlConn mysql.Connector) (uint64, error) {
+	var name string
+	var lost uint64
+	err := mysqlConn.DB().QueryRow("SHOW GLOBAL STATUS LIKE 'Performance_schema_digest_lost'").Scan(&name, &lost)
+	return lost, err
+}
+
+// TruncateDigests empties events_statements_summary_by_digest so new classes
+// can be counted again.
+func TruncateDigests(mysqlConn mysql.Connector) error {
+	_, err := mysqlConn.DB().Exec("TRUNCATE TABLE performance_schema.events_statements_summary_by_digest")
+	return err
 }
 
 // GetDigestRows connects to MySQL through `mysql.Connector`,
@@ -215,6 +250,12 @@ type Worker struct {
 	logger    *pct.Logger
 	mysqlConn mysql.Connector
 	getRows   GetDigestRowsFunc
+	// Optional, set by RealWorkerFactory.
+	getDigestLost    func() (uint64, error)
+	truncateDigests  func() error
+	setupBreakdown   func() error
+	getBreakdownRows func() ([]BreakdownRow, error)
+	cleanupBreakdown func() error
 	// --
 	name            string
 	status          *pct.Status
@@ -225,6 +266,17 @@ type Worker struct {
 	lastFetchTime   time.Time
 	lastPrepTime    float64
 	collectExamples bool
+	// Performance_schema_digest_lost
+	digestLost      uint64 // at last snapshot
+	haveDigestLost  bool   // digestLost is a valid baseline
+	totalDigestLost uint64 // since worker start
+	truncate        bool   // config.TruncateDigests
+	truncated       bool   // digest table truncated after last snapshot
+	// config.Breakdown
+	breakdown      breakdownAgg // since last Run, guarded by lock
+	breakdownSetup chan struct{}
+	breakdownStop  chan struct{}
+	breakdownDone  chan struct{}
 
 	//
 	lock                  sync.Mutex
@@ -245,6 +297,7 @@ func NewWorker(logger *pct.Logger, mysqlConn mysql.Connector, getRows GetDigestR
 			name,
 			name + "-last",
 			name + "-digests",
+			name + "-digest-lost",
 		}),
 		digests:       NewDigests(),
 		queryExamples: make(map[string]perfSchemaExample),
@@ -290,8 +343,12 @@ func (w *Worker) Run() (*report.Result, error) {
 		w.lastErr = err
 		return nil, err
 	}
+	lost := w.updateDigestLost()
 
-	if len(w.digests.All) == 0 {
+	// After truncate, the previous snapshot is empty: all values are new.
+	truncated := w.truncated
+	w.truncated = false
+	if len(w.digests.All) == 0 && !truncated {
 		return nil, nil
 	}
 
@@ -300,14 +357,70 @@ func (w *Worker) Run() (*report.Result, error) {
 		w.lastErr = err
 		return nil, err
 	}
+	if res != nil {
+		res.DigestLost = lost
+		res.Breakdown = w.takeBreakdown()
+	}
+
+	if w.truncate && lost > 0 && w.truncateDigests != nil {
+		counted := uint64(0)
+		if res != nil {
+			counted = uint64(res.Global.TotalQueries)
+		}
+		if float64(lost)/float64(lost+counted) >= TruncateDigestsLostRatio {
+			// Statements which execute between the snapshot and the truncate
+			// are not counted, but that's less than what's lost now.
+			if err := w.truncateDigests(); err != nil {
+				w.logger.Warn("Cannot truncate events_statements_summary_by_digest: ", err)
+			} else {
+				w.logger.Info(fmt.Sprintf("Truncated events_statements_summary_by_digest, %d statements lost", lost))
+				w.truncated = true
+			}
+		}
+	}
 
 	return res, nil
 }
 
+// updateDigestLost returns how much Performance_schema_digest_lost increased
+// since the last snapshot.
+func (w *Worker) updateDigestLost() uint64 {
+	if w.getDigestLost == nil {
+		return 0
+	}
+	curr, err := w.getDigestLost()
+	if err != nil {
+		w.logger.Warn("Cannot get Performance_schema_digest_lost: ", err)
+		w.haveDigestLost = false
+		return 0
+	}
+	lost := uint64(0)
+	switch {
+	case !w.haveDigestLost:
+		// First snapshot, only the baseline.
+	case curr < w.digestLost:
+		// MySQL restarted, the counter started again from zero.
+		lost = curr
+	default:
+		lost = curr - w.digestLost
+	}
+	w.digestLost = curr
+	w.haveDigestLost = true
+	w.totalDigestLost += lost
+	w.status.Update(w.name+"-digest-lost", fmt.Sprintf("last: %d, total: %d", lost, w.totalDigestLost))
+	return lost
+}
+
 func (w *Worker) Cleanup() error {
 	w.logger.Debug("Cleanup:call:", w.iter.Number)
 	defer w.logger.Debug("Cleanup:return:", w.iter.Number)
-	w.digests.MergeCurr()
+	if w.truncated {
+		// Values in the table start from zero again, so the next snapshot is
+		// compared to an empty one.
+		w.digests.Reset()
+	} else {
+		w.digests.MergeCurr()
+	}
 	last := fmt.Sprintf("rows: %d, fetch: %s, prep: %s",
 		w.lastRowCnt, w.lastFetchTime.Format(time.RFC3339), pct.Duration(w.lastPrepTime))
 	if w.lastErr != nil {
@@ -326,6 +439,7 @@ func (w *Worker) Stop() error {
 		w.collectExamplesTicker.Stop()
 		w.collectExamplesTicker = nil
 	}
+	w.stopBreakdown()
 	return nil
 }
 
@@ -333,14 +447,28 @@ func (w *Worker) Status() map[string]string {
 	return w.status.All()
 }
 
-func (w *Worker) SetConfig(config pc.QAN) {
+func (w *Worker) SetConfig(config qc.QAN) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 	w.collectExamples = *config.ExampleQueries
+	w.truncate = config.TruncateDigests != nil && *config.TruncateDigests
 	if w.collectExamples && w.collectExamplesTicker == nil {
 		w.collectExamplesTicker = time.NewTicker(time.Millisecond * 1000)
 		go w.getQueryExamples(w.collectExamplesTicker.C)
 	}
+	if config.Breakdown != nil && *config.Breakdown && w.getBreakdownRows != nil {
+		if w.breakdownStop != nil {
+			// MySQL is (re)configured, e.g. after a restart which reset
+			// the consumers and instruments.
+			select {
+			case w.breakdownSetup <- struct{}{}:
+			default:
+			}
+		}
+		w.startBreakdown(DefaultBreakdownPeriod)
+	} else {
+		w.stopBreakdown()
+	}
 }
 
 // --------------------------------------------------------------------------
@@ -354,6 +482,99 @@ func (w *Worker) reset() {
 	w.lastPrepTime = 0
 }
 
+// startBreakdown starts sampling waits and stages. Caller must hold w.lock.
+func (w *Worker) startBreakdown(period time.Duration) {
+	if w.breakdownStop != nil {
+		return
+	}
+	w.breakdown = breakdownAgg{}
+	w.breakdownSetup = make(chan struct{}, 1)
+	w.breakdownStop = make(chan struct{})
+	w.breakdownDone = make(chan struct{})
+	go w.sampleBreakdown(period, w.breakdownSetup, w.breakdownStop, w.breakdownDone)
+}
+
+// stopBreakdown stops sampling and waits for the last sample and the cleanup,
+// which restores performance_schema setup. Caller must hold w.lock.
+func (w *Worker) stopBreakdown() {
+	if w.breakdownStop == nil {
+		return
+	}
+	close(w.breakdownStop)
+	// The sampler takes w.lock to add rows, so release it while waiting.
+	done := w.breakdownDone
+	w.breakdownSetup, w.breakdownStop, w.breakdownDone = nil, nil, nil
+	w.lock.Unlock()
+	<-done
+	w.lock.Lock()
+	w.breakdown = nil
+}
+
+func (w *Worker) sampleBreakdown(period time.Duration, setupChan, stopChan, doneChan chan struct{}) {
+	defer close(doneChan)
+	if w.cleanupBreakdown != nil {
+		defer func() {
+			if err := w.cleanupBreakdown(); err != nil {
+				w.logger.Warn("Cannot restore performance_schema setup: ", err)
+			}
+		}()
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	setup := w.setupBreakdown != nil
+	setupErr := ""
+	lastErr := ""
+	for {
+		if setup {
+			if err := w.setupBreakdown(); err != nil {
+				// Retried every sample, so log every distinct error once too.
+				if err.Error() != setupErr {
+					w.logger.Warn("Cannot enable waits and stages: ", err)
+					setupErr = err.Error()
+				}
+			} else {
+				setup = false
+				setupErr = ""
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-setupChan:
+			setup = w.setupBreakdown != nil
+			continue
+		case <-stopChan:
+			return
+		}
+		rows, err := w.getBreakdownRows()
+		if err != nil {
+			// Log every distinct error once, not once per sample.
+			if err.Error() != lastErr {
+				w.logger.Warn("Cannot get waits and stages: ", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		w.lock.Lock()
+		if w.breakdown != nil {
+			w.breakdown.add(rows)
+		}
+		w.lock.Unlock()
+	}
+}
+
+// takeBreakdown returns the waits and stages per class since the last call.
+func (w *Worker) takeBreakdown() map[string]*report.Breakdown {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.breakdown == nil {
+		return nil
+	}
+	agg := w.breakdown
+	w.breakdown = breakdownAgg{}
+	return agg.breakdowns(BreakdownLimit)
+}
+
 func (w *Worker) getQueryExamples(ticker <-chan time.Time) {
 	isRunning := false
 	for range ticker {
@@ -406,8 +627,8 @@ func (w *Worker) getSnapshot() (Snapshot, error) {
 	}()
 
 	seconds := float64(0)
-	// If it's first snapshot we should fetch it all
-	if len(w.digests.All) == 0 {
+	// If it's first snapshot or the table was truncated, we should fetch it all
+	if len(w.digests.All) == 0 || w.truncated {
 		seconds = -1
 	} else {
 		seconds = time.Now().UTC().Sub(w.lastFetchTime).Seconds()
@@ -37,6 +37,7 @@ import (
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test"
 	"github.com/percona/qan-agent/test/mock"
 	. "github.com/percona/qan-agent/test/rootdir"
@@ -64,7 +65,7 @@ type WorkerTestSuite struct {
 	logger        *pct.Logger
 	now           time.Time
 	mysqlInstance proto.Instance
-	config        pc.QAN
+	config        qc.QAN
 	mysqlConn     mysql.Connector
 	worker        *Worker
 	nullmysql     *mock.NullMySQL
@@ -82,21 +83,23 @@ func (s *WorkerTestSuite) SetUpSuite(t *C) {
 	s.now = time.Now().UTC()
 	s.mysqlInstance = proto.Instance{UUID: "1", Name: "mysql1"}
 	exampleQueries := true
-	s.config = pc.QAN{
-		UUID: s.mysqlInstance.UUID,
-		Start: []string{
-			"SET GLOBAL slow_query_log=OFF",
-			"SET GLOBAL long_query_time=0.123",
-			"SET GLOBAL slow_query_log=ON",
+	s.config = qc.QAN{
+		QAN: pc.QAN{
+			UUID: s.mysqlInstance.UUID,
+			Start: []string{
+				"SET GLOBAL slow_query_log=OFF",
+				"SET GLOBAL long_query_time=0.123",
+				"SET GLOBAL slow_query_log=ON",
+			},
+			Stop: []string{
+				"SET GLOBAL slow_query_log=OFF",
+				"SET GLOBAL long_query_time=10",
+			},
+			Interval:       60,         // 1 min
+			MaxSlowLogSize: 1073741824, // 1 GiB
+			ExampleQueries: &exampleQueries,
+			CollectFrom:    "slowlog",
 		},
-		Stop: []string{
-			"SET GLOBAL slow_query_log=OFF",
-			"SET GLOBAL long_query_time=10",
-		},
-		Interval:       60,         // 1 min
-		MaxSlowLogSize: 1073741824, // 1 GiB
-		ExampleQueries: &exampleQueries,
-		CollectFrom:    "slowlog",
 	}
 	s.nullmysql = mock.NewNullMySQL()
 }
@@ -105,7 +108,7 @@ func (s *WorkerTestSuite) SetUpTest(t *C) {
 	s.nullmysql.Reset()
 }
 
-func (s *WorkerTestSuite) RunWorker(config pc.QAN, mysqlConn mysql.Connector, i *iter.Interval) (*report.Result, error) {
+func (s *WorkerTestSuite) RunWorker(config qc.QAN, mysqlConn mysql.Connector, i *iter.Interval) (*report.Result, error) {
 	w := NewWorker(s.logger, config, mysqlConn)
 	w.ZeroRunTime = true
 	w.Setup(i)
@@ -286,20 +289,22 @@ func (s *WorkerTestSuite) TestRotateAndRemoveSlowLog(t *C) {
 	exampleQueries := true
 	slowLogsRotation := true
 	slowLogsToKeep := 1
-	config := pc.QAN{
-		UUID:            s.mysqlInstance.UUID,
-		Interval:        300,
-		MaxSlowLogSize:  1000, // <-- HERE
-		ExampleQueries:  &exampleQueries,
-		SlowLogRotation: &slowLogsRotation,
-		RetainSlowLogs:  &slowLogsToKeep,
-		Start: []string{
-			"-- start",
-		},
-		Stop: []string{
-			"-- stop",
+	config := qc.QAN{
+		QAN: pc.QAN{
+			UUID:            s.mysqlInstance.UUID,
+			Interval:        300,
+			MaxSlowLogSize:  1000, // <-- HERE
+			ExampleQueries:  &exampleQueries,
+			SlowLogRotation: &slowLogsRotation,
+			RetainSlowLogs:  &slowLogsToKeep,
+			Start: []string{
+				"-- start",
+			},
+			Stop: []string{
+				"-- stop",
+			},
+			CollectFrom: "slowlog",
 		},
-		CollectFrom: "slowlog",
 	}
 	w := NewWorker(s.logger, config, s.nullmysql)
 
@@ -375,7 +380,7 @@ func (s *WorkerTestSuite) TestRotateAndRemoveSlowLog(t *C) {
 }
 
 func (s *WorkerTestSuite) TestRotateSlowLog(t *C) {
-	// Same as TestRotateAndRemoveSlowLog but pc.QAN.RemoveOldSlowLogs=false
+	// Same as TestRotateAndRemoveSlowLog but qc.QAN.RemoveOldSlowLogs=false
 	// so the old slow log file is not removed.
 
 	slowlogFile := "slow006.log"
@@ -388,20 +393,22 @@ func (s *WorkerTestSuite) TestRotateSlowLog(t *C) {
 	exampleQueries := true
 	slowLogsRotation := true
 	slowLogsToKeep := 1
-	config := pc.QAN{
-		UUID:            s.mysqlInstance.UUID,
-		Interval:        300,
-		MaxSlowLogSize:  1000,
-		ExampleQueries:  &exampleQueries,
-		SlowLogRotation: &slowLogsRotation,
-		RetainSlowLogs:  &slowLogsToKeep,
-		Start: []string{
-			"-- start",
+	config := qc.QAN{
+		QAN: pc.QAN{
+			UUID:            s.mysqlInstance.UUID,
+			Interval:        300,
+			MaxSlowLogSize:  1000,
+			ExampleQueries:  &exampleQueries,
+			SlowLogRotation: &slowLogsRotation,
+			RetainSlowLogs:  &slowLogsToKeep,
+			Start: []string{
+				"-- start",
+			},
+			Stop: []string{
+				"-- stop",
+			},
+			CollectFrom: "slowlog",
 		},
-		Stop: []string{
-			"-- stop",
-		},
-		CollectFrom: "slowlog",
 	}
 	w := NewWorker(s.logger, config, s.nullmysql)
 
@@ -486,7 +493,8 @@ func (s *WorkerTestSuite) TestRotateSlowLog(t *C) {
 }
 
 /*
-  This test uses a real MySQL connection because we need to test if the slow log
+	This test uses a real MySQL connection because we need to test if the slow log
+
 is being created when it is rotated.
 */
 func (s *WorkerTestSuite) TestRotateRealSlowLog(t *C) {
@@ -544,20 +552,22 @@ func (s *WorkerTestSuite) TestRotateRealSlowLog(t *C) {
 
 	// See TestStartService() for description of these startup tasks.
 	exampleQueries := true
-	config := pc.QAN{
-		UUID:           s.mysqlInstance.UUID,
-		Interval:       300,
-		MaxSlowLogSize: 1000,
-		ExampleQueries: &exampleQueries,
-		Start: []string{
-			"SET GLOBAL slow_query_log=1",
-			fmt.Sprintf("SET GLOBAL slow_query_log_file='%s'", slowlogFile),
+	config := qc.QAN{
+		QAN: pc.QAN{
+			UUID:           s.mysqlInstance.UUID,
+			Interval:       300,
+			MaxSlowLogSize: 1000,
+			ExampleQueries: &exampleQueries,
+			Start: []string{
+				"SET GLOBAL slow_query_log=1",
+				fmt.Sprintf("SET GLOBAL slow_query_log_file='%s'", slowlogFile),
+			},
+			Stop: []string{
+				"SET GLOBAL slow_query_log=0",
+				"FLUSH NO_WRITE_TO_BINLOG SLOW LOGS",
+			},
+			CollectFrom: "slowlog",
 		},
-		Stop: []string{
-			"SET GLOBAL slow_query_log=0",
-			"FLUSH NO_WRITE_TO_BINLOG SLOW LOGS",
-		},
-		CollectFrom: "slowlog",
 	}
 	w := NewWorker(s.logger, config, conn)
 
@@ -621,13 +631,15 @@ func (s *WorkerTestSuite) TestRotateRealSlowLog(t *C) {
 }
 
 func (s *WorkerTestSuite) TestStop(t *C) {
-	config := pc.QAN{
-		UUID:           s.mysqlInstance.UUID,
-		Interval:       300,
-		MaxSlowLogSize: 1024 * 1024 * 1024,
-		Start:          []string{},
-		Stop:           []string{},
-		CollectFrom:    "slowlog",
+	config := qc.QAN{
+		QAN: pc.QAN{
+			UUID:           s.mysqlInstance.UUID,
+			Interval:       300,
+			MaxSlowLogSize: 1024 * 1024 * 1024,
+			Start:          []string{},
+			Stop:           []string{},
+			CollectFrom:    "slowlog",
+		},
 	}
 	w := NewWorker(s.logger, config, s.nullmysql)
 
@@ -707,12 +719,14 @@ func (s *WorkerTestSuite) TestStop(t *C) {
 }
 
 func (s *WorkerTestSuite) TestResult014(t *C) {
-	config := pc.QAN{
-		UUID:           "1",
-		CollectFrom:    "slowlog",
-		Interval:       60,
-		ReportLimit:    500,
-		MaxSlowLogSize: 1024 * 1024 * 1000,
+	config := qc.QAN{
+		QAN: pc.QAN{
+			UUID:           "1",
+			CollectFrom:    "slowlog",
+			Interval:       60,
+			ReportLimit:    500,
+			MaxSlowLogSize: 1024 * 1024 * 1000,
+		},
 	}
 	logChan := make(chan proto.LogEntry, 1000)
 	w := NewWorker(pct.NewLogger(logChan, "w"), config, mock.NewNullMySQL())
@@ -0,0 +1,154 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package slowlog
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/qan-agent/mysql"
+)
+
+// Columns of mysql.slow_log. Times are read as Unix timestamps so they don't
+// depend on the session time_zone, which converts TIMESTAMP values.
+const slowLogTableQuery = "SELECT UNIX_TIMESTAMP(start_time), user_host," +
+	" TIME_TO_SEC(query_time) + MICROSECOND(query_time) / 1000000," +
+	" TIME_TO_SEC(lock_time) + MICROSECOND(lock_time) / 1000000," +
+	" rows_sent, rows_examined, db, sql_text" +
+	" FROM mysql.slow_log" +
+	" WHERE start_time > FROM_UNIXTIME(?) AND start_time <= FROM_UNIXTIME(?)" +
+	" ORDER BY start_time"
+
+// A TableParser reads events from mysql.slow_log, which MySQL writes instead of
+// the slow log file when log_output=TABLE. It reads rows with start_time in
+// (Since, Until], so consecutive parsers given consecutive ranges never read a
+// row twice. MySQL writes a row when the query ends, so a query which started
+// before Until but ended after the next parser read its range is not counted.
+type TableParser struct {
+	mysqlConn mysql.Connector
+	since     time.Time
+	until     time.Time
+	// --
+	eventChan chan *log.Event
+	stopChan  chan struct{}
+}
+
+func NewTableParser(mysqlConn mysql.Connector, since, until time.Time) *TableParser {
+	p := &TableParser{
+		mysqlConn: mysqlConn,
+		since:     since,
+		until:     until,
+		// --
+		eventChan: make(chan *log.Event),
+		stopChan:  make(chan struct{}),
+	}
+	return p
+}
+
+func (p *TableParser) EventChan() <-chan *log.Event {
+	return p.eventChan
+}
+
+func (p *TableParser) Stop() {
+	close(p.stopChan)
+}
+
+// Start reads the rows and sends them as events on EventChan, which is closed
+// when done. Like the slow log parser, it blocks until done or stopped.
+func (p *TableParser) Start() error {
+	defer close(p.eventChan)
+
+	rows, err := p.mysqlConn.DB().Query(slowLogTableQuery, unixTime(p.since), unixTime(p.until))
+	if err != nil {
+		return fmt.Errorf("cannot read mysql.slow_log: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row TableRow
+		if err := rows.Scan(&row.StartTime, &row.UserHost, &row.QueryTime, &row.LockTime,
+			&row.RowsSent, &row.RowsExamined, &row.Db, &row.SQLText); err != nil {
+			return fmt.Errorf("cannot read mysql.slow_log: %s", err)
+		}
+		select {
+		case p.eventChan <- row.Event():
+		case <-p.stopChan:
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// A TableRow is a row from mysql.slow_log.
+type TableRow struct {
+	StartTime    float64 // Unix timestamp
+	UserHost     string
+	QueryTime    float64
+	LockTime     float64
+	RowsSent     uint64
+	RowsExamined uint64
+	Db           sql.NullString
+	SQLText      string
+}
+
+// Event returns the row as a log.Event with the same metrics as the slow log
+// file of stock MySQL. The table has no Percona Server extended metrics.
+func (r TableRow) Event() *log.Event {
+	e := log.NewEvent()
+	sec, frac := math.Modf(r.StartTime)
+	e.Ts = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	e.User, e.Host = parseUserHost(r.UserHost)
+	e.Db = r.Db.String
+	e.Query = r.SQLText
+	e.TimeMetrics["Query_time"] = r.QueryTime
+	e.TimeMetrics["Lock_time"] = r.LockTime
+	e.NumberMetrics["Rows_sent"] = r.RowsSent
+	e.NumberMetrics["Rows_examined"] = r.RowsExamined
+	return e
+}
+
+// parseUserHost parses user_host, like "root[root] @ localhost [127.0.0.1]",
+// into the user and host. The host is the IP if there's no host name, as in
+// the "# User@Host:" line of the slow log file.
+func parseUserHost(userHost string) (user, host string) {
+	at := strings.Index(userHost, " @ ")
+	if at < 0 {
+		return "", ""
+	}
+	user = userHost[:at]
+	if i := strings.Index(user, "["); i >= 0 {
+		user = user[:i]
+	}
+	host = strings.TrimSpace(userHost[at+3:])
+	ip := ""
+	if i := strings.Index(host, "["); i >= 0 {
+		ip = strings.Trim(host[i:], "[]")
+		host = strings.TrimSpace(host[:i])
+	}
+	if host == "" {
+		host = ip
+	}
+	return user, host
+}
+
+func unixTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package slowlog
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/percona/qan-agent/test/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUserHost(t *testing.T) {
+	tests := []struct {
+		userHost string
+		user     string
+		host     string
+	}{
+		{"root[root] @ localhost []", "root", "localhost"},
+		{"app[app] @  [10.0.0.1]", "app", "10.0.0.1"},
+		{"app[app] @ web1 [10.0.0.1]", "app", "web1"},
+		{"[SLAVE] @  []", "", ""},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		user, host := parseUserHost(test.userHost)
+		assert.Equal(t, test.user, user, test.userHost)
+		assert.Equal(t, test.host, host, test.userHost)
+	}
+}
+
+func TestTableRowEvent(t *testing.T) {
+	row := TableRow{
+		StartTime:    1500000000.123456,
+		UserHost:     "root[root] @ localhost []",
+		QueryTime:    1.5,
+		LockTime:     0.000123,
+		RowsSent:     1,
+		RowsExamined: 100,
+		Db:           sql.NullString{String: "db1", Valid: true},
+		SQLText:      "SELECT c FROM t WHERE id=1",
+	}
+	e := row.Event()
+	assert.Equal(t, time.Date(2017, 7, 14, 2, 40, 0, 123456000, time.UTC), e.Ts.Round(time.Microsecond))
+	assert.Equal(t, "root", e.User)
+	assert.Equal(t, "localhost", e.Host)
+	assert.Equal(t, "db1", e.Db)
+	assert.Equal(t, "SELECT c FROM t WHERE id=1", e.Query)
+	assert.Equal(t, map[string]float64{"Query_time": 1.5, "Lock_time": 0.000123}, e.TimeMetrics)
+	assert.Equal(t, map[string]uint64{"Rows_sent": 1, "Rows_examined": 100}, e.NumberMetrics)
+}
+
+// tableParser sends the given events like TableParser does.
+type tableParser struct {
+	events    []*log.Event
+	eventChan chan *log.Event
+}
+
+func (p *tableParser) Start() error {
+	for _, e := range p.events {
+		p.eventChan <- e
+	}
+	close(p.eventChan)
+	return nil
+}
+
+func (p *tableParser) Stop() {}
+
+func (p *tableParser) EventChan() <-chan *log.Event {
+	return p.eventChan
+}
+
+func TestWorkerTable(t *testing.T) {
+	logChan := make(chan proto.LogEntry, 100)
+	table := true
+	config := qc.QAN{
+		QAN: pc.QAN{
+			Interval: 60,
+			// Rotation is ignored for mysql.slow_log.
+			SlowLogRotation: &table,
+			MaxSlowLogSize:  1,
+		},
+		SlowLogTable: &table,
+	}
+	w := NewWorker(pct.NewLogger(logChan, "qan-worker"), config, mock.NewNullMySQL())
+	w.ZeroRunTime = true
+
+	t0 := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	interval := func(n int) *iter.Interval {
+		return &iter.Interval{
+			Number:    n,
+			StartTime: t0.Add(time.Duration(n-1) * time.Minute),
+			StopTime:  t0.Add(time.Duration(n) * time.Minute),
+		}
+	}
+
+	// Rows are read from the start of the first interval.
+	rows := []TableRow{
+		{StartTime: float64(t0.Unix() + 1), QueryTime: 1, SQLText: "SELECT * FROM t WHERE id=1"},
+		{StartTime: float64(t0.Unix() + 2), QueryTime: 2, SQLText: "SELECT * FROM t WHERE id=2"},
+	}
+	p := &tableParser{eventChan: make(chan *log.Event)}
+	for _, row := range rows {
+		p.events = append(p.events, row.Event())
+	}
+	require.NoError(t, w.Setup(interval(1)))
+	assert.Equal(t, "mysql.slow_log 2017-07-14T02:40:00Z-2017-07-14T02:41:00Z", w.job.String())
+	w.SetLogParser(p)
+	res, err := w.Run()
+	require.NoError(t, err)
+	require.Len(t, res.Class, 1)
+	assert.Equal(t, uint(2), res.Class[0].TotalQueries)
+	assert.Equal(t, event.Float64(3), event.Float64(res.Class[0].Metrics.TimeMetrics["Query_time"].Sum))
+	assert.Equal(t, int64(0), res.StopOffset)
+
+	// Interval 3 follows interval 1 if interval 2 was lost: rows since the
+	// end of interval 1 are read.
+	require.NoError(t, w.Setup(interval(3)))
+	assert.Equal(t, t0.Add(time.Minute), w.job.Since)
+	assert.Equal(t, t0.Add(3*time.Minute), w.job.Until)
+}
@@ -29,16 +29,16 @@ import (
 	parser "github.com/percona/go-mysql/log/slow"
 	"github.com/percona/go-mysql/query"
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/config"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 type WorkerFactory interface {
-	Make(name string, config pc.QAN, mysqlConn mysql.Connector) *Worker
+	Make(name string, config qc.QAN, mysqlConn mysql.Connector) *Worker
 }
 
 type RealWorkerFactory struct {
@@ -52,7 +52,7 @@ func NewRealWorkerFactory(logChan chan proto.LogEntry) *RealWorkerFactory {
 	return f
 }
 
-func (f *RealWorkerFactory) Make(name string, config pc.QAN, mysqlConn mysql.Connector) *Worker {
+func (f *RealWorkerFactory) Make(name string, config qc.QAN, mysqlConn mysql.Connector) *Worker {
 	return NewWorker(pct.NewLogger(f.logChan, name), config, mysqlConn)
 }
 
@@ -66,15 +66,26 @@ type Job struct {
 	EndOffset      int64
 	ExampleQueries bool
 	RetainSlowLogs int
+	// mysql.slow_log, if config.SlowLogTable
+	Table bool
+	Since time.Time
+	Until time.Time
 }
 
 func (j *Job) String() string {
+	if j.Table {
+		return fmt.Sprintf("mysql.slow_log %s-%s", j.Since.Format(time.RFC3339), j.Until.Format(time.RFC3339))
+	}
 	return fmt.Sprintf("%s %d-%d", j.SlowLogFile, j.StartOffset, j.EndOffset)
 }
 
+// A LogParserFunc makes the parser of a log file other than the slow log,
+// like the general log.
+type LogParserFunc func(file *os.File, opts log.Options) log.LogParser
+
 type Worker struct {
 	logger    *pct.Logger
-	config    pc.QAN
+	config    qc.QAN
 	mysqlConn mysql.Connector
 	// --
 	ZeroRunTime bool // testing
@@ -90,11 +101,14 @@ type Worker struct {
 	sync            *pct.SyncChan
 	running         bool
 	logParser       log.LogParser
+	logParserFunc   LogParserFunc
+	flushLogs       string // reopens the log file after rotation
 	utcOffset       time.Duration
 	outlierTime     float64
+	tableUntil      time.Time // mysql.slow_log read up to this start_time
 }
 
-func NewWorker(logger *pct.Logger, config pc.QAN, mysqlConn mysql.Connector) *Worker {
+func NewWorker(logger *pct.Logger, config qc.QAN, mysqlConn mysql.Connector) *Worker {
 	// By default replace numbers in words with ?
 	query.ReplaceNumbersInWords = true
 
@@ -132,6 +146,7 @@ func NewWorker(logger *pct.Logger, config pc.QAN, mysqlConn mysql.Connector) *Wo
 		sync:            pct.NewSyncChan(),
 		utcOffset:       utcOffset,
 		outlierTime:     outlierTime.Float64,
+		flushLogs:       "FLUSH NO_WRITE_TO_BINLOG SLOW LOGS",
 	}
 	return w
 }
@@ -141,8 +156,10 @@ func (w *Worker) Setup(interval *iter.Interval) error {
 	defer w.logger.Debug("Setup:return")
 	w.logger.Debug("Setup:", interval)
 
-	// Check if slow log rotation is enabled.
-	if boolValue(w.config.SlowLogRotation) {
+	// Check if slow log rotation is enabled. mysql.slow_log is not rotated:
+	// it's a CSV table which MySQL doesn't let us truncate while logging.
+	table := boolValue(w.config.SlowLogTable)
+	if !table && boolValue(w.config.SlowLogRotation) {
 		// Check if max slow log size was reached.
 		if interval.EndOffset >= w.config.MaxSlowLogSize {
 			w.logger.Info(fmt.Sprintf("Rotating slow log: %s >= %s",
@@ -166,6 +183,16 @@ func (w *Worker) Setup(interval *iter.Interval) error {
 		ExampleQueries: boolValue(w.config.ExampleQueries),
 		RetainSlowLogs: intValue(w.config.RetainSlowLogs),
 	}
+	if table {
+		// Continue from where the last job stopped so no row is read twice,
+		// or missed if an interval was skipped.
+		w.job.Table = true
+		w.job.Since = interval.StartTime
+		if !w.tableUntil.IsZero() && w.tableUntil.Before(interval.StartTime) {
+			w.job.Since = w.tableUntil
+		}
+		w.job.Until = interval.StopTime
+	}
 	w.logger.Debug("Setup:", w.job)
 
 	return nil
@@ -187,24 +214,37 @@ func (w *Worker) Run() (*report.Result, error) {
 		w.running = false
 	}()
 
-	// Open the slow log file. Be sure to close it else we'll leak fd.
-	file, err := os.Open(w.job.SlowLogFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
 	// Create a slow log parser and run it.  It sends log.Event via its channel.
 	// Be sure to stop it when done, else we'll leak goroutines.
 	result := &report.Result{}
-	opts := log.Options{
-		StartOffset: uint64(w.job.StartOffset),
-		FilterAdminCommand: map[string]bool{
-			"Binlog Dump":      true,
-			"Binlog Dump GTID": true,
-		},
+	var file *os.File
+	var p log.LogParser
+	utcOffset := w.utcOffset
+	if w.job.Table {
+		if err := w.mysqlConn.Connect(); err != nil {
+			return nil, err
+		}
+		defer w.mysqlConn.Close()
+		p = w.MakeTableParser(w.job.Since, w.job.Until)
+		utcOffset = 0 // TableParser event times are UTC
+	} else {
+		// Open the slow log file. Be sure to close it else we'll leak fd.
+		var err error
+		file, err = os.Open(w.job.SlowLogFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		opts := log.Options{
+			StartOffset: uint64(w.job.StartOffset),
+			FilterAdminCommand: map[string]bool{
+				"Binlog Dump":      true,
+				"Binlog Dump GTID": true,
+			},
+		}
+		p = w.MakeLogParser(file, opts)
 	}
-	p := w.MakeLogParser(file, opts)
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
@@ -222,7 +262,7 @@ func (w *Worker) Run() (*report.Result, error) {
 
 	// Make an event aggregate to do all the heavy lifting: fingerprint
 	// queries, group, and aggregate.
-	aggregator := event.NewAggregator(w.job.ExampleQueries, w.utcOffset, w.outlierTime)
+	aggregator := event.NewAggregator(w.job.ExampleQueries, utcOffset, w.outlierTime)
 
 	// Misc runtime meta data.
 	jobSize := w.job.EndOffset - w.job.StartOffset
@@ -239,12 +279,19 @@ func (w *Worker) Run() (*report.Result, error) {
 	defer func() { w.doneChan <- true }()
 
 	t0 := time.Now().UTC()
+	nEvents := 0
 EVENT_LOOP:
 	for event := range p.EventChan() {
 		runtime = time.Now().UTC().Sub(t0)
-		progress = fmt.Sprintf("%.1f%% %d/%d %d %.1fs",
-			float64(event.Offset)/float64(w.job.EndOffset)*100, event.Offset, w.job.EndOffset, jobSize, runtime.Seconds())
-		w.status.Update(w.name, fmt.Sprintf("Parsing %s: %s", w.job.SlowLogFile, progress))
+		nEvents++
+		if w.job.Table {
+			progress = fmt.Sprintf("%d events %.1fs", nEvents, runtime.Seconds())
+			w.status.Update(w.name, fmt.Sprintf("Reading mysql.slow_log: %s", progress))
+		} else {
+			progress = fmt.Sprintf("%.1f%% %d/%d %d %.1fs",
+				float64(event.Offset)/float64(w.job.EndOffset)*100, event.Offset, w.job.EndOffset, jobSize, runtime.Seconds())
+			w.status.Update(w.name, fmt.Sprintf("Parsing %s: %s", w.job.SlowLogFile, progress))
+		}
 
 		// Stop if Stop() called.
 		select {
@@ -268,7 +315,7 @@ EVENT_LOOP:
 		// so typical case is, for example, parsing from offset 100 to 5000
 		// but slow log is already 7000 bytes large and growing. So the first
 		// event with offset > 5000 marks the end (StopOffset) of this slice.
-		if int64(event.Offset) >= w.job.EndOffset {
+		if !w.job.Table && int64(event.Offset) >= w.job.EndOffset {
 			result.StopOffset = int64(event.Offset)
 			break EVENT_LOOP
 		}
@@ -310,9 +357,15 @@ EVENT_LOOP:
 	// or we rotated the slow log in Setup() so we're finishing the rotated slow
 	// log file. So the StopOffset is the end of the file which we're already
 	// at, so use SEEK_CUR.
-	if result.StopOffset == 0 {
+	if result.StopOffset == 0 && file != nil {
 		result.StopOffset, _ = file.Seek(0, os.SEEK_CUR)
 	}
+	// Like a slice of the slow log file, the job's rows are done even if parsing
+	// stopped early. If the job didn't run, e.g. MySQL was down, the next one
+	// reads them.
+	if w.job.Table {
+		w.tableUntil = w.job.Until
+	}
 
 	// Finalize the global and class metrics, i.e. calculate metric stats.
 	w.status.Update(w.name, "Finalizing job "+w.job.Id)
@@ -359,7 +412,7 @@ func (w *Worker) Status() map[string]string {
 	return w.status.All()
 }
 
-func (w *Worker) SetConfig(config pc.QAN) {
+func (w *Worker) SetConfig(config qc.QAN) {
 	w.config = config
 }
 
@@ -369,12 +422,32 @@ func (w *Worker) SetLogParser(p log.LogParser) {
 	w.logParser = p
 }
 
+// SetLogParserFunc makes the worker parse another log file format instead of
+// the slow log. The log file is rotated like the slow log, then reopened by
+// the flushLogs statement, e.g. FLUSH NO_WRITE_TO_BINLOG GENERAL LOGS.
+func (w *Worker) SetLogParserFunc(f LogParserFunc, flushLogs string) {
+	w.logParserFunc = f
+	w.flushLogs = flushLogs
+}
+
+func (w *Worker) MakeTableParser(since, until time.Time) log.LogParser {
+	if w.logParser != nil {
+		p := w.logParser
+		w.logParser = nil
+		return p
+	}
+	return NewTableParser(w.mysqlConn, since, until)
+}
+
 func (w *Worker) MakeLogParser(file *os.File, opts log.Options) log.LogParser {
 	if w.logParser != nil {
 		p := w.logParser
 		w.logParser = nil
 		return p
 	}
+	if w.logParserFunc != nil {
+		return w.logParserFunc(file, opts)
+	}
 	return parser.NewSlowLogParser(file, opts)
 }
 
@@ -427,7 +500,7 @@ func (w *Worker) rotateSlowLog(interval *iter.Interval) error {
 		return err
 	}
 
-	if err := w.mysqlConn.Exec([]string{"FLUSH NO_WRITE_TO_BINLOG SLOW LOGS"}); err != nil {
+	if err := w.mysqlConn.Exec([]string{w.flushLogs}); err != nil {
 		// MySQL 5.1 support.
 		if err := w.mysqlConn.Exec([]string{"FLUSH LOGS"}); err != nil {
 			return err
@@ -0,0 +1,175 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	ethPAll  = 0x0003
+	ethPIP   = 0x0800
+	ethPIPv6 = 0x86dd
+
+	packetOutgoing = 4
+)
+
+// A Capture is a live capture on a network interface, or on all interfaces
+// if the name is "any". Packets are read as raw IPv4 or IPv6, without their
+// link layer header.
+type Capture struct {
+	fd       int
+	loopback map[int]bool // interface indexes
+	buf      []byte
+}
+
+// OpenCapture starts capturing TCP packets to and from the port on the
+// interface. It requires CAP_NET_RAW, usually root.
+func OpenCapture(iface string, port uint16) (*Capture, error) {
+	c := &Capture{
+		loopback: map[int]bool{},
+		buf:      make([]byte, 65536),
+	}
+	index := 0
+	if iface != "any" {
+		i, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		index = i.Index
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range ifaces {
+		if i.Flags&net.FlagLoopback != 0 {
+			c.loopback[i.Index] = true
+		}
+	}
+
+	// Protocol 0 receives nothing until bound, so no packet gets past the filter.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot capture on %s: %s", iface, err)
+	}
+	if err := syscall.AttachLsf(fd, portFilter(port)); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("cannot filter port %d on %s: %s", port, iface, err)
+	}
+	sa := &syscall.SockaddrLinklayer{
+		Protocol: htons(ethPAll),
+		Ifindex:  index,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("cannot capture on %s: %s", iface, err)
+	}
+	// Time out reads so ReadPacket returns io.EOF when there's no traffic.
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	c.fd = fd
+	return c, nil
+}
+
+func (c *Capture) ReadPacket() (Packet, error) {
+	for {
+		n, from, err := syscall.Recvfrom(c.fd, c.buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				return Packet{}, io.EOF
+			}
+			return Packet{}, err
+		}
+		ll, ok := from.(*syscall.SockaddrLinklayer)
+		if !ok {
+			continue
+		}
+		if ll.Protocol != htons(ethPIP) && ll.Protocol != htons(ethPIPv6) {
+			continue
+		}
+		// Loopback packets are seen going out and coming in.
+		if ll.Pkttype == packetOutgoing && c.loopback[ll.Ifindex] {
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, c.buf[:n])
+		pkt := Packet{
+			Ts:       time.Now().UTC(),
+			LinkType: LinkTypeRaw,
+			Data:     data,
+		}
+		return pkt, nil
+	}
+}
+
+func (c *Capture) Close() error {
+	return syscall.Close(c.fd)
+}
+
+// portFilter returns a classic BPF program which accepts only unfragmented
+// TCP packets from or to the port. SOCK_DGRAM packets start at the IP header.
+// IPv6 extension headers aren't followed: TCP must be the next header.
+func portFilter(port uint16) []syscall.SockFilter {
+	const (
+		accept = 19
+		drop   = 20
+	)
+	p := uint32(port)
+	// jump returns the relative offset from instruction i to target.
+	jump := func(i, target int) uint8 {
+		return uint8(target - i - 1)
+	}
+	return []syscall.SockFilter{
+		// IP version
+		/* 0 */ {Code: syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS, K: 0},
+		/* 1 */ {Code: syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K, K: 0xf0},
+		/* 2 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: 0x40, Jf: jump(2, 12)},
+		// IPv4: protocol, fragment offset, then ports after the IHL header
+		/* 3 */ {Code: syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS, K: 9},
+		/* 4 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: syscall.IPPROTO_TCP, Jf: jump(4, drop)},
+		/* 5 */ {Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_ABS, K: 6},
+		/* 6 */ {Code: syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K, K: 0x1fff, Jt: jump(6, drop)},
+		/* 7 */ {Code: syscall.BPF_LDX | syscall.BPF_B | syscall.BPF_MSH, K: 0},
+		/* 8 */ {Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_IND, K: 0},
+		/* 9 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: p, Jt: jump(9, accept)},
+		/* 10 */ {Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_IND, K: 2},
+		/* 11 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: p, Jt: jump(11, accept), Jf: jump(11, drop)},
+		// IPv6: next header, then ports after the fixed 40-byte header
+		/* 12 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: 0x60, Jf: jump(12, drop)},
+		/* 13 */ {Code: syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS, K: 6},
+		/* 14 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: syscall.IPPROTO_TCP, Jf: jump(14, drop)},
+		/* 15 */ {Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_ABS, K: 40},
+		/* 16 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: p, Jt: jump(16, accept)},
+		/* 17 */ {Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_ABS, K: 42},
+		/* 18 */ {Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: p, Jt: jump(18, accept), Jf: jump(18, drop)},
+		/* accept */ {Code: syscall.BPF_RET | syscall.BPF_K, K: 0x40000},
+		/* drop */ {Code: syscall.BPF_RET | syscall.BPF_K, K: 0},
+	}
+}
+
+func htons(n uint16) uint16 {
+	return n<<8 | n>>8
+}
@@ -0,0 +1,115 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runFilter runs the subset of classic BPF which portFilter uses.
+func runFilter(t *testing.T, prog []syscall.SockFilter, pkt []byte) uint32 {
+	var a, x uint32
+	load := func(off uint32, size uint32) uint32 {
+		require.True(t, int(off+size) <= len(pkt), "load out of packet")
+		if size == 1 {
+			return uint32(pkt[off])
+		}
+		return uint32(binary.BigEndian.Uint16(pkt[off:]))
+	}
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS:
+			a = load(ins.K, 1)
+		case syscall.BPF_LD | syscall.BPF_H | syscall.BPF_ABS:
+			a = load(ins.K, 2)
+		case syscall.BPF_LD | syscall.BPF_H | syscall.BPF_IND:
+			a = load(x+ins.K, 2)
+		case syscall.BPF_LDX | syscall.BPF_B | syscall.BPF_MSH:
+			x = 4 * (load(ins.K, 1) & 0xf)
+		case syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K:
+			a &= ins.K
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+			if a == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K:
+			if a&ins.K != 0 {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case syscall.BPF_RET | syscall.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unknown instruction %#x at %d", ins.Code, pc)
+		}
+	}
+	t.Fatal("no return")
+	return 0
+}
+
+func ipv4(proto byte, frag uint16, src, dst uint16) []byte {
+	pkt := make([]byte, 24+20)
+	pkt[0] = 0x46 // IHL 6: 4 bytes of options
+	pkt[9] = proto
+	binary.BigEndian.PutUint16(pkt[6:], frag)
+	binary.BigEndian.PutUint16(pkt[24:], src)
+	binary.BigEndian.PutUint16(pkt[26:], dst)
+	return pkt
+}
+
+func ipv6(next byte, src, dst uint16) []byte {
+	pkt := make([]byte, 40+20)
+	pkt[0] = 0x60
+	pkt[6] = next
+	binary.BigEndian.PutUint16(pkt[40:], src)
+	binary.BigEndian.PutUint16(pkt[42:], dst)
+	return pkt
+}
+
+func TestPortFilter(t *testing.T) {
+	prog := portFilter(3306)
+	tests := []struct {
+		name   string
+		pkt    []byte
+		accept bool
+	}{
+		{"IPv4 to port", ipv4(syscall.IPPROTO_TCP, 0, 40000, 3306), true},
+		{"IPv4 from port", ipv4(syscall.IPPROTO_TCP, 0x4000, 3306, 40000), true}, // DF
+		{"IPv4 other port", ipv4(syscall.IPPROTO_TCP, 0, 40000, 22), false},
+		{"IPv4 UDP", ipv4(syscall.IPPROTO_UDP, 0, 40000, 3306), false},
+		{"IPv4 fragment", ipv4(syscall.IPPROTO_TCP, 0x0010, 40000, 3306), false},
+		{"IPv6 to port", ipv6(syscall.IPPROTO_TCP, 40000, 3306), true},
+		{"IPv6 from port", ipv6(syscall.IPPROTO_TCP, 3306, 40000), true},
+		{"IPv6 other port", ipv6(syscall.IPPROTO_TCP, 22, 40000), false},
+		{"IPv6 UDP", ipv6(syscall.IPPROTO_UDP, 40000, 3306), false},
+		{"not IP", []byte{0x00, 0x00}, false},
+	}
+	for _, test := range tests {
+		accept := runFilter(t, prog, test.pkt) > 0
+		assert.Equal(t, test.accept, accept, test.name)
+	}
+}
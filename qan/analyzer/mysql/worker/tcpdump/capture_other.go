@@ -0,0 +1,41 @@
+//go:build !linux
+
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"errors"
+)
+
+// A Capture is a live capture on a network interface, which is only
+// supported on Linux.
+type Capture struct {
+}
+
+func OpenCapture(iface string, port uint16) (*Capture, error) {
+	return nil, errors.New("live capture is only supported on Linux")
+}
+
+func (c *Capture) ReadPacket() (Packet, error) {
+	return Packet{}, errors.New("live capture is only supported on Linux")
+}
+
+func (c *Capture) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const (
+	tcpFin = 0x01
+	tcpSyn = 0x02
+	tcpRst = 0x04
+)
+
+// An endpoint is an IP address and TCP port.
+type endpoint struct {
+	ip   string
+	port uint16
+}
+
+func (e endpoint) String() string {
+	return net.JoinHostPort(e.ip, strconv.Itoa(int(e.port)))
+}
+
+// A segment is a TCP segment.
+type segment struct {
+	src     endpoint
+	dst     endpoint
+	seq     uint32
+	flags   byte
+	payload []byte
+}
+
+// decodePacket returns the TCP segment in the packet, or nil if it's not TCP.
+func decodePacket(pkt Packet) (*segment, error) {
+	data := pkt.Data
+	var etherType uint16
+	switch pkt.LinkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil, fmt.Errorf("short ethernet frame")
+		}
+		etherType = binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// 802.1Q VLAN tags.
+		for etherType == 0x8100 && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, fmt.Errorf("short linux cooked frame")
+		}
+		etherType = binary.BigEndian.Uint16(data[14:])
+		data = data[16:]
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("short loopback frame")
+		}
+		// Address family in host byte order: 2 is IPv4, 24, 28 and 30 are IPv6.
+		family := binary.LittleEndian.Uint32(data)
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data)
+		}
+		etherType = 0x86dd
+		if family == 2 {
+			etherType = 0x0800
+		}
+		data = data[4:]
+	case LinkTypeRaw:
+		if len(data) < 1 {
+			return nil, fmt.Errorf("short raw frame")
+		}
+		etherType = 0x86dd
+		if data[0]>>4 == 4 {
+			etherType = 0x0800
+		}
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", pkt.LinkType)
+	}
+
+	var src, dst net.IP
+	switch etherType {
+	case 0x0800: // IPv4
+		if len(data) < 20 {
+			return nil, nil
+		}
+		ihl := int(data[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if data[9] != 6 || ihl < 20 || len(data) < ihl {
+			return nil, nil // not TCP
+		}
+		// Fragments other than the first aren't TCP segments we can use.
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return nil, nil
+		}
+		src, dst = net.IP(data[12:16]), net.IP(data[16:20])
+		if total >= ihl && total <= len(data) {
+			data = data[:total] // strip Ethernet padding
+		}
+		data = data[ihl:]
+	case 0x86dd: // IPv6, without extension headers
+		if len(data) < 40 || data[6] != 6 {
+			return nil, nil
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		src, dst = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40:]
+		if payloadLen <= len(data) {
+			data = data[:payloadLen]
+		}
+	default:
+		return nil, nil
+	}
+
+	if len(data) < 20 {
+		return nil, nil
+	}
+	off := int(data[12]>>4) * 4
+	if off < 20 || len(data) < off {
+		return nil, nil
+	}
+	seg := &segment{
+		src:     endpoint{src.String(), binary.BigEndian.Uint16(data[0:])},
+		dst:     endpoint{dst.String(), binary.BigEndian.Uint16(data[2:])},
+		seq:     binary.BigEndian.Uint32(data[4:]),
+		flags:   data[13],
+		payload: data[off:],
+	}
+	return seg, nil
+}
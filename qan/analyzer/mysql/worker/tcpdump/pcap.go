@@ -0,0 +1,178 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package tcpdump decodes MySQL queries and their response times from
+// captured network traffic, like pt-query-digest --type tcpdump.
+package tcpdump
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Link types, http://www.tcpdump.org/linktypes.html
+const (
+	LinkTypeNull     = 0   // BSD loopback
+	LinkTypeEthernet = 1   // also Linux loopback
+	LinkTypeRaw      = 101 // raw IPv4 or IPv6
+	LinkTypeLinuxSLL = 113 // tcpdump -i any
+)
+
+// A Packet is a captured link layer frame.
+type Packet struct {
+	Ts       time.Time
+	LinkType uint32
+	Data     []byte
+}
+
+// A PacketSource is a pcap file or a live capture.
+type PacketSource interface {
+	// ReadPacket returns the next packet, or io.EOF if there's none yet.
+	ReadPacket() (Packet, error)
+	Close() error
+}
+
+var ErrNotPcap = errors.New("not a pcap file")
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapHeaderLen  = 24
+	pcapRecordLen  = 16
+)
+
+// A PcapFile reads a file in the libpcap format written by tcpdump -w, which
+// can still be growing: an incomplete packet at the end of the file is read
+// once it's complete. The pcapng format is not supported.
+type PcapFile struct {
+	file *os.File
+	// --
+	order    binary.ByteOrder
+	nano     bool
+	snapLen  uint32
+	linkType uint32
+	offset   int64
+	header   [pcapRecordLen]byte
+}
+
+// OpenPcapFile opens the file and reads its header.
+func OpenPcapFile(name string) (*PcapFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	p := &PcapFile{
+		file: file,
+	}
+	if err := p.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PcapFile) readHeader() error {
+	var h [pcapHeaderLen]byte
+	if _, err := io.ReadFull(p.file, h[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrNotPcap
+		}
+		return err
+	}
+	switch {
+	case binary.LittleEndian.Uint32(h[0:]) == pcapMagicMicro:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(h[0:]) == pcapMagicMicro:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(h[0:]) == pcapMagicNano:
+		p.order, p.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(h[0:]) == pcapMagicNano:
+		p.order, p.nano = binary.BigEndian, true
+	default:
+		return ErrNotPcap
+	}
+	p.snapLen = p.order.Uint32(h[16:])
+	p.linkType = p.order.Uint32(h[20:])
+	p.offset = pcapHeaderLen
+	return nil
+}
+
+// LinkType returns the link type of all packets in the file.
+func (p *PcapFile) LinkType() uint32 {
+	return p.linkType
+}
+
+// Offset returns the offset after the last packet read.
+func (p *PcapFile) Offset() int64 {
+	return p.offset
+}
+
+func (p *PcapFile) ReadPacket() (Packet, error) {
+	n, err := p.file.ReadAt(p.header[:], p.offset)
+	if n < pcapRecordLen {
+		if err == nil || err == io.EOF {
+			err = io.EOF
+		}
+		return Packet{}, err
+	}
+	sec := p.order.Uint32(p.header[0:])
+	frac := p.order.Uint32(p.header[4:])
+	capLen := p.order.Uint32(p.header[8:])
+	if (p.snapLen > 0 && capLen > p.snapLen) || capLen > 256*1024 {
+		return Packet{}, fmt.Errorf("%s: invalid packet length %d at offset %d", p.file.Name(), capLen, p.offset)
+	}
+	data := make([]byte, capLen)
+	n, err = p.file.ReadAt(data, p.offset+pcapRecordLen)
+	if n < int(capLen) {
+		if err == nil || err == io.EOF {
+			err = io.EOF
+		}
+		return Packet{}, err
+	}
+	p.offset += pcapRecordLen + int64(capLen)
+	if !p.nano {
+		frac *= 1000
+	}
+	pkt := Packet{
+		Ts:       time.Unix(int64(sec), int64(frac)).UTC(),
+		LinkType: p.linkType,
+		Data:     data,
+	}
+	return pkt, nil
+}
+
+// Size returns the current size of the file and whether it's still the file
+// at its path, i.e. not rotated or removed.
+func (p *PcapFile) Size() (int64, bool, error) {
+	fi, err := p.file.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	cur, err := os.Stat(p.file.Name())
+	if err != nil {
+		return fi.Size(), false, nil
+	}
+	return fi.Size(), os.SameFile(fi, cur), nil
+}
+
+func (p *PcapFile) Close() error {
+	return p.file.Close()
+}
@@ -0,0 +1,242 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// builder writes a little-endian, microsecond pcap file of Ethernet, IPv4
+// and TCP packets like tcpdump -w. Checksums are zeros.
+type builder struct {
+	buf bytes.Buffer
+}
+
+func newBuilder() *builder {
+	b := &builder{}
+	h := make([]byte, pcapHeaderLen)
+	binary.LittleEndian.PutUint32(h[0:], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], 65535)
+	binary.LittleEndian.PutUint32(h[20:], LinkTypeEthernet)
+	b.buf.Write(h)
+	return b
+}
+
+func (b *builder) packet(ts time.Time, data []byte) {
+	h := make([]byte, pcapRecordLen)
+	binary.LittleEndian.PutUint32(h[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(len(data)))
+	b.buf.Write(h)
+	b.buf.Write(data)
+}
+
+func (b *builder) tcp(ts time.Time, src, dst endpoint, seq uint32, flags byte, payload []byte) {
+	frame := make([]byte, 14+20+20, 14+20+20+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+20+len(payload)))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], net.ParseIP(src.ip).To4())
+	copy(ip[16:], net.ParseIP(dst.ip).To4())
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags | 0x10 // ACK
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	b.packet(ts, append(frame, payload...))
+}
+
+// A session writes the packets of a client connection.
+type session struct {
+	b      *builder
+	ts     time.Time
+	client endpoint
+	server endpoint
+	cseq   uint32
+	sseq   uint32
+}
+
+func (b *builder) session(ts time.Time, clientPort uint16) *session {
+	s := &session{
+		b:      b,
+		ts:     ts,
+		client: endpoint{"10.0.0.2", clientPort},
+		server: endpoint{"10.0.0.1", 3306},
+		cseq:   1000,
+		sseq:   5000,
+	}
+	return s
+}
+
+func (s *session) connect() {
+	s.b.tcp(s.ts, s.client, s.server, s.cseq, tcpSyn, nil)
+	s.cseq++
+	s.b.tcp(s.ts, s.server, s.client, s.sseq, tcpSyn, nil)
+	s.sseq++
+}
+
+func (s *session) send(d time.Duration, pkts ...[]byte) {
+	s.ts = s.ts.Add(d)
+	payload := bytes.Join(pkts, nil)
+	s.b.tcp(s.ts, s.client, s.server, s.cseq, 0x08, payload)
+	s.cseq += uint32(len(payload))
+}
+
+func (s *session) recv(d time.Duration, pkts ...[]byte) {
+	s.ts = s.ts.Add(d)
+	payload := bytes.Join(pkts, nil)
+	s.b.tcp(s.ts, s.server, s.client, s.sseq, 0x08, payload)
+	s.sseq += uint32(len(payload))
+}
+
+func (s *session) close() {
+	s.b.tcp(s.ts, s.client, s.server, s.cseq, tcpFin, nil)
+}
+
+// login writes the handshake of user root with database test.
+func (s *session) login(caps uint32) {
+	s.connect()
+	s.recv(0, mp(0, []byte("\x0a5.7.20\x00")))
+	resp := make([]byte, 32)
+	binary.LittleEndian.PutUint32(resp, caps)
+	resp = append(resp, "root\x00"...)
+	resp = append(resp, 20)
+	resp = append(resp, make([]byte, 20)...)
+	resp = append(resp, "test\x00mysql_native_password\x00"...)
+	s.send(0, mp(1, resp))
+	s.recv(0, mp(2, ok(0, 0x0002)))
+}
+
+// mp returns a MySQL packet.
+func mp(seq byte, payload []byte) []byte {
+	h := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+	return append(h, payload...)
+}
+
+func ok(affected byte, status uint16) []byte {
+	return []byte{0x00, affected, 0, byte(status), byte(status >> 8), 0, 0}
+}
+
+func eof(status uint16) []byte {
+	return []byte{0xfe, 0, 0, byte(status), byte(status >> 8)}
+}
+
+func query(q string) []byte {
+	return append([]byte{comQuery}, q...)
+}
+
+var errPacket = []byte("\xff\x28\x04#42000You have an error")
+
+func writeFile(t *testing.T, dir string, b *builder) string {
+	file := filepath.Join(dir, "mysql.pcap")
+	require.NoError(t, ioutil.WriteFile(file, b.buf.Bytes(), 0644))
+	return file
+}
+
+// decodeFile returns the events in the pcap file.
+func decodeFile(t *testing.T, file string) []*log.Event {
+	p, err := OpenPcapFile(file)
+	require.NoError(t, err)
+	defer p.Close()
+	d := NewDecoder(DefaultPort)
+	events := []*log.Event{}
+	for {
+		pkt, err := p.ReadPacket()
+		if err == io.EOF {
+			return events
+		}
+		require.NoError(t, err)
+		seg, err := decodePacket(pkt)
+		require.NoError(t, err)
+		if seg != nil {
+			events = append(events, d.Decode(pkt.Ts, seg)...)
+		}
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func TestPcapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-tcpdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2017, 7, 1, 12, 0, 0, 123456000, time.UTC)
+	b := newBuilder()
+	s := b.session(ts, 40000)
+	s.send(0, mp(0, query("SELECT 1")))
+	data := b.buf.Bytes()
+
+	// The packet is incomplete until it's all written.
+	file := filepath.Join(dir, "mysql.pcap")
+	require.NoError(t, ioutil.WriteFile(file, data[:len(data)-3], 0644))
+	p, err := OpenPcapFile(file)
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, uint32(LinkTypeEthernet), p.LinkType())
+	_, err = p.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(pcapHeaderLen), p.Offset())
+
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+	pkt, err := p.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, ts, pkt.Ts)
+	assert.Equal(t, int64(len(data)), p.Offset())
+	size, same, err := p.Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.True(t, same)
+
+	seg, err := decodePacket(pkt)
+	require.NoError(t, err)
+	require.NotNil(t, seg)
+	assert.Equal(t, "10.0.0.2:40000", seg.src.String())
+	assert.Equal(t, "10.0.0.1:3306", seg.dst.String())
+	assert.Equal(t, uint32(1000), seg.seq)
+	assert.Equal(t, mp(0, query("SELECT 1")), seg.payload)
+
+	_, err = p.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+
+	// Not a pcap file.
+	require.NoError(t, ioutil.WriteFile(file, []byte("# Time: 2017-07-01T12:00:00\n"), 0644))
+	_, err = OpenPcapFile(file)
+	assert.Equal(t, ErrNotPcap, err)
+}
@@ -0,0 +1,559 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// Client capability flags, https://dev.mysql.com/doc/internals/en/capability-flags.html
+const (
+	clientConnectWithDb    = 0x00000008
+	clientCompress         = 0x00000020
+	clientProtocol41       = 0x00000200
+	clientSSL              = 0x00000800
+	clientSecureConnection = 0x00008000
+	clientPluginAuthLenenc = 0x00200000
+	clientDeprecateEOF     = 0x01000000
+)
+
+// Commands, https://dev.mysql.com/doc/internals/en/text-protocol.html
+const (
+	comQuit             = 0x01
+	comInitDB           = 0x02
+	comQuery            = 0x03
+	comStmtPrepare      = 0x16
+	comStmtExecute      = 0x17
+	comStmtSendLongData = 0x18
+	comStmtClose        = 0x19
+)
+
+const (
+	serverMoreResultsExists = 0x0008
+	maxPacketLen            = 0xffffff
+	maxQueryLen             = 1024 * 1024 // longer queries are truncated
+	maxBufferLen            = 32 * 1024 * 1024
+)
+
+// Connection states.
+const (
+	stateUnsynced  = iota // capture started mid-connection or lost data
+	stateGreeting         // waiting for the server greeting
+	stateHandshake        // waiting for the client handshake response
+	stateAuth             // waiting for the server OK
+	stateReady            // waiting for or running a command
+)
+
+// Response phases.
+const (
+	phaseFirst      = iota // OK, ERR or column count
+	phaseColumns           // column definitions
+	phaseColumnsEnd        // EOF after column definitions, unless deprecated
+	phaseRows              // rows until EOF or OK
+	phasePrepare           // parameter and column definitions of a prepare
+)
+
+// A Decoder decodes MySQL queries and their responses from the TCP segments
+// to and from a server port. Query_time is from the first packet of the
+// command to the last packet of its response, as seen where it's captured.
+type Decoder struct {
+	port  uint16
+	conns map[endpoint]*conn // keyed on client
+}
+
+func NewDecoder(port uint16) *Decoder {
+	d := &Decoder{
+		port:  port,
+		conns: map[endpoint]*conn{},
+	}
+	return d
+}
+
+type stream struct {
+	synced bool
+	next   uint32
+	buf    []byte
+	large  bool // last packet was maxPacketLen: the next one continues it
+}
+
+type conn struct {
+	client       endpoint
+	state        int
+	ignore       bool // SSL or compressed
+	user         string
+	db           string
+	deprecateEOF int8   // 0 unknown, 1 yes, -1 no
+	in           stream // from client
+	out          stream // from server
+	stmts        map[uint32]string
+	req          *request
+}
+
+type request struct {
+	ts       time.Time
+	cmd      byte
+	query    string
+	phase    int
+	columns  uint64
+	n        uint64 // column definitions or prepare definitions read
+	rows     uint64
+	affected uint64
+	failed   bool
+}
+
+// Conns returns the number of connections being decoded.
+func (d *Decoder) Conns() int {
+	return len(d.conns)
+}
+
+// Decode decodes the segment and returns the queries completed by it.
+func (d *Decoder) Decode(ts time.Time, seg *segment) []*log.Event {
+	fromClient := seg.dst.port == d.port
+	if !fromClient && seg.src.port != d.port {
+		return nil
+	}
+	client := seg.src
+	if !fromClient {
+		client = seg.dst
+	}
+	c, ok := d.conns[client]
+	if !ok {
+		c = &conn{
+			client: client,
+			stmts:  map[uint32]string{},
+		}
+		d.conns[client] = c
+	}
+
+	var events []*log.Event
+	s := &c.out
+	if fromClient {
+		s = &c.in
+	}
+	switch {
+	case seg.flags&tcpSyn != 0:
+		// New connection: everything is seen from the start.
+		s.synced, s.next, s.buf = true, seg.seq+1, nil
+		if fromClient {
+			*c = conn{client: client, stmts: map[uint32]string{}, state: stateGreeting}
+			c.in.synced, c.in.next = true, seg.seq+1
+		}
+	case len(seg.payload) > 0 && !c.ignore:
+		events = d.data(ts, c, s, fromClient, seg)
+	}
+	if seg.flags&(tcpFin|tcpRst) != 0 {
+		delete(d.conns, client)
+	}
+	return events
+}
+
+func (d *Decoder) data(ts time.Time, c *conn, s *stream, fromClient bool, seg *segment) []*log.Event {
+	payload := seg.payload
+	if !s.synced {
+		if fromClient && c.state == stateUnsynced {
+			// Resync on a command which is one whole packet in one segment.
+			if len(payload) < 5 || payload[3] != 0 || int(uint32(payload[0])|uint32(payload[1])<<8|uint32(payload[2])<<16) != len(payload)-4 {
+				return nil
+			}
+			c.state = stateReady
+		} else if fromClient || c.req == nil {
+			return nil
+		}
+		// A response starts at a segment boundary.
+		s.synced, s.next, s.buf, s.large = true, seg.seq, nil, false
+	}
+
+	diff := int32(seg.seq - s.next)
+	if diff < 0 {
+		// Retransmission: skip what was seen.
+		if int(-diff) >= len(payload) {
+			return nil
+		}
+		payload = payload[-diff:]
+	} else if diff > 0 {
+		// Lost data, so packet boundaries are unknown.
+		d.unsync(c)
+		return nil
+	}
+	s.next += uint32(len(payload))
+	s.buf = append(s.buf, payload...)
+	if len(s.buf) > maxBufferLen {
+		d.unsync(c)
+		return nil
+	}
+
+	var events []*log.Event
+	for len(s.buf) >= 4 {
+		n := int(uint32(s.buf[0]) | uint32(s.buf[1])<<8 | uint32(s.buf[2])<<16)
+		if len(s.buf) < 4+n {
+			break
+		}
+		seq := s.buf[3]
+		pkt := s.buf[4 : 4+n]
+		continued := s.large
+		s.large = n == maxPacketLen
+		if fromClient {
+			d.clientPacket(ts, c, seq, pkt, continued)
+		} else if e := d.serverPacket(ts, c, pkt, continued); e != nil {
+			events = append(events, e)
+		}
+		s.buf = s.buf[4+n:]
+		if c.ignore {
+			s.buf = nil
+			break
+		}
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil // don't keep the underlying array
+	}
+	return events
+}
+
+// unsync forgets the connection state which depends on packet boundaries.
+func (d *Decoder) unsync(c *conn) {
+	c.state = stateUnsynced
+	c.req = nil
+	c.in = stream{}
+	c.out = stream{}
+}
+
+func (d *Decoder) clientPacket(ts time.Time, c *conn, seq byte, pkt []byte, continued bool) {
+	if continued {
+		if c.req != nil && c.req.query != "" && len(c.req.query) < maxQueryLen {
+			c.req.query += string(pkt)
+		}
+		return
+	}
+	switch c.state {
+	case stateHandshake:
+		d.handshakeResponse(c, pkt)
+		return
+	case stateReady:
+	default:
+		return
+	}
+	// Commands start a new sequence, other packets are auth or LOAD DATA
+	// LOCAL INFILE data.
+	if seq != 0 || len(pkt) == 0 {
+		return
+	}
+	req := &request{
+		ts:  ts,
+		cmd: pkt[0],
+	}
+	c.req = req
+	c.out.synced = false // the response starts with the next segment
+	switch pkt[0] {
+	case comQuery:
+		req.query = string(pkt[1:])
+		if q := strings.TrimSpace(req.query); len(q) > 4 && strings.EqualFold(q[:4], "use ") {
+			c.db = strings.Trim(strings.TrimSpace(q[4:]), "`;")
+		}
+	case comStmtPrepare:
+		req.query = string(pkt[1:])
+	case comStmtExecute:
+		if len(pkt) >= 5 {
+			req.query = c.stmts[binary.LittleEndian.Uint32(pkt[1:])]
+		}
+	case comInitDB:
+		c.db = string(pkt[1:])
+	case comStmtClose:
+		if len(pkt) >= 5 {
+			delete(c.stmts, binary.LittleEndian.Uint32(pkt[1:]))
+		}
+		c.req = nil // no response
+	case comStmtSendLongData, comQuit:
+		c.req = nil // no response
+	}
+}
+
+func (d *Decoder) handshakeResponse(c *conn, pkt []byte) {
+	c.state = stateAuth
+	if len(pkt) < 4 {
+		return
+	}
+	caps := uint32(binary.LittleEndian.Uint16(pkt))
+	if caps&clientProtocol41 == 0 {
+		return // pre-4.1, not worth decoding
+	}
+	caps = binary.LittleEndian.Uint32(pkt)
+	if caps&(clientSSL|clientCompress) != 0 {
+		c.ignore = true
+		return
+	}
+	if caps&clientDeprecateEOF != 0 {
+		c.deprecateEOF = 1
+	} else {
+		c.deprecateEOF = -1
+	}
+	// capabilities(4) max_packet(4) charset(1) reserved(23) user NUL
+	if len(pkt) < 33 {
+		return
+	}
+	b := pkt[32:]
+	i := indexNul(b)
+	if i < 0 {
+		return
+	}
+	c.user = string(b[:i])
+	b = b[i+1:]
+	// auth response
+	switch {
+	case caps&clientPluginAuthLenenc != 0:
+		n, rest, ok := lenencInt(b)
+		if !ok || uint64(len(rest)) < n {
+			return
+		}
+		b = rest[n:]
+	case caps&clientSecureConnection != 0:
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return
+		}
+		b = b[1+int(b[0]):]
+	default:
+		if i = indexNul(b); i < 0 {
+			return
+		}
+		b = b[i+1:]
+	}
+	if caps&clientConnectWithDb != 0 {
+		if i = indexNul(b); i >= 0 {
+			c.db = string(b[:i])
+		}
+	}
+}
+
+func (d *Decoder) serverPacket(ts time.Time, c *conn, pkt []byte, continued bool) *log.Event {
+	if len(pkt) == 0 {
+		return nil
+	}
+	switch c.state {
+	case stateGreeting:
+		if pkt[0] == 0x0a {
+			c.state = stateHandshake
+		} else {
+			c.ignore = true // error, or not MySQL
+		}
+		return nil
+	case stateAuth:
+		switch pkt[0] {
+		case 0x00:
+			c.state = stateReady
+		case 0xff:
+			c.ignore = true
+		}
+		return nil
+	case stateReady:
+	default:
+		return nil
+	}
+
+	req := c.req
+	if req == nil || continued {
+		return nil
+	}
+
+	switch req.phase {
+	case phaseFirst:
+		switch pkt[0] {
+		case 0x00:
+			if req.cmd == comStmtPrepare {
+				// stmt_id(4) num_columns(2) num_params(2)
+				if len(pkt) < 9 {
+					return d.done(ts, c)
+				}
+				c.stmts[binary.LittleEndian.Uint32(pkt[1:])] = req.query
+				req.columns = uint64(binary.LittleEndian.Uint16(pkt[5:])) + uint64(binary.LittleEndian.Uint16(pkt[7:]))
+				if req.columns == 0 {
+					return d.done(ts, c)
+				}
+				req.phase = phasePrepare
+				return nil
+			}
+			affected, status := parseOK(pkt)
+			req.affected += affected
+			return d.more(ts, c, status)
+		case 0xff:
+			req.failed = true
+			return d.done(ts, c)
+		case 0xfb:
+			return nil // LOCAL INFILE request, the OK follows the data
+		}
+		n, _, ok := lenencInt(pkt)
+		if !ok || n == 0 {
+			return d.done(ts, c)
+		}
+		req.columns, req.n = n, 0
+		req.phase = phaseColumns
+	case phaseColumns:
+		req.n++
+		if req.n == req.columns {
+			req.phase = phaseColumnsEnd
+			if c.deprecateEOF == 1 {
+				req.phase = phaseRows
+			}
+		}
+	case phaseColumnsEnd:
+		req.phase = phaseRows
+		if isEOF(pkt) {
+			c.deprecateEOF = -1
+			return nil
+		}
+		// No EOF after the columns: this is the first row.
+		c.deprecateEOF = 1
+		return d.row(ts, c, pkt)
+	case phaseRows:
+		return d.row(ts, c, pkt)
+	case phasePrepare:
+		if !isEOF(pkt) {
+			req.n++
+		}
+		if req.n >= req.columns {
+			// The EOF after the last definition, if any, arrives when
+			// there's no request, so it's ignored.
+			return d.done(ts, c)
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) row(ts time.Time, c *conn, pkt []byte) *log.Event {
+	req := c.req
+	switch {
+	case pkt[0] == 0xff:
+		req.failed = true
+		return d.done(ts, c)
+	case pkt[0] == 0xfe && c.deprecateEOF != 1 && isEOF(pkt):
+		// EOF: warnings(2) status(2)
+		status := uint16(0)
+		if len(pkt) >= 5 {
+			status = binary.LittleEndian.Uint16(pkt[3:])
+		}
+		return d.more(ts, c, status)
+	case pkt[0] == 0xfe && c.deprecateEOF == 1 && isOKNotRow(pkt):
+		affected, status := parseOK(pkt)
+		req.affected += affected
+		return d.more(ts, c, status)
+	}
+	req.rows++
+	return nil
+}
+
+// more continues with the next result set if the server says there's more,
+// else the request is done.
+func (d *Decoder) more(ts time.Time, c *conn, status uint16) *log.Event {
+	if status&serverMoreResultsExists != 0 {
+		c.req.phase = phaseFirst
+		return nil
+	}
+	return d.done(ts, c)
+}
+
+// done ends the request and returns its event, if it's a query.
+func (d *Decoder) done(ts time.Time, c *conn) *log.Event {
+	req := c.req
+	c.req = nil
+	if req.query == "" || (req.cmd != comQuery && req.cmd != comStmtExecute) {
+		return nil
+	}
+	e := log.NewEvent()
+	e.Ts = req.ts
+	e.Query = req.query
+	if len(e.Query) > maxQueryLen {
+		e.Query = e.Query[:maxQueryLen]
+	}
+	e.User = c.user
+	e.Host = c.client.ip
+	e.Db = c.db
+	e.TimeMetrics["Query_time"] = ts.Sub(req.ts).Seconds()
+	e.NumberMetrics["Rows_sent"] = req.rows
+	e.NumberMetrics["Rows_affected"] = req.affected
+	e.BoolMetrics["Error"] = req.failed
+	return e
+}
+
+// --------------------------------------------------------------------------
+
+// isEOF returns true if the packet is an EOF packet, which is short unlike
+// rows that start with 0xfe, an 8-byte length.
+func isEOF(pkt []byte) bool {
+	return len(pkt) > 0 && pkt[0] == 0xfe && len(pkt) < 9
+}
+
+// isOKNotRow returns true if a packet starting with 0xfe, when EOF packets
+// are deprecated, is the OK which ends a result set, not a row whose first
+// value is longer than 2^24 bytes.
+func isOKNotRow(pkt []byte) bool {
+	if len(pkt) < 9 {
+		return true
+	}
+	n := binary.LittleEndian.Uint64(pkt[1:])
+	return n > uint64(len(pkt)-9)
+}
+
+// parseOK returns the affected rows and status flags of an OK packet.
+func parseOK(pkt []byte) (uint64, uint16) {
+	affected, b, ok := lenencInt(pkt[1:])
+	if !ok {
+		return 0, 0
+	}
+	if _, b, ok = lenencInt(b); !ok || len(b) < 2 {
+		return affected, 0
+	}
+	return affected, binary.LittleEndian.Uint16(b)
+}
+
+func lenencInt(b []byte) (uint64, []byte, bool) {
+	if len(b) < 1 {
+		return 0, nil, false
+	}
+	var n int
+	switch b[0] {
+	case 0xfc:
+		n = 2
+	case 0xfd:
+		n = 3
+	case 0xfe:
+		n = 8
+	case 0xfb, 0xff:
+		return 0, nil, false
+	default:
+		return uint64(b[0]), b[1:], true
+	}
+	if len(b) < n+1 {
+		return 0, nil, false
+	}
+	v := uint64(0)
+	for i := n; i >= 1; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, b[n+1:], true
+}
+
+func indexNul(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,173 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const caps = clientProtocol41 | clientSecureConnection | clientConnectWithDb
+
+func TestDecodeQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-tcpdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	b := newBuilder()
+	s := b.session(ts, 40000)
+	s.login(caps)
+
+	// Result set in two segments.
+	s.send(time.Second, mp(0, query("SELECT c FROM t WHERE id > 1")))
+	s.recv(time.Millisecond, mp(1, []byte{1}), mp(2, []byte("\x03def")), mp(3, eof(0x0002)))
+	s.recv(time.Millisecond, mp(4, []byte("\x01a")), mp(5, []byte("\x01b")), mp(6, eof(0x0002)))
+
+	s.send(time.Second, mp(0, append([]byte{comInitDB}, "db2"...)))
+	s.recv(time.Millisecond, mp(1, ok(0, 0x0002)))
+
+	s.send(time.Second, mp(0, query("INSERT INTO t VALUES (1)")))
+	s.recv(3*time.Millisecond, mp(1, ok(1, 0x0002)))
+
+	s.send(time.Second, mp(0, query("SELECT bad")))
+	s.recv(time.Millisecond, mp(1, errPacket))
+
+	// Multiple result sets.
+	s.send(time.Second, mp(0, query("CALL p()")))
+	s.recv(time.Millisecond, mp(1, []byte{1}), mp(2, []byte("\x03def")), mp(3, eof(0x0002)), mp(4, []byte("\x011")), mp(5, eof(0x000a)))
+	s.recv(time.Millisecond, mp(6, ok(0, 0x0002)))
+	s.close()
+
+	events := decodeFile(t, writeFile(t, dir, b))
+	require.Len(t, events, 4)
+
+	e := events[0]
+	assert.Equal(t, "SELECT c FROM t WHERE id > 1", e.Query)
+	assert.Equal(t, "root", e.User)
+	assert.Equal(t, "10.0.0.2", e.Host)
+	assert.Equal(t, "test", e.Db)
+	assert.Equal(t, ts.Add(time.Second), e.Ts)
+	assert.InDelta(t, 0.002, e.TimeMetrics["Query_time"], 0.000001)
+	assert.Equal(t, uint64(2), e.NumberMetrics["Rows_sent"])
+	assert.Equal(t, false, e.BoolMetrics["Error"])
+
+	e = events[1]
+	assert.Equal(t, "INSERT INTO t VALUES (1)", e.Query)
+	assert.Equal(t, "db2", e.Db)
+	assert.InDelta(t, 0.003, e.TimeMetrics["Query_time"], 0.000001)
+	assert.Equal(t, uint64(1), e.NumberMetrics["Rows_affected"])
+
+	e = events[2]
+	assert.Equal(t, "SELECT bad", e.Query)
+	assert.Equal(t, true, e.BoolMetrics["Error"])
+
+	e = events[3]
+	assert.Equal(t, "CALL p()", e.Query)
+	assert.InDelta(t, 0.002, e.TimeMetrics["Query_time"], 0.000001)
+	assert.Equal(t, uint64(1), e.NumberMetrics["Rows_sent"])
+}
+
+func TestDecodePreparedDeprecateEOF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-tcpdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	b := newBuilder()
+	s := b.session(ts, 40001)
+	s.login(caps | clientDeprecateEOF)
+
+	// stmt_id 7, 1 column, 1 parameter, then their definitions.
+	prepareOK := []byte{0x00, 7, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0}
+	s.send(time.Second, mp(0, append([]byte{comStmtPrepare}, "SELECT c FROM t WHERE id = ?"...)))
+	s.recv(time.Millisecond, mp(1, prepareOK), mp(2, []byte("\x03def")), mp(3, []byte("\x03def")))
+
+	execute := make([]byte, 5)
+	execute[0] = comStmtExecute
+	binary.LittleEndian.PutUint32(execute[1:], 7)
+	for i := 0; i < 2; i++ {
+		s.send(time.Second, mp(0, execute))
+		s.recv(time.Millisecond, mp(1, []byte{1}), mp(2, []byte("\x03def")), mp(3, []byte("\x00\x00\x01")))
+		s.recv(time.Millisecond, mp(4, []byte{0xfe, 0, 0, 2, 0, 0, 0}))
+	}
+
+	// Unknown statement.
+	binary.LittleEndian.PutUint32(execute[1:], 8)
+	s.send(time.Second, mp(0, execute))
+	s.recv(time.Millisecond, mp(1, errPacket))
+
+	events := decodeFile(t, writeFile(t, dir, b))
+	require.Len(t, events, 2)
+	for _, e := range events {
+		assert.Equal(t, "SELECT c FROM t WHERE id = ?", e.Query)
+		assert.InDelta(t, 0.002, e.TimeMetrics["Query_time"], 0.000001)
+		assert.Equal(t, uint64(1), e.NumberMetrics["Rows_sent"])
+	}
+}
+
+func TestDecodeMidStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-tcpdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Capture starts after the connection: the first response is partial
+	// and ignored, user and db are unknown.
+	ts := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	b := newBuilder()
+	s := b.session(ts, 40002)
+	s.recv(0, mp(5, []byte("\x011")), mp(6, eof(0x0002)))
+	s.send(time.Second, mp(0, query("SELECT 1")))
+	s.recv(time.Millisecond, mp(1, ok(0, 0x0002)))
+
+	// Lost segment: ignored until the next command.
+	s.cseq += 100
+	s.send(time.Second, mp(0, query("SELECT 2")))
+	s.recv(time.Millisecond, mp(1, ok(0, 0x0002)))
+	s.send(time.Second, mp(0, query("SELECT 3")))
+	s.recv(time.Millisecond, mp(1, ok(0, 0x0002)))
+
+	// Retransmission.
+	s.cseq -= uint32(len(mp(0, query("SELECT 3"))))
+	s.send(0, mp(0, query("SELECT 3")))
+
+	// Other ports are ignored.
+	s.server.port = 3307
+	s.send(time.Second, mp(0, query("SELECT 4")))
+	s.recv(time.Millisecond, mp(1, ok(0, 0x0002)))
+
+	events := decodeFile(t, writeFile(t, dir, b))
+	require.Len(t, events, 2)
+	assert.Equal(t, "SELECT 1", events[0].Query)
+	assert.Equal(t, "", events[0].User)
+	assert.Equal(t, "SELECT 3", events[1].Query)
+}
+
+func TestIsOKNotRow(t *testing.T) {
+	assert.True(t, isOKNotRow([]byte{0xfe, 0, 0, 2, 0, 0, 0}))
+	assert.True(t, isOKNotRow([]byte{0xfe, 0, 0, 2, 0, 0, 0, 'i', 'n', 'f', 'o'}))
+	row := make([]byte, 9+20)
+	row[0], row[1] = 0xfe, 20
+	assert.False(t, isOKNotRow(row))
+}
@@ -0,0 +1,323 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
+)
+
+const (
+	DefaultPort      = 3306
+	DefaultInterface = "any"
+)
+
+var (
+	// Wait this long for a pcap file to grow.
+	PollPeriod = 100 * time.Millisecond
+	// Wait this long to retry opening the packet source.
+	RetryPeriod = 10 * time.Second
+)
+
+type WorkerFactory interface {
+	Make(name string) *Worker
+}
+
+type RealWorkerFactory struct {
+	logChan chan proto.LogEntry
+}
+
+func NewRealWorkerFactory(logChan chan proto.LogEntry) *RealWorkerFactory {
+	f := &RealWorkerFactory{
+		logChan: logChan,
+	}
+	return f
+}
+
+func (f *RealWorkerFactory) Make(name string) *Worker {
+	return NewWorker(pct.NewLogger(f.logChan, name), OpenSource)
+}
+
+// OpenSourceFunc opens the packet source for the config.
+type OpenSourceFunc func(config qc.QAN) (PacketSource, error)
+
+// OpenSource opens the pcap file if the config has one, else it starts
+// capturing the config port on the config interface.
+func OpenSource(config qc.QAN) (PacketSource, error) {
+	if config.PcapFile != "" {
+		return OpenPcapFile(config.PcapFile)
+	}
+	iface := config.Interface
+	if iface == "" {
+		iface = DefaultInterface
+	}
+	port := uint16(config.Port)
+	if port == 0 {
+		port = DefaultPort
+	}
+	return OpenCapture(iface, port)
+}
+
+// --------------------------------------------------------------------------
+
+// A Worker reads packets in the background from the first interval on,
+// decodes the MySQL protocol and aggregates queries until the interval is
+// run. A pcap file is read from its start and followed like tail -F.
+type Worker struct {
+	logger     *pct.Logger
+	openSource OpenSourceFunc
+	// --
+	ZeroRunTime bool // testing
+	// --
+	name       string
+	status     *pct.Status
+	config     qc.QAN
+	lock       *sync.Mutex
+	aggregator *event.Aggregator
+	nQueries   int
+	conns      int
+	running    bool
+	stopChan   chan struct{}
+	doneChan   chan struct{}
+}
+
+func NewWorker(logger *pct.Logger, openSource OpenSourceFunc) *Worker {
+	name := logger.Service()
+	w := &Worker{
+		logger:     logger,
+		openSource: openSource,
+		// --
+		name: name,
+		status: pct.NewStatus([]string{
+			name,
+			name + "-last",
+			name + "-source",
+		}),
+		lock:     &sync.Mutex{},
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+	return w
+}
+
+func (w *Worker) Setup(interval *iter.Interval) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.running {
+		return nil
+	}
+	select {
+	case <-w.stopChan:
+		return nil
+	default:
+	}
+	w.running = true
+	w.aggregator = event.NewAggregator(boolValue(w.config.ExampleQueries), 0, 0)
+	go w.read(w.config)
+	return nil
+}
+
+func (w *Worker) Run() (*report.Result, error) {
+	w.logger.Debug("Run:call")
+	defer w.logger.Debug("Run:return")
+
+	t0 := time.Now().UTC()
+	w.lock.Lock()
+	aggregator := w.aggregator
+	nQueries, conns := w.nQueries, w.conns
+	w.aggregator = event.NewAggregator(boolValue(w.config.ExampleQueries), 0, 0)
+	w.nQueries = 0
+	w.lock.Unlock()
+
+	if aggregator == nil {
+		return nil, nil
+	}
+	r := aggregator.Finalize()
+	classes := make([]*event.Class, 0, len(r.Class))
+	for _, class := range r.Class {
+		classes = append(classes, class)
+	}
+	result := &report.Result{
+		Global: r.Global,
+		Class:  classes,
+	}
+	if !w.ZeroRunTime {
+		result.RunTime = time.Now().UTC().Sub(t0).Seconds()
+	}
+	w.status.Update(w.name+"-last", fmt.Sprintf("queries: %d, connections: %d", nQueries, conns))
+	return result, nil
+}
+
+func (w *Worker) Cleanup() error {
+	return nil
+}
+
+func (w *Worker) Stop() error {
+	w.lock.Lock()
+	select {
+	case <-w.stopChan:
+	default:
+		close(w.stopChan)
+	}
+	running := w.running
+	w.lock.Unlock()
+	if running {
+		<-w.doneChan
+	}
+	return nil
+}
+
+func (w *Worker) Status() map[string]string {
+	return w.status.All()
+}
+
+// SetConfig sets the config. The packet source is opened with the config
+// when the first interval is set up, so later changes require a restart.
+func (w *Worker) SetConfig(config qc.QAN) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.config = config
+}
+
+// --------------------------------------------------------------------------
+
+func (w *Worker) read(config qc.QAN) {
+	defer close(w.doneChan)
+	defer w.status.Update(w.name+"-source", "Stopped")
+
+	port := uint16(config.Port)
+	if port == 0 {
+		port = DefaultPort
+	}
+	var src PacketSource
+	var dec *Decoder
+	defer func() {
+		if src != nil {
+			src.Close()
+		}
+	}()
+	for {
+		select {
+		case <-w.stopChan:
+			return
+		default:
+		}
+
+		if src == nil {
+			var err error
+			src, err = w.openSource(config)
+			if err != nil {
+				w.logger.Warn(err.Error())
+				w.status.Update(w.name+"-source", "error: "+err.Error())
+				if !w.wait(RetryPeriod) {
+					return
+				}
+				continue
+			}
+			w.status.Update(w.name+"-source", sourceName(config))
+			dec = NewDecoder(port)
+		}
+
+		pkt, err := src.ReadPacket()
+		if err == io.EOF {
+			if f, ok := src.(*PcapFile); ok {
+				if size, same, err := f.Size(); err == nil && (size < f.Offset() || (!same && f.Offset() >= size)) {
+					// Truncated, or rotated and read to the end.
+					w.logger.Info("Reopening " + config.PcapFile)
+					f.Close()
+					src = nil
+					continue
+				}
+				if !w.wait(PollPeriod) {
+					return
+				}
+			}
+			continue
+		}
+		if err != nil {
+			w.logger.Warn(err.Error())
+			w.status.Update(w.name+"-source", "error: "+err.Error())
+			src.Close()
+			src = nil
+			if !w.wait(RetryPeriod) {
+				return
+			}
+			continue
+		}
+
+		seg, err := decodePacket(pkt)
+		if err != nil || seg == nil {
+			continue
+		}
+		events := dec.Decode(pkt.Ts, seg)
+		if len(events) == 0 {
+			continue
+		}
+		w.lock.Lock()
+		for _, e := range events {
+			fingerprint, id := util.Fingerprint(e.Query)
+			if id == "" {
+				continue
+			}
+			w.aggregator.AddEvent(e, id, fingerprint)
+			w.nQueries++
+		}
+		w.conns = dec.Conns()
+		w.lock.Unlock()
+	}
+}
+
+// wait waits for d and returns false if the worker is stopped meanwhile.
+func (w *Worker) wait(d time.Duration) bool {
+	select {
+	case <-w.stopChan:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func sourceName(config qc.QAN) string {
+	if config.PcapFile != "" {
+		return "Reading " + config.PcapFile
+	}
+	iface := config.Interface
+	if iface == "" {
+		iface = DefaultInterface
+	}
+	return "Capturing on " + iface
+}
+
+// boolValue returns the value of the bool pointer passed in or
+// false if the pointer is nil.
+func boolValue(v *bool) bool {
+	if v != nil {
+		return *v
+	}
+	return false
+}
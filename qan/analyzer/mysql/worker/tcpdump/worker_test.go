@@ -0,0 +1,95 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tcpdump
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	qc "github.com/percona/qan-agent/qan/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-tcpdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	b := newBuilder()
+	s := b.session(ts, 40000)
+	s.login(caps)
+	for i := 0; i < 3; i++ {
+		s.send(time.Second, mp(0, query("INSERT INTO t VALUES (1)")))
+		s.recv(time.Millisecond, mp(1, ok(1, 0x0002)))
+	}
+	file := writeFile(t, dir, b)
+
+	logChan := make(chan proto.LogEntry, 100)
+	w := NewWorker(pct.NewLogger(logChan, "qan-worker"), OpenSource)
+	w.ZeroRunTime = true
+	w.SetConfig(qc.QAN{QAN: pc.QAN{CollectFrom: "tcpdump"}, PcapFile: file})
+	require.NoError(t, w.Setup(&iter.Interval{Number: 1, StartTime: time.Now().UTC()}))
+	defer w.Stop()
+
+	queries := func() int {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		return w.nQueries
+	}
+	for i := 0; i < 100 && queries() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res, err := w.Run()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, uint(3), res.Global.TotalQueries)
+	require.Len(t, res.Class, 1)
+	class := res.Class[0]
+	assert.Equal(t, "insert into t values(?+)", class.Fingerprint)
+	assert.InDelta(t, 0.003, class.Metrics.TimeMetrics["Query_time"].Sum, 0.000001)
+	assert.Equal(t, uint64(3), class.Metrics.NumberMetrics["Rows_affected"].Sum)
+	assert.Equal(t, "queries: 3, connections: 1", w.Status()["qan-worker-last"])
+
+	// More packets are read as they're written.
+	n := b.buf.Len()
+	s.send(time.Second, mp(0, query("INSERT INTO t VALUES (1)")))
+	s.recv(time.Millisecond, mp(1, ok(1, 0x0002)))
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(b.buf.Bytes()[n:])
+	f.Close()
+	require.NoError(t, err)
+	for i := 0; i < 100 && queries() < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	res, err = w.Run()
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), res.Global.TotalQueries)
+
+	require.NoError(t, w.Stop())
+	assert.Equal(t, "Stopped", w.Status()["qan-worker-source"])
+}
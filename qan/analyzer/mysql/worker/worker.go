@@ -1,9 +1,9 @@
 package worker
 
 import (
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 // A Worker gets queries, aggregates them, and returns a Result. Workers are ran
@@ -14,5 +14,5 @@ type Worker interface {
 	Stop() error
 	Cleanup() error
 	Status() map[string]string
-	SetConfig(pc.QAN)
+	SetConfig(qc.QAN)
 }
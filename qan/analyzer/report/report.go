@@ -22,10 +22,10 @@ import (
 	"time"
 
 	"github.com/percona/go-mysql/event"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 // slowlog|perf schema --> Result --> qan.Report --> data.Spooler
@@ -33,12 +33,53 @@ import (
 // Data for an interval from slow log or performance schema (pfs) parser,
 // passed to MakeReport() which transforms into a qan.Report{}.
 type Result struct {
-	Global     *event.Class   // metrics for all data
-	Class      []*event.Class // per-class metrics
-	RateLimit  uint           // Percona Server rate limit
-	RunTime    float64        // seconds parsing data, hopefully < interval
-	StopOffset int64          // slow log offset where parsing stopped, should be <= end offset
-	Error      string         `json:",omitempty"`
+	Global     *event.Class          // metrics for all data
+	Class      []*event.Class        // per-class metrics
+	RateLimit  uint                  // Percona Server rate limit
+	RunTime    float64               // seconds parsing data, hopefully < interval
+	StopOffset int64                 // slow log offset where parsing stopped, should be <= end offset
+	DigestLost uint64                // perfschema statements not counted because the digest table is full
+	Breakdown  map[string]*Breakdown `json:",omitempty"` // perfschema waits and stages, keyed on class Id
+	Writes     []TableWrites         `json:",omitempty"` // binlog writes per table and class
+	Error      string                `json:",omitempty"`
+}
+
+// Report is a qan.Report with the data only some workers collect.
+// The fields are flattened into the qan.Report JSON.
+type Report struct {
+	qan.Report
+	// perfschema:
+	DigestLost uint64                `json:",omitempty"` // statements not counted, Performance_schema_digest_lost increase
+	Breakdown  map[string]*Breakdown `json:",omitempty"` // keyed on class Id, top classes only
+	// binlog:
+	Writes []TableWrites `json:",omitempty"` // per table and class, most bytes first
+}
+
+// TableWrites is the write traffic of a class to a table in the binary log.
+// Rows are counted only for row-based events.
+type TableWrites struct {
+	Db           string
+	Table        string
+	ClassId      string
+	Statements   uint64
+	RowsInserted uint64 `json:",omitempty"`
+	RowsUpdated  uint64 `json:",omitempty"`
+	RowsDeleted  uint64 `json:",omitempty"`
+	Bytes        uint64 // binary log bytes
+}
+
+// Breakdown is where the time of a class went: waits and stages of its statements.
+type Breakdown struct {
+	Waits  []EventTime
+	Stages []EventTime
+}
+
+// EventTime is the time spent in one wait or stage event.
+type EventTime struct {
+	Event   string // e.g. wait/io/file/innodb/innodb_log_file, stage/sql/Sending data
+	Object  string `json:",omitempty"` // file or db.table of waits
+	Count   uint64
+	Seconds float64
 }
 
 type ByQueryTime []*event.Class
@@ -48,21 +89,32 @@ func (a ByQueryTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a ByQueryTime) Less(i, j int) bool {
 	// todo: will panic if struct is incorrect
 	// descending order
-	return a[i].Metrics.TimeMetrics["Query_time"].Sum > a[j].Metrics.TimeMetrics["Query_time"].Sum
+	ti, tj := a[i].Metrics.TimeMetrics["Query_time"].Sum, a[j].Metrics.TimeMetrics["Query_time"].Sum
+	if ti != tj {
+		return ti > tj
+	}
+	// The general log and binary log have no or few times: most frequent first.
+	return a[i].TotalQueries > a[j].TotalQueries
 }
 
-func MakeReport(config pc.QAN, startTime, endTime time.Time, interval *iter.Interval, result *Result) *qan.Report {
+func MakeReport(config qc.QAN, startTime, endTime time.Time, interval *iter.Interval, result *Result) *Report {
 	// Sort classes by Query_time_sum, descending.
 	sort.Sort(ByQueryTime(result.Class))
 
 	// Make qan.Report from Result and other metadata (e.g. Interval).
-	report := &qan.Report{
-		UUID:    config.UUID,
-		StartTs: startTime,
-		EndTs:   endTime,
-		RunTime: result.RunTime,
-		Global:  result.Global,
-		Class:   result.Class,
+	report := &Report{
+		Report: qan.Report{
+			UUID:    config.UUID,
+			StartTs: startTime,
+			EndTs:   endTime,
+			RunTime: result.RunTime,
+			Global:  result.Global,
+			Class:   result.Class,
+		},
+		// perfschema
+		DigestLost: result.DigestLost,
+		// binlog
+		Writes: result.Writes,
 	}
 	if interval != nil {
 		size, err := pct.FileSize(interval.Filename)
@@ -83,11 +135,13 @@ func MakeReport(config pc.QAN, startTime, endTime time.Time, interval *iter.Inte
 	// less than the limit.
 	n := len(result.Class)
 	if config.ReportLimit == 0 || n <= int(config.ReportLimit) {
+		report.Breakdown = breakdown(result.Breakdown, report.Class)
 		return report // all classes, no LRQ
 	}
 
 	// Top queries
 	report.Class = result.Class[0:config.ReportLimit]
+	report.Breakdown = breakdown(result.Breakdown, report.Class)
 
 	// Low-ranking Queries
 	lrq := event.NewClass("lrq", "/* low-ranking queries */", false)
@@ -98,3 +152,20 @@ func MakeReport(config pc.QAN, startTime, endTime time.Time, interval *iter.Inte
 
 	return report // top classes, the rest as LRQ
 }
+
+// breakdown returns the breakdowns of the given classes only.
+func breakdown(all map[string]*Breakdown, classes []*event.Class) map[string]*Breakdown {
+	if len(all) == 0 {
+		return nil
+	}
+	top := map[string]*Breakdown{}
+	for _, class := range classes {
+		if b, ok := all[class.Id]; ok {
+			top[class.Id] = b
+		}
+	}
+	if len(top) == 0 {
+		return nil
+	}
+	return top
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/percona/go-mysql/event"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	qc "github.com/percona/qan-agent/qan/config"
 	. "github.com/percona/qan-agent/test/rootdir"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -51,9 +52,11 @@ func TestResult001(t *testing.T) {
 		StartOffset: 0,
 		EndOffset:   1000,
 	}
-	config := pc.QAN{
-		UUID:        "1",
-		ReportLimit: 10,
+	config := qc.QAN{
+		QAN: pc.QAN{
+			UUID:        "1",
+			ReportLimit: 10,
+		},
 	}
 	report := MakeReport(config, interval.StartTime, interval.StopTime, interval, result)
 
@@ -86,3 +89,31 @@ func TestResult001(t *testing.T) {
 	assert.Equal(t, event.Float64(1.12), report.Class[2].Metrics.TimeMetrics["Query_time"].Max)
 	assert.Equal(t, event.Float64((1+1+0.101001)/10), report.Class[2].Metrics.TimeMetrics["Query_time"].Avg)
 }
+
+func TestResultBreakdown(t *testing.T) {
+	data, err := ioutil.ReadFile(outputDir + "/result001.json")
+	require.NoError(t, err)
+	result := &Result{}
+	require.NoError(t, json.Unmarshal(data, result))
+
+	// Breakdowns are reported only for the top classes.
+	result.Breakdown = map[string]*Breakdown{
+		"3000000000000003": {Stages: []EventTime{{Event: "stage/sql/Sending data", Count: 1, Seconds: 2}}},
+		"5000000000000005": {Waits: []EventTime{{Event: "wait/io/file/sql/FRM", Count: 1, Seconds: 0.1}}},
+	}
+	interval := &iter.Interval{StartTime: time.Now().Add(-1 * time.Second), StopTime: time.Now()}
+	config := qc.QAN{QAN: pc.QAN{UUID: "1", ReportLimit: 10}}
+	report := MakeReport(config, interval.StartTime, interval.StopTime, interval, result)
+	assert.Equal(t, result.Breakdown, report.Breakdown)
+
+	config.ReportLimit = 2
+	report = MakeReport(config, interval.StartTime, interval.StopTime, interval, result)
+	assert.Equal(t, map[string]*Breakdown{
+		"3000000000000003": result.Breakdown["3000000000000003"],
+	}, report.Breakdown)
+
+	config.ReportLimit = 1
+	delete(result.Breakdown, "3000000000000003")
+	report = MakeReport(config, interval.StartTime, interval.StopTime, interval, result)
+	assert.Nil(t, report.Breakdown)
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package config is the QAN config of an instance: the pmm QAN config and
+// the options of the collectors which only this agent has.
+package config
+
+import (
+	pc "github.com/percona/pmm/proto/config"
+)
+
+// QAN is the QAN config of an instance. The options are flattened in JSON
+// like the pmm config, so config files and cmds set them the same way.
+type QAN struct {
+	pc.QAN
+	// "slowlog" specific options.
+	SlowLogTable *bool `json:",omitempty"` // read mysql.slow_log instead of the slow log file, set on start if @@log_output includes TABLE
+	// "perfschema" specific options.
+	TruncateDigests *bool `json:",omitempty"` // truncate events_statements_summary_by_digest when digests are lost
+	Breakdown       *bool `json:",omitempty"` // sample waits and stages per class from history tables
+	// "tcpdump" specific options.
+	PcapFile  string `json:",omitempty"` // read this file written by tcpdump -w instead of capturing
+	Interface string `json:",omitempty"` // capture on this interface, "" = "any"
+	Port      uint   `json:",omitempty"` // MySQL server port, 0 = 3306
+	// MySQL optional collectors.
+	InnoDBStatus *bool `json:",omitempty"` // spool new deadlocks from SHOW ENGINE INNODB STATUS
+	LockWaits    *bool `json:",omitempty"` // sample InnoDB lock waits and spool blocking classes
+}
+
+// NewQAN returns the default config.
+func NewQAN() QAN {
+	return QAN{QAN: pc.NewQAN()}
+}
@@ -18,20 +18,23 @@
 package qan
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 const (
@@ -46,7 +49,8 @@ var (
 // An AnalyzerInstance is an Analyzer ran by a Manager, one per MySQL instance
 // as configured.
 type AnalyzerInstance struct {
-	setConfig pc.QAN
+	setConfig qc.QAN
+	instance  proto.Instance
 	analyzer  analyzer.Analyzer
 }
 
@@ -96,30 +100,18 @@ func (m *Manager) Start() error {
 		m.status.Update(pkg, "Running")
 	}()
 
-	filepathGlob := fmt.Sprintf("%s/%s-*%s", pct.Basedir.Dir("config"), pkg, pct.CONFIG_FILE_SUFFIX)
-	files, err := filepath.Glob(filepathGlob)
+	setConfigs, err := m.readConfigs(true)
 	if err != nil {
 		return err
 	}
-	for _, file := range files {
-		data, err := ioutil.ReadFile(file)
-		if err != nil && !os.IsNotExist(err) {
-			m.logger.Warn(fmt.Sprintf("Cannot read %s: %s", file, err))
-			continue
-		}
-
-		if len(data) == 0 {
-			m.logger.Warn(fmt.Sprintf("%s is empty, removing", file))
-			pct.RemoveFile(file)
-			continue
-		}
-
-		setConfig := pc.QAN{}
-		if err := json.Unmarshal(data, &setConfig); err != nil {
-			m.logger.Warn(fmt.Sprintf("Cannot decode %s: %s", file, err))
-			continue
-		}
-
+	// Start the analyzers in UUID order, i.e. the order of their config files.
+	uuids := make([]string, 0, len(setConfigs))
+	for uuid := range setConfigs {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	for _, uuid := range uuids {
+		setConfig := setConfigs[uuid]
 		// Start the analyzer. If it fails that's ok for
 		// the manager itself (i.e. don't fail this func) because user can fix
 		// or reconfigure this analyzer instance later and have manager try
@@ -134,6 +126,57 @@ func (m *Manager) Start() error {
 	return nil // success
 }
 
+// Reload starts, restarts and stops analyzers whose config files were
+// created, changed or removed, and restarts analyzers whose instances changed.
+func (m *Manager) Reload() []error {
+	m.logger.Debug("Reload:call")
+	defer m.logger.Debug("Reload:return")
+
+	// A config file which can't be read or decoded, e.g. while it's being
+	// edited, doesn't stop its analyzer: nothing changes until it's fixed.
+	setConfigs, err := m.readConfigs(false)
+	if err != nil {
+		return []error{err}
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	errs := []error{}
+	for uuid, a := range m.analyzers {
+		setConfig, ok := setConfigs[uuid]
+		if !ok {
+			m.logger.Info("Config removed, stopping Query Analytics on instance", uuid)
+			if err := m.stopAnalyzer(uuid); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		in, err := m.instanceRepo.Get(uuid, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot get instance %s: %s", uuid, err))
+			continue
+		}
+		if sameConfig(a.setConfig, setConfig) && reflect.DeepEqual(a.instance, in) {
+			continue
+		}
+		m.logger.Info("Config or instance changed, restarting Query Analytics on instance", uuid)
+		if err := m.restartAnalyzer(setConfig); err != nil {
+			errs = append(errs, fmt.Errorf("Cannot restart Query Analytics on instance %s: %s", uuid, err))
+		}
+	}
+	for uuid, setConfig := range setConfigs {
+		if _, ok := m.analyzers[uuid]; ok {
+			continue
+		}
+		m.logger.Info("Config created, starting Query Analytics on instance", uuid)
+		if err := m.startAnalyzer(setConfig); err != nil {
+			errs = append(errs, fmt.Errorf("Cannot start Query Analytics on instance %s: %s", uuid, err))
+		}
+	}
+	return errs
+}
+
 func (m *Manager) Stop() error {
 	m.logger.Debug("Stop:call")
 	defer m.logger.Debug("Stop:return")
@@ -164,6 +207,25 @@ func (m *Manager) Status() map[string]string {
 	return status
 }
 
+// Alive returns an error if an analyzer doesn't respond before the ctx is done.
+func (m *Manager) Alive(ctx context.Context) error {
+	m.mux.RLock()
+	analyzers := make([]analyzer.Analyzer, 0, len(m.analyzers))
+	for _, a := range m.analyzers {
+		analyzers = append(analyzers, a.analyzer)
+	}
+	m.mux.RUnlock()
+
+	for _, a := range analyzers {
+		if lc, ok := a.(pct.LivenessChecker); ok {
+			if err := lc.Alive(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	m.logger.Debug("Handle:call")
 	defer m.logger.Debug("Handle:return")
@@ -176,11 +238,18 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 
 	switch cmd.Cmd {
 	case "StartTool":
-		setConfig := pc.QAN{}
+		setConfig := qc.QAN{}
 		if err := json.Unmarshal(cmd.Data, &setConfig); err != nil {
 			return cmd.Reply(nil, err)
 		}
 		uuid := setConfig.UUID
+		if pct.GetSetConfigFlags(cmd).DryRun {
+			if _, ok := m.analyzers[uuid]; ok {
+				return cmd.Reply(nil, ErrAlreadyRunning)
+			}
+			diff, err := m.diffConfig(setConfig)
+			return cmd.Reply(diff, err)
+		}
 		if err := m.startAnalyzer(setConfig); err != nil {
 			switch err {
 			case ErrAlreadyRunning:
@@ -204,11 +273,18 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 
 		return cmd.Reply(runningConfig) // success
 	case "RestartTool":
-		setConfig := pc.QAN{}
+		setConfig := qc.QAN{}
 		if err := json.Unmarshal(cmd.Data, &setConfig); err != nil {
 			return cmd.Reply(nil, err)
 		}
 		uuid := setConfig.UUID
+		if pct.GetSetConfigFlags(cmd).DryRun {
+			if _, ok := m.analyzers[uuid]; !ok {
+				return cmd.Reply(nil, ErrNotRunning)
+			}
+			diff, err := m.diffConfig(setConfig)
+			return cmd.Reply(diff, err)
+		}
 		if err := m.restartAnalyzer(setConfig); err != nil {
 			return cmd.Reply(nil, err)
 		}
@@ -247,6 +323,37 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		m.instanceRepo.Remove(uuid)
 
 		return cmd.Reply(nil, errs...)
+	case "RollbackConfig":
+		// Start or restart the analyzer with a previous version of its config.
+		r, err := pct.GetRollback(cmd)
+		if err != nil {
+			return cmd.Reply(nil, err)
+		}
+		if r.UUID == "" {
+			return cmd.Reply(nil, errors.New("missing instance UUID"))
+		}
+		setConfig := qc.QAN{}
+		if _, err := pct.Basedir.ReadConfigVersion(configName(r.UUID), r.Version, &setConfig); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		if _, ok := m.analyzers[r.UUID]; ok {
+			err = m.restartAnalyzer(setConfig)
+		} else {
+			err = m.startAnalyzer(setConfig)
+		}
+		if err != nil {
+			return cmd.Reply(nil, err)
+		}
+
+		// Write instance config to disk so agent runs instance on restart.
+		if err := pct.Basedir.WriteConfig(configName(r.UUID), setConfig); err != nil {
+			return cmd.Reply(nil, err)
+		}
+
+		a := m.analyzers[r.UUID]
+		runningConfig := a.analyzer.Config()
+
+		return cmd.Reply(runningConfig) // success
 	case "GetConfig":
 		config, errs := m.GetConfig()
 		return cmd.Reply(config, errs...)
@@ -292,7 +399,7 @@ func (m *Manager) GetDefaults(uuid string) map[string]interface{} {
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
-func (m *Manager) restartAnalyzer(setConfig pc.QAN) error {
+func (m *Manager) restartAnalyzer(setConfig qc.QAN) error {
 	// XXX Assume caller has locked m.mux.
 
 	m.logger.Debug("restartAnalyzer:call")
@@ -315,7 +422,7 @@ func (m *Manager) restartAnalyzer(setConfig pc.QAN) error {
 
 }
 
-func (m *Manager) startAnalyzer(setConfig pc.QAN) (err error) {
+func (m *Manager) startAnalyzer(setConfig qc.QAN) (err error) {
 	/*
 		XXX Assume caller has locked m.mux.
 	*/
@@ -374,6 +481,7 @@ func (m *Manager) startAnalyzer(setConfig pc.QAN) (err error) {
 	// Save the new analyzer and its associated parts.
 	m.analyzers[uuid] = AnalyzerInstance{
 		setConfig: setConfig,
+		instance:  protoInstance,
 		analyzer:  analyzer,
 	}
 
@@ -408,6 +516,96 @@ func (m *Manager) stopAnalyzer(uuid string) error {
 	return nil // success
 }
 
+// diffConfig validates the set config, if the analyzer can, and returns how
+// the running config changes when the analyzer is (re)started with it.
+func (m *Manager) diffConfig(setConfig qc.QAN) ([]pct.ConfigChange, error) {
+	/*
+		XXX Assume caller has locked m.mux.
+	*/
+
+	uuid := setConfig.UUID
+	protoInstance, err := m.instanceRepo.Get(uuid, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get instance %s: %s", uuid, err)
+	}
+
+	// Compare to the running config, or to nothing if not running, using
+	// the running analyzer or a new one, which isn't started, to validate.
+	var runningConfig interface{} = struct{}{}
+	var a analyzer.Analyzer
+	if ai, ok := m.analyzers[uuid]; ok {
+		runningConfig = ai.analyzer.Config()
+		a = ai.analyzer
+	} else {
+		a, err = m.analyzerFactory.Make(protoInstance.Subsystem, pkg+"-dry-run", protoInstance)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create analyzer %s: %s", uuid, err)
+		}
+	}
+	newConfig := setConfig
+	if v, ok := a.(analyzer.ConfigValidator); ok {
+		newConfig, err = v.ValidateConfig(setConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QAN config: %s", err)
+		}
+	}
+
+	return pct.DiffConfig(runningConfig, newConfig)
+}
+
+// readConfigs returns the analyzer configs in the config dir, keyed on UUID.
+// If skipInvalid, as on start, invalid config files are skipped and empty ones
+// removed; else the first invalid file is an error and no file is removed.
+func (m *Manager) readConfigs(skipInvalid bool) (map[string]qc.QAN, error) {
+	filepathGlob := fmt.Sprintf("%s/%s-*%s", pct.Basedir.Dir("config"), pkg, pct.CONFIG_FILE_SUFFIX)
+	files, err := filepath.Glob(filepathGlob)
+	if err != nil {
+		return nil, err
+	}
+	setConfigs := make(map[string]qc.QAN, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // removed since Glob
+			}
+			if !skipInvalid {
+				return nil, fmt.Errorf("cannot read %s: %s", file, err)
+			}
+			m.logger.Warn(fmt.Sprintf("Cannot read %s: %s", file, err))
+			continue
+		}
+
+		if len(data) == 0 {
+			if !skipInvalid {
+				return nil, fmt.Errorf("%s is empty", file)
+			}
+			m.logger.Warn(fmt.Sprintf("%s is empty, removing", file))
+			pct.RemoveFile(file)
+			continue
+		}
+
+		setConfig := qc.QAN{}
+		if err := json.Unmarshal(data, &setConfig); err != nil {
+			if !skipInvalid {
+				return nil, fmt.Errorf("cannot decode %s: %s", file, err)
+			}
+			m.logger.Warn(fmt.Sprintf("Cannot decode %s: %s", file, err))
+			continue
+		}
+		setConfigs[setConfig.UUID] = setConfig
+	}
+	return setConfigs, nil
+}
+
+// sameConfig compares configs as written to disk, i.e. without the fields
+// which are not written.
+func sameConfig(c1, c2 qc.QAN) bool {
+	b1, err1 := json.Marshal(c1)
+	b2, err2 := json.Marshal(c2)
+	return err1 == nil && err2 == nil && string(b1) == string(b2)
+}
+
 func configName(uuid string) string {
 	return fmt.Sprintf("%s-%s", pkg, uuid)
 }
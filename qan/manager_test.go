@@ -30,6 +30,7 @@ import (
 	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/stretchr/testify/assert"
@@ -164,19 +165,21 @@ func (s *ManagerTestSuite) TestStartWithConfig(t *C) {
 	f := mock.NewQanAnalyzerFactory(a1, a2)
 	m := qan.NewManager(s.logger, s.im, f)
 	t.Assert(m, NotNil)
-	configs := make([]pc.QAN, 0)
+	configs := make([]qc.QAN, 0)
 	for i, analyzerType := range []string{"slowlog", "perfschema"} {
 		// We have two analyzerTypes and two MySQL instances in fixture, lets re-use the index
 		// as we only need one of each analizer type and they need to be different instances.
 		mysqlInstance := mysqlInstances[i]
 		// Write a realistic qan.conf config to disk.
 		exampleQueries := true
-		config := pc.QAN{
-			UUID:           mysqlInstance.UUID,
-			CollectFrom:    analyzerType,
-			Interval:       300,
-			ExampleQueries: &exampleQueries, // specify optional args
-			ReportLimit:    200,             // specify optional args
+		config := qc.QAN{
+			QAN: pc.QAN{
+				UUID:           mysqlInstance.UUID,
+				CollectFrom:    analyzerType,
+				Interval:       300,
+				ExampleQueries: &exampleQueries, // specify optional args
+				ReportLimit:    200,             // specify optional args
+			},
 		}
 		err := pct.Basedir.WriteConfig("qan-"+mysqlInstance.UUID, &config)
 		t.Assert(err, IsNil)
@@ -206,7 +209,7 @@ func (s *ManagerTestSuite) TestStartWithConfig(t *C) {
 	} else {
 		t.Check(f.Args, HasLen, 2)
 
-		argConfigs := []pc.QAN{
+		argConfigs := []qc.QAN{
 			a1.Config(),
 			a2.Config(),
 		}
@@ -258,16 +261,18 @@ func (s *ManagerTestSuite) TestStart2RemoteQAN(t *C) {
 	f := mock.NewQanAnalyzerFactory(a1, a2)
 	m := qan.NewManager(s.logger, s.im, f)
 	t.Assert(m, NotNil)
-	configs := make([]pc.QAN, 0)
+	configs := make([]qc.QAN, 0)
 	for _, mysqlInstance := range mysqlInstances {
 		// Write a realistic qan.conf config to disk.
 		exampleQueries := true
-		config := pc.QAN{
-			UUID:           mysqlInstance.UUID,
-			CollectFrom:    "perfschema",
-			Interval:       300,
-			ExampleQueries: &exampleQueries, // specify optional args
-			ReportLimit:    200,             // specify optional args
+		config := qc.QAN{
+			QAN: pc.QAN{
+				UUID:           mysqlInstance.UUID,
+				CollectFrom:    "perfschema",
+				Interval:       300,
+				ExampleQueries: &exampleQueries, // specify optional args
+				ReportLimit:    200,             // specify optional args
+			},
 		}
 		err := pct.Basedir.WriteConfig("qan-"+mysqlInstance.UUID, &config)
 		t.Assert(err, IsNil)
@@ -297,7 +302,7 @@ func (s *ManagerTestSuite) TestStart2RemoteQAN(t *C) {
 	} else {
 		t.Check(f.Args, HasLen, 2)
 
-		argConfigs := []pc.QAN{
+		argConfigs := []qc.QAN{
 			a1.Config(),
 			a2.Config(),
 		}
@@ -345,10 +350,12 @@ func (s *ManagerTestSuite) TestGetConfig(t *C) {
 	mysqlUUID := mysqlInstances[0].UUID
 
 	// Write a realistic qan.conf config to disk.
-	pcQANSetExpected := pc.QAN{
-		UUID:        mysqlUUID,
-		CollectFrom: "slowlog",
-		Interval:    300,
+	pcQANSetExpected := qc.QAN{
+		QAN: pc.QAN{
+			UUID:        mysqlUUID,
+			CollectFrom: "slowlog",
+			Interval:    300,
+		},
 	}
 	err := pct.Basedir.WriteConfig("qan-"+mysqlUUID, &pcQANSetExpected)
 	t.Assert(err, IsNil)
@@ -372,12 +379,12 @@ func (s *ManagerTestSuite) TestGetConfig(t *C) {
 	t.Assert(errs, HasLen, 0)
 	t.Assert(gotConfig, HasLen, 1)
 
-	pcQANSet := pc.QAN{}
+	pcQANSet := qc.QAN{}
 	err = json.Unmarshal([]byte(gotConfig[0].Set), &pcQANSet)
 	require.NoError(t, err)
 	assert.Equal(t, pcQANSetExpected, pcQANSet)
 
-	pcQANRunning := pc.QAN{}
+	pcQANRunning := qc.QAN{}
 	err = json.Unmarshal([]byte(gotConfig[0].Running), &pcQANRunning)
 	require.NoError(t, err)
 	assert.Equal(t, pcQANRunningExpected, pcQANRunning)
@@ -424,21 +431,23 @@ func (s *ManagerTestSuite) TestAddInstance(t *C) {
 
 	// Create the qan config.
 	exampleQueries := true
-	config := &pc.QAN{
-		UUID: mysqlUUID,
-		Start: []string{
-			"SET GLOBAL slow_query_log=OFF",
-			"SET GLOBAL long_query_time=0.123",
-			"SET GLOBAL slow_query_log=ON",
-		},
-		Stop: []string{
-			"SET GLOBAL slow_query_log=OFF",
-			"SET GLOBAL long_query_time=10",
+	config := &qc.QAN{
+		QAN: pc.QAN{
+			UUID: mysqlUUID,
+			Start: []string{
+				"SET GLOBAL slow_query_log=OFF",
+				"SET GLOBAL long_query_time=0.123",
+				"SET GLOBAL slow_query_log=ON",
+			},
+			Stop: []string{
+				"SET GLOBAL slow_query_log=OFF",
+				"SET GLOBAL long_query_time=10",
+			},
+			Interval:       300,        // 5 min
+			MaxSlowLogSize: 1073741824, // 1 GiB
+			ExampleQueries: &exampleQueries,
+			CollectFrom:    "slowlog",
 		},
-		Interval:       300,        // 5 min
-		MaxSlowLogSize: 1073741824, // 1 GiB
-		ExampleQueries: &exampleQueries,
-		CollectFrom:    "slowlog",
 	}
 
 	// Send a StartTool cmd with the qan config to start an analyzer.
@@ -458,7 +467,7 @@ func (s *ManagerTestSuite) TestAddInstance(t *C) {
 	// The manager writes the qan config to disk.
 	data, err := ioutil.ReadFile(pct.Basedir.ConfigFile("qan-" + mysqlUUID))
 	t.Check(err, IsNil)
-	gotConfig := &pc.QAN{}
+	gotConfig := &qc.QAN{}
 	err = json.Unmarshal(data, gotConfig)
 	t.Check(err, IsNil)
 	t.Check(gotConfig, DeepEquals, config)
@@ -519,21 +528,23 @@ func (s *ManagerTestSuite) TestStartTool(t *C) {
 
 	// Create the qan config.
 	exampleQueries := true
-	config := &pc.QAN{
-		UUID: mysqlUUID,
-		Start: []string{
-			"SET GLOBAL slow_query_log=OFF",
-			"SET GLOBAL long_query_time=0.123",
-			"SET GLOBAL slow_query_log=ON",
-		},
-		Stop: []string{
-			"SET GLOBAL slow_query_log=OFF",
-			"SET GLOBAL long_query_time=10",
+	config := &qc.QAN{
+		QAN: pc.QAN{
+			UUID: mysqlUUID,
+			Start: []string{
+				"SET GLOBAL slow_query_log=OFF",
+				"SET GLOBAL long_query_time=0.123",
+				"SET GLOBAL slow_query_log=ON",
+			},
+			Stop: []string{
+				"SET GLOBAL slow_query_log=OFF",
+				"SET GLOBAL long_query_time=10",
+			},
+			Interval:       300,        // 5 min
+			MaxSlowLogSize: 1073741824, // 1 GiB
+			ExampleQueries: &exampleQueries,
+			CollectFrom:    "slowlog",
 		},
-		Interval:       300,        // 5 min
-		MaxSlowLogSize: 1073741824, // 1 GiB
-		ExampleQueries: &exampleQueries,
-		CollectFrom:    "slowlog",
 	}
 
 	// Send a StartTool cmd with the qan config to start an analyzer.
@@ -553,7 +564,7 @@ func (s *ManagerTestSuite) TestStartTool(t *C) {
 	// The manager writes the qan config to disk.
 	data, err := ioutil.ReadFile(pct.Basedir.ConfigFile("qan-" + mysqlUUID))
 	t.Check(err, IsNil)
-	gotConfig := &pc.QAN{}
+	gotConfig := &qc.QAN{}
 	err = json.Unmarshal(data, gotConfig)
 	t.Check(err, IsNil)
 	// For some reasons MaxSlowLogSize is explicitly marked to not be saved in config file
@@ -606,6 +617,189 @@ func (s *ManagerTestSuite) TestStartTool(t *C) {
 	t.Assert(err, IsNil)
 }
 
+func (s *ManagerTestSuite) TestReload(t *C) {
+	// Make and start a qan.Manager with mock factories, no analyzer yet.
+	a1 := mock.NewQanAnalyzer("qan-analizer-1")
+	a2 := mock.NewQanAnalyzer("qan-analizer-2")
+	a3 := mock.NewQanAnalyzer("qan-analizer-3")
+	f := mock.NewQanAnalyzerFactory(a1, a2, a3)
+	m := qan.NewManager(s.logger, s.im, f)
+	t.Assert(m, NotNil)
+	err := m.Start()
+	t.Check(err, IsNil)
+	defer m.Stop()
+	test.WaitStatus(1, m, "qan", "Running")
+
+	// Nothing to reload.
+	errs := m.Reload()
+	t.Check(errs, HasLen, 0)
+	configs, _ := m.GetConfig()
+	t.Check(configs, HasLen, 0)
+
+	// Config created outside the agent, e.g. by Puppet, starts an analyzer.
+	config := &qc.QAN{
+		QAN: pc.QAN{
+			UUID:        s.instanceUUID,
+			CollectFrom: "slowlog",
+			Interval:    60,
+		},
+	}
+	err = pct.Basedir.WriteConfig("qan-"+s.instanceUUID, config)
+	t.Assert(err, IsNil)
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	assert.True(t, <-a1.StartChan)
+	configs, _ = m.GetConfig()
+	t.Check(configs, HasLen, 1)
+
+	// Same config, so nothing changes.
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	select {
+	case <-a1.StopChan:
+		t.Error("Analyzer stopped")
+	case <-a2.StartChan:
+		t.Error("Analyzer started")
+	default:
+	}
+
+	// Config changed restarts the analyzer.
+	config.Interval = 300
+	err = pct.Basedir.WriteConfig("qan-"+s.instanceUUID, config)
+	t.Assert(err, IsNil)
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	assert.True(t, <-a1.StopChan)
+	assert.True(t, <-a2.StartChan)
+
+	// Instance changed restarts the analyzer too.
+	in := s.protoInstance
+	in.DSN = "user:newpass@tcp(localhost)/"
+	err = s.im.Update(in, false)
+	t.Assert(err, IsNil)
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	assert.True(t, <-a2.StopChan)
+	assert.True(t, <-a3.StartChan)
+	t.Check(f.Args[2].ProtoInstance.DSN, Equals, in.DSN)
+
+	// An invalid or empty config, e.g. while it's edited, is an error which
+	// leaves the analyzer running and the file in place.
+	configFile := pct.Basedir.ConfigFile("qan-" + s.instanceUUID)
+	for _, data := range []string{"{", ""} {
+		err = ioutil.WriteFile(configFile, []byte(data), 0644)
+		t.Assert(err, IsNil)
+		errs = m.Reload()
+		t.Check(errs, HasLen, 1)
+		t.Check(test.FileExists(configFile), Equals, true)
+		configs, _ = m.GetConfig()
+		t.Check(configs, HasLen, 1)
+	}
+	err = pct.Basedir.WriteConfig("qan-"+s.instanceUUID, config)
+	t.Assert(err, IsNil)
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	select {
+	case <-a3.StopChan:
+		t.Error("Analyzer stopped")
+	default:
+	}
+
+	// Config removed stops the analyzer.
+	err = pct.Basedir.RemoveConfig("qan-" + s.instanceUUID)
+	t.Assert(err, IsNil)
+	errs = m.Reload()
+	t.Check(errs, HasLen, 0)
+	assert.True(t, <-a3.StopChan)
+	configs, _ = m.GetConfig()
+	t.Check(configs, HasLen, 0)
+}
+
+func (s *ManagerTestSuite) TestDryRunAndRollback(t *C) {
+	// Make and start a qan.Manager with mock factories, no analyzer yet.
+	a1 := mock.NewQanAnalyzer("qan-analizer-1")
+	a2 := mock.NewQanAnalyzer("qan-analizer-2")
+	a3 := mock.NewQanAnalyzer("qan-analizer-3")
+	a4 := mock.NewQanAnalyzer("qan-analizer-4")
+	f := mock.NewQanAnalyzerFactory(a1, a2, a3, a4)
+	m := qan.NewManager(s.logger, s.im, f)
+	t.Assert(m, NotNil)
+	err := m.Start()
+	t.Check(err, IsNil)
+	defer m.Stop()
+	test.WaitStatus(1, m, "qan", "Running")
+
+	// Dry run of StartTool validates the config with a new analyzer which
+	// isn't started, and returns the diff from nothing.
+	a1.ValidateConfigMock = func(config qc.QAN) (qc.QAN, error) {
+		config.ReportLimit = 200
+		return config, nil
+	}
+	cmd := &proto.Cmd{
+		User:      "daniel",
+		Ts:        time.Now(),
+		AgentUUID: "123",
+		Service:   "qan",
+		Cmd:       "StartTool",
+		Data:      []byte(`{"UUID":"` + s.instanceUUID + `","CollectFrom":"slowlog","Interval":60,"DryRun":true}`),
+	}
+	reply := m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	diff := []pct.ConfigChange{}
+	err = json.Unmarshal(reply.Data, &diff)
+	t.Assert(err, IsNil)
+	t.Check(diff, DeepEquals, []pct.ConfigChange{
+		{Key: "CollectFrom", New: "slowlog"},
+		{Key: "Interval", New: float64(60)},
+		{Key: "ReportLimit", New: float64(200)},
+		{Key: "UUID", New: s.instanceUUID},
+	})
+	configs, _ := m.GetConfig()
+	t.Check(configs, HasLen, 0)
+	select {
+	case <-a1.StartChan:
+		t.Error("Analyzer started")
+	default:
+	}
+
+	// Start then restart the analyzer with a new config.
+	cmd.Data = []byte(`{"UUID":"` + s.instanceUUID + `","CollectFrom":"slowlog","Interval":60}`)
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	assert.True(t, <-a2.StartChan)
+
+	cmd.Cmd = "RestartTool"
+	cmd.Data = []byte(`{"UUID":"` + s.instanceUUID + `","CollectFrom":"perfschema","Interval":60,"DryRun":true}`)
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	diff = []pct.ConfigChange{}
+	err = json.Unmarshal(reply.Data, &diff)
+	t.Assert(err, IsNil)
+	t.Check(diff, DeepEquals, []pct.ConfigChange{{Key: "CollectFrom", Old: "slowlog", New: "perfschema"}})
+
+	cmd.Data = []byte(`{"UUID":"` + s.instanceUUID + `","CollectFrom":"perfschema","Interval":60}`)
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	assert.True(t, <-a2.StopChan)
+	assert.True(t, <-a3.StartChan)
+
+	// Roll back to the previous config, which restarts the analyzer with it.
+	cmd.Cmd = "RollbackConfig"
+	cmd.Data = []byte(`{"UUID":"` + s.instanceUUID + `"}`)
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	assert.True(t, <-a3.StopChan)
+	assert.True(t, <-a4.StartChan)
+	gotConfig := qc.QAN{}
+	err = json.Unmarshal(reply.Data, &gotConfig)
+	t.Assert(err, IsNil)
+	t.Check(gotConfig.CollectFrom, Equals, "slowlog")
+
+	cmd.Data = []byte(`{}`)
+	reply = m.Handle(cmd)
+	t.Check(reply.Error, Equals, "missing instance UUID")
+}
+
 func (s *ManagerTestSuite) TestBadCmd(t *C) {
 	a := mock.NewQanAnalyzer("qan-analizer-1")
 	f := mock.NewQanAnalyzerFactory(a)
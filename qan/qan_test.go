@@ -30,6 +30,7 @@ import (
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer"
 	"github.com/percona/qan-agent/qan/analyzer/factory"
+	qc "github.com/percona/qan-agent/qan/config"
 	"github.com/percona/qan-agent/test"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/stretchr/testify/assert"
@@ -81,10 +82,12 @@ func TestWithRealMySQL(t *testing.T) {
 	)
 
 	// Write a realistic qan.conf config to disk.
-	pcQANSetExpected := pc.QAN{
-		UUID:        protoInstance.UUID,
-		CollectFrom: "slowlog",
-		Interval:    300,
+	pcQANSetExpected := qc.QAN{
+		QAN: pc.QAN{
+			UUID:        protoInstance.UUID,
+			CollectFrom: "slowlog",
+			Interval:    300,
+		},
 	}
 	err = pct.Basedir.WriteConfig("qan-"+protoInstance.UUID, &pcQANSetExpected)
 	require.NoError(t, err)
@@ -18,11 +18,13 @@
 package query
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
 
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/agent/policy"
 	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/query/plugin"
@@ -39,8 +41,10 @@ type Manager struct {
 	logger       *pct.Logger
 	instanceRepo *instance.Repo
 	// --
-	plugins map[string]plugin.Plugin
-	running bool
+	plugins  map[string]plugin.Plugin
+	policy   *policy.Policy
+	running  bool
+	handling int // cmds
 	sync.Mutex
 	status *pct.Status
 }
@@ -96,17 +100,47 @@ func (m *Manager) Stop() error {
 	return nil
 }
 
-func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
+// SetPolicy sets the local policy which allows or denies commands, like
+// EXPLAIN of queries other than SELECT. A nil policy allows all commands.
+func (m *Manager) SetPolicy(p *policy.Policy) {
 	m.Lock()
 	defer m.Unlock()
+	m.policy = p
+}
+
+func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
+	return m.HandleContext(context.Background(), cmd)
+}
 
+// HandleContext handles the cmd like Handle, but stops the query when the ctx
+// is done. Cmds are handled in parallel, the mutex is not held while querying.
+func (m *Manager) HandleContext(ctx context.Context, cmd *proto.Cmd) *proto.Reply {
+	m.Lock()
 	// Don't query if this tool is stopped.
 	if !m.running {
+		m.Unlock()
 		return cmd.Reply(nil, pct.ServiceIsNotRunningError{})
 	}
 
+	if err := m.policy.Check(cmd); err != nil {
+		m.Unlock()
+		m.logger.Warn(err)
+		return cmd.Reply(nil, err)
+	}
+
+	plugins := m.plugins
+	m.handling++
 	m.status.UpdateRe(SERVICE_NAME, "Handling", cmd)
-	defer m.status.Update(SERVICE_NAME, "Idle")
+	m.Unlock()
+
+	defer func() {
+		m.Lock()
+		m.handling--
+		if m.handling == 0 {
+			m.status.Update(SERVICE_NAME, "Idle")
+		}
+		m.Unlock()
+	}()
 
 	// See which type of subsystem this query is for. Right now we only support
 	// MySQL, but this abstraction will make adding other subsystems easy.
@@ -120,12 +154,12 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		return cmd.Reply(nil, err)
 	}
 
-	p, ok := m.plugins[in.Subsystem]
+	p, ok := plugins[in.Subsystem]
 	if !ok {
 		return cmd.Reply(nil, fmt.Errorf("can't query %s", in.Subsystem))
 	}
 
-	data, err := p.Handle(cmd, in)
+	data, err := p.Handle(ctx, cmd, in)
 	if err != nil {
 		switch err.(type) {
 		case plugin.UnknownCmdError:
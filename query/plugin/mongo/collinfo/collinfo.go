@@ -0,0 +1,157 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package collinfo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/percona/pmgo"
+	"github.com/percona/pmm/proto"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Query lists collections as proto.Table, where Table is the collection name.
+type Query struct {
+	UUID        string
+	Collections []proto.Table
+}
+
+// IndexStat is one document returned by the `$indexStats` aggregation stage.
+type IndexStat struct {
+	Name  string
+	Key   string // JSON
+	Host  string
+	Ops   int64
+	Since time.Time
+}
+
+// Stats is a subset of the `collStats` command output.
+type Stats struct {
+	Ns             string
+	Count          int64
+	Size           int64
+	AvgObjSize     int64
+	StorageSize    int64
+	Nindexes       int64
+	TotalIndexSize int64
+	IndexSizes     map[string]int64
+	Capped         bool
+	Sharded        bool
+}
+
+type CollInfo struct {
+	Index  []IndexStat `json:",omitempty"`
+	Stats  *Stats      `json:",omitempty"`
+	Errors []string    `json:",omitempty"`
+}
+
+// Result is keyed on db.collection.
+type Result map[string]*CollInfo
+
+// IndexStats returns index usage for each collection, sorted by index name.
+func IndexStats(session pmgo.SessionManager, collections []proto.Table) (Result, error) {
+	res := make(Result)
+	for _, t := range collections {
+		info := res.get(t)
+
+		docs := []struct {
+			Name     string `bson:"name"`
+			Key      bson.D `bson:"key"`
+			Host     string `bson:"host"`
+			Accesses struct {
+				Ops   int64     `bson:"ops"`
+				Since time.Time `bson:"since"`
+			} `bson:"accesses"`
+		}{}
+		pipeline := []bson.M{{"$indexStats": bson.M{}}}
+		err := session.DB(t.Db).C(t.Table).Pipe(pipeline).All(&docs)
+		if err != nil {
+			info.Errors = append(info.Errors, fmt.Sprintf("$indexStats %s.%s: %s", t.Db, t.Table, err))
+			continue
+		}
+
+		for _, doc := range docs {
+			key, err := bson.MarshalJSON(doc.Key)
+			if err != nil {
+				return nil, err
+			}
+			info.Index = append(info.Index, IndexStat{
+				Name:  doc.Name,
+				Key:   string(key),
+				Host:  doc.Host,
+				Ops:   doc.Accesses.Ops,
+				Since: doc.Accesses.Since,
+			})
+		}
+		sort.Slice(info.Index, func(i, j int) bool {
+			return info.Index[i].Name < info.Index[j].Name
+		})
+	}
+	return res, nil
+}
+
+// CollStats returns size and index sizes for each collection.
+func CollStats(session pmgo.SessionManager, collections []proto.Table) (Result, error) {
+	res := make(Result)
+	for _, t := range collections {
+		info := res.get(t)
+
+		stats := struct {
+			Ns             string           `bson:"ns"`
+			Count          int64            `bson:"count"`
+			Size           int64            `bson:"size"`
+			AvgObjSize     int64            `bson:"avgObjSize"`
+			StorageSize    int64            `bson:"storageSize"`
+			Nindexes       int64            `bson:"nindexes"`
+			TotalIndexSize int64            `bson:"totalIndexSize"`
+			IndexSizes     map[string]int64 `bson:"indexSizes"`
+			Capped         bool             `bson:"capped"`
+			Sharded        bool             `bson:"sharded"`
+		}{}
+		err := session.DB(t.Db).Run(bson.D{{Name: "collStats", Value: t.Table}}, &stats)
+		if err != nil {
+			info.Errors = append(info.Errors, fmt.Sprintf("collStats %s.%s: %s", t.Db, t.Table, err))
+			continue
+		}
+		info.Stats = &Stats{
+			Ns:             stats.Ns,
+			Count:          stats.Count,
+			Size:           stats.Size,
+			AvgObjSize:     stats.AvgObjSize,
+			StorageSize:    stats.StorageSize,
+			Nindexes:       stats.Nindexes,
+			TotalIndexSize: stats.TotalIndexSize,
+			IndexSizes:     stats.IndexSizes,
+			Capped:         stats.Capped,
+			Sharded:        stats.Sharded,
+		}
+	}
+	return res, nil
+}
+
+func (r Result) get(t proto.Table) *CollInfo {
+	ns := t.Db + "." + t.Table
+	info, ok := r[ns]
+	if !ok {
+		info = &CollInfo{}
+		r[ns] = info
+	}
+	return info
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package collinfo
+
+import (
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/query/plugin/mongo/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCollInfo(t *testing.T) {
+	t.Parallel()
+
+	s, err := session.Dial("127.0.0.1:27017")
+	require.NoError(t, err)
+	defer s.Close()
+
+	db := "test"
+	coll := "collinfo"
+	s.DB(db).C(coll).Insert(bson.M{"name": "Alicja"})
+	collections := []proto.Table{{Db: db, Table: coll}}
+
+	t.Run("IndexStats", func(t *testing.T) {
+		got, err := IndexStats(s, collections)
+		require.NoError(t, err)
+		info := got[db+"."+coll]
+		require.NotNil(t, info)
+		assert.Empty(t, info.Errors)
+		require.NotEmpty(t, info.Index)
+		assert.Equal(t, "_id_", info.Index[0].Name)
+	})
+
+	t.Run("CollStats", func(t *testing.T) {
+		got, err := CollStats(s, collections)
+		require.NoError(t, err)
+		info := got[db+"."+coll]
+		require.NotNil(t, info)
+		assert.Empty(t, info.Errors)
+		require.NotNil(t, info.Stats)
+		assert.Equal(t, db+"."+coll, info.Stats.Ns)
+		assert.Contains(t, info.Stats.IndexSizes, "_id_")
+	})
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package currentop
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/percona/pmgo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Query selects operations returned by CurrentOp.
+type Query struct {
+	UUID        string
+	Ns          string // db or db.collection, empty for all namespaces
+	MinSecs     int    // only operations running at least this long
+	IncludeIdle bool   // include idle connections and system operations
+}
+
+// Op describes one in-progress operation from `db.currentOp()`.
+type Op struct {
+	Opid             interface{} `bson:"opid"` // int, or "shard:opid" on mongos
+	Op               string      `bson:"op"`
+	Ns               string      `bson:"ns"`
+	Desc             string      `bson:"desc"`
+	Client           string      `bson:"client"`
+	Active           bool        `bson:"active"`
+	SecsRunning      int64       `bson:"secs_running"`
+	MicrosecsRunning int64       `bson:"microsecs_running"`
+	WaitingForLock   bool        `bson:"waitingForLock"`
+	NumYields        int64       `bson:"numYields"`
+	PlanSummary      string      `bson:"planSummary,omitempty" json:",omitempty"`
+	Msg              string      `bson:"msg,omitempty" json:",omitempty"`
+	Command          string      `bson:"-" json:",omitempty"` // JSON
+}
+
+// CurrentOp returns operations in progress matching q, longest running first.
+func CurrentOp(session pmgo.SessionManager, q Query) ([]Op, error) {
+	result := struct {
+		Inprog []bson.Raw `bson:"inprog"`
+	}{}
+	if err := session.DB("admin").Run(Cmd(q), &result); err != nil {
+		return nil, err
+	}
+
+	ops := make([]Op, 0, len(result.Inprog))
+	for _, raw := range result.Inprog {
+		op := Op{}
+		if err := raw.Unmarshal(&op); err != nil {
+			return nil, err
+		}
+		// Command is kept as extended JSON so all BSON types survive.
+		cmd := struct {
+			Command bson.M `bson:"command"`
+		}{}
+		if err := raw.Unmarshal(&cmd); err == nil && len(cmd.Command) > 0 {
+			if b, err := bson.MarshalJSON(cmd.Command); err == nil {
+				op.Command = string(b)
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].MicrosecsRunning > ops[j].MicrosecsRunning
+	})
+	return ops, nil
+}
+
+// Cmd returns the currentOp command with filters for q.
+func Cmd(q Query) bson.D {
+	cmd := bson.D{{Name: "currentOp", Value: 1}}
+	if q.IncludeIdle {
+		cmd = append(cmd, bson.DocElem{Name: "$all", Value: true})
+	} else {
+		cmd = append(cmd, bson.DocElem{Name: "active", Value: true})
+	}
+	if q.Ns != "" {
+		if strings.Contains(q.Ns, ".") {
+			cmd = append(cmd, bson.DocElem{Name: "ns", Value: q.Ns})
+		} else {
+			// Only db given, so match all of its collections.
+			re := bson.RegEx{Pattern: "^" + regexp.QuoteMeta(q.Ns) + `\.`}
+			cmd = append(cmd, bson.DocElem{Name: "ns", Value: re})
+		}
+	}
+	if q.MinSecs > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "secs_running", Value: bson.M{"$gte": q.MinSecs}})
+	}
+	return cmd
+}
@@ -0,0 +1,72 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package currentop
+
+import (
+	"testing"
+
+	"github.com/percona/qan-agent/query/plugin/mongo/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCmd(t *testing.T) {
+	t.Parallel()
+
+	fs := []struct {
+		q    Query
+		want bson.D
+	}{
+		{
+			Query{},
+			bson.D{{Name: "currentOp", Value: 1}, {Name: "active", Value: true}},
+		},
+		{
+			Query{IncludeIdle: true},
+			bson.D{{Name: "currentOp", Value: 1}, {Name: "$all", Value: true}},
+		},
+		{
+			Query{Ns: "test.col1", MinSecs: 5},
+			bson.D{{Name: "currentOp", Value: 1}, {Name: "active", Value: true}, {Name: "ns", Value: "test.col1"}, {Name: "secs_running", Value: bson.M{"$gte": 5}}},
+		},
+		{
+			Query{Ns: "test"},
+			bson.D{{Name: "currentOp", Value: 1}, {Name: "active", Value: true}, {Name: "ns", Value: bson.RegEx{Pattern: `^test\.`}}},
+		},
+	}
+	for _, f := range fs {
+		assert.Equal(t, f.want, Cmd(f.q), "%+v", f.q)
+	}
+}
+
+func TestCurrentOp(t *testing.T) {
+	t.Parallel()
+
+	s, err := session.Dial("127.0.0.1:27017")
+	require.NoError(t, err)
+	defer s.Close()
+
+	// currentOp reports itself, so there is always at least one active op.
+	ops, err := CurrentOp(s, Query{})
+	require.NoError(t, err)
+	require.NotEmpty(t, ops)
+	for _, op := range ops {
+		assert.True(t, op.Active)
+	}
+}
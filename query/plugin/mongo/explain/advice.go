@@ -0,0 +1,366 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Finding types
+const (
+	FindingCollScan      = "COLLSCAN"
+	FindingInMemorySort  = "SORT"
+	FindingExaminedRatio = "EXAMINED_RATIO"
+)
+
+const (
+	// MaxExaminedRatio is how many keys or documents can be examined per
+	// document returned before the plan is considered poor.
+	MaxExaminedRatio = 10
+	// MinExamined avoids flagging ratios on tiny collections.
+	MinExamined = 100
+)
+
+type Finding struct {
+	Type    string
+	Stage   string
+	Message string
+}
+
+// Index is a candidate compound index built with the equality, sort, range
+// rule from the query filter and sort.
+type Index struct {
+	Key     string // JSON, e.g. {"status":1,"created":-1}
+	Command string // mongo shell command to create the index
+}
+
+type Advice struct {
+	Findings []Finding `json:",omitempty"`
+	Indexes  []Index   `json:",omitempty"`
+}
+
+// Analyze inspects the winning plan and execution stats in result, which is
+// the output of the explain cmd for namespace ns, and suggests an index if
+// the plan is poor.
+func Analyze(ns string, cmd bson.D, result bson.D) Advice {
+	advice := Advice{}
+
+	for _, stage := range winningStages(result) {
+		switch get(stage, "stage") {
+		case "COLLSCAN":
+			advice.Findings = append(advice.Findings, Finding{
+				Type:    FindingCollScan,
+				Stage:   "COLLSCAN",
+				Message: fmt.Sprintf("collection scan of %s", ns),
+			})
+		case "SORT":
+			pattern, _ := bson.MarshalJSON(mproto.BsonD(doc(get(stage, "sortPattern"))))
+			advice.Findings = append(advice.Findings, Finding{
+				Type:    FindingInMemorySort,
+				Stage:   "SORT",
+				Message: fmt.Sprintf("in-memory sort on %s", strings.TrimSpace(string(pattern))),
+			})
+		}
+	}
+
+	if stats := executionStats(result); stats != nil {
+		returned := toInt64(get(stats, "nReturned"))
+		keys := toInt64(get(stats, "totalKeysExamined"))
+		docs := toInt64(get(stats, "totalDocsExamined"))
+		examined := keys
+		if docs > examined {
+			examined = docs
+		}
+		div := returned
+		if div < 1 {
+			div = 1
+		}
+		if examined >= MinExamined && examined/div > MaxExaminedRatio {
+			advice.Findings = append(advice.Findings, Finding{
+				Type:    FindingExaminedRatio,
+				Message: fmt.Sprintf("%d keys and %d documents examined to return %d documents", keys, docs, returned),
+			})
+		}
+	}
+
+	if len(advice.Findings) == 0 || len(cmd) == 0 {
+		return advice
+	}
+
+	filter, sortBy := filterAndSort(doc(cmd[0].Value))
+	key := candidateIndex(filter, sortBy)
+	if len(key) == 0 {
+		return advice
+	}
+	keyJson, err := bson.MarshalJSON(mproto.BsonD(key))
+	if err != nil {
+		return advice
+	}
+	k := strings.TrimSpace(string(keyJson))
+	dbColl := strings.SplitN(ns, ".", 2)
+	command := fmt.Sprintf("db.%s.createIndex(%s)", ns, k)
+	if len(dbColl) == 2 {
+		command = fmt.Sprintf("db.getSiblingDB(%q).getCollection(%q).createIndex(%s)", dbColl[0], dbColl[1], k)
+	}
+	advice.Indexes = append(advice.Indexes, Index{
+		Key:     k,
+		Command: command,
+	})
+
+	return advice
+}
+
+// winningStages returns all stages of the winning plan(s), including the
+// per-shard plans on mongos and the $cursor stage of aggregations.
+func winningStages(result bson.D) []bson.D {
+	planners := []bson.D{}
+	if qp := doc(get(result, "queryPlanner")); qp != nil {
+		planners = append(planners, qp)
+	}
+	for _, stage := range list(get(result, "stages")) {
+		if qp := doc(get(doc(get(doc(stage), "$cursor")), "queryPlanner")); qp != nil {
+			planners = append(planners, qp)
+		}
+	}
+
+	stages := []bson.D{}
+	var walk func(plan bson.D)
+	walk = func(plan bson.D) {
+		if plan == nil {
+			return
+		}
+		if get(plan, "stage") != nil {
+			stages = append(stages, plan)
+		}
+		walk(doc(get(plan, "inputStage")))
+		walk(doc(get(plan, "queryPlan"))) // slot based execution, 5.x
+		for _, s := range list(get(plan, "inputStages")) {
+			walk(doc(s))
+		}
+		for _, s := range list(get(plan, "shards")) {
+			walk(doc(get(doc(s), "winningPlan")))
+		}
+	}
+	for _, qp := range planners {
+		walk(doc(get(qp, "winningPlan")))
+	}
+	return stages
+}
+
+func executionStats(result bson.D) bson.D {
+	if stats := doc(get(result, "executionStats")); stats != nil {
+		return stats
+	}
+	for _, stage := range list(get(result, "stages")) {
+		if stats := doc(get(doc(get(doc(stage), "$cursor")), "executionStats")); stats != nil {
+			return stats
+		}
+	}
+	return nil
+}
+
+// filterAndSort returns the query filter and sort of the explained cmd.
+func filterAndSort(cmd bson.D) (filter bson.D, sortBy bson.D) {
+	if len(cmd) == 0 {
+		return nil, nil
+	}
+	switch cmd[0].Name {
+	case "find":
+		return doc(get(cmd, "filter")), doc(get(cmd, "sort"))
+	case "findAndModify", "findandmodify":
+		return doc(get(cmd, "query")), doc(get(cmd, "sort"))
+	case "count", "distinct":
+		return doc(get(cmd, "query")), nil
+	case "update":
+		updates := list(get(cmd, "updates"))
+		if len(updates) > 0 {
+			return doc(get(doc(updates[0]), "q")), nil
+		}
+	case "delete":
+		deletes := list(get(cmd, "deletes"))
+		if len(deletes) > 0 {
+			return doc(get(doc(deletes[0]), "q")), nil
+		}
+	case "aggregate":
+		pipeline := list(get(cmd, "pipeline"))
+		for i, stage := range pipeline {
+			if i > 1 {
+				break
+			}
+			s := doc(stage)
+			if len(s) == 0 {
+				break
+			}
+			switch s[0].Name {
+			case "$match":
+				filter = doc(s[0].Value)
+			case "$sort":
+				sortBy = doc(s[0].Value)
+			}
+		}
+	}
+	return filter, sortBy
+}
+
+// candidateIndex orders index keys by equality, sort, range (ESR).
+func candidateIndex(filter, sortBy bson.D) bson.D {
+	equality := []string{}
+	ranges := []string{}
+	var add func(filter bson.D)
+	add = func(filter bson.D) {
+		for _, e := range filter {
+			if e.Name == "$and" {
+				for _, f := range list(e.Value) {
+					add(doc(f))
+				}
+				continue
+			}
+			if strings.HasPrefix(e.Name, "$") {
+				// $or, $nor, $expr, $text, etc. need more than one index or none.
+				continue
+			}
+			if isEquality(e.Value) {
+				equality = append(equality, e.Name)
+			} else {
+				ranges = append(ranges, e.Name)
+			}
+		}
+	}
+	add(filter)
+
+	key := bson.D{}
+	seen := map[string]bool{}
+	for _, f := range equality {
+		if !seen[f] {
+			seen[f] = true
+			key = append(key, bson.DocElem{Name: f, Value: 1})
+		}
+	}
+	for _, e := range sortBy {
+		if !seen[e.Name] {
+			seen[e.Name] = true
+			dir := 1
+			if toInt64(e.Value) < 0 {
+				dir = -1
+			}
+			key = append(key, bson.DocElem{Name: e.Name, Value: dir})
+		}
+	}
+	for _, f := range ranges {
+		if !seen[f] {
+			seen[f] = true
+			key = append(key, bson.DocElem{Name: f, Value: 1})
+		}
+	}
+	return key
+}
+
+// isEquality returns true if a filter value matches a single value, i.e. it's
+// a literal or uses only $eq or $in.
+func isEquality(v interface{}) bool {
+	if _, ok := v.(bson.RegEx); ok {
+		return false
+	}
+	ops := doc(v)
+	if len(ops) == 0 || !strings.HasPrefix(ops[0].Name, "$") {
+		return true // literal value or embedded document
+	}
+	for _, op := range ops {
+		if op.Name != "$eq" && op.Name != "$in" {
+			return false
+		}
+	}
+	return true
+}
+
+// doc returns v as bson.D if v is a document, else nil. Documents decoded by
+// the toolkit and by mgo can be any of these types.
+func doc(v interface{}) bson.D {
+	switch d := v.(type) {
+	case bson.D:
+		return d
+	case mproto.BsonD:
+		return bson.D(d)
+	case bson.M:
+		return fromMap(d)
+	case map[string]interface{}:
+		return fromMap(d)
+	}
+	return nil
+}
+
+func fromMap(m map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := make(bson.D, 0, len(m))
+	for _, k := range keys {
+		d = append(d, bson.DocElem{Name: k, Value: m[k]})
+	}
+	return d
+}
+
+func list(v interface{}) []interface{} {
+	switch l := v.(type) {
+	case []interface{}:
+		return l
+	case []mproto.BsonD:
+		res := make([]interface{}, len(l))
+		for i := range l {
+			res[i] = l[i]
+		}
+		return res
+	case []bson.D:
+		res := make([]interface{}, len(l))
+		for i := range l {
+			res[i] = l[i]
+		}
+		return res
+	}
+	return nil
+}
+
+func get(d bson.D, name string) interface{} {
+	for _, e := range d {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"testing"
+
+	mproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+
+	fs := []struct {
+		name     string
+		cmd      string
+		result   string
+		findings []string
+		index    string
+	}{
+		{
+			name: "collscan",
+			cmd:  `{"explain":{"find":"col1","filter":{"status":"A","qty":{"$gt":5}},"sort":{"created":-1}}}`,
+			result: `{
+				"queryPlanner":{"winningPlan":{"stage":"SORT","sortPattern":{"created":-1},
+					"inputStage":{"stage":"COLLSCAN","filter":{}}}},
+				"executionStats":{"nReturned":2,"totalKeysExamined":0,"totalDocsExamined":5000}
+			}`,
+			findings: []string{FindingInMemorySort, FindingCollScan, FindingExaminedRatio},
+			index:    `{"status":1,"created":-1,"qty":1}`,
+		},
+		{
+			name: "ixscan",
+			cmd:  `{"explain":{"find":"col1","filter":{"status":"A"}}}`,
+			result: `{
+				"queryPlanner":{"winningPlan":{"stage":"FETCH","inputStage":{"stage":"IXSCAN"}}},
+				"executionStats":{"nReturned":100,"totalKeysExamined":100,"totalDocsExamined":100}
+			}`,
+		},
+		{
+			name: "sharded aggregate",
+			cmd:  `{"explain":{"aggregate":"col1","pipeline":[{"$match":{"$and":[{"a":1},{"b":{"$in":[1,2]}}],"c":{"$exists":true}}},{"$sort":{"d":1}}]}}`,
+			result: `{
+				"queryPlanner":{"winningPlan":{"stage":"SHARD_MERGE","shards":[
+					{"shardName":"rs1","winningPlan":{"stage":"COLLSCAN"}}
+				]}}
+			}`,
+			findings: []string{FindingCollScan},
+			index:    `{"a":1,"b":1,"d":1,"c":1}`,
+		},
+	}
+	for _, f := range fs {
+		var cmd, result mproto.BsonD
+		require.NoError(t, bson.UnmarshalJSON([]byte(f.cmd), &cmd), f.name)
+		require.NoError(t, bson.UnmarshalJSON([]byte(f.result), &result), f.name)
+
+		advice := Analyze("test.col1", bson.D(cmd), bson.D(result))
+		findings := []string{}
+		for _, finding := range advice.Findings {
+			findings = append(findings, finding.Type)
+		}
+		assert.ElementsMatch(t, f.findings, findings, f.name)
+
+		if f.index == "" {
+			assert.Empty(t, advice.Indexes, f.name)
+			continue
+		}
+		require.Len(t, advice.Indexes, 1, f.name)
+		assert.Equal(t, f.index, advice.Indexes[0].Key, f.name)
+		assert.Equal(t, `db.getSiblingDB("test").getCollection("col1").createIndex(`+f.index+`)`, advice.Indexes[0].Command, f.name)
+	}
+}
@@ -18,48 +18,176 @@
 package explain
 
 import (
+	"context"
+	"fmt"
 	"time"
 
-	"github.com/percona/percona-toolkit/src/go/mongolib/explain"
-	"github.com/percona/pmgo"
+	mproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	"github.com/percona/pmm/proto"
-	"gopkg.in/mgo.v2"
+	"github.com/percona/qan-agent/query/plugin/mongo/session"
+	"gopkg.in/mgo.v2/bson"
 )
 
+// Explain verbosity modes, see https://docs.mongodb.com/manual/reference/command/explain/
 const (
-	MgoTimeoutDialInfo      = 5 * time.Second
-	MgoTimeoutSessionSync   = 5 * time.Second
-	MgoTimeoutSessionSocket = 5 * time.Second
+	VerbosityQueryPlanner      = "queryPlanner"
+	VerbosityExecutionStats    = "executionStats"
+	VerbosityAllPlansExecution = "allPlansExecution"
 )
 
-func Explain(dsn, db, query string) (*proto.ExplainResult, error) {
-	// if dsn is incorrect we should exit immediately as this is not gonna correct itself
-	dialInfo, err := pmgo.ParseURL(dsn)
+const (
+	// DefaultMaxTimeMS is used when Query.MaxTimeMS is not set.
+	DefaultMaxTimeMS = 3000
+	// MaxMaxTimeMS caps Query.MaxTimeMS because executionStats and
+	// allPlansExecution run the query to completion.
+	MaxMaxTimeMS = 10000
+)
+
+// Query is proto.ExplainQuery with explain options. It decodes from the same
+// cmd data as proto.ExplainQuery, so the options are optional.
+type Query struct {
+	proto.ExplainQuery
+	Verbosity  string // default is server default, i.e. allPlansExecution
+	MaxTimeMS  int64  // default DefaultMaxTimeMS, at most MaxMaxTimeMS
+	AllowWrite bool   // allow explain of insert, update, delete, etc. with an execution verbosity
+}
+
+// Result is proto.ExplainResult with the advice from Analyze.
+type Result struct {
+	proto.ExplainResult
+	Advice Advice
+}
+
+// WriteNotAllowedError is returned when explain with an explicit execution
+// verbosity would execute a write command but Query.AllowWrite is not set.
+type WriteNotAllowedError string
+
+func (e WriteNotAllowedError) Error() string {
+	return fmt.Sprintf("explain of write command %s with verbosity other than %s is not allowed", string(e), VerbosityQueryPlanner)
+}
+
+func Explain(ctx context.Context, dsn string, q Query) (*Result, error) {
+	var eq mproto.ExampleQuery
+	if err := bson.UnmarshalJSON([]byte(q.Query), &eq); err != nil {
+		return nil, fmt.Errorf("explain: unable to decode query %s: %s", q.Query, err)
+	}
+
+	db := q.Db
+	if db == "" {
+		db = eq.Db()
+	}
+
+	cmd, maxTime, err := explainCmd(eq, q)
 	if err != nil {
 		return nil, err
 	}
-	dialer := pmgo.NewDialer()
 
-	dialInfo.Timeout = MgoTimeoutDialInfo
-	// Disable automatic replicaSet detection, connect directly to specified server
-	dialInfo.Direct = true
-	session, err := dialer.DialWithInfo(dialInfo)
+	s, err := session.DialContext(ctx, dsn)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
-	session.SetMode(mgo.Eventual, true)
-	session.SetSyncTimeout(MgoTimeoutSessionSync)
-	session.SetSocketTimeout(MgoTimeoutSessionSocket)
+	defer s.Close()
+	if maxTime > 0 {
+		// Let the server hit maxTimeMS before the client gives up.
+		s.SetSocketTimeout(maxTime + session.MgoTimeoutSessionSocket)
+	}
 
-	ex := explain.New(session)
-	resultJson, err := ex.Explain(db, []byte(query))
+	// mgo can't cancel a command, so stop waiting for it when the ctx is
+	// done, and let the deferred Close abort it.
+	var result mproto.BsonD
+	ran := make(chan error, 1)
+	go func() {
+		ran <- s.DB(db).Run(cmd, &result)
+	}()
+	select {
+	case err := <-ran:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	resultJson, err := bson.MarshalJSON(result)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("explain: unable to encode explain result of %s: %s", q.Query, err)
 	}
 
-	explainResult := &proto.ExplainResult{
-		JSON: string(resultJson),
+	explainResult := &Result{
+		ExplainResult: proto.ExplainResult{
+			JSON: string(resultJson),
+		},
+		Advice: Analyze(eq.Ns, cmd, bson.D(result)),
 	}
 	return explainResult, nil
 }
+
+// explainCmd returns the explain command for eq with the verbosity, write
+// guard and maxTimeMS of q applied. maxTime is zero if explain doesn't
+// execute the query.
+func explainCmd(eq mproto.ExampleQuery, q Query) (bson.D, time.Duration, error) {
+	switch q.Verbosity {
+	case "", VerbosityQueryPlanner, VerbosityExecutionStats, VerbosityAllPlansExecution:
+	default:
+		return nil, 0, fmt.Errorf("invalid verbosity %s", q.Verbosity)
+	}
+
+	cmd := eq.ExplainCmd()
+	if q.Verbosity != "" {
+		cmd = append(cmd, bson.DocElem{Name: "verbosity", Value: q.Verbosity})
+	}
+	inner := doc(cmd[0].Value)
+	if q.Verbosity == VerbosityQueryPlanner || len(inner) == 0 {
+		// Only plans the query, nothing is executed.
+		return cmd, 0, nil
+	}
+	name := inner[0].Name
+
+	// Without a verbosity, explain runs as it always did: only explicit
+	// execution modes are guarded.
+	if q.Verbosity != "" && isWrite(name, inner) && !q.AllowWrite {
+		return nil, 0, WriteNotAllowedError(name)
+	}
+
+	maxTimeMS := q.MaxTimeMS
+	if maxTimeMS <= 0 {
+		maxTimeMS = DefaultMaxTimeMS
+	}
+	if maxTimeMS > MaxMaxTimeMS {
+		maxTimeMS = MaxMaxTimeMS
+	}
+	switch name {
+	case "find", "aggregate", "count", "distinct", "findAndModify", "findandmodify":
+		inner = set(inner, "maxTimeMS", maxTimeMS)
+	}
+	cmd[0].Value = inner
+
+	return cmd, time.Duration(maxTimeMS) * time.Millisecond, nil
+}
+
+func isWrite(name string, cmd bson.D) bool {
+	switch name {
+	case "insert", "update", "delete", "findAndModify", "findandmodify":
+		return true
+	case "aggregate":
+		for _, stage := range list(get(cmd, "pipeline")) {
+			for _, e := range doc(stage) {
+				if e.Name == "$out" || e.Name == "$merge" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// set sets name to value in d, or appends it if not found.
+func set(d bson.D, name string, value interface{}) bson.D {
+	for i := range d {
+		if d[i].Name == name {
+			d[i].Value = value
+			return d
+		}
+	}
+	return append(d, bson.DocElem{Name: name, Value: value})
+}
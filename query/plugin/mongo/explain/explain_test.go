@@ -18,8 +18,11 @@
 package explain
 
 import (
+	"context"
 	"testing"
 
+	mproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmm/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/mgo.v2/bson"
@@ -35,7 +38,7 @@ func TestExplain(t *testing.T) {
 	db := "test"
 	query := `{"ns":"test.col1","op":"query","query":{"find":"col1","filter":{"name":"Alicja"}}}`
 
-	explainResult, err := Explain(dsn, db, query)
+	explainResult, err := Explain(context.Background(), dsn, newQuery(db, query))
 	require.NoError(t, err)
 
 	got := bson.M{}
@@ -60,8 +63,81 @@ func TestExplainDecodeQueryError(t *testing.T) {
 	db := "test"
 	query := `{Jas`
 
-	explainResult, err := Explain(dsn, db, query)
+	explainResult, err := Explain(context.Background(), dsn, newQuery(db, query))
 	assert.Nil(t, explainResult)
 	assert.Error(t, err)
 	assert.Equal(t, "explain: unable to decode query {Jas: unexpected EOF", err.Error())
 }
+
+func TestExplainVerbosity(t *testing.T) {
+	t.Parallel()
+
+	dsn := "127.0.0.1:27017"
+
+	q := newQuery("test", `{"ns":"test.col1","op":"query","query":{"find":"col1","filter":{"name":"Alicja"}}}`)
+	q.Verbosity = VerbosityQueryPlanner
+	explainResult, err := Explain(context.Background(), dsn, q)
+	require.NoError(t, err)
+
+	got := bson.M{}
+	err = bson.UnmarshalJSON([]byte(explainResult.JSON), &got)
+	require.NoError(t, err)
+	assert.NotEmpty(t, got["queryPlanner"])
+	assert.Empty(t, got["executionStats"])
+}
+
+func TestExplainCmd(t *testing.T) {
+	t.Parallel()
+
+	find := `{"ns":"test.col1","op":"query","query":{"find":"col1","filter":{"name":"Alicja"}}}`
+	update := `{"ns":"test.col1","op":"update","query":{"name":"Alicja"},"updateobj":{"$set":{"name":"Ala"}}}`
+	out := `{"ns":"test.col1","op":"command","command":{"aggregate":"col1","pipeline":[{"$match":{"a":1}},{"$out":"col2"}]}}`
+
+	fs := []struct {
+		query     string
+		verbosity string
+		allow     bool
+		maxTimeMS int64
+		err       error
+		wantMax   interface{}
+	}{
+		{find, "", false, 0, nil, int64(DefaultMaxTimeMS)},
+		{find, VerbosityExecutionStats, false, 60000, nil, int64(MaxMaxTimeMS)},
+		{find, VerbosityQueryPlanner, false, 0, nil, nil},
+		{update, "", false, 0, nil, nil},
+		{update, VerbosityExecutionStats, false, 0, WriteNotAllowedError("update"), nil},
+		{update, VerbosityQueryPlanner, false, 0, nil, nil},
+		{update, VerbosityExecutionStats, true, 0, nil, nil},
+		{out, VerbosityAllPlansExecution, false, 0, WriteNotAllowedError("aggregate"), nil},
+	}
+	for _, f := range fs {
+		var eq mproto.ExampleQuery
+		require.NoError(t, bson.UnmarshalJSON([]byte(f.query), &eq))
+		q := newQuery("test", f.query)
+		q.Verbosity = f.verbosity
+		q.AllowWrite = f.allow
+		q.MaxTimeMS = f.maxTimeMS
+
+		cmd, _, err := explainCmd(eq, q)
+		assert.Equal(t, f.err, err, f.query)
+		if err != nil {
+			continue
+		}
+		if f.verbosity != "" {
+			assert.Equal(t, f.verbosity, get(cmd, "verbosity"))
+		}
+		assert.Equal(t, f.wantMax, get(doc(cmd[0].Value), "maxTimeMS"), f.query)
+	}
+
+	_, _, err := explainCmd(mproto.ExampleQuery{}, Query{Verbosity: "foo"})
+	assert.Error(t, err)
+}
+
+func newQuery(db, query string) Query {
+	return Query{
+		ExplainQuery: proto.ExplainQuery{
+			Db:    db,
+			Query: query,
+		},
+	}
+}
@@ -18,11 +18,16 @@
 package mongo
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/query/plugin"
+	"github.com/percona/qan-agent/query/plugin/mongo/collinfo"
+	"github.com/percona/qan-agent/query/plugin/mongo/currentop"
 	"github.com/percona/qan-agent/query/plugin/mongo/explain"
+	"github.com/percona/qan-agent/query/plugin/mongo/serverstatus"
+	"github.com/percona/qan-agent/query/plugin/mongo/session"
 	"github.com/percona/qan-agent/query/plugin/mongo/summary"
 )
 
@@ -32,8 +37,12 @@ var _ plugin.Plugin = (*Mongo)(nil)
 var (
 	// available cmds
 	cmds = map[string]execFunc{
-		"Explain": execExplain,
-		"Summary": execSummary,
+		"Explain":      execExplain,
+		"Summary":      execSummary,
+		"CurrentOp":    execCurrentOp,
+		"IndexStats":   execIndexStats,
+		"CollStats":    execCollStats,
+		"ServerStatus": execServerStatus,
 	}
 )
 
@@ -50,26 +59,86 @@ func New() *Mongo {
 }
 
 // Handle executes cmd for given instance and returns resulting data
-func (m *Mongo) Handle(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+func (m *Mongo) Handle(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 	c, ok := m.cmds[cmd.Cmd]
 	if !ok {
 		return nil, plugin.UnknownCmdError(cmd.Cmd)
 	}
 
-	return c(cmd, in)
+	return c(ctx, cmd, in)
 }
 
-type execFunc func(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
+type execFunc func(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error)
 
-func execExplain(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	q := &proto.ExplainQuery{}
-	if err := json.Unmarshal(cmd.Data, q); err != nil {
+func execExplain(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := explain.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
 		return nil, err
 	}
 
-	return explain.Explain(in.DSN, q.Db, q.Query)
+	return explain.Explain(ctx, in.DSN, q)
 }
 
-func execSummary(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	return summary.Summary(in.DSN)
+func execSummary(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	return summary.Summary(ctx, in.DSN)
+}
+
+func execCurrentOp(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := currentop.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	s, err := session.DialContext(ctx, in.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return currentop.CurrentOp(s, q)
+}
+
+func execIndexStats(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := collinfo.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	s, err := session.DialContext(ctx, in.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return collinfo.IndexStats(s, q.Collections)
+}
+
+func execCollStats(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := collinfo.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	s, err := session.DialContext(ctx, in.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return collinfo.CollStats(s, q.Collections)
+}
+
+func execServerStatus(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := serverstatus.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	s, err := session.DialContext(ctx, in.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return serverstatus.ServerStatus(s, q)
 }
@@ -18,10 +18,12 @@
 package mongo
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/query/plugin/mongo/explain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/mgo.v2/bson"
@@ -84,7 +86,7 @@ func TestHandle(t *testing.T) {
 				require.NoError(t, err)
 
 				// unpack data
-				explainResult := data.(*proto.ExplainResult)
+				explainResult := data.(*explain.Result)
 				got := bson.M{}
 				err = bson.UnmarshalJSON([]byte(explainResult.JSON), &got)
 
@@ -98,6 +100,6 @@ func TestHandle(t *testing.T) {
 		},
 	}
 	for _, f := range fs {
-		f.test(m.Handle(f.cmd, f.in))
+		f.test(m.Handle(context.Background(), f.cmd, f.in))
 	}
 }
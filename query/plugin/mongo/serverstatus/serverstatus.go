@@ -0,0 +1,76 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package serverstatus
+
+import (
+	"encoding/json"
+
+	"github.com/percona/pmgo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultSections are returned when Query.Sections is empty. Big sections
+// like wiredTiger and metrics must be asked for explicitly.
+var DefaultSections = []string{
+	"host",
+	"version",
+	"process",
+	"uptime",
+	"connections",
+	"opcounters",
+	"opcountersRepl",
+	"globalLock",
+	"mem",
+	"network",
+	"repl",
+	"storageEngine",
+}
+
+// Query selects top-level sections of `serverStatus`.
+type Query struct {
+	UUID     string
+	Sections []string
+}
+
+// ServerStatus returns the selected sections as extended JSON, keyed on
+// section name. Sections the server doesn't report are omitted.
+func ServerStatus(session pmgo.SessionManager, q Query) (map[string]json.RawMessage, error) {
+	sections := q.Sections
+	if len(sections) == 0 {
+		sections = DefaultSections
+	}
+
+	status := bson.M{}
+	if err := session.DB("admin").Run(bson.D{{Name: "serverStatus", Value: 1}}, &status); err != nil {
+		return nil, err
+	}
+
+	res := map[string]json.RawMessage{}
+	for _, name := range sections {
+		v, ok := status[name]
+		if !ok {
+			continue
+		}
+		b, err := bson.MarshalJSON(v)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = json.RawMessage(b)
+	}
+	return res, nil
+}
@@ -0,0 +1,40 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package serverstatus
+
+import (
+	"testing"
+
+	"github.com/percona/qan-agent/query/plugin/mongo/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerStatus(t *testing.T) {
+	t.Parallel()
+
+	s, err := session.Dial("127.0.0.1:27017")
+	require.NoError(t, err)
+	defer s.Close()
+
+	got, err := ServerStatus(s, Query{Sections: []string{"connections", "opcounters", "doesNotExist"}})
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+	assert.Contains(t, string(got["connections"]), "current")
+	assert.Contains(t, string(got["opcounters"]), "query")
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/percona/pmgo"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	MgoTimeoutDialInfo      = 5 * time.Second
+	MgoTimeoutSessionSync   = 5 * time.Second
+	MgoTimeoutSessionSocket = 5 * time.Second
+)
+
+// Dial connects directly to the server given by dsn, without replica set
+// discovery, so cmds always run against the instance they were sent for.
+func Dial(dsn string) (pmgo.SessionManager, error) {
+	return DialContext(context.Background(), dsn)
+}
+
+// DialContext is Dial which gives up when the ctx is done. mgo can't cancel a
+// dial, so the session is closed when the dial returns.
+func DialContext(ctx context.Context, dsn string) (pmgo.SessionManager, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// if dsn is incorrect we should exit immediately as this is not gonna correct itself
+	dialInfo, err := pmgo.ParseURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	dialer := pmgo.NewDialer()
+
+	dialInfo.Timeout = MgoTimeoutDialInfo
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < dialInfo.Timeout {
+		dialInfo.Timeout = time.Until(deadline)
+	}
+	// Disable automatic replicaSet detection, connect directly to specified server
+	dialInfo.Direct = true
+
+	type dialResult struct {
+		session pmgo.SessionManager
+		err     error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		session, err := dialer.DialWithInfo(dialInfo)
+		dialed <- dialResult{session, err}
+	}()
+	var session pmgo.SessionManager
+	select {
+	case res := <-dialed:
+		if res.err != nil {
+			return nil, res.err
+		}
+		session = res.session
+	case <-ctx.Done():
+		go func() {
+			if res := <-dialed; res.err == nil {
+				res.session.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+	session.SetMode(mgo.Eventual, true)
+	session.SetSyncTimeout(MgoTimeoutSessionSync)
+	session.SetSocketTimeout(MgoTimeoutSessionSocket)
+
+	return session, nil
+}
@@ -0,0 +1,44 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialContext(t *testing.T) {
+	t.Parallel()
+
+	// Nothing listens there, so without the ctx Dial gives up after MgoTimeoutDialInfo.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	s, err := DialContext(ctx, "mongodb://127.0.0.1:1")
+	assert.Error(t, err)
+	assert.Nil(t, s)
+	assert.True(t, time.Since(start) < MgoTimeoutDialInfo, "dial didn't give up when ctx was done")
+
+	// A done ctx gives up at once.
+	cancel()
+	_, err = DialContext(ctx, "mongodb://127.0.0.1:1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -18,12 +18,14 @@
 package summary
 
 import (
+	"context"
+
 	"github.com/percona/pmgo"
 	"github.com/percona/qan-agent/pct/cmd"
 )
 
 // Summary executes `pt-mongodb-summary` for given dsn
-func Summary(dsn string) (string, error) {
+func Summary(ctx context.Context, dsn string) (string, error) {
 	dialInfo, err := pmgo.ParseURL(dsn)
 	if err != nil {
 		return "", err
@@ -37,7 +39,7 @@ func Summary(dsn string) (string, error) {
 	// add host[:port] e.g. `pt-mongodb-summary localhost:27017`
 	args = append(args, addrArgs(dialInfo.Addrs)...)
 
-	return cmd.NewRealCmd(name, args...).Run()
+	return cmd.NewRealCmd(name, args...).RunContext(ctx)
 }
 
 // authArgs returns authentication arguments for cmd
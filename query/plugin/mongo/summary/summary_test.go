@@ -18,6 +18,7 @@
 package summary
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,7 +29,7 @@ func TestSummary(t *testing.T) {
 
 	dsn := "127.0.0.1:27017"
 
-	output, err := Summary(dsn)
+	output, err := Summary(context.Background(), dsn)
 	assert.NoError(t, err)
 
 	assert.Regexp(t, "# Instances #", output)
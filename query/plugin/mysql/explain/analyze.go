@@ -0,0 +1,273 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+)
+
+const (
+	// DefaultMaxExecutionTime is used when Query.MaxExecutionTime is not set.
+	DefaultMaxExecutionTime = 5000 // ms
+	// MaxMaxExecutionTime caps Query.MaxExecutionTime because EXPLAIN ANALYZE
+	// runs the query, and cmds time out after 1 minute.
+	MaxMaxExecutionTime = 30000 // ms
+)
+
+// Version constraints of the opt-in explain cmds.
+const (
+	ExplainAnalyzeVersion   = ">= 8.0.18, < 10.0.0"
+	ExplainTreeVersion      = ">= 8.0.16, < 10.0.0"
+	OptimizerTraceVersion   = ">= 5.6.5, < 10.0.0 || >= 10.4.3"
+	maxExecutionTimeVersion = ">= 5.7.8, < 10.0.0"
+)
+
+var ErrNotSelect = errors.New("only SELECT statements without locking reads or INTO can be explained with this cmd")
+
+var (
+	selectRe       = regexp.MustCompile(`(?is)^\s*(?:\(\s*)*select\b`)
+	withRe         = regexp.MustCompile(`(?is)^\s*(?:\(\s*)*with\s+(?:recursive\s+)?`)
+	cteNameRe      = regexp.MustCompile("(?s)^\\s*(?:`[^`]+`|\\w+)\\s*")
+	cteAsRe        = regexp.MustCompile(`(?is)^\s*as\s*`)
+	cteNextRe      = regexp.MustCompile(`(?s)^\s*,`)
+	lockingReadsRe = regexp.MustCompile(`(?is)\bfor\s+(?:update|share)\b|\block\s+in\s+share\s+mode\b|\binto\s+(?:outfile|dumpfile|@)`)
+)
+
+// Query is the data of the ExplainAnalyze, ExplainTree and OptimizerTrace cmds.
+type Query struct {
+	proto.ExplainQuery
+	MaxExecutionTime int64 // ms, default DefaultMaxExecutionTime, at most MaxMaxExecutionTime
+}
+
+// TreeResult is the output of EXPLAIN ANALYZE or EXPLAIN FORMAT=TREE.
+type TreeResult struct {
+	Tree string
+}
+
+// TraceResult is the row from INFORMATION_SCHEMA.OPTIMIZER_TRACE.
+type TraceResult struct {
+	Query                        string
+	Trace                        string // JSON
+	MissingBytesBeyondMaxMemSize int64
+	InsufficientPrivileges       bool
+}
+
+// ExplainAnalyze runs EXPLAIN ANALYZE, which executes the query, in a read-only
+// transaction with max_execution_time.
+func ExplainAnalyze(ctx context.Context, c mysql.Connector, q Query) (*TreeResult, error) {
+	res := &TreeResult{}
+	err := readOnly(ctx, c, q, ExplainAnalyzeVersion, "EXPLAIN ANALYZE", func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, "EXPLAIN ANALYZE "+q.Query).Scan(&res.Tree)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExplainTree runs EXPLAIN FORMAT=TREE in a read-only transaction.
+func ExplainTree(ctx context.Context, c mysql.Connector, q Query) (*TreeResult, error) {
+	res := &TreeResult{}
+	err := readOnly(ctx, c, q, ExplainTreeVersion, "EXPLAIN FORMAT=TREE", func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, "EXPLAIN FORMAT=TREE "+q.Query).Scan(&res.Tree)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// OptimizerTrace captures the optimizer trace of EXPLAIN for the query, so the
+// query itself is not executed.
+func OptimizerTrace(ctx context.Context, c mysql.Connector, q Query) (*TraceResult, error) {
+	res := &TraceResult{}
+	err := readOnly(ctx, c, q, OptimizerTraceVersion, "optimizer_trace", func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SET SESSION optimizer_trace='enabled=on', optimizer_trace_offset=-1, optimizer_trace_limit=1"); err != nil {
+			return err
+		}
+		defer tx.ExecContext(ctx, "SET SESSION optimizer_trace='enabled=off'")
+
+		rows, err := tx.QueryContext(ctx, "EXPLAIN "+q.Query)
+		if err != nil {
+			return err
+		}
+		// Trace is complete only after all rows are read.
+		for rows.Next() {
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(ctx,
+			"SELECT QUERY, TRACE, MISSING_BYTES_BEYOND_MAX_MEM_SIZE, INSUFFICIENT_PRIVILEGES"+
+				" FROM INFORMATION_SCHEMA.OPTIMIZER_TRACE",
+		).Scan(&res.Query, &res.Trace, &res.MissingBytesBeyondMaxMemSize, &res.InsufficientPrivileges)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// IsSelect returns true if query is a SELECT which neither locks rows nor
+// writes its result anywhere.
+func IsSelect(query string) bool {
+	stmt := query
+	if loc := withRe.FindStringIndex(query); loc != nil {
+		// WITH ... UPDATE and WITH ... DELETE are writes too.
+		var ok bool
+		if stmt, ok = skipCTEs(query[loc[1]:]); !ok {
+			return false
+		}
+	}
+	return selectRe.MatchString(stmt) && !lockingReadsRe.MatchString(query)
+}
+
+// skipCTEs returns the statement after the common table expressions of a
+// WITH clause, e.g. "SELECT * FROM c" for "c (a) AS (SELECT 1) SELECT * FROM
+// c". It returns false if the CTEs can't be parsed.
+func skipCTEs(s string) (string, bool) {
+	for {
+		loc := cteNameRe.FindStringIndex(s)
+		if loc == nil {
+			return "", false
+		}
+		s = s[loc[1]:]
+		if strings.HasPrefix(s, "(") { // column list
+			n := skipParens(s)
+			if n < 0 {
+				return "", false
+			}
+			s = s[n:]
+		}
+		loc = cteAsRe.FindStringIndex(s)
+		if loc == nil || !strings.HasPrefix(s[loc[1]:], "(") {
+			return "", false
+		}
+		n := skipParens(s[loc[1]:])
+		if n < 0 {
+			return "", false
+		}
+		s = s[loc[1]+n:]
+		loc = cteNextRe.FindStringIndex(s)
+		if loc == nil {
+			return s, true
+		}
+		s = s[loc[1]:]
+	}
+}
+
+// skipParens returns the length of the parenthesized prefix of s, which
+// starts with "(", skipping quoted strings and identifiers, or -1 if the
+// parentheses aren't balanced.
+func skipParens(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' && quote != '`' {
+				i++ // escaped char
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// readOnly checks the query and version, and calls f in a read-only
+// transaction with the default db of q and max_execution_time set.
+func readOnly(ctx context.Context, c mysql.Connector, q Query, constraint, what string, f func(tx *sql.Tx) error) error {
+	if strings.TrimSpace(q.Query) == "" {
+		return fmt.Errorf("cannot run %s on an empty query example", what)
+	}
+	if isDMLQuery(q.Query) || !IsSelect(q.Query) {
+		return ErrNotSelect
+	}
+
+	ok, err := c.VersionConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s requires MySQL %s", what, constraint)
+	}
+
+	maxTime := q.MaxExecutionTime
+	if maxTime <= 0 {
+		maxTime = DefaultMaxExecutionTime
+	}
+	if maxTime > MaxMaxExecutionTime {
+		maxTime = MaxMaxExecutionTime
+	}
+
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Transaction because we need to ensure USE, SET and EXPLAIN are run in one connection.
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if q.Db != "" {
+		db := q.Db
+		if !strings.HasPrefix(db, "`") {
+			db = "`" + db + "`"
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("USE %s", db)); err != nil {
+			return err
+		}
+	}
+
+	if ok, _ := c.VersionConstraint(maxExecutionTimeVersion); ok {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET SESSION max_execution_time=%d", maxTime)); err != nil {
+			return err
+		}
+		// Reset before Rollback returns the connection to the pool.
+		defer tx.ExecContext(ctx, "SET SESSION max_execution_time=DEFAULT")
+	}
+
+	return f(tx)
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSelect(t *testing.T) {
+	t.Parallel()
+
+	fs := map[string]bool{
+		"SELECT 1":                      true,
+		"  select * from t where a = 1": true,
+		"(SELECT a FROM t1) UNION (SELECT a FROM t2)": true,
+		"WITH cte AS (SELECT 1) SELECT * FROM cte":    true,
+		"SELECT * FROM t FOR UPDATE":                  false,
+		"SELECT * FROM t FOR SHARE":                   false,
+		"SELECT * FROM t LOCK IN SHARE MODE":          false,
+		"SELECT * INTO OUTFILE '/tmp/x' FROM t":       false,
+		"SELECT 1 INTO @a":                            false,
+		"UPDATE t SET a = 1":                          false,
+		"DELETE FROM t":                               false,
+		"INSERT INTO t SELECT * FROM t2":              false,
+		"SET GLOBAL read_only=1":                      false,
+
+		// The statement after the CTEs must be a SELECT.
+		"WITH RECURSIVE c (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM c WHERE n < 5) SELECT * FROM c": true,
+		"WITH a AS (SELECT ')'), `b c` AS (SELECT * FROM a) (SELECT * FROM `b c`)":                     true,
+		"WITH cte AS (SELECT 1) UPDATE t, cte SET t.a = 1":                                             false,
+		"WITH cte AS (SELECT id FROM t2) DELETE FROM t WHERE id IN (SELECT id FROM cte)":               false,
+		"WITH cte AS (SELECT * FROM t FOR UPDATE) SELECT * FROM cte":                                   false,
+		"WITH cte AS (SELECT 1 SELECT * FROM cte":                                                      false,
+	}
+	for query, want := range fs {
+		assert.Equal(t, want, IsSelect(query), query)
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+	conn := mysql.NewConnection(dsn)
+	require.NoError(t, conn.Connect())
+	defer conn.Close()
+
+	q := Query{
+		ExplainQuery: proto.ExplainQuery{
+			Db:    "mysql",
+			Query: "SELECT * FROM user WHERE User = 'root'",
+		},
+	}
+
+	dml := q
+	dml.Query = "DELETE FROM user"
+	_, err := ExplainAnalyze(context.Background(), conn, dml)
+	assert.Equal(t, ErrNotSelect, err)
+	_, err = OptimizerTrace(context.Background(), conn, dml)
+	assert.Equal(t, ErrNotSelect, err)
+
+	ok, err := conn.VersionConstraint(ExplainAnalyzeVersion)
+	require.NoError(t, err)
+	res, err := ExplainAnalyze(context.Background(), conn, q)
+	if ok {
+		require.NoError(t, err)
+		assert.Contains(t, res.Tree, "actual time=")
+	} else {
+		assert.Error(t, err)
+	}
+
+	ok, err = conn.VersionConstraint(ExplainTreeVersion)
+	require.NoError(t, err)
+	res, err = ExplainTree(context.Background(), conn, q)
+	if ok {
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(res.Tree, "->"), res.Tree)
+	} else {
+		assert.Error(t, err)
+	}
+
+	ok, err = conn.VersionConstraint(OptimizerTraceVersion)
+	require.NoError(t, err)
+	trace, err := OptimizerTrace(context.Background(), conn, q)
+	if ok {
+		require.NoError(t, err)
+		assert.Contains(t, trace.Query, q.Query)
+		assert.Contains(t, trace.Trace, "join_optimization")
+	} else {
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,467 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/query/plugin/mysql/tableinfo"
+)
+
+// Sources of synthesized placeholder values
+const (
+	ValueFromSample  = "sample"  // a value read from the column
+	ValueFromType    = "type"    // a value plausible for the column type
+	ValueFromDefault = "default" // column unknown, e.g. placeholder in a function call
+)
+
+const (
+	defaultLimit = "10"
+	defaultValue = "1"
+)
+
+// SynthesizedResult is EXPLAIN of a query made from DIGEST_TEXT by replacing
+// placeholders with made up values. It's only an approximation of the plan of
+// real queries, which can have different values and so different plans.
+type SynthesizedResult struct {
+	*proto.ExplainResult
+	Synthesized bool   // always true
+	Query       string // query that was explained
+	Values      []Placeholder
+}
+
+// Placeholder describes the value which replaced a ? in DIGEST_TEXT.
+type Placeholder struct {
+	Column string `json:",omitempty"` // db.table.column
+	Value  string
+	Source string
+}
+
+// ExplainDigest explains digest text, e.g. events_statements_summary_by_digest.DIGEST_TEXT,
+// by filling placeholders with values plausible for the compared columns.
+func ExplainDigest(ctx context.Context, c mysql.Connector, db, digestText string) (*SynthesizedResult, error) {
+	query, values, err := Synthesize(ctx, c, db, digestText)
+	if err != nil {
+		return nil, err
+	}
+	explainResult, err := Explain(ctx, c, db, query, false)
+	if err != nil {
+		return nil, fmt.Errorf("EXPLAIN of synthesized query %s: %s", query, err)
+	}
+	res := &SynthesizedResult{
+		ExplainResult: explainResult,
+		Synthesized:   true,
+		Query:         query,
+		Values:        values,
+	}
+	return res, nil
+}
+
+// Synthesize returns digestText with placeholders replaced by values, and
+// where each value came from.
+func Synthesize(ctx context.Context, c mysql.Connector, db, digestText string) (string, []Placeholder, error) {
+	d := newDigest(digestText)
+	if d.truncated() {
+		return "", nil, fmt.Errorf("digest text is truncated, increase performance_schema_max_digest_length")
+	}
+
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+
+	// Columns are read once per table, and sampled once per column.
+	columns := map[string][]tableinfo.Column{}
+	samples := map[string]Placeholder{}
+	resolve := func(ref tableRef) []tableinfo.Column {
+		if ref.db == "" {
+			ref.db = db
+		}
+		key := ref.db + "." + ref.table
+		cols, ok := columns[key]
+		if !ok {
+			cols, _ = tableinfo.Columns(ctx, conn, ref.db, ref.table)
+			columns[key] = cols
+		}
+		return cols
+	}
+
+	values := []Placeholder{}
+	replace := map[int]string{} // token index => text
+	tables := d.tables()
+	for i, t := range d.tokens {
+		if t.kind != tokPlaceholder && t.kind != tokList {
+			continue
+		}
+
+		// "?, ..." is a list of values, one value is enough.
+		if t.kind == tokList && i > 0 && d.tokens[i-1].text == "," {
+			replace[i-1] = ""
+			replace[i] = ""
+			continue
+		}
+
+		if d.isLimit(i) {
+			replace[i] = defaultLimit
+			values = append(values, Placeholder{Value: defaultLimit, Source: ValueFromDefault})
+			continue
+		}
+
+		p := Placeholder{Value: defaultValue, Source: ValueFromDefault}
+		qualifier, name, ok := d.column(i)
+		if ok {
+			for _, ref := range tables {
+				if qualifier != "" && qualifier != ref.alias && qualifier != ref.table {
+					continue
+				}
+				col, found := findColumn(resolve(ref), name)
+				if !found {
+					continue
+				}
+				refDb := ref.db
+				if refDb == "" {
+					refDb = db
+				}
+				key := refDb + "." + ref.table + "." + col.Name
+				if sample, ok := samples[key]; ok {
+					p = sample
+					break
+				}
+				p = Placeholder{Column: key}
+				p.Value, p.Source = sampleValue(ctx, conn, refDb, ref.table, col)
+				samples[key] = p
+				break
+			}
+		}
+		replace[i] = p.Value
+		values = append(values, p)
+	}
+
+	return d.text(replace), values, nil
+}
+
+// sampleValue returns a literal for col, read from the table if possible.
+func sampleValue(ctx context.Context, conn mysql.Queryer, db, table string, col tableinfo.Column) (string, string) {
+	var v sql.NullString
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL LIMIT 1",
+		quoteIdent(col.Name), quoteIdent(db)+"."+quoteIdent(table), quoteIdent(col.Name))
+	if err := conn.QueryRowContext(ctx, q).Scan(&v); err == nil && v.Valid {
+		if isNumericType(col.DataType) {
+			if _, err := strconv.ParseFloat(v.String, 64); err == nil {
+				return v.String, ValueFromSample
+			}
+		}
+		return quoteString(v.String), ValueFromSample
+	}
+	return typeValue(col), ValueFromType
+}
+
+// typeValue returns a literal valid for the column type.
+func typeValue(col tableinfo.Column) string {
+	switch col.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "bit", "year":
+		if col.DataType == "year" {
+			return "2000"
+		}
+		return "1"
+	case "decimal", "numeric", "float", "double", "real":
+		return "1.0"
+	case "date":
+		return "'2000-01-01'"
+	case "datetime", "timestamp":
+		return "'2000-01-01 00:00:00'"
+	case "time":
+		return "'00:00:00'"
+	case "json":
+		return "'{}'"
+	case "enum", "set":
+		// First value of enum('a','b') or set('a','b').
+		start := strings.Index(col.ColumnType, "'")
+		if start >= 0 {
+			end := strings.Index(col.ColumnType[start+1:], "'")
+			if end >= 0 {
+				return col.ColumnType[start : start+end+2]
+			}
+		}
+	}
+	return "'a'"
+}
+
+func isNumericType(dataType string) bool {
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "bit", "year",
+		"decimal", "numeric", "float", "double", "real":
+		return true
+	}
+	return false
+}
+
+func findColumn(cols []tableinfo.Column, name string) (tableinfo.Column, bool) {
+	for _, col := range cols {
+		if strings.EqualFold(col.Name, name) {
+			return col, true
+		}
+	}
+	return tableinfo.Column{}, false
+}
+
+func quoteIdent(s string) string {
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+func quoteString(s string) string {
+	return "'" + strings.NewReplacer(
+		"\\", "\\\\",
+		"'", "\\'",
+		"\x00", "\\0",
+		"\n", "\\n",
+		"\r", "\\r",
+		"\x1a", "\\Z",
+	).Replace(s) + "'"
+}
+
+// --------------------------------------------------------------------------
+// Digest text tokenizer
+// --------------------------------------------------------------------------
+
+const (
+	tokWord        = iota // keyword or function name
+	tokIdent              // `quoted` identifier
+	tokPlaceholder        // ?
+	tokList               // ..., a list of placeholders
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind       int
+	text       string // unquoted for tokIdent
+	start, end int    // offsets in digest text
+}
+
+type tableRef struct {
+	db, table, alias string
+}
+
+type digest struct {
+	raw    string
+	tokens []token
+}
+
+func newDigest(raw string) *digest {
+	d := &digest{raw: raw}
+	for i := 0; i < len(raw); {
+		ch := raw[i]
+		start := i
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+			continue
+		case ch == '`':
+			i++
+			ident := ""
+			for i < len(raw) {
+				if raw[i] == '`' {
+					if i+1 < len(raw) && raw[i+1] == '`' {
+						ident += "`"
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				ident += string(raw[i])
+				i++
+			}
+			d.tokens = append(d.tokens, token{tokIdent, ident, start, i})
+		case ch == '\'' || ch == '"':
+			i++
+			for i < len(raw) && raw[i] != ch {
+				if raw[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			if i > len(raw) {
+				i = len(raw)
+			}
+			d.tokens = append(d.tokens, token{tokString, raw[start:i], start, i})
+		case ch == '?':
+			i++
+			d.tokens = append(d.tokens, token{tokPlaceholder, "?", start, i})
+		case strings.HasPrefix(raw[i:], "..."):
+			i += 3
+			d.tokens = append(d.tokens, token{tokList, "...", start, i})
+		case isWordChar(ch) && !(ch >= '0' && ch <= '9'):
+			for i < len(raw) && isWordChar(raw[i]) {
+				i++
+			}
+			d.tokens = append(d.tokens, token{tokWord, strings.ToUpper(raw[start:i]), start, i})
+		case ch >= '0' && ch <= '9':
+			for i < len(raw) && (isWordChar(raw[i]) || raw[i] == '.') {
+				i++
+			}
+			d.tokens = append(d.tokens, token{tokNumber, raw[start:i], start, i})
+		default:
+			n := 1
+			for _, op := range []string{"<=>", "<=", ">=", "<>", "!=", ":="} {
+				if strings.HasPrefix(raw[i:], op) {
+					n = len(op)
+					break
+				}
+			}
+			i += n
+			d.tokens = append(d.tokens, token{tokPunct, raw[start:i], start, i})
+		}
+	}
+	return d
+}
+
+func isWordChar(ch byte) bool {
+	return ch == '_' || ch == '$' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// truncated returns true if the digest text was cut at performance_schema_max_digest_length,
+// in which case it ends with "..." outside of parenthesis.
+func (d *digest) truncated() bool {
+	n := len(d.tokens)
+	if n == 0 || d.tokens[n-1].kind != tokList {
+		return false
+	}
+	return n < 2 || d.tokens[n-2].text != "(" && d.tokens[n-2].text != ","
+}
+
+// text returns the digest text with tokens replaced.
+func (d *digest) text(replace map[int]string) string {
+	out := ""
+	pos := 0
+	for i, t := range d.tokens {
+		v, ok := replace[i]
+		if !ok {
+			continue
+		}
+		out += d.raw[pos:t.start] + v
+		pos = t.end
+	}
+	out += d.raw[pos:]
+	return out
+}
+
+// tables returns tables after FROM, JOIN, UPDATE and INTO.
+func (d *digest) tables() []tableRef {
+	refs := []tableRef{}
+	for i := 0; i < len(d.tokens); i++ {
+		switch d.tokens[i].text {
+		case "FROM", "JOIN", "STRAIGHT_JOIN", "UPDATE", "INTO":
+		default:
+			continue
+		}
+		if d.tokens[i].kind != tokWord {
+			continue
+		}
+		for j := i + 1; j < len(d.tokens); {
+			ref, next, ok := d.tableRef(j)
+			if !ok {
+				break
+			}
+			refs = append(refs, ref)
+			// FROM t1, t2 and UPDATE t1, t2
+			if next < len(d.tokens) && d.tokens[next].text == "," {
+				j = next + 1
+				continue
+			}
+			break
+		}
+	}
+	return refs
+}
+
+func (d *digest) tableRef(i int) (tableRef, int, bool) {
+	ref := tableRef{}
+	if i >= len(d.tokens) || d.tokens[i].kind != tokIdent {
+		return ref, i, false
+	}
+	ref.table = d.tokens[i].text
+	i++
+	if i+1 < len(d.tokens) && d.tokens[i].text == "." && d.tokens[i+1].kind == tokIdent {
+		ref.db = ref.table
+		ref.table = d.tokens[i+1].text
+		i += 2
+	}
+	if i < len(d.tokens) && d.tokens[i].kind == tokWord && d.tokens[i].text == "AS" {
+		i++
+	}
+	if i < len(d.tokens) && d.tokens[i].kind == tokIdent {
+		ref.alias = d.tokens[i].text
+		i++
+	}
+	return ref, i, true
+}
+
+// column returns the column compared to the placeholder at i, e.g. `t`.`a` in
+// `t`.`a` = ?, `a` IN (...) or `a` BETWEEN ? AND ?.
+func (d *digest) column(i int) (qualifier, name string, ok bool) {
+	for j := i - 1; j >= 0; j-- {
+		t := d.tokens[j]
+		switch t.kind {
+		case tokIdent:
+			if j >= 2 && d.tokens[j-1].text == "." && d.tokens[j-2].kind == tokIdent {
+				return d.tokens[j-2].text, t.text, true
+			}
+			return "", t.text, true
+		case tokPlaceholder, tokList, tokNumber, tokString:
+			continue
+		case tokPunct:
+			switch t.text {
+			case "=", "<", ">", "<=", ">=", "<>", "!=", "<=>", "(", ",":
+				continue
+			}
+		case tokWord:
+			switch t.text {
+			case "IN", "NOT", "LIKE", "BETWEEN", "AND":
+				continue
+			}
+		}
+		return "", "", false
+	}
+	return "", "", false
+}
+
+// isLimit returns true if the placeholder at i is LIMIT ?, LIMIT ?, ? or OFFSET ?.
+func (d *digest) isLimit(i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		t := d.tokens[j]
+		switch {
+		case t.kind == tokWord && (t.text == "LIMIT" || t.text == "OFFSET"):
+			return true
+		case t.kind == tokPlaceholder || t.kind == tokNumber || t.text == ",":
+			continue
+		}
+		return false
+	}
+	return false
+}
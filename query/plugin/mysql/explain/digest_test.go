@@ -0,0 +1,140 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/query/plugin/mysql/tableinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestTables(t *testing.T) {
+	t.Parallel()
+
+	d := newDigest("SELECT `a` . `x` FROM `db1` . `t1` AS `a` JOIN `t2` `b` ON `a` . `id` = `b` . `id` , `t3` WHERE `a` . `x` = ?")
+	assert.Equal(t, []tableRef{
+		{db: "db1", table: "t1", alias: "a"},
+		{table: "t2", alias: "b"},
+	}, d.tables())
+
+	d = newDigest("SELECT * FROM `t1` , `t2` WHERE `t1` . `id` = `t2` . `id`")
+	assert.Equal(t, []tableRef{{table: "t1"}, {table: "t2"}}, d.tables())
+
+	d = newDigest("INSERT INTO `t` ( `a` , `b` ) VALUES (...)")
+	assert.Equal(t, []tableRef{{table: "t"}}, d.tables())
+}
+
+func TestDigestColumn(t *testing.T) {
+	t.Parallel()
+
+	d := newDigest("SELECT * FROM `t` AS `x` WHERE `x` . `a` = ? AND `b` IN (...) AND `c` BETWEEN ? AND ? AND LOWER ( ? ) LIMIT ?")
+	placeholders := []int{}
+	for i, tok := range d.tokens {
+		if tok.kind == tokPlaceholder || tok.kind == tokList {
+			placeholders = append(placeholders, i)
+		}
+	}
+	require.Len(t, placeholders, 6)
+
+	type col struct {
+		qualifier, name string
+		ok              bool
+	}
+	got := []col{}
+	for _, i := range placeholders {
+		q, n, ok := d.column(i)
+		got = append(got, col{q, n, ok})
+	}
+	assert.Equal(t, []col{
+		{"x", "a", true},
+		{"", "b", true},
+		{"", "c", true},
+		{"", "c", true},
+		{"", "", false},
+		{"", "", false},
+	}, got)
+	assert.True(t, d.isLimit(placeholders[5]))
+	assert.False(t, d.isLimit(placeholders[0]))
+}
+
+func TestDigestTruncated(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, newDigest("SELECT * FROM `t` WHERE `a` IN (...)").truncated())
+	assert.False(t, newDigest("SELECT * FROM `t` WHERE `a` IN ( ? , ... )").truncated())
+	assert.True(t, newDigest("SELECT * FROM `t` WHERE `a` = ? AND `b` ...").truncated())
+}
+
+func TestDigestText(t *testing.T) {
+	t.Parallel()
+
+	d := newDigest("SELECT * FROM `t` WHERE `a` = ? AND `b` IN ( ? , ... )")
+	replace := map[int]string{}
+	for i, tok := range d.tokens {
+		if tok.kind == tokPlaceholder {
+			replace[i] = "1"
+		}
+	}
+	assert.Equal(t, "SELECT * FROM `t` WHERE `a` = 1 AND `b` IN ( 1 , ... )", d.text(replace))
+}
+
+func TestTypeValue(t *testing.T) {
+	t.Parallel()
+
+	fs := map[string]string{
+		"int":       "1",
+		"year":      "2000",
+		"decimal":   "1.0",
+		"varchar":   "'a'",
+		"date":      "'2000-01-01'",
+		"timestamp": "'2000-01-01 00:00:00'",
+		"json":      "'{}'",
+	}
+	for dataType, want := range fs {
+		assert.Equal(t, want, typeValue(tableinfo.Column{DataType: dataType}), dataType)
+	}
+	assert.Equal(t, "'on'", typeValue(tableinfo.Column{DataType: "enum", ColumnType: "enum('on','off')"}))
+	assert.Equal(t, `'it\'s'`, quoteString("it's"))
+}
+
+func TestExplainDigest(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+	conn := mysql.NewConnection(dsn)
+	require.NoError(t, conn.Connect())
+	defer conn.Close()
+
+	_, err := ExplainDigest(context.Background(), conn, "mysql", "SELECT * FROM `user` WHERE `User` = ? AND ...")
+	assert.Error(t, err)
+
+	res, err := ExplainDigest(context.Background(), conn, "mysql", "SELECT * FROM `user` WHERE `User` = ? AND `Host` IN (...) LIMIT ?")
+	require.NoError(t, err)
+	assert.True(t, res.Synthesized)
+	require.Len(t, res.Values, 3)
+	assert.Equal(t, "mysql.user.User", res.Values[0].Column)
+	assert.Equal(t, "mysql.user.Host", res.Values[1].Column)
+	assert.Equal(t, ValueFromDefault, res.Values[2].Source)
+	assert.NotNil(t, res.Classic)
+}
@@ -18,6 +18,7 @@
 package explain
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -26,11 +27,11 @@ import (
 	"github.com/percona/qan-agent/mysql"
 )
 
-func Explain(c mysql.Connector, db, query string, convert bool) (*proto.ExplainResult, error) {
+func Explain(ctx context.Context, c mysql.Connector, db, query string, convert bool) (*proto.ExplainResult, error) {
 	if db != "" && !strings.HasPrefix(db, "`") {
 		db = "`" + db + "`"
 	}
-	explainResult, err := explain(c, db, query)
+	explainResult, err := explain(ctx, c, db, query)
 	if err != nil {
 		// MySQL 5.5 returns syntax error because it doesn't support non-SELECT EXPLAIN.
 		// MySQL 5.6 non-SELECT EXPLAIN requires privs for the SQL statement.
@@ -40,7 +41,7 @@ func Explain(c mysql.Connector, db, query string, convert bool) (*proto.ExplainR
 			if query == "" {
 				return nil, fmt.Errorf("cannot convert query to SELECT")
 			}
-			explainResult, err = explain(c, db, query) // query converted to SELECT
+			explainResult, err = explain(ctx, c, db, query) // query converted to SELECT
 		}
 		if err != nil {
 			return nil, err
@@ -51,9 +52,15 @@ func Explain(c mysql.Connector, db, query string, convert bool) (*proto.ExplainR
 
 // --------------------------------------------------------------------------
 
-func explain(c mysql.Connector, db, query string) (*proto.ExplainResult, error) {
+func explain(ctx context.Context, c mysql.Connector, db, query string) (*proto.ExplainResult, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
 	// Transaction because we need to ensure USE and EXPLAIN are run in one connection
-	tx, err := c.DB().Begin()
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -62,18 +69,18 @@ func explain(c mysql.Connector, db, query string) (*proto.ExplainResult, error)
 	// If the query has a default db, use it; else, all tables need to be db-qualified
 	// or EXPLAIN will throw an error.
 	if db != "" {
-		_, err := tx.Exec(fmt.Sprintf("USE %s", db))
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("USE %s", db))
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	classicExplain, err := classicExplain(c, tx, query)
+	classicExplain, err := classicExplain(ctx, c, tx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	jsonExplain, err := jsonExplain(c, tx, query)
+	jsonExplain, err := jsonExplain(ctx, c, tx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -86,14 +93,14 @@ func explain(c mysql.Connector, db, query string) (*proto.ExplainResult, error)
 	return explain, nil
 }
 
-func classicExplain(c mysql.Connector, tx *sql.Tx, query string) (classicExplain []*proto.ExplainRow, err error) {
+func classicExplain(ctx context.Context, c mysql.Connector, tx *sql.Tx, query string) (classicExplain []*proto.ExplainRow, err error) {
 	// Partitions are introduced since MySQL 5.1
 	// We can simply run EXPLAIN /*!50100 PARTITIONS*/ to get this column when it's available
 	// without prior check for MySQL version.
 	if strings.TrimSpace(query) == "" {
 		return nil, fmt.Errorf("cannot run EXPLAIN on an empty query example")
 	}
-	rows, err := tx.Query(fmt.Sprintf("EXPLAIN %s", query))
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("EXPLAIN %s", query))
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +174,7 @@ func classicExplain(c mysql.Connector, tx *sql.Tx, query string) (classicExplain
 	return classicExplain, nil
 }
 
-func jsonExplain(c mysql.Connector, tx *sql.Tx, query string) (string, error) {
+func jsonExplain(ctx context.Context, c mysql.Connector, tx *sql.Tx, query string) (string, error) {
 	// EXPLAIN in JSON format is introduced since MySQL 5.6.5 and MariaDB 10.1.2
 	// https://mariadb.com/kb/en/mariadb/explain-format-json/
 	ok, err := c.VersionConstraint(">= 5.6.5, < 10.0.0 || >= 10.1.2")
@@ -176,7 +183,7 @@ func jsonExplain(c mysql.Connector, tx *sql.Tx, query string) (string, error) {
 	}
 
 	explain := ""
-	err = tx.QueryRow(fmt.Sprintf("EXPLAIN FORMAT=JSON %s", query)).Scan(&explain)
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("EXPLAIN FORMAT=JSON %s", query)).Scan(&explain)
 	if err != nil {
 		return "", err
 	}
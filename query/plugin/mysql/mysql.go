@@ -18,12 +18,16 @@
 package mysql
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/innodb"
 	"github.com/percona/qan-agent/query/plugin"
 	"github.com/percona/qan-agent/query/plugin/mysql/explain"
+	"github.com/percona/qan-agent/query/plugin/mysql/processlist"
 	"github.com/percona/qan-agent/query/plugin/mysql/summary"
 	"github.com/percona/qan-agent/query/plugin/mysql/tableinfo"
 )
@@ -42,29 +46,37 @@ func New() *MySQL {
 	m := &MySQL{}
 	m.connFactory = &mysql.RealConnectionFactory{}
 	m.cmds = map[string]execFunc{
-		"Explain":   m.explain,
-		"TableInfo": m.tableInfo,
-		"Summary":   m.summary,
+		"Explain":        m.explain,
+		"ExplainAnalyze": m.explainAnalyze,
+		"ExplainDigest":  m.explainDigest,
+		"ExplainTree":    m.explainTree,
+		"OptimizerTrace": m.optimizerTrace,
+		"TableInfo":      m.tableInfo,
+		"IndexAdvice":    m.indexAdvice,
+		"Processlist":    m.processlist,
+		"KillQuery":      m.killQuery,
+		"InnoDBStatus":   m.innodbStatus,
+		"Summary":        m.summary,
 	}
 
 	return m
 }
 
 // Handle executes cmd for given instance and returns resulting data
-func (m *MySQL) Handle(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+func (m *MySQL) Handle(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 	c, ok := m.cmds[cmd.Cmd]
 	if !ok {
 		return nil, plugin.UnknownCmdError(cmd.Cmd)
 	}
 
-	return c(cmd, in)
+	return c(ctx, cmd, in)
 }
 
-type execFunc func(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
+type execFunc func(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error)
 
-func (m *MySQL) explain(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+func (m *MySQL) explain(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 	conn := m.connFactory.Make(in.DSN)
-	if err := conn.Connect(); err != nil {
+	if err := conn.ConnectContext(ctx); err != nil {
 		return nil, err
 	}
 	defer conn.Close()
@@ -74,12 +86,72 @@ func (m *MySQL) explain(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
 		return nil, err
 	}
 
-	return explain.Explain(conn, q.Db, q.Query, q.Convert)
+	return explain.Explain(ctx, conn, q.Db, q.Query, q.Convert)
 }
 
-func (m *MySQL) tableInfo(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+func (m *MySQL) explainDigest(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 	conn := m.connFactory.Make(in.DSN)
-	if err := conn.Connect(); err != nil {
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := &proto.ExplainQuery{}
+	if err := json.Unmarshal(cmd.Data, q); err != nil {
+		return nil, err
+	}
+
+	return explain.ExplainDigest(ctx, conn, q.Db, q.Query)
+}
+
+func (m *MySQL) explainAnalyze(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := explain.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	return explain.ExplainAnalyze(ctx, conn, q)
+}
+
+func (m *MySQL) explainTree(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := explain.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	return explain.ExplainTree(ctx, conn, q)
+}
+
+func (m *MySQL) optimizerTrace(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := explain.Query{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	return explain.OptimizerTrace(ctx, conn, q)
+}
+
+func (m *MySQL) tableInfo(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
 		return nil, err
 	}
 	defer conn.Close()
@@ -89,9 +161,87 @@ func (m *MySQL) tableInfo(cmd *proto.Cmd, in proto.Instance) (interface{}, error
 		return nil, err
 	}
 
-	return tableinfo.TableInfo(conn, tableInfo)
+	return tableinfo.TableInfo(ctx, conn, tableInfo)
+}
+
+func (m *MySQL) indexAdvice(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := &tableinfo.AdviceQuery{}
+	if err := json.Unmarshal(cmd.Data, q); err != nil {
+		return nil, err
+	}
+
+	return tableinfo.IndexAdvice(ctx, conn, q)
+}
+
+func (m *MySQL) processlist(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := processlist.Query{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, &q); err != nil {
+			return nil, err
+		}
+	}
+
+	return processlist.Processlist(ctx, conn, q)
+}
+
+func (m *MySQL) killQuery(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	q := processlist.KillQuery{}
+	if err := json.Unmarshal(cmd.Data, &q); err != nil {
+		return nil, err
+	}
+
+	return nil, processlist.Kill(ctx, conn, q)
+}
+
+func (m *MySQL) innodbStatus(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return innodb.Read(ctx, conn)
 }
 
-func (m *MySQL) summary(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	return summary.Summary(in.DSN)
+func (m *MySQL) summary(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := summary.Query{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, &q); err != nil {
+			return nil, err
+		}
+	}
+
+	switch q.Format {
+	case "", summary.FormatJSON:
+	case summary.FormatText:
+		return summary.Summary(ctx, in.DSN)
+	default:
+		return nil, fmt.Errorf("invalid format %s", q.Format)
+	}
+
+	conn := m.connFactory.Make(in.DSN)
+	if err := conn.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return summary.ReadServer(ctx, conn, q)
 }
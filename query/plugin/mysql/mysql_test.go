@@ -18,12 +18,14 @@
 package mysql
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
 
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/query/plugin/mysql/summary"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,6 +94,25 @@ func TestHandle(t *testing.T) {
 				}
 				return cmd, in
 			},
+			func(data interface{}, err error) {
+				require.NoError(t, err)
+				server := data.(*summary.Server)
+				assert.NotEmpty(t, server.Version)
+				assert.NotEmpty(t, server.Rates)
+			},
+		},
+		// Summary in text format
+		{
+			func() (*proto.Cmd, proto.Instance) {
+				cmd := &proto.Cmd{
+					Cmd:  "Summary",
+					Data: []byte(`{"Format":"text"}`),
+				}
+				in := proto.Instance{
+					DSN: dsn,
+				}
+				return cmd, in
+			},
 			func(data interface{}, err error) {
 				require.NoError(t, err)
 				assert.Regexp(t, "# Percona Toolkit MySQL Summary Report #", data)
@@ -134,7 +155,7 @@ func TestHandle(t *testing.T) {
 				t.Parallel()
 
 				cmd, in := f.provider()
-				f.test(m.Handle(cmd, in))
+				f.test(m.Handle(context.Background(), cmd, in))
 			})
 		}
 	})
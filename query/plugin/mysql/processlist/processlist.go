@@ -0,0 +1,214 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package processlist
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+)
+
+// Query is the data of the Processlist cmd.
+type Query struct {
+	UUID        string
+	IncludeIdle bool   // include threads in Sleep
+	ClassId     string // only threads running this class, slowlog or perfschema class ID
+}
+
+// Thread is one foreground thread, i.e. one connection.
+type Thread struct {
+	Id      int64 // processlist ID, used by KILL
+	User    string
+	Host    string
+	Db      string
+	Command string
+	Time    int64 // seconds in the current state
+	State   string
+	Info    string // statement, may be truncated
+
+	// Same as the slowlog QAN collector: query.Fingerprint and query.Id of Info.
+	Fingerprint string `json:",omitempty"`
+	ClassId     string `json:",omitempty"`
+
+	// Same as the perfschema QAN collector, from events_statements_current.
+	DigestText    string `json:",omitempty"`
+	DigestClassId string `json:",omitempty"`
+}
+
+// Source of the Processlist
+const (
+	SourcePerfSchema  = "performance_schema.threads"
+	SourceProcesslist = "information_schema.PROCESSLIST"
+)
+
+// Result is a snapshot of running threads.
+type Result struct {
+	Source  string
+	Threads []Thread
+}
+
+// KillQuery is the data of the KillQuery cmd.
+type KillQuery struct {
+	UUID       string
+	Id         int64
+	ClassId    string // optional, kill only if the thread still runs this class
+	Connection bool   // KILL CONNECTION instead of KILL QUERY
+}
+
+var (
+	ErrKillSelf = errors.New("cannot kill own connection")
+)
+
+// ThreadNotFoundError is returned when the thread to kill doesn't exist anymore.
+type ThreadNotFoundError int64
+
+func (e ThreadNotFoundError) Error() string {
+	return fmt.Sprintf("thread %d not found", int64(e))
+}
+
+// ClassMismatchError is returned when the thread runs another class than expected,
+// for example because the ID was reused or the statement finished.
+type ClassMismatchError struct {
+	Id      int64
+	ClassId string
+}
+
+func (e ClassMismatchError) Error() string {
+	return fmt.Sprintf("thread %d is not running class %s", e.Id, e.ClassId)
+}
+
+const perfSchemaQuery = `
+SELECT t.PROCESSLIST_ID, COALESCE(t.PROCESSLIST_USER, ''), COALESCE(t.PROCESSLIST_HOST, ''),
+       COALESCE(t.PROCESSLIST_DB, ''), COALESCE(t.PROCESSLIST_COMMAND, ''), COALESCE(t.PROCESSLIST_TIME, 0),
+       COALESCE(t.PROCESSLIST_STATE, ''), COALESCE(t.PROCESSLIST_INFO, ''),
+       COALESCE(s.DIGEST, ''), COALESCE(s.DIGEST_TEXT, '')
+  FROM performance_schema.threads t
+  LEFT JOIN performance_schema.events_statements_current s ON s.THREAD_ID = t.THREAD_ID
+ WHERE t.TYPE = 'FOREGROUND' AND t.PROCESSLIST_ID IS NOT NULL AND t.PROCESSLIST_ID != CONNECTION_ID()`
+
+const processlistQuery = `
+SELECT ID, COALESCE(USER, ''), COALESCE(HOST, ''), COALESCE(DB, ''), COALESCE(COMMAND, ''),
+       COALESCE(TIME, 0), COALESCE(STATE, ''), COALESCE(INFO, ''), '', ''
+  FROM information_schema.PROCESSLIST
+ WHERE ID != CONNECTION_ID()`
+
+// Processlist returns foreground threads, longest running first. It reads
+// performance_schema.threads, which doesn't take the mutex SHOW PROCESSLIST takes,
+// and falls back to information_schema.PROCESSLIST if performance_schema isn't enabled.
+func Processlist(ctx context.Context, c mysql.Connector, q Query) (*Result, error) {
+	res := &Result{Source: SourcePerfSchema}
+	rows, err := c.DB().QueryContext(ctx, perfSchemaQuery+" ORDER BY t.PROCESSLIST_TIME DESC")
+	if err != nil {
+		res.Source = SourceProcesslist
+		rows, err = c.DB().QueryContext(ctx, processlistQuery+" ORDER BY TIME DESC")
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	res.Threads = []Thread{}
+	for rows.Next() {
+		t := Thread{}
+		var digest string
+		if err := rows.Scan(&t.Id, &t.User, &t.Host, &t.Db, &t.Command, &t.Time, &t.State, &t.Info, &digest, &t.DigestText); err != nil {
+			return nil, err
+		}
+		if !q.IncludeIdle && t.Command == "Sleep" {
+			continue
+		}
+		classify(&t, digest)
+		if q.ClassId != "" && !t.Is(q.ClassId) {
+			continue
+		}
+		res.Threads = append(res.Threads, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Is returns true if the thread runs class classId, either slowlog or perfschema class ID.
+func (t Thread) Is(classId string) bool {
+	classId = strings.ToUpper(classId)
+	return classId != "" && (classId == t.ClassId || classId == t.DigestClassId)
+}
+
+// Kill kills the statement running in thread q.Id, or the whole connection
+// if q.Connection is true. If q.ClassId is set, the thread is killed only if
+// it's still running that class.
+func Kill(ctx context.Context, c mysql.Connector, q KillQuery) error {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if q.Id == conn.Id() {
+		return ErrKillSelf
+	}
+
+	if q.ClassId != "" {
+		t := Thread{Id: q.Id}
+		var digest string
+		err := conn.QueryRowContext(ctx, perfSchemaQuery+" AND t.PROCESSLIST_ID = ?", q.Id).Scan(
+			&t.Id, &t.User, &t.Host, &t.Db, &t.Command, &t.Time, &t.State, &t.Info, &digest, &t.DigestText)
+		if err != nil && err != sql.ErrNoRows {
+			err = conn.QueryRowContext(ctx, processlistQuery+" AND ID = ?", q.Id).Scan(
+				&t.Id, &t.User, &t.Host, &t.Db, &t.Command, &t.Time, &t.State, &t.Info, &digest, &t.DigestText)
+		}
+		if err == sql.ErrNoRows {
+			return ThreadNotFoundError(q.Id)
+		}
+		if err != nil {
+			return err
+		}
+		classify(&t, digest)
+		if !t.Is(q.ClassId) {
+			return ClassMismatchError{Id: q.Id, ClassId: q.ClassId}
+		}
+	}
+
+	kill := "KILL QUERY"
+	if q.Connection {
+		kill = "KILL CONNECTION"
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("%s %d", kill, q.Id)); err != nil {
+		if mysql.MySQLErrorCode(err) == mysql.ER_NO_SUCH_THREAD {
+			return ThreadNotFoundError(q.Id)
+		}
+		return err
+	}
+	return nil
+}
+
+// classify sets the fingerprint and class IDs of t like QAN collectors do.
+func classify(t *Thread, digest string) {
+	if len(digest) >= 32 {
+		t.DigestClassId = strings.ToUpper(digest[16:32])
+	}
+	if t.Info != "" {
+		t.Fingerprint, t.ClassId = util.Fingerprint(t.Info)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package processlist
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/go-mysql/query"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	t.Parallel()
+
+	th := Thread{Info: "SELECT * FROM t WHERE id = 5"}
+	classify(&th, "fbe070dfb47e4a2401c5be6b5201254e")
+	assert.Equal(t, "select * from t where id = ?", th.Fingerprint)
+	assert.Equal(t, query.Id(th.Fingerprint), th.ClassId)
+	assert.Equal(t, "01C5BE6B5201254E", th.DigestClassId)
+
+	assert.True(t, th.Is(th.ClassId))
+	assert.True(t, th.Is("01c5be6b5201254e"))
+	assert.False(t, th.Is("0000000000000000"))
+	assert.False(t, th.Is(""))
+
+	idle := Thread{}
+	classify(&idle, "")
+	assert.Empty(t, idle.ClassId)
+	assert.Empty(t, idle.DigestClassId)
+	assert.False(t, idle.Is(""))
+}
+
+func TestProcesslistKill(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+	conn := mysql.NewConnection(dsn)
+	require.NoError(t, conn.Connect())
+	defer conn.Close()
+
+	sleeper := mysql.NewConnection(dsn)
+	require.NoError(t, sleeper.Connect())
+	defer sleeper.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := sleeper.DB().Exec("SELECT SLEEP(30)")
+		done <- err
+	}()
+
+	classId := query.Id(query.Fingerprint("SELECT SLEEP(30)"))
+	var th Thread
+	for i := 0; i < 50 && th.Id == 0; i++ {
+		res, err := Processlist(context.Background(), conn, Query{ClassId: classId})
+		require.NoError(t, err)
+		if len(res.Threads) > 0 {
+			th = res.Threads[0]
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	require.NotZero(t, th.Id, "SELECT SLEEP(30) not in processlist")
+	assert.Equal(t, "select sleep(?)", th.Fingerprint)
+
+	err := Kill(context.Background(), conn, KillQuery{Id: th.Id, ClassId: "0000000000000000"})
+	assert.Equal(t, ClassMismatchError{Id: th.Id, ClassId: "0000000000000000"}, err)
+
+	require.NoError(t, Kill(context.Background(), conn, KillQuery{Id: th.Id, ClassId: classId}))
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Error("query was not killed")
+	}
+
+	var self int64
+	require.NoError(t, conn.DB().QueryRow("SELECT CONNECTION_ID()").Scan(&self))
+	assert.Equal(t, ErrKillSelf, Kill(context.Background(), conn, KillQuery{Id: self}))
+	assert.Equal(t, ThreadNotFoundError(1<<40), Kill(context.Background(), conn, KillQuery{Id: 1 << 40}))
+}
@@ -0,0 +1,441 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package summary
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/config"
+)
+
+// Output formats of the Summary cmd
+const (
+	FormatJSON = "json" // Server from ReadServer, the default
+	FormatText = "text" // pt-mysql-summary output
+)
+
+const (
+	DefaultSleep = 1  // seconds between SHOW GLOBAL STATUS samples
+	MaxSleep     = 30 // cmds time out after 1 minute
+)
+
+// Variables are the global variables reported in Server.Variables, in addition
+// to the ones from config.ReadInfoFromShowGlobalStatus.
+var Variables = []string{
+	"binlog_format",
+	"character_set_server",
+	"collation_server",
+	"datadir",
+	"gtid_mode",
+	"innodb_buffer_pool_instances",
+	"innodb_buffer_pool_size",
+	"innodb_file_per_table",
+	"innodb_flush_log_at_trx_commit",
+	"innodb_flush_method",
+	"innodb_io_capacity",
+	"innodb_log_file_size",
+	"innodb_log_files_in_group",
+	"log_bin",
+	"max_connections",
+	"max_heap_table_size",
+	"query_cache_size",
+	"query_cache_type",
+	"read_only",
+	"server_id",
+	"sql_mode",
+	"super_read_only",
+	"sync_binlog",
+	"table_open_cache",
+	"thread_cache_size",
+	"tmp_table_size",
+	"transaction_isolation",
+	"tx_isolation",
+}
+
+// replicaColumns are the SHOW SLAVE STATUS columns reported in Server.Replication.
+var replicaColumns = []string{
+	"Channel_Name",
+	"Master_Host",
+	"Master_Port",
+	"Master_Log_File",
+	"Read_Master_Log_Pos",
+	"Relay_Master_Log_File",
+	"Exec_Master_Log_Pos",
+	"Slave_IO_Running",
+	"Slave_SQL_Running",
+	"Seconds_Behind_Master",
+	"Last_IO_Error",
+	"Last_SQL_Error",
+	"Retrieved_Gtid_Set",
+	"Executed_Gtid_Set",
+}
+
+// Status variables which are not counters, so they have no rate.
+var (
+	gaugePrefixes = []string{
+		"Innodb_buffer_pool_bytes_",
+		"Innodb_buffer_pool_pages_",
+		"Max_used_",
+		"Open_",
+		"Qcache_free_",
+		"Threads_",
+		"Uptime",
+	}
+	counters = map[string]bool{
+		"Threads_created": true,
+	}
+	gauges = map[string]bool{
+		"Innodb_num_open_files":         true,
+		"Innodb_page_size":              true,
+		"Innodb_row_lock_current_waits": true,
+		"Qcache_queries_in_cache":       true,
+		"Qcache_total_blocks":           true,
+		"Slave_open_temp_tables":        true,
+	}
+)
+
+// Query is the optional data of the Summary cmd.
+type Query struct {
+	UUID   string
+	Format string
+	Sleep  int // seconds, DefaultSleep if zero
+}
+
+// Server is a structured equivalent of pt-mysql-summary. Sections that can't
+// be read, usually because of missing privileges, are reported in Errors.
+type Server struct {
+	Version        string
+	VersionComment string
+	Distro         string
+	Hostname       string
+	Uptime         int64
+	Variables      map[string]interface{} // keyed on CamelCase name
+	Sleep          int                    // seconds between status samples
+	Status         map[string]string      // second sample
+	Rates          map[string]float64     // per second, counters which changed
+	InnoDB         InnoDB
+	Replication    []map[string]string `json:",omitempty"`
+	Schemas        []Schema
+	Plugins        []Plugin
+	Users          []User
+	Errors         []string `json:",omitempty"`
+}
+
+type InnoDB struct {
+	BufferPoolSize      int64 // bytes
+	BufferPoolInstances int64
+	PagesTotal          int64
+	PagesFree           int64
+	PagesData           int64
+	PagesDirty          int64
+	HitRatio            float64 // since the last sample, 0..1
+}
+
+type Schema struct {
+	Name        string
+	Engine      string
+	Tables      int64
+	DataLength  int64
+	IndexLength int64
+}
+
+type Plugin struct {
+	Name    string
+	Status  string
+	Type    string
+	Library string `json:",omitempty"`
+}
+
+type User struct {
+	User   string
+	Host   string
+	Plugin string `json:",omitempty"`
+}
+
+// ReadServer returns the summary of the MySQL server c is connected to.
+func ReadServer(ctx context.Context, c mysql.Connector, q Query) (*Server, error) {
+	sleep := q.Sleep
+	if sleep <= 0 {
+		sleep = DefaultSleep
+	}
+	if sleep > MaxSleep {
+		sleep = MaxSleep
+	}
+
+	s := &Server{
+		Variables: config.ReadInfoFromShowGlobalStatus(c),
+		Sleep:     sleep,
+	}
+	errs := []error{}
+
+	err := c.DB().QueryRow("SELECT @@version, @@version_comment, @@hostname").Scan(&s.Version, &s.VersionComment, &s.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	s.Distro = mysql.Distro(s.VersionComment)
+
+	vars, err := showGlobalVariables(c, Variables)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("SHOW GLOBAL VARIABLES: %s", err))
+	}
+	for k, v := range vars {
+		s.Variables[config.UnderscoreToCamelCase(k)] = v
+	}
+
+	status1, err := showGlobalStatus(c)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(time.Duration(sleep) * time.Second):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	status2, err := showGlobalStatus(c)
+	if err != nil {
+		return nil, err
+	}
+	s.Status = status2
+	s.Rates = Rates(status1, status2, float64(sleep))
+	s.Uptime, _ = strconv.ParseInt(status2["Uptime"], 10, 64)
+
+	s.InnoDB = innoDB(vars, status1, status2)
+
+	if s.Replication, err = replication(c); err != nil {
+		errs = append(errs, fmt.Errorf("SHOW SLAVE STATUS: %s", err))
+	}
+	if s.Schemas, err = schemas(c); err != nil {
+		errs = append(errs, fmt.Errorf("schemas: %s", err))
+	}
+	if s.Plugins, err = plugins(c); err != nil {
+		errs = append(errs, fmt.Errorf("plugins: %s", err))
+	}
+	if s.Users, err = users(c); err != nil {
+		errs = append(errs, fmt.Errorf("users: %s", err))
+	}
+
+	for _, err := range errs {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	return s, nil
+}
+
+// Rates returns per second rates of counters which changed between samples.
+func Rates(status1, status2 map[string]string, seconds float64) map[string]float64 {
+	rates := map[string]float64{}
+	if seconds <= 0 {
+		return rates
+	}
+	for name, v2 := range status2 {
+		if isGauge(name) {
+			continue
+		}
+		v1, ok := status1[name]
+		if !ok {
+			continue
+		}
+		n1, err := strconv.ParseFloat(v1, 64)
+		if err != nil {
+			continue
+		}
+		n2, err := strconv.ParseFloat(v2, 64)
+		if err != nil {
+			continue
+		}
+		// Counters can be reset by FLUSH STATUS.
+		if n2 > n1 {
+			rates[name] = (n2 - n1) / seconds
+		}
+	}
+	return rates
+}
+
+func isGauge(name string) bool {
+	if counters[name] {
+		return false
+	}
+	if gauges[name] {
+		return true
+	}
+	for _, prefix := range gaugePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func innoDB(vars, status1, status2 map[string]string) InnoDB {
+	i := InnoDB{}
+	i.BufferPoolSize, _ = strconv.ParseInt(vars["innodb_buffer_pool_size"], 10, 64)
+	i.BufferPoolInstances, _ = strconv.ParseInt(vars["innodb_buffer_pool_instances"], 10, 64)
+	i.PagesTotal, _ = strconv.ParseInt(status2["Innodb_buffer_pool_pages_total"], 10, 64)
+	i.PagesFree, _ = strconv.ParseInt(status2["Innodb_buffer_pool_pages_free"], 10, 64)
+	i.PagesData, _ = strconv.ParseInt(status2["Innodb_buffer_pool_pages_data"], 10, 64)
+	i.PagesDirty, _ = strconv.ParseInt(status2["Innodb_buffer_pool_pages_dirty"], 10, 64)
+
+	delta := func(name string) float64 {
+		n1, _ := strconv.ParseFloat(status1[name], 64)
+		n2, _ := strconv.ParseFloat(status2[name], 64)
+		return n2 - n1
+	}
+	requests := delta("Innodb_buffer_pool_read_requests")
+	reads := delta("Innodb_buffer_pool_reads") // from disk
+	if requests > 0 && reads >= 0 {
+		i.HitRatio = 1 - reads/requests
+	}
+	return i
+}
+
+func showGlobalVariables(c mysql.Connector, names []string) (map[string]string, error) {
+	args := make([]interface{}, len(names))
+	for i := range names {
+		args[i] = names[i]
+	}
+	q := "SHOW GLOBAL VARIABLES WHERE Variable_name IN (?" + strings.Repeat(", ?", len(names)-1) + ")"
+	return nameValues(c.DB().Query(q, args...))
+}
+
+func showGlobalStatus(c mysql.Connector) (map[string]string, error) {
+	return nameValues(c.DB().Query("SHOW GLOBAL STATUS"))
+}
+
+func nameValues(rows *sql.Rows, err error) (map[string]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[string]string{}
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		res[name] = value.String
+	}
+	return res, rows.Err()
+}
+
+func replication(c mysql.Connector) ([]map[string]string, error) {
+	rows, err := c.DB().Query("SHOW SLAVE STATUS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	replicas := []map[string]string{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		all := map[string]string{}
+		for i, col := range columns {
+			all[col] = values[i].String
+		}
+		replica := map[string]string{}
+		for _, col := range replicaColumns {
+			if v, ok := all[col]; ok {
+				replica[col] = v
+			}
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, rows.Err()
+}
+
+func schemas(c mysql.Connector) ([]Schema, error) {
+	rows, err := c.DB().Query(
+		"SELECT table_schema, IFNULL(engine, ''), COUNT(*), IFNULL(SUM(data_length), 0), IFNULL(SUM(index_length), 0)" +
+			" FROM information_schema.tables" +
+			" WHERE table_schema NOT IN ('information_schema', 'performance_schema', 'sys')" +
+			" GROUP BY table_schema, engine ORDER BY table_schema, engine")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemas := []Schema{}
+	for rows.Next() {
+		s := Schema{}
+		if err := rows.Scan(&s.Name, &s.Engine, &s.Tables, &s.DataLength, &s.IndexLength); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, rows.Err()
+}
+
+func plugins(c mysql.Connector) ([]Plugin, error) {
+	rows, err := c.DB().Query(
+		"SELECT plugin_name, plugin_status, plugin_type, IFNULL(plugin_library, '')" +
+			" FROM information_schema.plugins ORDER BY plugin_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plugins := []Plugin{}
+	for rows.Next() {
+		p := Plugin{}
+		if err := rows.Scan(&p.Name, &p.Status, &p.Type, &p.Library); err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, rows.Err()
+}
+
+// users never selects passwords or authentication strings.
+func users(c mysql.Connector) ([]User, error) {
+	rows, err := c.DB().Query("SELECT User, Host, plugin FROM mysql.user ORDER BY User, Host")
+	if err != nil {
+		// plugin column is available since MySQL 5.5.7.
+		rows, err = c.DB().Query("SELECT User, Host, '' FROM mysql.user ORDER BY User, Host")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u := User{}
+		if err := rows.Scan(&u.User, &u.Host, &u.Plugin); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
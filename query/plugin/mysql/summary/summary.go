@@ -18,6 +18,7 @@
 package summary
 
 import (
+	"context"
 	"net"
 
 	"github.com/go-sql-driver/mysql"
@@ -25,7 +26,7 @@ import (
 )
 
 // Summary executes `pt-mysql-summary` for given dsn
-func Summary(dsn string) (string, error) {
+func Summary(ctx context.Context, dsn string) (string, error) {
 	cfg, err := mysql.ParseDSN(dsn)
 	if err != nil {
 		return "", err
@@ -41,7 +42,7 @@ func Summary(dsn string) (string, error) {
 	}
 	args = append(args, a...)
 
-	return cmd.NewRealCmd(name, args...).Run()
+	return cmd.NewRealCmd(name, args...).RunContext(ctx)
 }
 
 // authArgs returns username and/or password arguments for cmd, e.g:
@@ -18,6 +18,7 @@
 package summary
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -52,8 +53,72 @@ func TestSummary(t *testing.T) {
 	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
 	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
 
-	output, err := Summary(dsn)
+	output, err := Summary(context.Background(), dsn)
 	require.NoError(t, err, "output: %s", output)
 
 	assert.Regexp(t, "# Percona Toolkit MySQL Summary Report #", output)
 }
+
+func TestReadServer(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+	conn := mysql.NewConnection(dsn)
+	err := conn.Connect()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := ReadServer(context.Background(), conn, Query{})
+	require.NoError(t, err)
+	assert.Empty(t, s.Errors)
+	assert.NotEmpty(t, s.Version)
+	assert.Equal(t, DefaultSleep, s.Sleep)
+	assert.NotZero(t, s.Uptime)
+	assert.NotEmpty(t, s.Variables["InnodbBufferPoolSize"])
+	assert.Contains(t, s.Variables, "SlowQueryLog")
+	// SHOW GLOBAL STATUS itself increments Questions.
+	assert.NotZero(t, s.Rates["Questions"])
+	assert.NotZero(t, s.InnoDB.PagesTotal)
+	assert.NotEmpty(t, s.Plugins)
+
+	found := false
+	for _, u := range s.Users {
+		if u.User == "root" {
+			found = true
+		}
+	}
+	assert.True(t, found, "root user: %v", s.Users)
+}
+
+func TestRates(t *testing.T) {
+	t.Parallel()
+
+	status1 := map[string]string{
+		"Questions":         "100",
+		"Threads_created":   "10",
+		"Threads_connected": "5",
+		"Com_select":        "50",
+		"Slow_queries":      "7",
+		"Uptime":            "1000",
+		"Rpl_status":        "AUTH_MASTER",
+		"Bytes_sent":        "2000",
+	}
+	status2 := map[string]string{
+		"Questions":         "120",
+		"Threads_created":   "12",
+		"Threads_connected": "9",
+		"Com_select":        "40", // FLUSH STATUS
+		"Slow_queries":      "7",
+		"Uptime":            "1002",
+		"Rpl_status":        "AUTH_MASTER",
+		"Bytes_sent":        "3000",
+		"Com_insert":        "1",
+	}
+	got := Rates(status1, status2, 2)
+	assert.Equal(t, map[string]float64{
+		"Questions":       10,
+		"Threads_created": 1,
+		"Bytes_sent":      500,
+	}, got)
+}
@@ -0,0 +1,357 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tableinfo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+)
+
+// Types of IndexAdvice findings
+const (
+	FindingDuplicate    = "duplicate"      // same columns as another index
+	FindingRedundant    = "redundant"      // leftmost prefix of another index
+	FindingClustered    = "clustered"      // ends with the primary key which InnoDB appends anyway
+	FindingUnused       = "unused"         // not used since server start
+	FindingNoPrimaryKey = "no_primary_key" // table has no primary key
+)
+
+// AdviceQuery is the data of the IndexAdvice cmd.
+type AdviceQuery struct {
+	UUID   string
+	Tables []proto.Table
+}
+
+// Finding is one problem with the indexes of a table, and the DDL to fix it,
+// if there's one. DDL is only a suggestion, it must be reviewed before running it.
+type Finding struct {
+	Type      string
+	Index     string `json:",omitempty"`
+	Columns   []string
+	CoveredBy string `json:",omitempty"` // index which makes Index unnecessary
+	Reason    string
+	DDL       string `json:",omitempty"`
+}
+
+// Advice is the result of IndexAdvice for one table.
+type Advice struct {
+	Findings []Finding
+	Errors   []string `json:",omitempty"`
+}
+
+// AdviceResult is keyed on db.table.
+type AdviceResult map[string]*Advice
+
+// index is SHOW INDEX rows of one index.
+type index struct {
+	name    string
+	columns []string // with prefix length, e.g. "name(10)"
+	unique  bool
+	notNull bool // all columns are NOT NULL
+	kind    string
+}
+
+// IndexAdvice finds duplicate, redundant and unused indexes, and tables without
+// primary key. Duplicate and redundant indexes are found like pt-duplicate-key-checker does.
+func IndexAdvice(ctx context.Context, c mysql.Connector, q *AdviceQuery) (AdviceResult, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	res := make(AdviceResult)
+	for _, t := range q.Tables {
+		advice := &Advice{Findings: []Finding{}}
+		res[t.Db+"."+t.Table] = advice
+
+		db := escapeString(t.Db)
+		table := escapeString(t.Table)
+		rows, err := showIndex(ctx, conn, ident(db, table))
+		if err != nil {
+			advice.Errors = append(advice.Errors, fmt.Sprintf("SHOW INDEX FROM %s.%s: %s", t.Db, t.Table, err))
+			continue
+		}
+		engine := ""
+		status, err := showStatus(ctx, conn, ident(db, ""), table)
+		if err != nil {
+			advice.Errors = append(advice.Errors, fmt.Sprintf("SHOW TABLE STATUS FROM %s WHERE Name='%s': %s", t.Db, t.Table, err))
+		} else {
+			engine = status.Engine
+		}
+
+		indexes := groupIndexes(rows)
+		advice.Findings = append(advice.Findings, duplicateIndexes(t.Db, t.Table, indexes, strings.EqualFold(engine, "InnoDB"))...)
+
+		unused, err := unusedIndexes(ctx, conn, t.Db, t.Table)
+		if err != nil {
+			advice.Errors = append(advice.Errors, fmt.Sprintf("unused indexes of %s.%s: %s", t.Db, t.Table, err))
+			continue
+		}
+		// Dropping a unique index changes constraints, not only performance.
+		reported := map[string]bool{}
+		for _, f := range advice.Findings {
+			reported[f.Index] = true
+		}
+		for _, idx := range indexes {
+			if !unused[idx.name] || idx.unique || reported[idx.name] {
+				continue
+			}
+			advice.Findings = append(advice.Findings, Finding{
+				Type:    FindingUnused,
+				Index:   idx.name,
+				Columns: idx.columns,
+				Reason:  "index was not used since server start or performance_schema truncate",
+				DDL:     dropIndex(t.Db, t.Table, idx.name),
+			})
+		}
+	}
+	return res, nil
+}
+
+// groupIndexes returns indexes ordered PRIMARY first, then unique, then by name,
+// so when two indexes are duplicates the one to keep comes first.
+func groupIndexes(rows map[string][]proto.ShowIndexRow) []index {
+	indexes := []index{}
+	for name, parts := range rows {
+		idx := index{name: name, notNull: true}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].SeqInIndex < parts[j].SeqInIndex })
+		for _, p := range parts {
+			col := p.ColumnName
+			if p.SubPart.Valid {
+				col = fmt.Sprintf("%s(%d)", col, p.SubPart.Int64)
+			}
+			idx.columns = append(idx.columns, col)
+			idx.unique = !p.NonUnique
+			idx.kind = p.IndexType
+			if p.Null.Valid && p.Null.String == "YES" {
+				idx.notNull = false
+			}
+		}
+		indexes = append(indexes, idx)
+	}
+	rank := func(idx index) int {
+		switch {
+		case idx.name == "PRIMARY":
+			return 0
+		case idx.unique:
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		ri, rj := rank(indexes[i]), rank(indexes[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return indexes[i].name < indexes[j].name
+	})
+	return indexes
+}
+
+func duplicateIndexes(db, table string, indexes []index, innodb bool) []Finding {
+	findings := []Finding{}
+	dropped := map[string]bool{}
+
+	var primary *index
+	for i := range indexes {
+		if indexes[i].name == "PRIMARY" {
+			primary = &indexes[i]
+		}
+	}
+	if primary == nil {
+		f := Finding{
+			Type:   FindingNoPrimaryKey,
+			Reason: "table has no primary key",
+		}
+		// InnoDB already clusters on the first NOT NULL unique index, so make it explicit.
+		for _, idx := range indexes {
+			if idx.unique && idx.notNull && idx.kind == "BTREE" {
+				f.Columns = idx.columns
+				f.Reason += fmt.Sprintf(", unique index %s on NOT NULL columns can be the primary key", idx.name)
+				f.DDL = fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", quote(db, table), quoteColumns(idx.columns))
+				break
+			}
+		}
+		findings = append(findings, f)
+	}
+
+	for i, a := range indexes {
+		for _, b := range indexes[i+1:] {
+			if dropped[b.name] || a.kind != b.kind {
+				continue
+			}
+			// b is after a, so it's never PRIMARY and is unique only if a is unique.
+			if sameColumns(a, b) {
+				dropped[b.name] = true
+				findings = append(findings, Finding{
+					Type:      FindingDuplicate,
+					Index:     b.name,
+					Columns:   b.columns,
+					CoveredBy: a.name,
+					Reason:    fmt.Sprintf("%s is a duplicate of %s", b.name, a.name),
+					DDL:       dropIndex(db, table, b.name),
+				})
+			}
+		}
+	}
+
+	// Redundant: a is a leftmost prefix of b. Unique indexes are constraints
+	// so they are kept. FULLTEXT column order doesn't matter, so it's handled
+	// by sameColumns.
+	for _, a := range indexes {
+		if dropped[a.name] || a.unique || a.kind != "BTREE" {
+			continue
+		}
+		for _, b := range indexes {
+			if a.name == b.name || dropped[b.name] || b.kind != a.kind {
+				continue
+			}
+			if len(a.columns) < len(b.columns) && isPrefix(a.columns, b.columns) {
+				dropped[a.name] = true
+				findings = append(findings, Finding{
+					Type:      FindingRedundant,
+					Index:     a.name,
+					Columns:   a.columns,
+					CoveredBy: b.name,
+					Reason:    fmt.Sprintf("%s is a left-prefix of %s", a.name, b.name),
+					DDL:       dropIndex(db, table, a.name),
+				})
+				break
+			}
+		}
+	}
+
+	// InnoDB appends the primary key to every secondary index, so a secondary
+	// index ending with the primary key columns can be shorter.
+	if innodb && primary != nil {
+		for _, a := range indexes {
+			if dropped[a.name] || a.name == "PRIMARY" || a.unique || a.kind != "BTREE" || len(a.columns) <= len(primary.columns) {
+				continue
+			}
+			n := len(a.columns) - len(primary.columns)
+			if !sameSlice(a.columns[n:], primary.columns) {
+				continue
+			}
+			shorter := a.columns[:n]
+			findings = append(findings, Finding{
+				Type:      FindingClustered,
+				Index:     a.name,
+				Columns:   a.columns,
+				CoveredBy: "PRIMARY",
+				Reason:    fmt.Sprintf("%s ends with the primary key, which InnoDB appends to secondary indexes", a.name),
+				DDL: fmt.Sprintf("ALTER TABLE %s DROP INDEX %s, ADD INDEX %s (%s);",
+					quote(db, table), quote("", a.name), quote("", a.name), quoteColumns(shorter)),
+			})
+		}
+	}
+
+	return findings
+}
+
+// unusedIndexes returns names of indexes which were not used since server start.
+// sys.schema_unused_indexes is preferred, performance_schema is used when there's
+// no sys schema.
+func unusedIndexes(ctx context.Context, q mysql.Queryer, db, table string) (map[string]bool, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT index_name FROM sys.schema_unused_indexes"+
+			" WHERE object_schema = ? AND object_name = ?",
+		db, table)
+	if err != nil {
+		rows, err = q.QueryContext(ctx,
+			"SELECT INDEX_NAME FROM performance_schema.table_io_waits_summary_by_index_usage"+
+				" WHERE OBJECT_SCHEMA = ? AND OBJECT_NAME = ?"+
+				" AND INDEX_NAME IS NOT NULL AND INDEX_NAME != 'PRIMARY' AND COUNT_STAR = 0",
+			db, table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	unused := map[string]bool{}
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name.Valid {
+			unused[name.String] = true
+		}
+	}
+	return unused, rows.Err()
+}
+
+func sameColumns(a, b index) bool {
+	if a.kind != "FULLTEXT" {
+		return sameSlice(a.columns, b.columns)
+	}
+	x := append([]string{}, a.columns...)
+	y := append([]string{}, b.columns...)
+	sort.Strings(x)
+	sort.Strings(y)
+	return sameSlice(x, y)
+}
+
+func isPrefix(prefix, columns []string) bool {
+	return len(prefix) <= len(columns) && sameSlice(prefix, columns[:len(prefix)])
+}
+
+func sameSlice(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func dropIndex(db, table, name string) string {
+	return fmt.Sprintf("ALTER TABLE %s DROP INDEX %s;", quote(db, table), quote("", name))
+}
+
+// quote returns `db`.`table`, or only `table` if db is empty.
+func quote(db, table string) string {
+	q := func(s string) string { return "`" + strings.Replace(s, "`", "``", -1) + "`" }
+	if db == "" {
+		return q(table)
+	}
+	return q(db) + "." + q(table)
+}
+
+// quoteColumns returns `a`, `b`(10) for a, b(10).
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		prefix := ""
+		if n := strings.LastIndex(col, "("); n > 0 && strings.HasSuffix(col, ")") {
+			col, prefix = col[:n], col[n:]
+		}
+		quoted[i] = quote("", col) + prefix
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,155 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package tableinfo
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// showIndexRows returns SHOW INDEX rows for "name:col1,col2" specs; unique
+// names start with "u" or are PRIMARY.
+func showIndexRows(kind string, specs ...string) map[string][]proto.ShowIndexRow {
+	rows := map[string][]proto.ShowIndexRow{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		name := parts[0]
+		for i, col := range strings.Split(parts[1], ",") {
+			rows[name] = append(rows[name], proto.ShowIndexRow{
+				KeyName:    name,
+				NonUnique:  !(name == "PRIMARY" || strings.HasPrefix(name, "u")),
+				SeqInIndex: i + 1,
+				ColumnName: col,
+				IndexType:  kind,
+			})
+		}
+	}
+	return rows
+}
+
+func TestDuplicateIndexes(t *testing.T) {
+	t.Parallel()
+
+	indexes := groupIndexes(showIndexRows("BTREE",
+		"PRIMARY:id",
+		"u_email:email",
+		"idx_email:email",
+		"idx_a:a",
+		"idx_ab:a,b",
+		"idx_ab2:a,b",
+		"idx_c_id:c,id",
+	))
+	got := duplicateIndexes("db", "t", indexes, true)
+	require.Len(t, got, 4)
+
+	assert.Equal(t, FindingDuplicate, got[0].Type)
+	assert.Equal(t, "idx_email", got[0].Index)
+	assert.Equal(t, "u_email", got[0].CoveredBy)
+	assert.Equal(t, "ALTER TABLE `db`.`t` DROP INDEX `idx_email`;", got[0].DDL)
+
+	assert.Equal(t, FindingDuplicate, got[1].Type)
+	assert.Equal(t, "idx_ab2", got[1].Index)
+	assert.Equal(t, "idx_ab", got[1].CoveredBy)
+
+	assert.Equal(t, FindingRedundant, got[2].Type)
+	assert.Equal(t, "idx_a", got[2].Index)
+	assert.Equal(t, "idx_ab", got[2].CoveredBy)
+
+	assert.Equal(t, FindingClustered, got[3].Type)
+	assert.Equal(t, "idx_c_id", got[3].Index)
+	assert.Equal(t, "ALTER TABLE `db`.`t` DROP INDEX `idx_c_id`, ADD INDEX `idx_c_id` (`c`);", got[3].DDL)
+
+	// Clustered index is InnoDB only.
+	got = duplicateIndexes("db", "t", indexes, false)
+	assert.Len(t, got, 3)
+}
+
+func TestNoPrimaryKey(t *testing.T) {
+	t.Parallel()
+
+	got := duplicateIndexes("db", "t", groupIndexes(showIndexRows("BTREE", "idx_a:a")), true)
+	require.Len(t, got, 1)
+	assert.Equal(t, FindingNoPrimaryKey, got[0].Type)
+	assert.Empty(t, got[0].DDL)
+
+	got = duplicateIndexes("db", "t", groupIndexes(showIndexRows("BTREE", "u_ab:a,b")), true)
+	require.Len(t, got, 1)
+	assert.Equal(t, FindingNoPrimaryKey, got[0].Type)
+	assert.Equal(t, "ALTER TABLE `db`.`t` ADD PRIMARY KEY (`a`, `b`);", got[0].DDL)
+}
+
+func TestFulltextIndexes(t *testing.T) {
+	t.Parallel()
+
+	rows := showIndexRows("FULLTEXT", "ft_ab:a,b", "ft_ba:b,a", "ft_a:a")
+	for name, r := range showIndexRows("BTREE", "PRIMARY:id", "idx_a:a") {
+		rows[name] = r
+	}
+	got := duplicateIndexes("db", "t", groupIndexes(rows), true)
+	require.Len(t, got, 1)
+	assert.Equal(t, FindingDuplicate, got[0].Type)
+	assert.Equal(t, "ft_ba", got[0].Index)
+	assert.Equal(t, "ft_ab", got[0].CoveredBy)
+}
+
+func TestQuoteColumns(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "`a`, `b`(10), `c``d`", quoteColumns([]string{"a", "b(10)", "c`d"}))
+}
+
+func TestIndexAdvice(t *testing.T) {
+	t.Parallel()
+
+	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
+	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
+	conn := mysql.NewConnection(dsn)
+	require.NoError(t, conn.Connect())
+	defer conn.Close()
+
+	_, err := conn.DB().Exec("CREATE DATABASE IF NOT EXISTS index_advice")
+	require.NoError(t, err)
+	defer conn.DB().Exec("DROP DATABASE IF EXISTS index_advice")
+	_, err = conn.DB().Exec("CREATE TABLE index_advice.t (a INT NOT NULL, b INT, KEY idx_a (a), KEY idx_ab (a, b)) ENGINE=InnoDB")
+	require.NoError(t, err)
+
+	q := &AdviceQuery{
+		Tables: []proto.Table{{Db: "index_advice", Table: "t"}, {Db: "index_advice", Table: "missing"}},
+	}
+	got, err := IndexAdvice(context.Background(), conn, q)
+	require.NoError(t, err)
+
+	advice := got["index_advice.t"]
+	require.NotNil(t, advice)
+	types := map[string]string{}
+	for _, f := range advice.Findings {
+		types[f.Index] = f.Type
+	}
+	assert.Equal(t, FindingNoPrimaryKey, types[""])
+	assert.Equal(t, FindingRedundant, types["idx_a"])
+
+	require.NotNil(t, got["index_advice.missing"])
+	assert.NotEmpty(t, got["index_advice.missing"].Errors)
+}
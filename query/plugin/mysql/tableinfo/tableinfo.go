@@ -18,6 +18,7 @@
 package tableinfo
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -26,7 +27,13 @@ import (
 	"github.com/percona/qan-agent/mysql"
 )
 
-func TableInfo(c mysql.Connector, tables *proto.TableInfoQuery) (proto.TableInfoResult, error) {
+func TableInfo(ctx context.Context, c mysql.Connector, tables *proto.TableInfoQuery) (proto.TableInfoResult, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
 	res := make(proto.TableInfoResult)
 
 	if len(tables.Create) > 0 {
@@ -40,7 +47,7 @@ func TableInfo(c mysql.Connector, tables *proto.TableInfoQuery) (proto.TableInfo
 
 			db := escapeString(t.Db)
 			table := escapeString(t.Table)
-			def, err := showCreate(c, ident(db, table))
+			def, err := showCreate(ctx, conn, ident(db, table))
 			if err != nil {
 				if tableInfo.Errors == nil {
 					tableInfo.Errors = []string{}
@@ -63,7 +70,7 @@ func TableInfo(c mysql.Connector, tables *proto.TableInfoQuery) (proto.TableInfo
 
 			db := escapeString(t.Db)
 			table := escapeString(t.Table)
-			indexes, err := showIndex(c, ident(db, table))
+			indexes, err := showIndex(ctx, conn, ident(db, table))
 			if err != nil {
 				if tableInfo.Errors == nil {
 					tableInfo.Errors = []string{}
@@ -87,7 +94,7 @@ func TableInfo(c mysql.Connector, tables *proto.TableInfoQuery) (proto.TableInfo
 			// SHOW TABLE STATUS does not accept db.tbl so pass them separately.
 			db := escapeString(t.Db)
 			table := escapeString(t.Table)
-			status, err := showStatus(c, ident(db, ""), table)
+			status, err := showStatus(ctx, conn, ident(db, ""), table)
 			if err != nil {
 				if tableInfo.Errors == nil {
 					tableInfo.Errors = []string{}
@@ -102,22 +109,63 @@ func TableInfo(c mysql.Connector, tables *proto.TableInfoQuery) (proto.TableInfo
 	return res, nil
 }
 
+// Column is one row from INFORMATION_SCHEMA.COLUMNS.
+type Column struct {
+	Name       string
+	DataType   string // int, varchar, ...
+	ColumnType string // int(10) unsigned, enum('a','b'), ...
+	Nullable   bool
+	Key        string // PRI, UNI, MUL or empty
+}
+
+// Columns returns columns of db.table in table order.
+func Columns(ctx context.Context, q mysql.Queryer, db, table string) ([]Column, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY"+
+			" FROM INFORMATION_SCHEMA.COLUMNS"+
+			" WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"+
+			" ORDER BY ORDINAL_POSITION",
+		db, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []Column{}
+	for rows.Next() {
+		col := Column{}
+		var nullable string
+		if err := rows.Scan(&col.Name, &col.DataType, &col.ColumnType, &nullable, &col.Key); err != nil {
+			return nil, err
+		}
+		col.Nullable = nullable == "YES"
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s doesn't exist", db, table)
+	}
+	return columns, nil
+}
+
 // --------------------------------------------------------------------------
 
-func showCreate(c mysql.Connector, dbTable string) (string, error) {
+func showCreate(ctx context.Context, q mysql.Queryer, dbTable string) (string, error) {
 	// Result from SHOW CREATE TABLE includes two columns, "Table" and
 	// "Create Table", we ignore the first one as we need only "Create Table".
 	var tableName string
 	var tableDef string
-	err := c.DB().QueryRow("SHOW CREATE TABLE "+dbTable).Scan(&tableName, &tableDef)
+	err := q.QueryRowContext(ctx, "SHOW CREATE TABLE "+dbTable).Scan(&tableName, &tableDef)
 	if err == sql.ErrNoRows {
 		err = fmt.Errorf("table %s doesn't exist ", dbTable)
 	}
 	return tableDef, err
 }
 
-func showIndex(c mysql.Connector, dbTable string) (map[string][]proto.ShowIndexRow, error) {
-	rows, err := c.DB().Query("SHOW INDEX FROM " + dbTable)
+func showIndex(ctx context.Context, q mysql.Queryer, dbTable string) (map[string][]proto.ShowIndexRow, error) {
+	rows, err := q.QueryContext(ctx, "SHOW INDEX FROM "+dbTable)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -177,9 +225,9 @@ func showIndex(c mysql.Connector, dbTable string) (map[string][]proto.ShowIndexR
 	return indexes, nil
 }
 
-func showStatus(c mysql.Connector, db, table string) (*proto.ShowTableStatus, error) {
+func showStatus(ctx context.Context, q mysql.Queryer, db, table string) (*proto.ShowTableStatus, error) {
 	status := &proto.ShowTableStatus{}
-	err := c.DB().QueryRow(fmt.Sprintf("SHOW TABLE STATUS FROM %s WHERE Name='%s'", db, table)).Scan(
+	err := q.QueryRowContext(ctx, fmt.Sprintf("SHOW TABLE STATUS FROM %s WHERE Name='%s'", db, table)).Scan(
 		&status.Name,
 		&status.Engine,
 		&status.Version,
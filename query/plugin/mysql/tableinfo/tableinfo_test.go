@@ -18,6 +18,7 @@
 package tableinfo
 
 import (
+	"context"
 	"database/sql"
 	"os"
 	"strings"
@@ -54,7 +55,7 @@ func TestTableInfo(t *testing.T) {
 				Status: []proto.Table{{db, table}},
 			}
 
-			got, err := TableInfo(conn, tables)
+			got, err := TableInfo(context.Background(), conn, tables)
 			require.NoError(t, err)
 
 			tableInfo, ok := got[db+"."+table]
@@ -90,7 +91,7 @@ func TestTableInfo(t *testing.T) {
 				Status: []proto.Table{{db, table}},
 			}
 
-			got, err := TableInfo(conn, tables)
+			got, err := TableInfo(context.Background(), conn, tables)
 			require.NoError(t, err)
 
 			tableInfo, ok := got[db+"."+table]
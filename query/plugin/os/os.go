@@ -18,6 +18,10 @@
 package os
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+
 	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/query/plugin"
 	"github.com/percona/qan-agent/query/plugin/os/summary"
@@ -46,17 +50,31 @@ func New() *Os {
 }
 
 // Handle executes cmd for given instance and returns resulting data
-func (o *Os) Handle(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+func (o *Os) Handle(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 	c, ok := o.cmds[cmd.Cmd]
 	if !ok {
 		return nil, plugin.UnknownCmdError(cmd.Cmd)
 	}
 
-	return c(cmd, in)
+	return c(ctx, cmd, in)
 }
 
-type execFunc func(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
+type execFunc func(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error)
+
+func execSummary(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := summary.Query{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, &q); err != nil {
+			return nil, err
+		}
+	}
 
-func execSummary(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	return summary.Summary()
+	switch q.Format {
+	case "", summary.FormatJSON:
+		return summary.ReadSystem()
+	case summary.FormatText:
+		return summary.Summary(ctx)
+	default:
+		return nil, fmt.Errorf("invalid format %s", q.Format)
+	}
 }
@@ -18,10 +18,12 @@
 package os
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/query/plugin/os/summary"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -56,6 +58,22 @@ func TestHandle(t *testing.T) {
 				in := proto.Instance{}
 				return cmd, in
 			},
+			func(data interface{}, err error) {
+				require.NoError(t, err)
+				system := data.(*summary.System)
+				assert.NotZero(t, system.CPU.Processors)
+			},
+		},
+		// Summary in text format
+		{
+			func() (*proto.Cmd, proto.Instance) {
+				cmd := &proto.Cmd{
+					Cmd:  "Summary",
+					Data: []byte(`{"Format":"text"}`),
+				}
+				in := proto.Instance{}
+				return cmd, in
+			},
 			func(data interface{}, err error) {
 				// TODO: require.NoError panics if you call it with an error from a go-routine
 				// NoError calls FailNow and FailNow calls runtime.GoExit and panics.
@@ -81,7 +99,7 @@ func TestHandle(t *testing.T) {
 				t.Parallel()
 
 				cmd, in := f.provider()
-				f.test(o.Handle(cmd, in))
+				f.test(o.Handle(context.Background(), cmd, in))
 			})
 		}
 	})
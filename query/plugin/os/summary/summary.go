@@ -18,13 +18,27 @@
 package summary
 
 import (
+	"context"
+
 	"github.com/percona/qan-agent/pct/cmd"
 )
 
+// Output formats of the Summary cmd
+const (
+	FormatJSON = "json" // System from ReadSystem, the default
+	FormatText = "text" // pt-summary output
+)
+
+// Query is the optional data of the Summary cmd.
+type Query struct {
+	UUID   string
+	Format string
+}
+
 // Summary executes `pt-summary`
-func Summary() (string, error) {
+func Summary(ctx context.Context) (string, error) {
 	name := "pt-summary"
 	args := []string{}
 
-	return cmd.NewRealCmd(name, args...).Run()
+	return cmd.NewRealCmd(name, args...).RunContext(ctx)
 }
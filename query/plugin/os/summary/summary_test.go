@@ -18,8 +18,11 @@
 package summary
 
 import (
+	"context"
+	"path/filepath"
 	"testing"
 
+	"github.com/percona/qan-agent/test/rootdir"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,8 +30,78 @@ import (
 func TestSummary(t *testing.T) {
 	t.Parallel()
 
-	output, err := Summary()
+	output, err := Summary(context.Background())
 	require.NoError(t, err)
 
 	assert.Regexp(t, "# Percona Toolkit System Summary Report #", output)
 }
+
+func TestReadSystem(t *testing.T) {
+	t.Parallel()
+
+	s, err := readSystem(filepath.Join(rootdir.RootDir(), "test/os/summary001"))
+	require.NoError(t, err)
+	assert.Empty(t, s.Errors)
+
+	assert.Equal(t, "db01", s.Hostname)
+	assert.Equal(t, "4.15.0-112-generic", s.Kernel)
+	assert.Equal(t, 1234.56, s.Uptime)
+
+	assert.Equal(t, CPU{
+		Model:      "Intel(R) Xeon(R) CPU E5-2620 v4 @ 2.10GHz",
+		Processors: 8,
+		Cores:      4,
+		Sockets:    2,
+		MHz:        2100,
+		Governor:   "performance",
+	}, s.CPU)
+
+	assert.Equal(t, uint64(16326596*1024), s.Memory.Total)
+	assert.Equal(t, uint64(9876540*1024), s.Memory.Available)
+	assert.Equal(t, uint64(2048*1024), s.Memory.HugePageSize)
+	assert.Equal(t, uint64(0), s.Memory.HugePages)
+
+	require.Len(t, s.NUMA, 2)
+	assert.Equal(t, NUMANode{Node: 1, CPUs: "4-7", MemTotal: 8163298 * 1024, MemFree: 421072 * 1024}, s.NUMA[1])
+
+	// proc and sysfs are skipped.
+	require.Len(t, s.Mounts, 3)
+	assert.Equal(t, "/var/lib/mysql", s.Mounts[1].MountPoint)
+	assert.Equal(t, "xfs", s.Mounts[1].FSType)
+	assert.Equal(t, "rw,noatime,attr2,inode64,noquota", s.Mounts[1].Options)
+
+	// loop devices are skipped.
+	require.Len(t, s.BlockDevices, 2)
+	assert.Equal(t, BlockDevice{Name: "nvme0n1", Size: 1953525168 * 512, Scheduler: "none", ReadAheadKB: 128, NrRequests: 256}, s.BlockDevices[0])
+	assert.Equal(t, BlockDevice{Name: "sda", Size: 976773168 * 512, Rotational: true, Scheduler: "deadline", ReadAheadKB: 128, NrRequests: 256}, s.BlockDevices[1])
+
+	require.Len(t, s.Network, 2)
+	assert.Equal(t, NetInterface{
+		Name:      "eth0",
+		Address:   "52:54:00:12:34:56",
+		MTU:       9000,
+		Speed:     10000,
+		OperState: "up",
+		RxBytes:   123456789,
+		TxBytes:   987654321,
+		TxErrors:  2,
+	}, s.Network[0])
+	assert.Equal(t, int64(0), s.Network[1].Speed)
+
+	assert.Equal(t, map[string]string{
+		"net.core.somaxconn": "4096",
+		"vm.dirty_ratio":     "20",
+		"vm.swappiness":      "1",
+	}, s.Sysctl)
+	assert.Equal(t, THP{Enabled: "never", Defrag: "madvise"}, s.THP)
+}
+
+func TestReadSystemLocal(t *testing.T) {
+	t.Parallel()
+
+	s, err := ReadSystem()
+	require.NoError(t, err)
+	assert.NotZero(t, s.CPU.Processors)
+	assert.NotZero(t, s.Memory.Total)
+	assert.NotEmpty(t, s.Network)
+}
@@ -0,0 +1,442 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package summary
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Sysctls are the kernel tunables reported in System.Sysctl.
+var Sysctls = []string{
+	"fs.aio-max-nr",
+	"fs.file-max",
+	"kernel.numa_balancing",
+	"net.core.somaxconn",
+	"net.ipv4.ip_local_port_range",
+	"net.ipv4.tcp_max_syn_backlog",
+	"vm.dirty_background_ratio",
+	"vm.dirty_ratio",
+	"vm.overcommit_memory",
+	"vm.swappiness",
+	"vm.zone_reclaim_mode",
+}
+
+// pseudoFS are filesystems without storage, not reported in System.Mounts.
+var pseudoFS = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"efivarfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"pstore":      true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"selinuxfs":   true,
+	"sysfs":       true,
+	"tracefs":     true,
+}
+
+// System is a structured equivalent of pt-summary built from /proc and /sys.
+// Sections that can't be read are reported in Errors, the rest is still returned.
+type System struct {
+	Hostname     string
+	Kernel       string
+	Uptime       float64 // seconds
+	CPU          CPU
+	Memory       Memory
+	NUMA         []NUMANode    `json:",omitempty"`
+	Mounts       []Mount       `json:",omitempty"`
+	BlockDevices []BlockDevice `json:",omitempty"`
+	Network      []NetInterface
+	Sysctl       map[string]string
+	THP          THP
+	Errors       []string `json:",omitempty"`
+}
+
+type CPU struct {
+	Model      string
+	Processors int // logical CPUs
+	Cores      int // physical cores
+	Sockets    int
+	MHz        float64
+	Governor   string `json:",omitempty"`
+}
+
+// Memory values are bytes.
+type Memory struct {
+	Total         uint64
+	Free          uint64
+	Available     uint64
+	Buffers       uint64
+	Cached        uint64
+	Dirty         uint64
+	SwapTotal     uint64
+	SwapFree      uint64
+	HugePages     uint64 // number of huge pages
+	HugePageSize  uint64
+	HugePagesFree uint64
+}
+
+type NUMANode struct {
+	Node     int
+	CPUs     string // cpulist, e.g. 0-7,16-23
+	MemTotal uint64
+	MemFree  uint64
+}
+
+type Mount struct {
+	Device     string
+	MountPoint string
+	FSType     string
+	Options    string
+	Size       uint64 `json:",omitempty"`
+	Free       uint64 `json:",omitempty"`
+}
+
+type BlockDevice struct {
+	Name        string
+	Size        uint64 // bytes
+	Rotational  bool
+	Scheduler   string
+	ReadAheadKB int64
+	NrRequests  int64
+}
+
+type NetInterface struct {
+	Name      string
+	Address   string
+	MTU       int64
+	Speed     int64 `json:",omitempty"` // Mbit/s
+	OperState string
+	RxBytes   uint64
+	TxBytes   uint64
+	RxErrors  uint64
+	TxErrors  uint64
+}
+
+// THP is the active transparent huge pages mode.
+type THP struct {
+	Enabled string
+	Defrag  string
+}
+
+// ReadSystem returns the summary of the local system.
+func ReadSystem() (*System, error) {
+	return readSystem("/")
+}
+
+// readSystem reads /proc and /sys relative to root, so tests can use a fake tree.
+func readSystem(root string) (*System, error) {
+	if _, err := os.Stat(filepath.Join(root, "proc")); err != nil {
+		return nil, err
+	}
+
+	s := &System{
+		Hostname: readString(root, "proc/sys/kernel/hostname"),
+		Kernel:   readString(root, "proc/sys/kernel/osrelease"),
+		Sysctl:   map[string]string{},
+	}
+	errs := []error{}
+
+	if f := strings.Fields(readString(root, "proc/uptime")); len(f) > 0 {
+		s.Uptime, _ = strconv.ParseFloat(f[0], 64)
+	}
+
+	var err error
+	if s.CPU, err = readCPU(root); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Memory, err = readMemory(root); err != nil {
+		errs = append(errs, err)
+	}
+	if s.NUMA, err = readNUMA(root); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Mounts, err = readMounts(root); err != nil {
+		errs = append(errs, err)
+	}
+	if s.BlockDevices, err = readBlockDevices(root); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Network, err = readNetwork(root); err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range Sysctls {
+		file := filepath.Join("proc/sys", strings.Replace(name, ".", "/", -1))
+		if v, err := ioutil.ReadFile(filepath.Join(root, file)); err == nil {
+			s.Sysctl[name] = strings.Join(strings.Fields(string(v)), " ")
+		}
+	}
+	s.THP.Enabled = activeOption(readString(root, "sys/kernel/mm/transparent_hugepage/enabled"))
+	s.THP.Defrag = activeOption(readString(root, "sys/kernel/mm/transparent_hugepage/defrag"))
+
+	for _, err := range errs {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	return s, nil
+}
+
+func readCPU(root string) (CPU, error) {
+	cpu := CPU{}
+	file, err := os.Open(filepath.Join(root, "proc/cpuinfo"))
+	if err != nil {
+		return cpu, err
+	}
+	defer file.Close()
+
+	sockets := map[string]bool{}
+	cores := map[string]bool{}
+	physicalId := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		k := strings.TrimSpace(kv[0])
+		v := strings.TrimSpace(kv[1])
+		switch k {
+		case "processor":
+			cpu.Processors++
+		case "model name":
+			cpu.Model = v
+		case "cpu MHz":
+			cpu.MHz, _ = strconv.ParseFloat(v, 64)
+		case "physical id":
+			physicalId = v
+			sockets[v] = true
+		case "core id":
+			cores[physicalId+":"+v] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return cpu, err
+	}
+	cpu.Sockets = len(sockets)
+	cpu.Cores = len(cores)
+	// Some virtual machines don't report topology.
+	if cpu.Cores == 0 {
+		cpu.Cores = cpu.Processors
+	}
+	if cpu.Sockets == 0 && cpu.Processors > 0 {
+		cpu.Sockets = 1
+	}
+	cpu.Governor = readString(root, "sys/devices/system/cpu/cpu0/cpufreq/scaling_governor")
+	return cpu, nil
+}
+
+func readMemory(root string) (Memory, error) {
+	mem := Memory{}
+	info, err := readMeminfo(filepath.Join(root, "proc/meminfo"), "")
+	if err != nil {
+		return mem, err
+	}
+	mem.Total = info["MemTotal"]
+	mem.Free = info["MemFree"]
+	mem.Available = info["MemAvailable"]
+	mem.Buffers = info["Buffers"]
+	mem.Cached = info["Cached"]
+	mem.Dirty = info["Dirty"]
+	mem.SwapTotal = info["SwapTotal"]
+	mem.SwapFree = info["SwapFree"]
+	mem.HugePages = info["HugePages_Total"]
+	mem.HugePagesFree = info["HugePages_Free"]
+	mem.HugePageSize = info["Hugepagesize"]
+	return mem, nil
+}
+
+// readMeminfo parses /proc/meminfo and the per-node meminfo files, which have
+// a "Node N " prefix. Values in kB are returned in bytes.
+func readMeminfo(file, prefix string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), prefix)
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		f := strings.Fields(kv[1])
+		if len(f) == 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(f) > 1 && f[1] == "kB" {
+			n *= 1024
+		}
+		info[strings.TrimSpace(kv[0])] = n
+	}
+	return info, scanner.Err()
+}
+
+func readNUMA(root string) ([]NUMANode, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "sys/devices/system/node/node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	nodes := []NUMANode{}
+	for _, dir := range dirs {
+		n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		node := NUMANode{
+			Node: n,
+			CPUs: readString(dir, "cpulist"),
+		}
+		info, err := readMeminfo(filepath.Join(dir, "meminfo"), fmt.Sprintf("Node %d ", n))
+		if err != nil {
+			return nil, err
+		}
+		node.MemTotal = info["MemTotal"]
+		node.MemFree = info["MemFree"]
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes, nil
+}
+
+func readMounts(root string) ([]Mount, error) {
+	f, err := os.Open(filepath.Join(root, "proc/mounts"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := []Mount{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || pseudoFS[fields[2]] {
+			continue
+		}
+		m := Mount{
+			Device:     fields[0],
+			MountPoint: fields[1],
+			FSType:     fields[2],
+			Options:    fields[3],
+		}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(filepath.Join(root, m.MountPoint), &st); err == nil {
+			m.Size = st.Blocks * uint64(st.Bsize)
+			m.Free = st.Bavail * uint64(st.Bsize)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+func readBlockDevices(root string) ([]BlockDevice, error) {
+	dirs, err := ioutil.ReadDir(filepath.Join(root, "sys/block"))
+	if err != nil {
+		return nil, err
+	}
+	devices := []BlockDevice{}
+	for _, d := range dirs {
+		name := d.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		dir := filepath.Join(root, "sys/block", name)
+		sectors, _ := strconv.ParseUint(readString(dir, "size"), 10, 64)
+		dev := BlockDevice{
+			Name:       name,
+			Size:       sectors * 512, // always 512-byte sectors, regardless of device
+			Rotational: readString(dir, "queue/rotational") == "1",
+			Scheduler:  activeOption(readString(dir, "queue/scheduler")),
+		}
+		dev.ReadAheadKB, _ = strconv.ParseInt(readString(dir, "queue/read_ahead_kb"), 10, 64)
+		dev.NrRequests, _ = strconv.ParseInt(readString(dir, "queue/nr_requests"), 10, 64)
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+func readNetwork(root string) ([]NetInterface, error) {
+	dirs, err := ioutil.ReadDir(filepath.Join(root, "sys/class/net"))
+	if err != nil {
+		return nil, err
+	}
+	ifaces := []NetInterface{}
+	for _, d := range dirs {
+		dir := filepath.Join(root, "sys/class/net", d.Name())
+		iface := NetInterface{
+			Name:      d.Name(),
+			Address:   readString(dir, "address"),
+			OperState: readString(dir, "operstate"),
+		}
+		iface.MTU, _ = strconv.ParseInt(readString(dir, "mtu"), 10, 64)
+		// Speed is -1 or unreadable for virtual and down interfaces.
+		if speed, err := strconv.ParseInt(readString(dir, "speed"), 10, 64); err == nil && speed > 0 {
+			iface.Speed = speed
+		}
+		iface.RxBytes, _ = strconv.ParseUint(readString(dir, "statistics/rx_bytes"), 10, 64)
+		iface.TxBytes, _ = strconv.ParseUint(readString(dir, "statistics/tx_bytes"), 10, 64)
+		iface.RxErrors, _ = strconv.ParseUint(readString(dir, "statistics/rx_errors"), 10, 64)
+		iface.TxErrors, _ = strconv.ParseUint(readString(dir, "statistics/tx_errors"), 10, 64)
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+// readString returns the trimmed content of file, or empty string on error.
+func readString(dir, file string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// activeOption returns the [selected] value of sysfs options like
+// "always [madvise] never", or the value itself if nothing is selected.
+func activeOption(v string) string {
+	start := strings.Index(v, "[")
+	end := strings.Index(v, "]")
+	if start < 0 || end < start {
+		return v
+	}
+	return v[start+1 : end]
+}
@@ -18,9 +18,10 @@
 package plugin
 
 import (
+	"context"
 	"github.com/percona/pmm/proto"
 )
 
 type Plugin interface {
-	Handle(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
+	Handle(ctx context.Context, cmd *proto.Cmd, in proto.Instance) (interface{}, error)
 }
@@ -26,6 +26,7 @@ import (
 	"testing"
 
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/agent/policy"
 	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/query"
@@ -177,3 +178,107 @@ func (s *ManagerTestSuite) TestHandleExplain(t *C) {
 	t.Assert(gotReply, NotNil)
 	t.Assert(gotReply.Error, Equals, fmt.Sprintf("Unknown command: %s", cmd.Cmd))
 }
+
+func (s *ManagerTestSuite) TestHandlePolicy(t *C) {
+	m := query.NewManager(s.logger, s.repo)
+	t.Assert(m, NotNil)
+	p := &policy.Policy{Preset: policy.ReadOnly}
+	t.Assert(p.Init(), IsNil)
+	m.SetPolicy(p)
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	// EXPLAIN of a SELECT is allowed.
+	query := proto.ExplainQuery{
+		UUID:  "313",
+		Query: "SELECT 1",
+		Db:    "mysql",
+	}
+	data, err := json.Marshal(query)
+	t.Assert(err, IsNil)
+	cmd := &proto.Cmd{
+		Service: "query",
+		Cmd:     "Explain",
+		Data:    data,
+	}
+	gotReply := m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Equals, "")
+
+	// Other queries are rejected, not explained.
+	query.Query = "DELETE FROM user"
+	cmd.Data, err = json.Marshal(query)
+	t.Assert(err, IsNil)
+	gotReply = m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Equals, "Explain command rejected because the local agent policy denies it for service query")
+
+	// So are commands that aren't reads.
+	cmd.Cmd = "KillQuery"
+	gotReply = m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Equals, "KillQuery command rejected because the local agent policy denies it for service query")
+}
+
+func (s *ManagerTestSuite) TestHandleReloadedInstance(t *C) {
+	m := query.NewManager(s.logger, s.repo)
+	t.Assert(m, NotNil)
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	in, err := s.repo.Get("313", false)
+	t.Assert(err, IsNil)
+	file := filepath.Join(s.configDir, "313"+pct.INSTANCE_FILE_SUFFIX)
+	writeInstance := func(in proto.Instance) {
+		data, err := json.Marshal(in)
+		t.Assert(err, IsNil)
+		err = ioutil.WriteFile(file, data, 0600)
+		t.Assert(err, IsNil)
+	}
+	defer func() {
+		// Restore the instance for the other tests.
+		writeInstance(in)
+		t.Check(s.repo.Reload(), IsNil)
+		os.Remove(file)
+	}()
+
+	query := proto.ExplainQuery{
+		UUID:  "313",
+		Query: "SELECT 1",
+		Db:    "mysql",
+	}
+	data, err := json.Marshal(query)
+	t.Assert(err, IsNil)
+	cmd := &proto.Cmd{
+		Service: "query",
+		Cmd:     "Explain",
+		Data:    data,
+	}
+
+	// Cmds get the instance when they're handled, so a cmd handled after
+	// a reload uses the reloaded instance.
+	bad := in
+	bad.DSN = "user:pass@tcp(127.0.0.1:1)/"
+	writeInstance(bad)
+	t.Assert(s.repo.Reload(), IsNil)
+	gotReply := m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Not(Equals), "")
+
+	// A cmd for a removed instance fails.
+	err = os.Remove(file)
+	t.Assert(err, IsNil)
+	t.Assert(s.repo.Reload(), IsNil)
+	gotReply = m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Matches, "Cannot read instance file: .*")
+
+	// And succeeds again once it's restored.
+	writeInstance(in)
+	t.Assert(s.repo.Reload(), IsNil)
+	gotReply = m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Equals, "")
+}
@@ -42,6 +42,11 @@ type API struct {
 	GetError  []error
 	GetResp   []APIResponse
 	PutResp   []APIResponse
+	// CreateInstance records the instances in Created. If Existing has the
+	// "subsystem/name" of an instance, it gets the UUID and Links of the
+	// existing one, like the real API which updates the existing instance.
+	Created  []proto.Instance
+	Existing map[string]proto.Instance
 }
 
 func NewAPI(origin, hostname, agentUuid string, links map[string]string) *API {
@@ -97,6 +102,16 @@ func (a *API) ConnectChan() chan bool {
 }
 
 func (a *API) CreateInstance(url string, it interface{}) (bool, error) {
+	in, ok := it.(*proto.Instance)
+	if !ok {
+		return true, nil
+	}
+	a.Created = append(a.Created, *in)
+	if existing, ok := a.Existing[in.Subsystem+"/"+in.Name]; ok {
+		in.UUID = existing.UUID
+		in.Links = existing.Links
+		return false, nil
+	}
 	return true, nil
 }
 
@@ -18,6 +18,7 @@
 package mock
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"sync"
@@ -71,6 +72,14 @@ func (n *NullMySQL) Connect() error {
 	return nil
 }
 
+func (n *NullMySQL) ConnectContext(ctx context.Context) error {
+	return nil
+}
+
+func (n *NullMySQL) Conn(ctx context.Context) (*mysql.Conn, error) {
+	return nil, mysql.ErrNotConnected
+}
+
 func (n *NullMySQL) Close() {
 	return
 }
@@ -21,8 +21,8 @@ import (
 	"time"
 
 	"github.com/percona/pmm/proto"
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/qan/analyzer"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 type QanAnalyzer struct {
@@ -30,9 +30,9 @@ type QanAnalyzer struct {
 	StopChan           chan bool
 	ErrorChan          chan error
 	CrashChan          chan bool
-	config             pc.QAN
+	config             qc.QAN
 	name               string
-	ValidateConfigMock func(config pc.QAN) (pc.QAN, error)
+	ValidateConfigMock func(config qc.QAN) (qc.QAN, error)
 	Defaults           map[string]interface{}
 }
 
@@ -42,9 +42,9 @@ func NewQanAnalyzer(name string) *QanAnalyzer {
 		StopChan:  make(chan bool, 1),
 		ErrorChan: make(chan error, 1),
 		CrashChan: make(chan bool, 1),
-		config:    pc.QAN{},
+		config:    qc.QAN{},
 		name:      name,
-		ValidateConfigMock: func(config pc.QAN) (pc.QAN, error) {
+		ValidateConfigMock: func(config qc.QAN) (qc.QAN, error) {
 			return config, nil
 		},
 		Defaults: map[string]interface{}{},
@@ -72,15 +72,15 @@ func (a *QanAnalyzer) String() string {
 	return a.name
 }
 
-func (a *QanAnalyzer) Config() pc.QAN {
+func (a *QanAnalyzer) Config() qc.QAN {
 	return a.config
 }
 
-func (a *QanAnalyzer) SetConfig(config pc.QAN) {
+func (a *QanAnalyzer) SetConfig(config qc.QAN) {
 	a.config = config
 }
 
-func (a *QanAnalyzer) ValidateConfig(config pc.QAN) (pc.QAN, error) {
+func (a *QanAnalyzer) ValidateConfig(config qc.QAN) (qc.QAN, error) {
 	return a.ValidateConfigMock(config)
 }
 
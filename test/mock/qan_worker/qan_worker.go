@@ -18,9 +18,9 @@
 package qan_worker
 
 import (
-	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	"github.com/percona/qan-agent/qan/analyzer/report"
+	qc "github.com/percona/qan-agent/qan/config"
 )
 
 type QanWorker struct {
@@ -77,7 +77,7 @@ func (w *QanWorker) Status() map[string]string {
 	}
 }
 
-func (w *QanWorker) SetConfig(config pc.QAN) {
+func (w *QanWorker) SetConfig(config qc.QAN) {
 	return
 }
 
@@ -34,6 +34,7 @@ type MockServiceManager struct {
 	IsRunningVal   bool
 	status         *pct.Status
 	Cmds           []*proto.Cmd
+	ReloadErrs     []error
 }
 
 func NewMockServiceManager(
@@ -95,6 +96,11 @@ func (m *MockServiceManager) Handle(cmd *proto.Cmd) *proto.Reply {
 	return cmd.Reply(nil)
 }
 
+func (m *MockServiceManager) Reload() []error {
+	m.traceChan <- "Reload " + m.name
+	return m.ReloadErrs
+}
+
 func (m *MockServiceManager) Reset() {
 	m.status.Update(m.name, "")
 }